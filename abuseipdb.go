@@ -0,0 +1,261 @@
+package dns
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/caddyserver/certmagic"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(new(AbuseIPDBSource))
+}
+
+// The default refresh interval of the AbuseIPDB source. The free plan
+// allows five blacklist downloads a day.
+const DefaultAbuseIPDBInterval = caddy.Duration(6 * time.Hour)
+
+// The AbuseIPDB API, and the storage of the config, which holds the last
+// downloaded blacklist across restarts. Overridden in tests.
+var (
+	abuseIPDBAPIURL = "https://api.abuseipdb.com/api/v2"
+	sourceStorage   = func(ctx caddy.Context) certmagic.Storage { return ctx.Storage() }
+)
+
+// AbuseIPDBSource provides the addresses on the AbuseIPDB blacklist: those
+// most reported for abuse. The blacklist is saved to Caddy's storage, so
+// that restarts don't use up the API's daily limit.
+type AbuseIPDBSource struct {
+	// The API key. Defaults to {env.ABUSEIPDB_API_KEY}.
+	APIKey string `json:"api_key,omitempty"`
+
+	// The minimum abuse confidence score of the addresses, from 25 to 100.
+	// Defaults to the API's default of 100.
+	ConfidenceMinimum int `json:"confidence_minimum,omitempty"`
+
+	// The maximum number of addresses. Defaults to the API's default of
+	// 10000, the maximum of the free plan.
+	Limit int `json:"limit,omitempty"`
+
+	SourceOptions
+
+	refresher  refresher
+	apiKey     string
+	storage    certmagic.Storage
+	storageKey string
+	logger     *zap.Logger
+}
+
+// savedPrefixes are prefixes saved to storage, such as a blacklist, and
+// when they were fetched.
+type savedPrefixes struct {
+	Fetched  time.Time `json:"fetched"`
+	Prefixes []string  `json:"prefixes"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (*AbuseIPDBSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.abuseipdb",
+		New: func() caddy.Module { return new(AbuseIPDBSource) },
+	}
+}
+
+// Provision fetches the blacklist, or loads it from storage if it was
+// downloaded less than an interval ago, and starts refreshing it.
+func (s *AbuseIPDBSource) Provision(ctx caddy.Context) error {
+	if s.ConfidenceMinimum != 0 && (s.ConfidenceMinimum < 25 || s.ConfidenceMinimum > 100) {
+		return errors.New("confidence_minimum must be between 25 and 100")
+	}
+	if s.Limit < 0 {
+		return errors.New("limit cannot be negative")
+	}
+	if s.APIKey == "" {
+		s.APIKey = "{env.ABUSEIPDB_API_KEY}"
+	}
+	s.apiKey = caddy.NewReplacer().ReplaceKnown(s.APIKey, "")
+	if s.apiKey == "" {
+		return errors.New("no API key provided")
+	}
+	if err := s.SourceOptions.validate(DefaultAbuseIPDBInterval); err != nil {
+		return err
+	}
+	s.logger = ctx.Logger()
+	s.storage = sourceStorage(ctx)
+	// Only the query matters to the blacklist; the API key is hashed so as
+	// not to reveal it.
+	sum := sha256.Sum256([]byte(fmt.Sprint(s.apiKey, " ", s.ConfidenceMinimum, " ", s.Limit)))
+	s.storageKey = "ip_sources/abuseipdb/" + hex.EncodeToString(sum[:8]) + ".json"
+
+	first := true
+	return s.refresher.start(ctx, s.SourceOptions, sourceKey(s), func(ctx context.Context) ([]netip.Prefix, error) {
+		if first {
+			first = false
+			if prefixes, ok := s.load(ctx); ok {
+				return prefixes, nil
+			}
+		}
+		return s.fetch(ctx)
+	})
+}
+
+// load returns the saved blacklist, if it was downloaded less than an
+// interval ago.
+func (s *AbuseIPDBSource) load(ctx context.Context) ([]netip.Prefix, bool) {
+	data, err := s.storage.Load(ctx, s.storageKey)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			s.logger.Warn("loading saved blacklist failed", zap.Error(err))
+		}
+		return nil, false
+	}
+	var saved savedPrefixes
+	if err := json.Unmarshal(data, &saved); err != nil {
+		s.logger.Warn("invalid saved blacklist", zap.Error(err))
+		return nil, false
+	}
+	age := time.Since(saved.Fetched)
+	if s.Interval > 0 && age >= time.Duration(s.Interval) {
+		return nil, false
+	}
+	prefixes, err := parsePrefixList(saved.Prefixes)
+	if err != nil {
+		s.logger.Warn("invalid saved blacklist", zap.Error(err))
+		return nil, false
+	}
+	s.logger.Debug("using saved blacklist", zap.Duration("age", age))
+	return prefixes, true
+}
+
+// fetch downloads the blacklist, and saves it to storage.
+func (s *AbuseIPDBSource) fetch(ctx context.Context) ([]netip.Prefix, error) {
+	query := url.Values{}
+	if s.ConfidenceMinimum != 0 {
+		query.Set("confidenceMinimum", strconv.Itoa(s.ConfidenceMinimum))
+	}
+	if s.Limit != 0 {
+		query.Set("limit", strconv.Itoa(s.Limit))
+	}
+	var list struct {
+		Data []struct {
+			IPAddress string `json:"ipAddress"`
+		} `json:"data"`
+	}
+	rawURL := abuseIPDBAPIURL + "/blacklist"
+	if len(query) > 0 {
+		rawURL += "?" + query.Encode()
+	}
+	header := http.Header{"Key": {s.apiKey}, "Accept": {"application/json"}}
+	if err := fetchJSON(ctx, rawURL, header, &list); err != nil {
+		return nil, err
+	}
+	strs := make([]string, 0, len(list.Data))
+	for _, d := range list.Data {
+		strs = append(strs, d.IPAddress)
+	}
+	prefixes, err := parsePrefixList(strs)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(savedPrefixes{Fetched: time.Now(), Prefixes: strs})
+	if err == nil {
+		err = s.storage.Store(ctx, s.storageKey, data)
+	}
+	if err != nil {
+		// The blacklist is still good, so only the next restart suffers.
+		s.logger.Warn("saving blacklist failed", zap.Error(fmt.Errorf("%s: %w", s.storageKey, err)))
+	}
+	return prefixes, nil
+}
+
+// Cleanup stops refreshing the blacklist.
+func (s *AbuseIPDBSource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the addresses on the blacklist.
+func (s *AbuseIPDBSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *AbuseIPDBSource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	abuseipdb {
+//	    api_key <key>
+//	    confidence_minimum <score>
+//	    limit <count>
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *AbuseIPDBSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "api_key":
+			if !d.AllArgs(&s.APIKey) {
+				return d.ArgErr()
+			}
+		case "confidence_minimum", "limit":
+			name := d.Val()
+			var arg string
+			if !d.AllArgs(&arg) {
+				return d.ArgErr()
+			}
+			n, err := strconv.Atoi(arg)
+			if err != nil {
+				return d.Errf("invalid %s %q", name, arg)
+			}
+			if name == "limit" {
+				s.Limit = n
+			} else {
+				s.ConfidenceMinimum = n
+			}
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown abuseipdb option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*AbuseIPDBSource)(nil)
+	_ caddy.Provisioner       = (*AbuseIPDBSource)(nil)
+	_ caddy.CleanerUpper      = (*AbuseIPDBSource)(nil)
+	_ caddyfile.Unmarshaler   = (*AbuseIPDBSource)(nil)
+	_ caddyhttp.IPRangeSource = (*AbuseIPDBSource)(nil)
+)
@@ -0,0 +1,83 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/certmagic"
+)
+
+func TestAbuseIPDBSource(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/blacklist" || r.Header.Get("Key") != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if got := r.URL.Query().Get("confidenceMinimum"); got != "90" {
+			t.Errorf("got confidenceMinimum %q", got)
+		}
+		fmt.Fprint(w, `{"meta":{"generatedAt":"2024-05-01T10:00:00+00:00"},"data":[
+			{"ipAddress":"192.0.2.44","countryCode":"NL","abuseConfidenceScore":100,"lastReportedAt":"2024-05-01T09:59:01+00:00"},
+			{"ipAddress":"2001:db8::44","countryCode":"US","abuseConfidenceScore":97,"lastReportedAt":"2024-05-01T09:58:01+00:00"}
+		]}`)
+	}))
+	defer srv.Close()
+	defer func(u string, f func(caddy.Context) certmagic.Storage) {
+		abuseIPDBAPIURL, sourceStorage = u, f
+	}(abuseIPDBAPIURL, sourceStorage)
+	abuseIPDBAPIURL = srv.URL
+	storage := &certmagic.FileStorage{Path: t.TempDir()}
+	sourceStorage = func(caddy.Context) certmagic.Storage { return storage }
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	s := AbuseIPDBSource{APIKey: "secret", ConfidenceMinimum: 90}
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[192.0.2.44/32 2001:db8::44/128]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	s.Cleanup()
+
+	// After a restart, the saved blacklist is used.
+	s = AbuseIPDBSource{APIKey: "secret", ConfidenceMinimum: 90}
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[192.0.2.44/32 2001:db8::44/128]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	s.Cleanup()
+	if requests != 1 {
+		t.Errorf("got %d requests, want 1", requests)
+	}
+
+	s = AbuseIPDBSource{APIKey: "wrong", ConfidenceMinimum: 90}
+	if err := s.Provision(ctx); err == nil {
+		t.Error("no error for a wrong API key")
+	}
+	s.Cleanup()
+}
+
+func TestUnmarshalAbuseIPDBSource(t *testing.T) {
+	var s AbuseIPDBSource
+	input := `abuseipdb {
+		api_key {env.ABUSEIPDB_KEY}
+		confidence_minimum 75
+		limit 500
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.APIKey, " ", s.ConfidenceMinimum, " ", s.Limit), "{env.ABUSEIPDB_KEY} 75 500"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
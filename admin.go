@@ -0,0 +1,429 @@
+package dns
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"sort"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func init() {
+	caddy.RegisterModule(adminAPI{})
+}
+
+// adminAPI is a module that provides admin endpoints for DNS ranges.
+type adminAPI struct{}
+
+// CaddyModule returns the Caddy module information.
+func (adminAPI) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.dns_ip_range",
+		New: func() caddy.Module { return new(adminAPI) },
+	}
+}
+
+// Routes returns the admin routes.
+func (a adminAPI) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{
+		{
+			Pattern: "/dns_ip_range/refresh",
+			Handler: caddy.AdminHandlerFunc(a.handleRefresh),
+		},
+		{
+			Pattern: "/dns_ip_range/accept",
+			Handler: caddy.AdminHandlerFunc(a.handleAccept),
+		},
+		{
+			Pattern: "/dns_ip_range/state",
+			Handler: caddy.AdminHandlerFunc(a.handleState),
+		},
+		{
+			Pattern: "/dns_ip_range/hosts",
+			Handler: caddy.AdminHandlerFunc(a.handleHosts),
+		},
+		{
+			Pattern: "/dns_ip_range/history",
+			Handler: caddy.AdminHandlerFunc(a.handleHistory),
+		},
+		{
+			Pattern: "/dns_ip_range/health",
+			Handler: caddy.AdminHandlerFunc(a.handleHealth),
+		},
+	}
+}
+
+// handleRefresh triggers an immediate refresh of the host given by the "host"
+// query parameter, or of all hosts if it's not given. It responds with the
+// list of hosts for which a refresh was triggered.
+func (adminAPI) handleRefresh(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	host := r.URL.Query().Get("host")
+	refreshed := []string{}
+
+	instancesMu.Lock()
+	for d := range instances {
+		refreshed = append(refreshed, d.triggerRefresh(host)...)
+	}
+	instancesMu.Unlock()
+
+	if host != "" && len(refreshed) == 0 {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("unknown host %q", host),
+		}
+	}
+
+	sort.Strings(refreshed)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	return json.NewEncoder(w).Encode(refreshed)
+}
+
+// handleAccept applies the pending update of the host given by the "host"
+// query parameter, or of all hosts if it's not given. It responds with the
+// list of hosts whose update was applied.
+func (adminAPI) handleAccept(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	host := r.URL.Query().Get("host")
+	accepted := []string{}
+
+	instancesMu.Lock()
+	for d := range instances {
+		accepted = append(accepted, d.acceptPending(host)...)
+	}
+	instancesMu.Unlock()
+
+	if host != "" && len(accepted) == 0 {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("no pending update for host %q", host),
+		}
+	}
+
+	sort.Strings(accepted)
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(accepted)
+}
+
+// triggerRefresh makes the watcher of host refresh immediately, or the
+// watchers of all hosts if host is empty. It returns the hosts for which
+// a refresh was triggered. Hosts that are only resolved once are skipped.
+func (d *DNSRange) triggerRefresh(host string) (hosts []string) {
+	for _, h := range d.Hosts {
+		if (host != "" && h != host) || d.interval(h) < 0 {
+			continue
+		}
+		if d.sched.refreshNow(h) {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// adminState is the response of the state endpoint. Hosts and prefixes are
+// sorted, so that the output can be diffed between nodes and over time.
+type adminState struct {
+	// The addresses of each host.
+	Hosts map[string][]netip.Prefix `json:"hosts"`
+
+	// The addresses of all hosts, without duplicates.
+	Merged []netip.Prefix `json:"merged"`
+
+	// The hosts sharing each prefix that appears under more than one host.
+	Shared map[netip.Prefix][]string `json:"shared"`
+
+	// Updates rejected by safety checks, awaiting review.
+	Pending map[string]*pendingUpdate `json:"pending,omitempty"`
+}
+
+// handleState responds with the current addresses of all hosts.
+func (adminAPI) handleState(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	// The same host may be configured in several places.
+	hosts := make(map[string]map[netip.Prefix]struct{})
+	var pending map[string]*pendingUpdate
+	instancesMu.Lock()
+	now := time.Now()
+	for d := range instances {
+		for host, h := range d.state {
+			if v := h.current(); v != nil && !d.stale(v, now) {
+				set := hosts[host]
+				if set == nil {
+					set = make(map[netip.Prefix]struct{}, len(v.addresses))
+					hosts[host] = set
+				}
+				for _, prefix := range v.addresses {
+					set[prefix] = struct{}{}
+				}
+			}
+			h.mu.Lock()
+			if h.pending != nil {
+				if pending == nil {
+					pending = make(map[string]*pendingUpdate)
+				}
+				pending[host] = h.pending
+			}
+			h.mu.Unlock()
+		}
+	}
+	instancesMu.Unlock()
+
+	state := adminState{
+		Hosts:   make(map[string][]netip.Prefix, len(hosts)),
+		Merged:  []netip.Prefix{},
+		Shared:  make(map[netip.Prefix][]string),
+		Pending: pending,
+	}
+	owners := make(map[netip.Prefix][]string)
+	for host, set := range hosts {
+		addrs := make([]netip.Prefix, 0, len(set))
+		for prefix := range set {
+			addrs = append(addrs, prefix)
+			owners[prefix] = append(owners[prefix], host)
+		}
+		sortPrefixes(addrs)
+		state.Hosts[host] = addrs
+	}
+	for prefix, names := range owners {
+		state.Merged = append(state.Merged, prefix)
+		if len(names) > 1 {
+			sort.Strings(names)
+			state.Shared[prefix] = names
+		}
+	}
+	sortPrefixes(state.Merged)
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(state)
+}
+
+// hostInfo describes the state of a host in one range, for the hosts endpoint.
+type hostInfo struct {
+	Host string `json:"host"`
+
+	// The name of the range, if it has one.
+	Range string `json:"range,omitempty"`
+
+	// The current addresses, and when they were last confirmed. Addresses
+	// is null if the host hasn't been resolved, or its addresses expired.
+	Addresses []netip.Prefix `json:"addresses"`
+	Confirmed *time.Time     `json:"confirmed,omitempty"`
+
+	// The last lookup, its error if it failed, and the number of lookups
+	// in a row that failed.
+	LastLookup          *time.Time `json:"last_lookup,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+
+	// Whether the host is "scheduled" to be refreshed at NextRefresh, being
+	// refreshed ("running"), "paused" because the range is idle, or not
+	// refreshed anymore ("stopped").
+	Schedule    string     `json:"schedule"`
+	NextRefresh *time.Time `json:"next_refresh,omitempty"`
+
+	// An update rejected by safety checks, if any.
+	Pending *pendingUpdate `json:"pending,omitempty"`
+
+	// Whether each resolved address was reachable when last probed, if
+	// probing is enabled.
+	Alive map[netip.Addr]bool `json:"alive,omitempty"`
+}
+
+// handleHosts responds with the detailed state of each host in each range,
+// or only of the host given by the "host" query parameter. Entries are sorted
+// by host, then by range.
+func (adminAPI) handleHosts(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	host := r.URL.Query().Get("host")
+	infos := []hostInfo{}
+	instancesMu.Lock()
+	for d := range instances {
+		for _, h := range d.Hosts {
+			if host == "" || h == host {
+				infos = append(infos, d.hostInfo(h))
+			}
+		}
+	}
+	instancesMu.Unlock()
+
+	if host != "" && len(infos) == 0 {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("unknown host %q", host),
+		}
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Host != infos[j].Host {
+			return infos[i].Host < infos[j].Host
+		}
+		return infos[i].Range < infos[j].Range
+	})
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(infos)
+}
+
+// hostInfo returns the state of host.
+func (d *DNSRange) hostInfo(host string) hostInfo {
+	info := hostInfo{Host: host, Range: d.Name}
+	optTime := func(t time.Time) *time.Time {
+		if t.IsZero() {
+			return nil
+		}
+		return &t
+	}
+
+	h := d.state[host]
+	if v := h.current(); v != nil && !d.stale(v, time.Now()) {
+		info.Addresses = v.addresses
+		info.Confirmed = optTime(v.confirmed)
+	}
+	h.mu.Lock()
+	info.LastLookup = optTime(h.lastLookup)
+	info.LastError = h.lastError
+	info.ConsecutiveFailures = h.failures
+	info.Pending = h.pending
+	info.Alive = h.alive
+	h.mu.Unlock()
+
+	var next time.Time
+	info.Schedule, next = d.sched.status(host)
+	info.NextRefresh = optTime(next)
+	return info
+}
+
+// hostHistory is the history of a host in one range, for the history endpoint.
+type hostHistory struct {
+	Host string `json:"host"`
+
+	// The name of the range, if it has one.
+	Range string `json:"range,omitempty"`
+
+	// The distinct lookup results, newest first.
+	Entries []historyEntry `json:"entries"`
+}
+
+// handleHistory responds with the recent lookup results of each host in each
+// range, or only of the host given by the "host" query parameter. With the
+// "at" parameter, an RFC 3339 time, only the result in effect at that time is
+// included, if it's still in the history.
+func (adminAPI) handleHistory(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	query := r.URL.Query()
+	host := query.Get("host")
+	var at time.Time
+	if s := query.Get("at"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        fmt.Errorf("invalid time %q: %v", s, err),
+			}
+		}
+		at = t
+	}
+
+	histories := []hostHistory{}
+	instancesMu.Lock()
+	for d := range instances {
+		for _, h := range d.Hosts {
+			if host == "" || h == host {
+				histories = append(histories, d.hostHistory(h, at))
+			}
+		}
+	}
+	instancesMu.Unlock()
+
+	if host != "" && len(histories) == 0 {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("unknown host %q", host),
+		}
+	}
+
+	sort.Slice(histories, func(i, j int) bool {
+		if histories[i].Host != histories[j].Host {
+			return histories[i].Host < histories[j].Host
+		}
+		return histories[i].Range < histories[j].Range
+	})
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(histories)
+}
+
+// hostHistory returns the history of host, newest first. If at is set, only
+// the entry in effect at that time is returned.
+func (d *DNSRange) hostHistory(host string, at time.Time) hostHistory {
+	h := d.state[host]
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entries := []historyEntry{}
+	for i := len(h.history) - 1; i >= 0; i-- {
+		e := h.history[i]
+		if at.IsZero() {
+			entries = append(entries, e)
+		} else if !e.From.After(at) {
+			entries = append(entries, e)
+			break
+		}
+	}
+	return hostHistory{Host: host, Range: d.Name, Entries: entries}
+}
+
+// handleHealth responds with the health of all ranges, with status 503 if
+// any host is degraded, so that it can be used as a health check.
+func (adminAPI) handleHealth(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	h := currentHealth()
+	w.Header().Set("Content-Type", "application/json")
+	if h.Status != HealthOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	return json.NewEncoder(w).Encode(h)
+}
+
+// Interface guards
+var (
+	_ caddy.AdminRouter = (*adminAPI)(nil)
+)
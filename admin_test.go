@@ -0,0 +1,182 @@
+package dns
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// waitFor polls cond until it returns true, failing the test after a while.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestAdminRefresh(t *testing.T) {
+	res := &fakeResolver{hosts: map[string][]string{"proxy.example.com": {"192.0.2.1"}}}
+	r := DNSRange{Hosts: []string{"proxy.example.com"}, Interval: caddy.Duration(time.Hour)}
+	cancel, err := provision(t, &r, res)
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Cleanup()
+
+	var api adminAPI
+	serve := func(method, target string) (int, error) {
+		w := httptest.NewRecorder()
+		err := api.handleRefresh(w, httptest.NewRequest(method, target, nil))
+		var apiErr caddy.APIError
+		if errors.As(err, &apiErr) {
+			return apiErr.HTTPStatus, nil
+		}
+		return w.Code, err
+	}
+
+	if code, _ := serve(http.MethodGet, "/dns_ip_range/refresh"); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: got status %d", code)
+	}
+	if code, _ := serve(http.MethodPost, "/dns_ip_range/refresh?host=unknown.example.com"); code != http.StatusNotFound {
+		t.Errorf("unknown host: got status %d", code)
+	}
+
+	res.set("proxy.example.com", "192.0.2.2")
+	if code, err := serve(http.MethodPost, "/dns_ip_range/refresh?host=proxy.example.com"); code != http.StatusAccepted || err != nil {
+		t.Fatalf("refresh: got status %d, error %v", code, err)
+	}
+	waitFor(t, "refreshed address", func() bool {
+		ranges := r.GetIPRanges(nil)
+		return len(ranges) == 1 && ranges[0].Addr().String() == "192.0.2.2"
+	})
+}
+
+func TestAdminState(t *testing.T) {
+	res := &fakeResolver{hosts: map[string][]string{
+		"a.example.com": {"192.0.2.2", "192.0.2.1"},
+		"b.example.com": {"2001:db8::1", "192.0.2.1"},
+	}}
+	r := DNSRange{Hosts: []string{"b.example.com", "a.example.com"}, Interval: caddy.Duration(time.Hour)}
+	cancel, err := provision(t, &r, res)
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Cleanup()
+
+	var api adminAPI
+	w := httptest.NewRecorder()
+	if err := api.handleState(w, httptest.NewRequest(http.MethodGet, "/dns_ip_range/state", nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"hosts":{"a.example.com":["192.0.2.1/32","192.0.2.2/32"],"b.example.com":["192.0.2.1/32","2001:db8::1/128"]},` +
+		`"merged":["192.0.2.1/32","192.0.2.2/32","2001:db8::1/128"],` +
+		`"shared":{"192.0.2.1/32":["a.example.com","b.example.com"]}}` + "\n"
+	if got := w.Body.String(); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestAdminHosts(t *testing.T) {
+	res := &fakeResolver{hosts: map[string][]string{"up.example.com": {"192.0.2.1"}}}
+	r := DNSRange{
+		Name:             "proxies",
+		Hosts:            []string{"up.example.com", "down.example.com"},
+		Interval:         caddy.Duration(time.Hour),
+		ResolutionPolicy: ResolveAny,
+	}
+	cancel, err := provision(t, &r, res)
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Cleanup()
+
+	var api adminAPI
+	w := httptest.NewRecorder()
+	if err := api.handleHosts(w, httptest.NewRequest(http.MethodGet, "/dns_ip_range/hosts", nil)); err != nil {
+		t.Fatal(err)
+	}
+	var infos []hostInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &infos); err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 {
+		t.Fatalf("got %d hosts, want 2: %s", len(infos), w.Body)
+	}
+
+	down, up := infos[0], infos[1]
+	if down.Host != "down.example.com" || down.Range != "proxies" || down.Addresses != nil ||
+		down.ConsecutiveFailures != 1 || down.LastError == "" || down.Schedule != scheduleQueued || down.NextRefresh == nil {
+		t.Errorf("failing host: got %+v", down)
+	}
+	if up.Host != "up.example.com" || len(up.Addresses) != 1 || up.Confirmed == nil ||
+		up.ConsecutiveFailures != 0 || up.LastError != "" || up.Schedule != scheduleQueued {
+		t.Errorf("resolved host: got %+v", up)
+	}
+
+	err = api.handleHosts(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/dns_ip_range/hosts?host=unknown.example.com", nil))
+	var apiErr caddy.APIError
+	if !errors.As(err, &apiErr) || apiErr.HTTPStatus != http.StatusNotFound {
+		t.Errorf("unknown host: got %v", err)
+	}
+}
+
+func TestAdminHealth(t *testing.T) {
+	res := &fakeResolver{hosts: map[string][]string{"up.example.com": {"192.0.2.1"}}}
+	r := DNSRange{
+		Name:             "proxies",
+		Hosts:            []string{"up.example.com", "down.example.com"},
+		Interval:         caddy.Duration(time.Hour),
+		ResolutionPolicy: ResolveAny,
+		FailureThreshold: 2,
+		StaleAfter:       caddy.Duration(time.Hour),
+	}
+	cancel, err := provision(t, &r, res)
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Cleanup()
+
+	check := func(wantCode int, wantHosts ...string) {
+		t.Helper()
+		var api adminAPI
+		w := httptest.NewRecorder()
+		if err := api.handleHealth(w, httptest.NewRequest(http.MethodGet, "/dns_ip_range/health", nil)); err != nil {
+			t.Fatal(err)
+		}
+		var h health
+		if err := json.Unmarshal(w.Body.Bytes(), &h); err != nil {
+			t.Fatal(err)
+		}
+		var hosts []string
+		for _, d := range h.Degraded {
+			hosts = append(hosts, d.Host)
+		}
+		if w.Code != wantCode || !reflect.DeepEqual(hosts, wantHosts) {
+			t.Errorf("got %d %s, want %d with degraded hosts %v", w.Code, w.Body, wantCode, wantHosts)
+		}
+	}
+
+	// One failure is below the threshold.
+	check(http.StatusOK)
+
+	r.state["down.example.com"].attempted(errors.New("lookup failed"))
+	check(http.StatusServiceUnavailable, "down.example.com")
+
+	r.StaleAfter = caddy.Duration(time.Nanosecond)
+	check(http.StatusServiceUnavailable, "down.example.com", "up.example.com")
+}
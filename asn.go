@@ -0,0 +1,235 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(new(ASNSource))
+}
+
+// The default refresh interval of the ASN source.
+const DefaultASNInterval = caddy.Duration(24 * time.Hour)
+
+// Supported APIs to look up announced prefixes with.
+const (
+	ASNAPIRIPEstat = "ripestat"
+	ASNAPIBGPView  = "bgpview"
+)
+
+// The APIs, overridden in tests.
+var (
+	ripestatURL = "https://stat.ripe.net"
+	bgpviewURL  = "https://api.bgpview.io"
+)
+
+// ASNSource provides the prefixes announced in BGP by autonomous systems,
+// as seen by RIPEstat or BGPView.
+type ASNSource struct {
+	// The AS numbers, such as "AS13335" or "13335".
+	ASNs []string `json:"asns"`
+
+	// The API to use: "ripestat" (the default) or "bgpview".
+	API string `json:"api,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+	asns      []uint32
+}
+
+// CaddyModule returns the Caddy module information.
+func (*ASNSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.asn",
+		New: func() caddy.Module { return new(ASNSource) },
+	}
+}
+
+// Provision fetches the prefixes, and starts refreshing them.
+func (s *ASNSource) Provision(ctx caddy.Context) error {
+	if len(s.ASNs) == 0 {
+		return errors.New("no AS numbers provided")
+	}
+	s.asns = s.asns[:0]
+	for _, asn := range s.ASNs {
+		n, err := parseASN(asn)
+		if err != nil {
+			return err
+		}
+		s.asns = append(s.asns, n)
+	}
+	switch s.API {
+	case "":
+		s.API = ASNAPIRIPEstat
+	case ASNAPIRIPEstat, ASNAPIBGPView:
+	default:
+		return fmt.Errorf("unknown API %q", s.API)
+	}
+	if err := s.SourceOptions.validate(DefaultASNInterval); err != nil {
+		return err
+	}
+	return s.refresher.start(ctx, s.SourceOptions, sourceKey(s), s.fetch)
+}
+
+// parseASN parses an AS number, with or without "AS" in front.
+func parseASN(s string) (uint32, error) {
+	digits := s
+	if len(s) > 2 && strings.EqualFold(s[:2], "AS") {
+		digits = s[2:]
+	}
+	n, err := strconv.ParseUint(digits, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid AS number %q", s)
+	}
+	return uint32(n), nil
+}
+
+// fetch fetches the prefixes announced by each AS. An AS may announce no
+// prefixes, but if none of them do, the API is more likely at fault.
+func (s *ASNSource) fetch(ctx context.Context) ([]netip.Prefix, error) {
+	var strs []string
+	for _, asn := range s.asns {
+		var (
+			announced []string
+			err       error
+		)
+		if s.API == ASNAPIBGPView {
+			announced, err = fetchBGPViewPrefixes(ctx, asn)
+		} else {
+			announced, err = fetchRIPEstatPrefixes(ctx, asn)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("AS%d: %w", asn, err)
+		}
+		strs = append(strs, announced...)
+	}
+	if len(strs) == 0 {
+		return nil, errors.New("no prefixes announced")
+	}
+	return parsePrefixList(strs)
+}
+
+// fetchRIPEstatPrefixes fetches the prefixes announced by an AS, with the
+// announced-prefixes data call of RIPEstat.
+func fetchRIPEstatPrefixes(ctx context.Context, asn uint32) ([]string, error) {
+	var resp struct {
+		Status string `json:"status"`
+		Data   struct {
+			Prefixes []struct {
+				Prefix string `json:"prefix"`
+			} `json:"prefixes"`
+		} `json:"data"`
+	}
+	query := url.Values{"resource": {fmt.Sprintf("AS%d", asn)}, "sourceapp": {sourceUserAgent}}
+	if err := fetchJSON(ctx, ripestatURL+"/data/announced-prefixes/data.json?"+query.Encode(), nil, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Status != "ok" {
+		return nil, fmt.Errorf("RIPEstat status %q", resp.Status)
+	}
+	strs := make([]string, 0, len(resp.Data.Prefixes))
+	for _, p := range resp.Data.Prefixes {
+		strs = append(strs, p.Prefix)
+	}
+	return strs, nil
+}
+
+// fetchBGPViewPrefixes fetches the prefixes announced by an AS from
+// BGPView.
+func fetchBGPViewPrefixes(ctx context.Context, asn uint32) ([]string, error) {
+	type prefix struct {
+		Prefix string `json:"prefix"`
+	}
+	var resp struct {
+		Status string `json:"status"`
+		Data   struct {
+			IPv4 []prefix `json:"ipv4_prefixes"`
+			IPv6 []prefix `json:"ipv6_prefixes"`
+		} `json:"data"`
+	}
+	if err := fetchJSON(ctx, fmt.Sprintf("%s/asn/%d/prefixes", bgpviewURL, asn), nil, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Status != "ok" {
+		return nil, fmt.Errorf("BGPView status %q", resp.Status)
+	}
+	strs := make([]string, 0, len(resp.Data.IPv4)+len(resp.Data.IPv6))
+	for _, p := range append(resp.Data.IPv4, resp.Data.IPv6...) {
+		strs = append(strs, p.Prefix)
+	}
+	return strs, nil
+}
+
+// Cleanup stops refreshing the prefixes.
+func (s *ASNSource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the prefixes announced by the autonomous systems.
+func (s *ASNSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *ASNSource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	asn <asn...> {
+//	    api ripestat|bgpview
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *ASNSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	s.ASNs = append(s.ASNs, d.RemainingArgs()...)
+	if len(s.ASNs) == 0 {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "api":
+			if !d.AllArgs(&s.API) {
+				return d.ArgErr()
+			}
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown asn option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*ASNSource)(nil)
+	_ caddy.Provisioner       = (*ASNSource)(nil)
+	_ caddy.CleanerUpper      = (*ASNSource)(nil)
+	_ caddyfile.Unmarshaler   = (*ASNSource)(nil)
+	_ caddyhttp.IPRangeSource = (*ASNSource)(nil)
+)
@@ -0,0 +1,76 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestASNSource(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/data/announced-prefixes/data.json", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("resource") {
+		case "AS64500":
+			fmt.Fprint(w, `{"status":"ok","data":{"prefixes":[
+				{"prefix":"192.0.2.0/24","timelines":[{"starttime":"2024-04-17T08:00:00","endtime":"2024-05-01T08:00:00"}]},
+				{"prefix":"2001:db8::/32","timelines":[]}
+			],"resource":"64500"}}`)
+		case "AS64501":
+			fmt.Fprint(w, `{"status":"ok","data":{"prefixes":[],"resource":"64501"}}`)
+		default:
+			fmt.Fprint(w, `{"status":"error","messages":[["error","invalid resource"]]}`)
+		}
+	})
+	mux.HandleFunc("/asn/64500/prefixes", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status":"ok","data":{"ipv4_prefixes":[{"prefix":"198.51.100.0/24","ip":"198.51.100.0","cidr":24}],"ipv6_prefixes":[{"prefix":"2001:db8:1::/48"}]}}`)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	defer func(r, b string) { ripestatURL, bgpviewURL = r, b }(ripestatURL, bgpviewURL)
+	ripestatURL, bgpviewURL = srv.URL, srv.URL
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	for _, test := range []*struct {
+		ASNSource
+		want string
+	}{
+		{ASNSource{ASNs: []string{"AS64500", "64501"}}, "[192.0.2.0/24 2001:db8::/32]"},
+		{ASNSource{ASNs: []string{"as64500"}, API: ASNAPIBGPView}, "[198.51.100.0/24 2001:db8:1::/48]"},
+	} {
+		if err := test.Provision(ctx); err != nil {
+			t.Errorf("%v: %v", test.ASNs, err)
+			continue
+		}
+		if got := fmt.Sprint(test.GetIPRanges(nil)); got != test.want {
+			t.Errorf("%v: got %s, want %s", test.ASNs, got, test.want)
+		}
+		test.Cleanup()
+	}
+
+	for _, asns := range [][]string{{"64501"}, {"64502"}, {"ASX"}} {
+		s := ASNSource{ASNs: asns}
+		if err := s.Provision(ctx); err == nil {
+			t.Errorf("%v: no error", asns)
+		}
+		s.Cleanup()
+	}
+}
+
+func TestUnmarshalASNSource(t *testing.T) {
+	var s ASNSource
+	input := `asn AS13335 AS209242 {
+		api bgpview
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.ASNs, " ", s.API), "[AS13335 AS209242] bgpview"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,221 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(new(AWSSource))
+}
+
+const (
+	// The default refresh interval of the AWS source.
+	DefaultAWSInterval = caddy.Duration(6 * time.Hour)
+
+	// The URL of the list of AWS ranges.
+	DefaultAWSURL = "https://ip-ranges.amazonaws.com/ip-ranges.json"
+)
+
+// AWSSource provides the ranges AWS publishes in ip-ranges.json, optionally
+// only those of some services and regions.
+type AWSSource struct {
+	// The URL to fetch the ranges from, in the format of ip-ranges.json.
+	// Defaults to DefaultAWSURL.
+	URL string `json:"url,omitempty"`
+
+	// The services to include, such as "CLOUDFRONT" or "EC2". Defaults to
+	// all of them. Case-insensitive.
+	Services []string `json:"services,omitempty"`
+
+	// The regions to include, such as "us-east-1" or "GLOBAL". Defaults to
+	// all of them. Case-insensitive.
+	Regions []string `json:"regions,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+
+	// The syncToken of the most recent list, to ignore older copies.
+	mu        sync.Mutex
+	syncToken int64
+}
+
+// awsIPRanges is the content of ip-ranges.json.
+type awsIPRanges struct {
+	SyncToken string `json:"syncToken"`
+	Prefixes  []struct {
+		Prefix  string `json:"ip_prefix"`
+		Region  string `json:"region"`
+		Service string `json:"service"`
+	} `json:"prefixes"`
+	IPv6Prefixes []struct {
+		Prefix  string `json:"ipv6_prefix"`
+		Region  string `json:"region"`
+		Service string `json:"service"`
+	} `json:"ipv6_prefixes"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (*AWSSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.aws",
+		New: func() caddy.Module { return new(AWSSource) },
+	}
+}
+
+// Provision fetches the ranges, and starts refreshing them.
+func (s *AWSSource) Provision(ctx caddy.Context) error {
+	if s.URL == "" {
+		s.URL = DefaultAWSURL
+	}
+	if err := s.SourceOptions.validate(DefaultAWSInterval); err != nil {
+		return err
+	}
+	return s.refresher.start(ctx, s.SourceOptions, sourceKey(s), s.fetch)
+}
+
+// fetch fetches the ranges.
+func (s *AWSSource) fetch(ctx context.Context) ([]netip.Prefix, error) {
+	var ranges awsIPRanges
+	if err := fetchJSON(ctx, s.URL, nil, &ranges); err != nil {
+		return nil, err
+	}
+
+	// The syncToken is the publication time, so a lower one means a stale
+	// copy from a cache.
+	token, _ := strconv.ParseInt(ranges.SyncToken, 10, 64)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if token != 0 && token < s.syncToken {
+		s.refresher.logger.Debug("ignoring outdated AWS ranges",
+			zap.Int64("sync_token", token),
+			zap.Int64("current_sync_token", s.syncToken))
+		return nil, errUnchanged
+	}
+
+	var strs []string
+	for _, p := range ranges.Prefixes {
+		if s.include(p.Service, p.Region) {
+			strs = append(strs, p.Prefix)
+		}
+	}
+	for _, p := range ranges.IPv6Prefixes {
+		if s.include(p.Service, p.Region) {
+			strs = append(strs, p.Prefix)
+		}
+	}
+	if len(strs) == 0 {
+		return nil, errors.New("no AWS ranges match the services and regions")
+	}
+	prefixes, err := parsePrefixList(strs)
+	if err != nil {
+		return nil, err
+	}
+	s.syncToken = token
+	return prefixes, nil
+}
+
+// include reports whether ranges of service in region are included.
+func (s *AWSSource) include(service, region string) bool {
+	return matchesAny(s.Services, service) && matchesAny(s.Regions, region)
+}
+
+// matchesAny reports whether s case-insensitively equals one of values, or
+// values is empty.
+func matchesAny(values []string, s string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Cleanup stops refreshing the ranges.
+func (s *AWSSource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the selected AWS ranges.
+func (s *AWSSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *AWSSource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	aws {
+//	    service <service...>
+//	    region <region...>
+//	    url <url>
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *AWSSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "service":
+			services := d.RemainingArgs()
+			if len(services) == 0 {
+				return d.ArgErr()
+			}
+			s.Services = append(s.Services, services...)
+		case "region":
+			regions := d.RemainingArgs()
+			if len(regions) == 0 {
+				return d.ArgErr()
+			}
+			s.Regions = append(s.Regions, regions...)
+		case "url":
+			if !d.AllArgs(&s.URL) {
+				return d.ArgErr()
+			}
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown aws option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*AWSSource)(nil)
+	_ caddy.Provisioner       = (*AWSSource)(nil)
+	_ caddy.CleanerUpper      = (*AWSSource)(nil)
+	_ caddyfile.Unmarshaler   = (*AWSSource)(nil)
+	_ caddyhttp.IPRangeSource = (*AWSSource)(nil)
+)
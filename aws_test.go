@@ -0,0 +1,111 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestAWSSource(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{
+			"syncToken": "1680000000",
+			"prefixes": [
+				{"ip_prefix": "3.2.34.0/26", "region": "af-south-1", "service": "EC2", "network_border_group": "af-south-1"},
+				{"ip_prefix": "13.32.0.0/15", "region": "GLOBAL", "service": "CLOUDFRONT", "network_border_group": "GLOBAL"},
+				{"ip_prefix": "15.158.0.0/16", "region": "us-east-1", "service": "CLOUDFRONT", "network_border_group": "us-east-1"}
+			],
+			"ipv6_prefixes": [
+				{"ipv6_prefix": "2600:9000::/28", "region": "GLOBAL", "service": "CLOUDFRONT", "network_border_group": "GLOBAL"}
+			]
+		}`)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	for _, test := range []struct {
+		services, regions []string
+		want              string
+	}{
+		{nil, nil, "[3.2.34.0/26 13.32.0.0/15 15.158.0.0/16 2600:9000::/28]"},
+		{[]string{"cloudfront"}, nil, "[13.32.0.0/15 15.158.0.0/16 2600:9000::/28]"},
+		{[]string{"CLOUDFRONT"}, []string{"global"}, "[13.32.0.0/15 2600:9000::/28]"},
+		{nil, []string{"af-south-1", "us-east-1"}, "[3.2.34.0/26 15.158.0.0/16]"},
+	} {
+		s := AWSSource{URL: srv.URL, Services: test.services, Regions: test.regions}
+		if err := s.Provision(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprint(s.GetIPRanges(nil)); got != test.want {
+			t.Errorf("services %q, regions %q: got %s, want %s", test.services, test.regions, got, test.want)
+		}
+		s.Cleanup()
+	}
+
+	s := AWSSource{URL: srv.URL, Services: []string{"S3"}}
+	if err := s.Provision(ctx); err == nil {
+		t.Error("no error when no ranges match")
+	}
+	s.Cleanup()
+}
+
+func TestAWSSourceSyncToken(t *testing.T) {
+	var token atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := token.Load()
+		fmt.Fprintf(w, `{"syncToken": "%d", "prefixes": [{"ip_prefix": "10.0.%d.0/24", "region": "GLOBAL", "service": "AMAZON"}]}`, n, n)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	s := AWSSource{URL: srv.URL, SourceOptions: SourceOptions{Interval: IntervalOnce}}
+	token.Store(5)
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cleanup()
+
+	// An older list is ignored, a newer one is used.
+	token.Store(4)
+	if err := s.refresher.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[10.0.5.0/24]"; got != want {
+		t.Errorf("after older list: got %s, want %s", got, want)
+	}
+	token.Store(6)
+	if err := s.refresher.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[10.0.6.0/24]"; got != want {
+		t.Errorf("after newer list: got %s, want %s", got, want)
+	}
+}
+
+func TestUnmarshalAWSSource(t *testing.T) {
+	var s AWSSource
+	input := `aws {
+		service CLOUDFRONT
+		service EC2
+		region GLOBAL us-east-1
+		interval 1h
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.Services, s.Regions), "[CLOUDFRONT EC2] [GLOBAL us-east-1]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if err := new(AWSSource).UnmarshalCaddyfile(caddyfile.NewTestDispenser("aws {\n service\n }")); err == nil {
+		t.Error("no error for service without arguments")
+	}
+}
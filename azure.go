@@ -0,0 +1,318 @@
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(new(AzureSource))
+}
+
+const (
+	// The default refresh interval of the Azure source. The download is
+	// updated weekly.
+	DefaultAzureInterval = caddy.Duration(24 * time.Hour)
+
+	// The default location to query the Service Tag Discovery API in.
+	DefaultAzureLocation = "westus"
+)
+
+// The endpoints used by the Azure source. Variables, so that tests can
+// replace them.
+var (
+	// The page linking to the current weekly download of the public
+	// service tags.
+	azureDownloadPage = "https://www.microsoft.com/en-us/download/details.aspx?id=56519"
+
+	// The Microsoft identity platform, for the credentials of the API.
+	azureLoginURL = "https://login.microsoftonline.com"
+
+	// Azure Resource Manager, which serves the Service Tag Discovery API.
+	azureManagementURL = "https://management.azure.com"
+)
+
+// azureDownloadLink matches the link to the weekly download.
+var azureDownloadLink = regexp.MustCompile(`https?://[^"'\s<>]+/ServiceTags_Public_\d+\.json`)
+
+// AzureSource provides the ranges of Azure service tags, such as
+// AzureFrontDoor.Backend. By default, they are taken from the weekly
+// download of the public service tags. With credentials, they are taken from
+// the Service Tag Discovery API instead.
+type AzureSource struct {
+	// The service tags to include, such as "AzureFrontDoor.Backend". A tag
+	// also matches its regional tags, like "AzureCloud" matches
+	// "AzureCloud.westeurope". Case-insensitive. Required.
+	Tags []string `json:"tags"`
+
+	// The regions to include, such as "westeurope". Defaults to all of them,
+	// including the global tags. Case-insensitive.
+	Regions []string `json:"regions,omitempty"`
+
+	// The URL of a service tags JSON file to use instead of the weekly
+	// download, such as a mirror of it.
+	URL string `json:"url,omitempty"`
+
+	// The credentials to use the Service Tag Discovery API with: a service
+	// principal's tenant ID, client ID and secret, and the subscription to
+	// query. Values may use global placeholders like {env.AZURE_CLIENT_SECRET}.
+	TenantID       string `json:"tenant_id,omitempty"`
+	ClientID       string `json:"client_id,omitempty"`
+	ClientSecret   string `json:"client_secret,omitempty"`
+	SubscriptionID string `json:"subscription_id,omitempty"`
+
+	// The location to query the API in. Defaults to DefaultAzureLocation.
+	Location string `json:"location,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+}
+
+// azureServiceTags is the format of both the weekly download and the API.
+type azureServiceTags struct {
+	Values []struct {
+		Name       string `json:"name"`
+		Properties struct {
+			Region          string   `json:"region"`
+			AddressPrefixes []string `json:"addressPrefixes"`
+		} `json:"properties"`
+	} `json:"values"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (*AzureSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.azure",
+		New: func() caddy.Module { return new(AzureSource) },
+	}
+}
+
+// Provision fetches the ranges, and starts refreshing them.
+func (s *AzureSource) Provision(ctx caddy.Context) error {
+	if len(s.Tags) == 0 {
+		return errors.New("no service tags provided")
+	}
+	if s.useAPI() {
+		if s.TenantID == "" || s.ClientID == "" || s.ClientSecret == "" || s.SubscriptionID == "" {
+			return errors.New("the API needs tenant_id, client_id, client_secret and subscription_id")
+		}
+		if s.URL != "" {
+			return errors.New("url cannot be combined with API credentials")
+		}
+	}
+	if s.Location == "" {
+		s.Location = DefaultAzureLocation
+	}
+	if err := s.SourceOptions.validate(DefaultAzureInterval); err != nil {
+		return err
+	}
+	return s.refresher.start(ctx, s.SourceOptions, sourceKey(s), s.fetch)
+}
+
+// useAPI reports whether the API is used instead of the download.
+func (s *AzureSource) useAPI() bool {
+	return s.TenantID != "" || s.ClientID != "" || s.ClientSecret != "" || s.SubscriptionID != ""
+}
+
+// fetch fetches the ranges.
+func (s *AzureSource) fetch(ctx context.Context) ([]netip.Prefix, error) {
+	var tags azureServiceTags
+	var err error
+	switch {
+	case s.useAPI():
+		err = s.fetchAPI(ctx, &tags)
+	case s.URL != "":
+		err = fetchJSON(ctx, s.URL, nil, &tags)
+	default:
+		err = s.fetchDownload(ctx, &tags)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var strs []string
+	for _, tag := range tags.Values {
+		if s.include(tag.Name, tag.Properties.Region) {
+			strs = append(strs, tag.Properties.AddressPrefixes...)
+		}
+	}
+	if len(strs) == 0 {
+		return nil, errors.New("no Azure ranges match the service tags and regions")
+	}
+	return parsePrefixList(strs)
+}
+
+// include reports whether the ranges of the service tag name in region are
+// included.
+func (s *AzureSource) include(name, region string) bool {
+	if !matchesAny(s.Regions, region) {
+		return false
+	}
+	base := name
+	if suffix := "." + region; region != "" && len(name) > len(suffix) && strings.EqualFold(name[len(name)-len(suffix):], suffix) {
+		base = name[:len(name)-len(suffix)]
+	}
+	return matchesAny(s.Tags, name) || matchesAny(s.Tags, base)
+}
+
+// fetchDownload fetches the current weekly download into tags.
+func (s *AzureSource) fetchDownload(ctx context.Context, tags *azureServiceTags) error {
+	page, err := fetchDocument(ctx, azureDownloadPage, nil)
+	if err != nil {
+		return err
+	}
+	link := azureDownloadLink.Find(page)
+	if link == nil {
+		return errors.New("no link to the service tags found on the download page")
+	}
+	return fetchJSON(ctx, string(link), nil, tags)
+}
+
+// fetchAPI fetches the service tags from the API into tags.
+func (s *AzureSource) fetchAPI(ctx context.Context, tags *azureServiceTags) error {
+	repl := caddy.NewReplacer()
+	tenant := repl.ReplaceKnown(s.TenantID, "")
+	subscription := repl.ReplaceKnown(s.SubscriptionID, "")
+
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {repl.ReplaceKnown(s.ClientID, "")},
+		"client_secret": {repl.ReplaceKnown(s.ClientSecret, "")},
+		"scope":         {azureManagementURL + "/.default"},
+	}
+	tokenURL := azureLoginURL + "/" + url.PathEscape(tenant) + "/oauth2/v2.0/token"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("getting an Azure access token: unexpected status %s", resp.Status)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return fmt.Errorf("getting an Azure access token: %w", err)
+	}
+
+	apiURL := fmt.Sprintf("%s/subscriptions/%s/providers/Microsoft.Network/locations/%s/serviceTags?api-version=2023-05-01",
+		azureManagementURL, url.PathEscape(subscription), url.PathEscape(s.Location))
+	header := http.Header{"Authorization": {"Bearer " + token.AccessToken}}
+	return fetchJSON(ctx, apiURL, header, tags)
+}
+
+// Cleanup stops refreshing the ranges.
+func (s *AzureSource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the ranges of the selected service tags.
+func (s *AzureSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *AzureSource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	azure [<tag...>] {
+//	    tag <tag...>
+//	    region <region...>
+//	    url <url>
+//	    tenant_id <id>
+//	    client_id <id>
+//	    client_secret <secret>
+//	    subscription_id <id>
+//	    location <location>
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *AzureSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	s.Tags = append(s.Tags, d.RemainingArgs()...)
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "tag":
+			tags := d.RemainingArgs()
+			if len(tags) == 0 {
+				return d.ArgErr()
+			}
+			s.Tags = append(s.Tags, tags...)
+		case "region":
+			regions := d.RemainingArgs()
+			if len(regions) == 0 {
+				return d.ArgErr()
+			}
+			s.Regions = append(s.Regions, regions...)
+		case "url":
+			if !d.AllArgs(&s.URL) {
+				return d.ArgErr()
+			}
+		case "tenant_id":
+			if !d.AllArgs(&s.TenantID) {
+				return d.ArgErr()
+			}
+		case "client_id":
+			if !d.AllArgs(&s.ClientID) {
+				return d.ArgErr()
+			}
+		case "client_secret":
+			if !d.AllArgs(&s.ClientSecret) {
+				return d.ArgErr()
+			}
+		case "subscription_id":
+			if !d.AllArgs(&s.SubscriptionID) {
+				return d.ArgErr()
+			}
+		case "location":
+			if !d.AllArgs(&s.Location) {
+				return d.ArgErr()
+			}
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown azure option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*AzureSource)(nil)
+	_ caddy.Provisioner       = (*AzureSource)(nil)
+	_ caddy.CleanerUpper      = (*AzureSource)(nil)
+	_ caddyfile.Unmarshaler   = (*AzureSource)(nil)
+	_ caddyhttp.IPRangeSource = (*AzureSource)(nil)
+)
@@ -0,0 +1,113 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+const testAzureServiceTags = `{
+	"changeNumber": 250,
+	"cloud": "Public",
+	"values": [
+		{"name": "AzureFrontDoor.Backend", "id": "AzureFrontDoor.Backend", "properties": {
+			"changeNumber": 10, "region": "", "systemService": "AzureFrontDoor",
+			"addressPrefixes": ["13.73.248.8/29", "2a01:111:2050::/44"]}},
+		{"name": "AzureCloud.westeurope", "id": "AzureCloud.westeurope", "properties": {
+			"changeNumber": 60, "region": "westeurope", "systemService": "",
+			"addressPrefixes": ["13.69.0.0/17"]}},
+		{"name": "AzureCloud.eastus", "id": "AzureCloud.eastus", "properties": {
+			"changeNumber": 70, "region": "eastus", "systemService": "",
+			"addressPrefixes": ["13.68.128.0/17"]}}
+	]
+}`
+
+func TestAzureSource(t *testing.T) {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	mux.HandleFunc("/details", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<a href="%s/ServiceTags_Public_20230327.json">Download</a>`, srv.URL)
+	})
+	mux.HandleFunc("/ServiceTags_Public_20230327.json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, testAzureServiceTags)
+	})
+	mux.HandleFunc("/tenant/oauth2/v2.0/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("client_secret") != "secret" {
+			http.Error(w, "bad credentials", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"token_type": "Bearer", "access_token": "token"}`)
+	})
+	mux.HandleFunc("/subscriptions/sub/providers/Microsoft.Network/locations/westus/serviceTags", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, testAzureServiceTags)
+	})
+
+	defer func(page, login, management string) {
+		azureDownloadPage, azureLoginURL, azureManagementURL = page, login, management
+	}(azureDownloadPage, azureLoginURL, azureManagementURL)
+	azureDownloadPage, azureLoginURL, azureManagementURL = srv.URL+"/details", srv.URL, srv.URL
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	for _, test := range []struct {
+		source *AzureSource
+		want   string
+	}{
+		{&AzureSource{Tags: []string{"azurefrontdoor.backend"}}, "[13.73.248.8/29 2a01:111:2050::/44]"},
+		{&AzureSource{Tags: []string{"AzureCloud"}}, "[13.68.128.0/17 13.69.0.0/17]"},
+		{&AzureSource{Tags: []string{"AzureCloud", "AzureFrontDoor.Backend"}, Regions: []string{"westeurope"}}, "[13.69.0.0/17]"},
+		{&AzureSource{Tags: []string{"AzureCloud.eastus"}, URL: srv.URL + "/ServiceTags_Public_20230327.json"}, "[13.68.128.0/17]"},
+		{&AzureSource{Tags: []string{"AzureCloud.eastus"}, TenantID: "tenant", ClientID: "id", ClientSecret: "secret", SubscriptionID: "sub"}, "[13.68.128.0/17]"},
+	} {
+		s := test.source
+		if err := s.Provision(ctx); err != nil {
+			t.Fatalf("tags %q: %v", s.Tags, err)
+		}
+		if got := fmt.Sprint(s.GetIPRanges(nil)); got != test.want {
+			t.Errorf("tags %q, regions %q: got %s, want %s", s.Tags, s.Regions, got, test.want)
+		}
+		s.Cleanup()
+	}
+
+	for _, s := range []*AzureSource{
+		{},
+		{Tags: []string{"Storage"}},
+		{Tags: []string{"AzureCloud"}, TenantID: "tenant"},
+		{Tags: []string{"AzureCloud"}, TenantID: "tenant", ClientID: "id", ClientSecret: "wrong", SubscriptionID: "sub"},
+	} {
+		if err := s.Provision(ctx); err == nil {
+			t.Errorf("no error for tags %q, tenant %q", s.Tags, s.TenantID)
+		}
+		s.Cleanup()
+	}
+}
+
+func TestUnmarshalAzureSource(t *testing.T) {
+	var s AzureSource
+	input := `azure AzureFrontDoor.Backend {
+		tag AzureCloud
+		region westeurope
+		tenant_id {env.AZURE_TENANT_ID}
+		client_id {env.AZURE_CLIENT_ID}
+		client_secret {env.AZURE_CLIENT_SECRET}
+		subscription_id {env.AZURE_SUBSCRIPTION_ID}
+		location westeurope
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.Tags, s.Regions, " ", s.ClientSecret, " ", s.Location), "[AzureFrontDoor.Backend AzureCloud] [westeurope] {env.AZURE_CLIENT_SECRET} westeurope"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,170 @@
+package dns
+
+import (
+	"container/list"
+	"context"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+)
+
+// DefaultCacheTTL is how long answers are cached if the resolver doesn't
+// report a TTL and no cache TTL is configured.
+const DefaultCacheTTL = 30 * time.Second
+
+// dnsCache caches the answers of DNS lookups, up to a maximum number of
+// entries. Entries are keyed by the kind of lookup and the queried name,
+// so that lookups of different address families don't collide.
+type dnsCache struct {
+	maxSize int
+	ttl     time.Duration
+
+	mu      sync.Mutex
+	entries map[cacheKey]*list.Element
+	lru     *list.List // of *cacheEntry, most recently used first
+}
+
+// cacheKey identifies a cached lookup.
+type cacheKey struct {
+	kind string
+	name string
+}
+
+// cacheEntry is a cached answer.
+type cacheEntry struct {
+	key     cacheKey
+	values  []netip.Addr
+	expires time.Time
+}
+
+// newDNSCache creates a cache holding up to maxSize entries. Answers without
+// a TTL are cached for ttl, or DefaultCacheTTL if it's not positive.
+func newDNSCache(maxSize int, ttl time.Duration) *dnsCache {
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	return &dnsCache{
+		maxSize: maxSize,
+		ttl:     ttl,
+		entries: make(map[cacheKey]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// lookup returns the cached answer for the lookup of the given kind and name,
+// or calls fetch and caches its answer. Fetch returns the TTL of its answer,
+// or zero if unknown. Errors aren't cached.
+func (c *dnsCache) lookup(ctx context.Context, kind, name string, fetch func(context.Context) ([]netip.Addr, time.Duration, error)) ([]netip.Addr, error) {
+	key := cacheKey{kind: kind, name: name}
+
+	c.mu.Lock()
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		if time.Now().Before(entry.expires) {
+			c.lru.MoveToFront(elem)
+			c.mu.Unlock()
+			cacheRequests.WithLabelValues("hit").Inc()
+			return append([]netip.Addr(nil), entry.values...), nil
+		}
+		c.remove(elem)
+	}
+	c.mu.Unlock()
+	cacheRequests.WithLabelValues("miss").Inc()
+
+	values, ttl, err := fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if ttl <= 0 {
+		ttl = c.ttl
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		// Fetched concurrently by someone else.
+		c.remove(elem)
+	}
+	entry := &cacheEntry{key: key, values: values, expires: time.Now().Add(ttl)}
+	c.entries[key] = c.lru.PushFront(entry)
+	cacheEntries.Inc()
+	for c.lru.Len() > c.maxSize {
+		c.remove(c.lru.Back())
+		cacheEvictions.Inc()
+	}
+
+	return append([]netip.Addr(nil), values...), nil
+}
+
+// Destruct implements caddy.Destructor, removing all entries.
+func (c *dnsCache) Destruct() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+	return nil
+}
+
+// remove removes an entry. The caller must hold c.mu.
+func (c *dnsCache) remove(elem *list.Element) {
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+	c.lru.Remove(elem)
+	cacheEntries.Dec()
+}
+
+// ttlResolver is implemented by resolvers that know how long their answers
+// may be cached.
+type ttlResolver interface {
+	lookupNetIPTTL(ctx context.Context, network, host string) ([]netip.Addr, time.Duration, error)
+}
+
+// caches holds the caches of resolvers, keyed by the configuration of the
+// resolver. Resolvers configured the same way share one cache, whether
+// they're in different blocks or in successive configs.
+var caches = caddy.NewUsagePool()
+
+// sharedCache returns the cache of resolvers configured by c, and the key
+// to release it with.
+func (c *ResolverConfig) sharedCache() (*dnsCache, string, error) {
+	key := string(caddyconfig.JSON(c, nil))
+	val, _, err := caches.LoadOrNew(key, func() (caddy.Destructor, error) {
+		return newDNSCache(c.CacheSize, time.Duration(c.CacheTTL)), nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return val.(*dnsCache), key, nil
+}
+
+// releaseResolver releases the shared cache of r, if it has one. The last
+// resolver to release a cache empties it.
+func releaseResolver(r resolver) {
+	if cr, ok := r.(*cachingResolver); ok && cr.key != "" {
+		caches.Delete(cr.key)
+	}
+}
+
+// cachingResolver is a resolver that caches the answers of another one.
+type cachingResolver struct {
+	next  resolver
+	cache *dnsCache
+
+	// The key of the cache in caches, if it's shared.
+	key string
+}
+
+// LookupNetIP looks up the addresses of host, or returns them from the cache.
+func (r *cachingResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	return r.cache.lookup(ctx, network, host, func(ctx context.Context) ([]netip.Addr, time.Duration, error) {
+		defer observeLookup(r.next, host, time.Now())
+		if next, ok := r.next.(ttlResolver); ok {
+			return next.lookupNetIPTTL(ctx, network, host)
+		}
+		addrs, err := r.next.LookupNetIP(ctx, network, host)
+		return addrs, 0, err
+	})
+}
@@ -0,0 +1,264 @@
+package dns
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/netip"
+	"sync/atomic"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/caddyserver/certmagic"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(new(CacheSource))
+}
+
+// The default time the cache source serves the ranges of its source before
+// asking it again.
+const DefaultCacheSourceTTL = caddy.Duration(5 * time.Minute)
+
+// CacheSource serves the ranges of another source, asking it for them only
+// every TTL. While the source is unhealthy, the cached ranges are kept, for
+// at most ServeStale longer. With Persist, the ranges are saved to storage,
+// so that the config still loads when the source fails to.
+type CacheSource struct {
+	// The source to cache.
+	SourceRaw json.RawMessage `json:"source,omitempty" caddy:"namespace=http.ip_sources inline_key=source"`
+
+	// How long to serve the ranges before asking the source again. Defaults
+	// to DefaultCacheSourceTTL.
+	TTL caddy.Duration `json:"ttl,omitempty"`
+
+	// How long past the TTL to keep serving the cached ranges while the
+	// source is unhealthy, after which there are none until it recovers. If
+	// not set, they're kept until it recovers.
+	ServeStale caddy.Duration `json:"serve_stale,omitempty"`
+
+	// Whether to save the ranges to Caddy's storage. If the source fails to
+	// load, the saved ranges are served instead, until the config is
+	// reloaded, as long as they aren't too stale.
+	Persist bool `json:"persist,omitempty"`
+
+	source     caddyhttp.IPRangeSource
+	refresher  refresher
+	storage    certmagic.Storage
+	storageKey string
+	logger     *zap.Logger
+
+	// When the cached ranges were taken from the source, in Unix
+	// nanoseconds.
+	fetched atomic.Int64
+}
+
+// CaddyModule returns the Caddy module information.
+func (*CacheSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.cache",
+		New: func() caddy.Module { return new(CacheSource) },
+	}
+}
+
+// Provision loads the source, or the saved ranges if that fails, and starts
+// refreshing the cached ranges.
+func (s *CacheSource) Provision(ctx caddy.Context) error {
+	if s.SourceRaw == nil {
+		return errors.New("no source provided")
+	}
+	if s.TTL < 0 {
+		return errors.New("ttl cannot be negative")
+	}
+	if s.ServeStale < 0 {
+		return errors.New("serve_stale cannot be negative")
+	}
+	opts := SourceOptions{Interval: s.TTL}
+	if err := opts.validate(DefaultCacheSourceTTL); err != nil {
+		return err
+	}
+	s.TTL = opts.Interval
+	s.logger = ctx.Logger()
+	if s.Persist {
+		// Loading the source clears its config, so the key is derived
+		// first.
+		s.storage = sourceStorage(ctx)
+		sum := sha256.Sum256(s.SourceRaw)
+		s.storageKey = "ip_sources/cache/" + hex.EncodeToString(sum[:8]) + ".json"
+	}
+
+	var saved []netip.Prefix
+	sources, err := loadIPSources(ctx, s, "SourceRaw")
+	if err != nil {
+		if !s.Persist {
+			return err
+		}
+		var ok bool
+		if saved, ok = s.load(ctx); !ok {
+			return err
+		}
+		s.logger.Warn("loading source failed, serving saved ranges until the config is reloaded", zap.Error(err))
+		// Without a source, there's nothing to refresh.
+		opts.Interval = IntervalOnce
+	} else {
+		s.source = sources[0]
+	}
+
+	// The source shares its own results, so the cache doesn't.
+	first := true
+	return s.refresher.start(ctx, opts, "", func(ctx context.Context) ([]netip.Prefix, error) {
+		if s.source == nil {
+			return saved, nil
+		}
+		// The source was just provisioned, so it's as healthy as it gets.
+		if !first && !sourceHealthy(s.source) {
+			return nil, errors.New("source unhealthy, keeping cached ranges")
+		}
+		first = false
+		return s.fetch(ctx), nil
+	})
+}
+
+// load returns the saved ranges, if they aren't too stale to serve.
+func (s *CacheSource) load(ctx context.Context) ([]netip.Prefix, bool) {
+	data, err := s.storage.Load(ctx, s.storageKey)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			s.logger.Warn("loading saved ranges failed", zap.Error(err))
+		}
+		return nil, false
+	}
+	var saved savedPrefixes
+	if err := json.Unmarshal(data, &saved); err != nil {
+		s.logger.Warn("invalid saved ranges", zap.Error(err))
+		return nil, false
+	}
+	if s.ServeStale > 0 && time.Since(saved.Fetched) >= time.Duration(s.TTL+s.ServeStale) {
+		return nil, false
+	}
+	prefixes, err := parsePrefixList(saved.Prefixes)
+	if err != nil {
+		s.logger.Warn("invalid saved ranges", zap.Error(err))
+		return nil, false
+	}
+	s.fetched.Store(saved.Fetched.UnixNano())
+	return prefixes, true
+}
+
+// fetch takes the ranges of the source, and saves them if configured to.
+func (s *CacheSource) fetch(ctx context.Context) []netip.Prefix {
+	// The refresher sorts the prefixes in place, and they're the source's.
+	prefixes := append([]netip.Prefix(nil), s.source.GetIPRanges(nil)...)
+	now := time.Now()
+	s.fetched.Store(now.UnixNano())
+	if !s.Persist {
+		return prefixes
+	}
+
+	strs := make([]string, len(prefixes))
+	for i, p := range prefixes {
+		strs[i] = p.String()
+	}
+	data, err := json.Marshal(savedPrefixes{Fetched: now, Prefixes: strs})
+	if err == nil {
+		err = s.storage.Store(ctx, s.storageKey, data)
+	}
+	if err != nil {
+		s.logger.Warn("saving ranges failed", zap.Error(fmt.Errorf("%s: %w", s.storageKey, err)))
+	}
+	return prefixes
+}
+
+// Cleanup stops refreshing the cached ranges.
+func (s *CacheSource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the cached ranges, unless they're too stale.
+func (s *CacheSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	if s.ServeStale > 0 && time.Since(time.Unix(0, s.fetched.Load())) >= time.Duration(s.TTL+s.ServeStale) {
+		return nil
+	}
+	return s.refresher.current()
+}
+
+// healthy reports whether the source is loaded and healthy.
+func (s *CacheSource) healthy() bool {
+	return s.source != nil && s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	cache {
+//	    source <module> ...
+//	    ttl <duration>
+//	    serve_stale <duration>
+//	    persist
+//	}
+//
+// where the source is an IP range source module, with the same syntax as in
+// trusted_proxies.
+func (s *CacheSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "source":
+			if s.SourceRaw != nil {
+				return d.Err("source already specified")
+			}
+			raw, err := unmarshalIPSource(d)
+			if err != nil {
+				return err
+			}
+			s.SourceRaw = raw
+		case "ttl", "serve_stale":
+			name := d.Val()
+			var val string
+			if !d.AllArgs(&val) {
+				return d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(val)
+			if err != nil {
+				return d.Errf("invalid %s %q: %v", name, val, err)
+			}
+			if name == "ttl" {
+				s.TTL = caddy.Duration(dur)
+			} else {
+				s.ServeStale = caddy.Duration(dur)
+			}
+		case "persist":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			s.Persist = true
+		default:
+			return d.Errf("unknown cache option %q", d.Val())
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*CacheSource)(nil)
+	_ caddy.Provisioner       = (*CacheSource)(nil)
+	_ caddy.CleanerUpper      = (*CacheSource)(nil)
+	_ caddyfile.Unmarshaler   = (*CacheSource)(nil)
+	_ caddyhttp.IPRangeSource = (*CacheSource)(nil)
+)
@@ -0,0 +1,137 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/certmagic"
+)
+
+// newListServer returns a server of a list of addresses, and a function to
+// change the list, or its status code.
+func newListServer(t *testing.T, body string) (*httptest.Server, func(code int, body string)) {
+	var (
+		mu   sync.Mutex
+		code = http.StatusOK
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.WriteHeader(code)
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func(c int, b string) {
+		mu.Lock()
+		code, body = c, b
+		mu.Unlock()
+	}
+}
+
+func TestCacheSource(t *testing.T) {
+	srv, set := newListServer(t, "192.0.2.1\n")
+
+	var s CacheSource
+	input := fmt.Sprintf(`cache {
+		source http %s
+		ttl 10ms
+		serve_stale 100ms
+	}`, srv.URL)
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprintf("%s %v %v", s.SourceRaw, s.TTL, s.ServeStale), fmt.Sprintf(`{"source":"http","url":"%s"} 10000000 100000000`, srv.URL); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cleanup()
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[192.0.2.1/32]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// Changes are picked up after the TTL.
+	source := s.source.(*HTTPSource)
+	set(http.StatusOK, "192.0.2.2\n")
+	if err := source.refresher.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "change", func() bool { return fmt.Sprint(s.GetIPRanges(nil)) == "[192.0.2.2/32]" })
+
+	// While the source fails, its ranges are served until they're too
+	// stale.
+	set(http.StatusInternalServerError, "")
+	if err := source.refresher.refresh(); err == nil {
+		t.Fatal("no error for failed refresh")
+	}
+	waitFor(t, "expiry", func() bool { return len(s.GetIPRanges(nil)) == 0 })
+	if s.healthy() {
+		t.Error("healthy with a failing source")
+	}
+}
+
+func TestCacheSourcePersist(t *testing.T) {
+	defer func(f func(caddy.Context) certmagic.Storage) { sourceStorage = f }(sourceStorage)
+	storage := &certmagic.FileStorage{Path: t.TempDir()}
+	sourceStorage = func(caddy.Context) certmagic.Storage { return storage }
+
+	srv, _ := newListServer(t, "192.0.2.1\n198.51.100.0/24\n")
+	input := fmt.Sprintf(`cache {
+		source http %s
+		persist
+	}`, srv.URL)
+	provision := func(ctx caddy.Context) (*CacheSource, error) {
+		s := new(CacheSource)
+		if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+			t.Fatalf("error parsing Caddyfile: %v", err)
+		}
+		return s, s.Provision(ctx)
+	}
+
+	// The source is cleaned up with its config, releasing its result.
+	oldCtx, cancelOld := caddy.NewContext(caddy.Context{Context: context.Background()})
+	s, err := provision(oldCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Cleanup()
+	cancelOld()
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	if keys, err := storage.List(ctx, "ip_sources/cache", false); err != nil || len(keys) != 1 {
+		t.Errorf("got saved ranges %v (%v), want one key", keys, err)
+	}
+
+	// When the source fails to load, the saved ranges are served.
+	srv.Close()
+	s, err = provision(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cleanup()
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[192.0.2.1/32 198.51.100.0/24]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if s.healthy() {
+		t.Error("healthy without a source")
+	}
+
+	var missing CacheSource
+	if err := missing.UnmarshalCaddyfile(caddyfile.NewTestDispenser(fmt.Sprintf("cache {\n source http %s/other\n persist\n}", srv.URL))); err != nil {
+		t.Fatal(err)
+	}
+	if err := missing.Provision(ctx); err == nil {
+		missing.Cleanup()
+		t.Error("no error without saved ranges")
+	}
+}
@@ -0,0 +1,138 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(new(CloudflareSource))
+}
+
+const (
+	// The default refresh interval of the Cloudflare source. Cloudflare
+	// announces changes of its ranges well in advance.
+	DefaultCloudflareInterval = caddy.Duration(24 * time.Hour)
+
+	// The API endpoint listing Cloudflare's ranges.
+	DefaultCloudflareURL = "https://api.cloudflare.com/client/v4/ips"
+)
+
+// CloudflareSource provides the IPv4 and IPv6 ranges Cloudflare connects to
+// origins from.
+type CloudflareSource struct {
+	// The URL to fetch the ranges from, in the format of Cloudflare's API.
+	// Defaults to DefaultCloudflareURL.
+	URL string `json:"url,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+}
+
+// cloudflareIPs is the response of the Cloudflare API.
+type cloudflareIPs struct {
+	Success bool `json:"success"`
+	Result  struct {
+		IPv4 []string `json:"ipv4_cidrs"`
+		IPv6 []string `json:"ipv6_cidrs"`
+	} `json:"result"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (*CloudflareSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.cloudflare",
+		New: func() caddy.Module { return new(CloudflareSource) },
+	}
+}
+
+// Provision fetches the ranges, and starts refreshing them.
+func (s *CloudflareSource) Provision(ctx caddy.Context) error {
+	if s.URL == "" {
+		s.URL = DefaultCloudflareURL
+	}
+	if err := s.SourceOptions.validate(DefaultCloudflareInterval); err != nil {
+		return err
+	}
+	return s.refresher.start(ctx, s.SourceOptions, sourceKey(s), s.fetch)
+}
+
+// fetch fetches the ranges.
+func (s *CloudflareSource) fetch(ctx context.Context) ([]netip.Prefix, error) {
+	var ips cloudflareIPs
+	if err := fetchJSON(ctx, s.URL, nil, &ips); err != nil {
+		return nil, err
+	}
+	if !ips.Success || len(ips.Result.IPv4)+len(ips.Result.IPv6) == 0 {
+		return nil, errors.New("cloudflare API returned no ranges")
+	}
+	return parsePrefixList(append(ips.Result.IPv4, ips.Result.IPv6...))
+}
+
+// Cleanup stops refreshing the ranges.
+func (s *CloudflareSource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns Cloudflare's ranges.
+func (s *CloudflareSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *CloudflareSource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	cloudflare {
+//	    url <url>
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *CloudflareSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "url":
+			if !d.AllArgs(&s.URL) {
+				return d.ArgErr()
+			}
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown cloudflare option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*CloudflareSource)(nil)
+	_ caddy.Provisioner       = (*CloudflareSource)(nil)
+	_ caddy.CleanerUpper      = (*CloudflareSource)(nil)
+	_ caddyfile.Unmarshaler   = (*CloudflareSource)(nil)
+	_ caddyhttp.IPRangeSource = (*CloudflareSource)(nil)
+)
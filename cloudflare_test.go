@@ -0,0 +1,76 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestCloudflareSource(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		fmt.Fprint(w, `{"result":{"ipv4_cidrs":["173.245.48.0/20"],"ipv6_cidrs":["2400:cb00::/32"],"etag":"x"},"success":true,"errors":[],"messages":[]}`)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	s := CloudflareSource{URL: srv.URL}
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[173.245.48.0/20 2400:cb00::/32]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// After a reload, the new config reuses the ranges fetched by the old one.
+	reloaded := CloudflareSource{URL: srv.URL}
+	if err := reloaded.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	s.Cleanup()
+	defer reloaded.Cleanup()
+	if n := requests.Load(); n != 1 {
+		t.Errorf("got %d requests, want 1", n)
+	}
+	if got := len(reloaded.GetIPRanges(nil)); got != 2 {
+		t.Errorf("got %d prefixes after reload, want 2", got)
+	}
+}
+
+func TestCloudflareSourceFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"result":{},"success":false}`)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	s := CloudflareSource{URL: srv.URL}
+	if err := s.Provision(ctx); err == nil {
+		t.Error("no error for unsuccessful response")
+	}
+	s.Cleanup()
+}
+
+func TestUnmarshalCloudflareSource(t *testing.T) {
+	var s CloudflareSource
+	input := "cloudflare {\n interval 12h\n }"
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if s.Interval != caddy.Duration(12*time.Hour) {
+		t.Errorf("interval: got %v", s.Interval)
+	}
+	if err := new(CloudflareSource).UnmarshalCaddyfile(caddyfile.NewTestDispenser("cloudflare extra")); err == nil {
+		t.Error("no error for argument")
+	}
+}
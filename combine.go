@@ -0,0 +1,153 @@
+package dns
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/netip"
+	"sync/atomic"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/fvbommel/caddy-dns-ip-range/iprange"
+)
+
+func init() {
+	caddy.RegisterModule(new(CombineSource))
+}
+
+// CombineSource provides the union of the ranges of other IP range sources,
+// for consumers that take only one, such as trusted_proxies.
+type CombineSource struct {
+	// The sources to combine.
+	SourcesRaw []json.RawMessage `json:"sources,omitempty" caddy:"namespace=http.ip_sources inline_key=source"`
+
+	sources []caddyhttp.IPRangeSource
+	union   derivedRanges
+}
+
+// CaddyModule returns the Caddy module information.
+func (*CombineSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.combine",
+		New: func() caddy.Module { return new(CombineSource) },
+	}
+}
+
+// Provision loads the sources, which are cleaned up along with the config.
+func (s *CombineSource) Provision(ctx caddy.Context) error {
+	if len(s.SourcesRaw) == 0 {
+		return errors.New("no sources provided")
+	}
+	sources, err := loadIPSources(ctx, s, "SourcesRaw")
+	if err != nil {
+		return err
+	}
+	s.sources = sources
+	return nil
+}
+
+// GetIPRanges returns the union of the ranges of the sources.
+func (s *CombineSource) GetIPRanges(r *http.Request) []netip.Prefix {
+	return s.union.get(s.sources, r, func(from [][]netip.Prefix) []netip.Prefix {
+		var prefixes []netip.Prefix
+		for _, p := range from {
+			prefixes = append(prefixes, p...)
+		}
+		return iprange.Aggregate(prefixes)
+	})
+}
+
+// healthy reports whether all sources are healthy.
+func (s *CombineSource) healthy() bool {
+	return allHealthy(s.sources)
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	combine {
+//	    source <module> ...
+//	}
+//
+// where each source is an IP range source module, with the same syntax as
+// in trusted_proxies.
+func (s *CombineSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		if d.Val() != "source" {
+			return d.Errf("unknown combine option %q", d.Val())
+		}
+		raw, err := unmarshalIPSource(d)
+		if err != nil {
+			return err
+		}
+		s.SourcesRaw = append(s.SourcesRaw, raw)
+	}
+
+	return nil
+}
+
+// derivedRanges caches ranges computed from those of other sources, until
+// any of them changes. Sources return the same slice until their ranges
+// change, so the slices are compared rather than their contents, in place,
+// so that requests don't allocate while nothing changes.
+type derivedRanges struct {
+	last atomic.Pointer[derived]
+}
+
+// derived is a result of derivedRanges, and the ranges it was computed from.
+type derived struct {
+	from     [][]netip.Prefix
+	prefixes []netip.Prefix
+}
+
+// get returns the ranges computed from those of sources for r, calling
+// compute if they differ from the last ranges.
+func (c *derivedRanges) get(sources []caddyhttp.IPRangeSource, r *http.Request, compute func(from [][]netip.Prefix) []netip.Prefix) []netip.Prefix {
+	d := c.last.Load()
+	i := 0
+	var got []netip.Prefix
+	for ; d != nil && i < len(sources); i++ {
+		if got = sources[i].GetIPRanges(r); !sameSlice(got, d.from[i]) {
+			break
+		}
+	}
+	if d != nil && i == len(sources) {
+		return d.prefixes
+	}
+
+	// The sources before i returned the last ranges, and source i returned
+	// got, if there was a last result.
+	from := make([][]netip.Prefix, len(sources))
+	if d != nil {
+		copy(from, d.from[:i])
+		from[i] = got
+		i++
+	}
+	for ; i < len(sources); i++ {
+		from[i] = sources[i].GetIPRanges(r)
+	}
+	prefixes := compute(from)
+	c.last.Store(&derived{from: from, prefixes: prefixes})
+	return prefixes
+}
+
+// sameSlice reports whether a and b are the same slice.
+func sameSlice(a, b []netip.Prefix) bool {
+	return len(a) == len(b) && (len(a) == 0 || &a[0] == &b[0])
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*CombineSource)(nil)
+	_ caddy.Provisioner       = (*CombineSource)(nil)
+	_ caddyfile.Unmarshaler   = (*CombineSource)(nil)
+	_ caddyhttp.IPRangeSource = (*CombineSource)(nil)
+)
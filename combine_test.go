@@ -0,0 +1,73 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestCombineSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxies.txt")
+	if err := os.WriteFile(path, []byte("192.0.3.0/24\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var s CombineSource
+	input := fmt.Sprintf(`combine {
+		source static 192.0.2.0/24 2001:db8::/32
+		source file %s
+	}`, path)
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprintf("%s", s.SourcesRaw), fmt.Sprintf(`[{"ranges":["192.0.2.0/24","2001:db8::/32"],"source":"static"} {"path":"%s","source":"file"}]`, path); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	got := s.GetIPRanges(nil)
+	if want := "[192.0.2.0/23 2001:db8::/32]"; fmt.Sprint(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if again := s.GetIPRanges(nil); &again[0] != &got[0] {
+		t.Error("union computed again for the same ranges")
+	}
+	if allocs := testing.AllocsPerRun(100, func() { s.GetIPRanges(nil) }); allocs != 0 {
+		t.Errorf("GetIPRanges allocates %v times per call", allocs)
+	}
+
+	waitFor(t, "update", func() bool {
+		if err := os.WriteFile(path, []byte("198.51.100.0/24\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return fmt.Sprint(s.GetIPRanges(nil)) == "[192.0.2.0/24 198.51.100.0/24 2001:db8::/32]"
+	})
+
+	for _, input := range []string{
+		`combine`,
+		`combine {
+			source unknown
+		}`,
+		`combine {
+			sources static 192.0.2.0/24
+		}`,
+	} {
+		var s CombineSource
+		err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input))
+		if err == nil {
+			err = s.Provision(ctx)
+		}
+		if err == nil {
+			t.Errorf("%s: no error", input)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(new(ConsulSource))
+}
+
+const (
+	// The default refresh interval of the Consul source. Changes are picked
+	// up with blocking queries as they happen, so this is only a fallback.
+	DefaultConsulInterval = caddy.Duration(5 * time.Minute)
+
+	// The default Consul agent, if CONSUL_HTTP_ADDR isn't set.
+	DefaultConsulAddress = "http://127.0.0.1:8500"
+)
+
+// How long a blocking query waits for changes.
+const blockingQueryWait = 5 * time.Minute
+
+// ConsulSource provides the addresses of the healthy instances of a service
+// in the Consul catalog. It watches the service with blocking queries, so
+// that changes apply as they happen, without the short TTLs of Consul DNS.
+type ConsulSource struct {
+	// The address of the Consul agent. Defaults to $CONSUL_HTTP_ADDR, or
+	// else DefaultConsulAddress.
+	Address string `json:"address,omitempty"`
+
+	// The name of the service.
+	Service string `json:"service"`
+
+	// Tags the instances must all have.
+	Tags []string `json:"tags,omitempty"`
+
+	// The datacenter to query. Defaults to that of the agent.
+	Datacenter string `json:"datacenter,omitempty"`
+
+	// The ACL token to use. Defaults to $CONSUL_HTTP_TOKEN. May use global
+	// placeholders like {env.CONSUL_TOKEN}.
+	Token string `json:"token,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+	url       string
+	header    http.Header
+}
+
+// consulServiceEntry is an instance returned by the health API.
+type consulServiceEntry struct {
+	Node struct {
+		Address string `json:"Address"`
+	} `json:"Node"`
+	Service struct {
+		Address string `json:"Address"`
+	} `json:"Service"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (*ConsulSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.consul",
+		New: func() caddy.Module { return new(ConsulSource) },
+	}
+}
+
+// Provision fetches the addresses, and starts watching for changes.
+func (s *ConsulSource) Provision(ctx caddy.Context) error {
+	if s.Service == "" {
+		return errors.New("no service provided")
+	}
+	if err := s.SourceOptions.validate(DefaultConsulInterval); err != nil {
+		return err
+	}
+
+	address := s.Address
+	if address == "" {
+		address = os.Getenv("CONSUL_HTTP_ADDR")
+	}
+	if address == "" {
+		address = DefaultConsulAddress
+	}
+	query := url.Values{"passing": {"1"}, "tag": s.Tags}
+	if s.Datacenter != "" {
+		query.Set("dc", s.Datacenter)
+	}
+	s.url = apiBase(address) + "/v1/health/service/" + url.PathEscape(s.Service) + "?" + query.Encode()
+
+	token := s.Token
+	if token == "" {
+		token = os.Getenv("CONSUL_HTTP_TOKEN")
+	}
+	s.header = make(http.Header)
+	if token != "" {
+		s.header.Set("X-Consul-Token", caddy.NewReplacer().ReplaceKnown(token, ""))
+	}
+
+	// The result is kept up to date by blocking queries, which start over
+	// after a reload, so it isn't shared.
+	if err := s.refresher.start(ctx, s.SourceOptions, "", s.fetch); err != nil {
+		return err
+	}
+	s.refresher.watch(func(ctx context.Context) error {
+		return watchBlocking(ctx, s.url, s.header, "X-Consul-Index", s.refresher.refreshNow)
+	})
+	return nil
+}
+
+// fetch fetches the addresses of the healthy instances.
+func (s *ConsulSource) fetch(ctx context.Context) ([]netip.Prefix, error) {
+	var entries []consulServiceEntry
+	if err := fetchJSON(ctx, s.url, s.header, &entries); err != nil {
+		return nil, err
+	}
+	strs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		// Instances without an address of their own use that of their node.
+		addr := entry.Service.Address
+		if addr == "" {
+			addr = entry.Node.Address
+		}
+		strs = append(strs, addr)
+	}
+	return parsePrefixList(strs)
+}
+
+// Cleanup stops refreshing the addresses.
+func (s *ConsulSource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the addresses of the instances.
+func (s *ConsulSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *ConsulSource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	consul <service> {
+//	    address <address>
+//	    tag <tag...>
+//	    datacenter <datacenter>
+//	    token <token>
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *ConsulSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	if !d.AllArgs(&s.Service) {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "address":
+			if !d.AllArgs(&s.Address) {
+				return d.ArgErr()
+			}
+		case "tag":
+			tags := d.RemainingArgs()
+			if len(tags) == 0 {
+				return d.ArgErr()
+			}
+			s.Tags = append(s.Tags, tags...)
+		case "datacenter":
+			if !d.AllArgs(&s.Datacenter) {
+				return d.ArgErr()
+			}
+		case "token":
+			if !d.AllArgs(&s.Token) {
+				return d.ArgErr()
+			}
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown consul option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// apiBase returns the base URL of an API at address, which may lack the
+// scheme.
+func apiBase(address string) string {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	return strings.TrimSuffix(address, "/")
+}
+
+// watchBlocking watches the result at rawURL with blocking queries, as
+// supported by Consul and Nomad, and calls changed whenever the index in the
+// indexHeader response header field changes. It returns when ctx is canceled
+// or a query fails.
+func watchBlocking(ctx context.Context, rawURL string, header http.Header, indexHeader string, changed func()) error {
+	var index uint64
+	for {
+		next, err := blockingQuery(ctx, rawURL, header, indexHeader, index)
+		if err != nil {
+			return err
+		}
+		if index != 0 && next != index {
+			changed()
+		}
+		// The index may go backwards, for example after a restore, which
+		// means starting over.
+		if next < index {
+			next = 0
+		}
+		index = next
+	}
+}
+
+// blockingQuery waits until the index of the result at rawURL differs from
+// index, or the wait time runs out, and returns the new index. With index 0,
+// it returns right away.
+func blockingQuery(ctx context.Context, rawURL string, header http.Header, indexHeader string, index uint64) (uint64, error) {
+	sep := "?"
+	if strings.Contains(rawURL, "?") {
+		sep = "&"
+	}
+	query := url.Values{"index": {strconv.FormatUint(index, 10)}, "wait": {blockingQueryWait.String()}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL+sep+query.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("User-Agent", sourceUserAgent)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxDocumentSize))
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("watching %s: unexpected status %s", req.URL.Redacted(), resp.Status)
+	}
+	next, err := strconv.ParseUint(resp.Header.Get(indexHeader), 10, 64)
+	if err != nil || next == 0 {
+		return 0, fmt.Errorf("watching %s: invalid %s %q", req.URL.Redacted(), indexHeader, resp.Header.Get(indexHeader))
+	}
+	return next, nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*ConsulSource)(nil)
+	_ caddy.Provisioner       = (*ConsulSource)(nil)
+	_ caddy.CleanerUpper      = (*ConsulSource)(nil)
+	_ caddyfile.Unmarshaler   = (*ConsulSource)(nil)
+	_ caddyhttp.IPRangeSource = (*ConsulSource)(nil)
+)
@@ -0,0 +1,118 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// fakeBlocking serves a document that supports blocking queries, with the
+// index in the given response header field.
+type fakeBlocking struct {
+	indexHeader string
+
+	mu      sync.Mutex
+	index   uint64
+	body    string
+	changed chan struct{}
+	queries []string
+}
+
+func newFakeBlocking(indexHeader, body string) *fakeBlocking {
+	return &fakeBlocking{indexHeader: indexHeader, index: 1, body: body, changed: make(chan struct{})}
+}
+
+func (f *fakeBlocking) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.queries = append(f.queries, r.URL.RawQuery)
+	index, changed := f.index, f.changed
+	f.mu.Unlock()
+
+	if r.URL.Query().Get("index") == strconv.FormatUint(index, 10) {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-changed:
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.Header().Set(f.indexHeader, strconv.FormatUint(f.index, 10))
+	fmt.Fprint(w, f.body)
+}
+
+// set changes the document, and wakes up blocking queries.
+func (f *fakeBlocking) set(body string) {
+	f.mu.Lock()
+	f.body = body
+	f.index++
+	close(f.changed)
+	f.changed = make(chan struct{})
+	f.mu.Unlock()
+}
+
+func TestConsulSource(t *testing.T) {
+	consul := newFakeBlocking("X-Consul-Index", `[
+		{"Node": {"Address": "10.0.0.1"}, "Service": {"Address": "10.0.1.1"}},
+		{"Node": {"Address": "10.0.0.2"}, "Service": {"Address": ""}}
+	]`)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/health/service/edge" || r.Header.Get("X-Consul-Token") != "secret" {
+			http.NotFound(w, r)
+			return
+		}
+		q := r.URL.Query()
+		if q.Get("passing") != "1" || fmt.Sprint(q["tag"]) != "[proxy]" || q.Get("dc") != "ams" {
+			http.Error(w, "unexpected query "+r.URL.RawQuery, http.StatusBadRequest)
+			return
+		}
+		consul.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	t.Setenv("CONSUL_HTTP_TOKEN", "secret")
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	s := ConsulSource{Address: srv.Listener.Addr().String(), Service: "edge", Tags: []string{"proxy"}, Datacenter: "ams"}
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cleanup()
+
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[10.0.0.2/32 10.0.1.1/32]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// Wait for the blocking query, then change the catalog.
+	waitFor(t, "blocking query", func() bool {
+		consul.mu.Lock()
+		defer consul.mu.Unlock()
+		return len(consul.queries) >= 3
+	})
+	consul.set(`[{"Node": {"Address": "10.0.0.3"}, "Service": {"Address": ""}}]`)
+	waitFor(t, "change", func() bool { return fmt.Sprint(s.GetIPRanges(nil)) == "[10.0.0.3/32]" })
+}
+
+func TestUnmarshalConsulSource(t *testing.T) {
+	var s ConsulSource
+	input := `consul edge {
+		address https://consul.internal:8501
+		tag proxy ams
+		datacenter dc1
+		token {env.CONSUL_TOKEN}
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.Service, " ", s.Address, " ", s.Tags, " ", s.Datacenter, " ", s.Token), "edge https://consul.internal:8501 [proxy ams] dc1 {env.CONSUL_TOKEN}"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,162 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(new(CrawlersSource))
+}
+
+// The default refresh interval of the crawlers source.
+const DefaultCrawlersInterval = caddy.Duration(24 * time.Hour)
+
+// The URLs of the published crawler lists, by name. They all have the
+// format of Google's cloud.json.
+var crawlerURLs = map[string]string{
+	"googlebot":               "https://developers.google.com/static/search/apis/ipranges/googlebot.json",
+	"special-crawlers":        "https://developers.google.com/static/search/apis/ipranges/special-crawlers.json",
+	"user-triggered-fetchers": "https://developers.google.com/static/search/apis/ipranges/user-triggered-fetchers.json",
+	"bingbot":                 "https://www.bing.com/toolbox/bingbot.json",
+}
+
+// The crawlers included by default.
+var defaultCrawlers = []string{"googlebot", "special-crawlers", "bingbot"}
+
+// CrawlersSource provides the ranges search engines publish for their
+// crawlers, merged into one set.
+type CrawlersSource struct {
+	// The crawlers to include: "googlebot", "special-crawlers",
+	// "user-triggered-fetchers" or "bingbot". Defaults to "googlebot",
+	// "special-crawlers" and "bingbot".
+	Crawlers []string `json:"crawlers,omitempty"`
+
+	// The URLs of more lists to include, in the same format.
+	URLs []string `json:"urls,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+}
+
+// CaddyModule returns the Caddy module information.
+func (*CrawlersSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.crawlers",
+		New: func() caddy.Module { return new(CrawlersSource) },
+	}
+}
+
+// Provision fetches the ranges, and starts refreshing them.
+func (s *CrawlersSource) Provision(ctx caddy.Context) error {
+	if len(s.Crawlers) == 0 && len(s.URLs) == 0 {
+		s.Crawlers = defaultCrawlers
+	}
+	for _, crawler := range s.Crawlers {
+		if _, ok := crawlerURLs[crawler]; !ok {
+			return fmt.Errorf("unknown crawler %q", crawler)
+		}
+	}
+	if err := s.SourceOptions.validate(DefaultCrawlersInterval); err != nil {
+		return err
+	}
+	return s.refresher.start(ctx, s.SourceOptions, sourceKey(s), s.fetch)
+}
+
+// fetch fetches all lists. If one of them fails, so does fetch, so that the
+// previous ranges are kept.
+func (s *CrawlersSource) fetch(ctx context.Context) ([]netip.Prefix, error) {
+	urls := append([]string(nil), s.URLs...)
+	for _, crawler := range s.Crawlers {
+		urls = append(urls, crawlerURLs[crawler])
+	}
+
+	var strs []string
+	for _, url := range urls {
+		var ranges gcpIPRanges
+		if err := fetchJSON(ctx, url, nil, &ranges); err != nil {
+			return nil, err
+		}
+		for _, p := range ranges.Prefixes {
+			if p.IPv4Prefix != "" {
+				strs = append(strs, p.IPv4Prefix)
+			}
+			if p.IPv6Prefix != "" {
+				strs = append(strs, p.IPv6Prefix)
+			}
+		}
+	}
+	if len(strs) == 0 {
+		return nil, errors.New("crawler lists contain no ranges")
+	}
+	return parsePrefixList(strs)
+}
+
+// Cleanup stops refreshing the ranges.
+func (s *CrawlersSource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the ranges of the crawlers.
+func (s *CrawlersSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *CrawlersSource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	crawlers [<crawler...>] {
+//	    url <url...>
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *CrawlersSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	s.Crawlers = append(s.Crawlers, d.RemainingArgs()...)
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "url":
+			urls := d.RemainingArgs()
+			if len(urls) == 0 {
+				return d.ArgErr()
+			}
+			s.URLs = append(s.URLs, urls...)
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown crawlers option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*CrawlersSource)(nil)
+	_ caddy.Provisioner       = (*CrawlersSource)(nil)
+	_ caddy.CleanerUpper      = (*CrawlersSource)(nil)
+	_ caddyfile.Unmarshaler   = (*CrawlersSource)(nil)
+	_ caddyhttp.IPRangeSource = (*CrawlersSource)(nil)
+)
@@ -0,0 +1,62 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestCrawlersSource(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/googlebot.json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"creationTime": "2023-03-28T10:00:00.000000", "prefixes": [{"ipv4Prefix": "66.249.64.0/27"}, {"ipv6Prefix": "2001:4860:4801:10::/64"}]}`)
+	})
+	mux.HandleFunc("/bingbot.json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"creationTime": "2023-03-28T10:00:00.000000", "prefixes": [{"ipv4Prefix": "157.55.39.0/24"}, {"ipv4Prefix": "66.249.64.0/27"}]}`)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	s := CrawlersSource{URLs: []string{srv.URL + "/googlebot.json", srv.URL + "/bingbot.json"}}
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[66.249.64.0/27 157.55.39.0/24 2001:4860:4801:10::/64]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	s.Cleanup()
+
+	// If one list fails, so does the source.
+	s = CrawlersSource{URLs: []string{srv.URL + "/googlebot.json", srv.URL + "/missing.json"}}
+	if err := s.Provision(ctx); err == nil {
+		t.Error("no error for missing list")
+	}
+	s.Cleanup()
+
+	s = CrawlersSource{Crawlers: []string{"yandexbot"}}
+	if err := s.Provision(ctx); err == nil {
+		t.Error("no error for unknown crawler")
+	}
+	s.Cleanup()
+}
+
+func TestUnmarshalCrawlersSource(t *testing.T) {
+	var s CrawlersSource
+	input := `crawlers googlebot bingbot {
+		url https://example.com/crawlers.json
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.Crawlers, s.URLs), "[googlebot bingbot] [https://example.com/crawlers.json]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
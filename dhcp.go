@@ -0,0 +1,376 @@
+package dns
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(new(DHCPLeasesSource))
+}
+
+// The default refresh interval of the DHCP leases source. Changes to the
+// file are picked up as they happen, but leases also expire without them.
+const DefaultDHCPLeasesInterval = caddy.Duration(time.Minute)
+
+// Supported lease file formats.
+const (
+	// The leases file of dnsmasq, with a line per lease.
+	LeasesDnsmasq = "dnsmasq"
+
+	// The dhcpd.leases file of ISC dhcpd, with a block per lease.
+	LeasesISC = "isc"
+)
+
+// DHCPLeasesSource provides the addresses leased to devices with the given
+// MAC addresses or host names, from the lease file of a DHCP server. It
+// watches the file, so that new leases apply right away.
+type DHCPLeasesSource struct {
+	// The path of the lease file.
+	Path string `json:"path"`
+
+	// The format of the file: "dnsmasq" or "isc". Detected from the file if
+	// not set.
+	Format string `json:"format,omitempty"`
+
+	// The MAC addresses of the devices to include.
+	MACs []string `json:"macs,omitempty"`
+
+	// The host names of the devices to include, as sent by the devices.
+	// Case-insensitive.
+	Hosts []string `json:"hosts,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+	macs      []string
+}
+
+// dhcpLease is a lease in a lease file.
+type dhcpLease struct {
+	addr     netip.Addr
+	mac      string
+	hostname string
+
+	// When the lease ends, or zero for leases that don't.
+	ends time.Time
+
+	// Whether the lease is still bound. Released leases stay in ISC files.
+	active bool
+}
+
+// CaddyModule returns the Caddy module information.
+func (*DHCPLeasesSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.dhcp_leases",
+		New: func() caddy.Module { return new(DHCPLeasesSource) },
+	}
+}
+
+// Provision reads the leases, and starts watching the file.
+func (s *DHCPLeasesSource) Provision(ctx caddy.Context) error {
+	if s.Path == "" {
+		return errors.New("no path provided")
+	}
+	if len(s.MACs) == 0 && len(s.Hosts) == 0 {
+		return errors.New("no MAC addresses or hosts provided")
+	}
+	switch s.Format {
+	case "", LeasesDnsmasq, LeasesISC:
+	default:
+		return fmt.Errorf("unknown lease file format %q", s.Format)
+	}
+	s.macs = make([]string, 0, len(s.MACs))
+	for _, mac := range s.MACs {
+		hw, err := net.ParseMAC(mac)
+		if err != nil {
+			return err
+		}
+		s.macs = append(s.macs, hw.String())
+	}
+	if err := s.SourceOptions.validate(DefaultDHCPLeasesInterval); err != nil {
+		return err
+	}
+	// As with the file source, the result isn't shared, so that changes
+	// made during a reload aren't missed.
+	if err := s.refresher.start(ctx, s.SourceOptions, "", s.fetch); err != nil {
+		return err
+	}
+	s.refresher.watch(func(ctx context.Context) error {
+		return watchFile(ctx, s.Path, s.refresher.refreshNow)
+	})
+	return nil
+}
+
+// fetch reads the current leases of the devices from the file.
+func (s *DHCPLeasesSource) fetch(_ context.Context) ([]netip.Prefix, error) {
+	f, err := os.Open(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxDocumentSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxDocumentSize {
+		return nil, fmt.Errorf("reading %s: file larger than %d bytes", s.Path, maxDocumentSize)
+	}
+
+	format := s.Format
+	if format == "" {
+		format = detectLeasesFormat(data)
+	}
+	var leases []dhcpLease
+	if format == LeasesISC {
+		leases, err = parseISCLeases(data)
+	} else {
+		leases, err = parseDnsmasqLeases(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.Path, err)
+	}
+
+	now := time.Now()
+	var prefixes []netip.Prefix
+	for _, lease := range leases {
+		if !lease.active || (!lease.ends.IsZero() && !lease.ends.After(now)) || !s.include(lease) {
+			continue
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(lease.addr, lease.addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// include reports whether the lease is of one of the devices.
+func (s *DHCPLeasesSource) include(lease dhcpLease) bool {
+	for _, mac := range s.macs {
+		if lease.mac == mac {
+			return true
+		}
+	}
+	return len(s.Hosts) > 0 && lease.hostname != "" && matchesAny(s.Hosts, lease.hostname)
+}
+
+// detectLeasesFormat guesses the format of a lease file: ISC files consist
+// of "lease" blocks, and dnsmasq files of lines starting with a time.
+func detectLeasesFormat(data []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := strconv.ParseInt(strings.Fields(line)[0], 10, 64); err == nil || strings.HasPrefix(line, "duid ") {
+			return LeasesDnsmasq
+		}
+		return LeasesISC
+	}
+	return LeasesDnsmasq
+}
+
+// parseDnsmasqLeases parses a dnsmasq leases file, in which each line holds
+// the expiry time (0 for never), MAC address, IP address, host name and
+// client ID of a lease. DHCPv6 leases have an IAID instead of a MAC address,
+// and follow a "duid" line.
+func parseDnsmasqLeases(data []byte) ([]dhcpLease, error) {
+	var leases []dhcpLease
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] == "duid" {
+			continue
+		}
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("line %d: expected at least 4 fields", line)
+		}
+		expiry, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid expiry time %q", line, fields[0])
+		}
+		addr, err := netip.ParseAddr(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		lease := dhcpLease{addr: addr.Unmap(), active: true}
+		if expiry != 0 {
+			lease.ends = time.Unix(expiry, 0)
+		}
+		if hw, err := net.ParseMAC(fields[1]); err == nil {
+			lease.mac = hw.String()
+		}
+		if fields[3] != "*" {
+			lease.hostname = fields[3]
+		}
+		leases = append(leases, lease)
+	}
+	return leases, scanner.Err()
+}
+
+// parseISCLeases parses an ISC dhcpd.leases file, which holds a block per
+// lease. Leases are appended as they change, so later blocks for an address
+// replace earlier ones.
+func parseISCLeases(data []byte) ([]dhcpLease, error) {
+	var (
+		leases []dhcpLease
+		index  = make(map[netip.Addr]int)
+		lease  *dhcpLease
+	)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		text = strings.TrimSuffix(strings.TrimSpace(text), ";")
+		fields := strings.Fields(text)
+		switch {
+		case len(fields) == 0:
+		case lease == nil && fields[0] == "lease":
+			if len(fields) != 3 || fields[2] != "{" {
+				return nil, fmt.Errorf("line %d: invalid lease %q", line, text)
+			}
+			addr, err := netip.ParseAddr(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			// Leases without a binding state, from old versions, are active.
+			lease = &dhcpLease{addr: addr.Unmap(), active: true}
+		case lease == nil:
+			// Other declarations, such as server-duid.
+		case fields[0] == "}":
+			if i, ok := index[lease.addr]; ok {
+				leases[i] = *lease
+			} else {
+				index[lease.addr] = len(leases)
+				leases = append(leases, *lease)
+			}
+			lease = nil
+		case fields[0] == "ends":
+			ends, err := parseISCTime(fields[1:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			lease.ends = ends
+		case len(fields) == 3 && fields[0] == "binding" && fields[1] == "state":
+			lease.active = fields[2] == "active"
+		case len(fields) == 3 && fields[0] == "hardware":
+			if hw, err := net.ParseMAC(fields[2]); err == nil {
+				lease.mac = hw.String()
+			}
+		case len(fields) == 2 && fields[0] == "client-hostname":
+			lease.hostname = strings.Trim(fields[1], `"`)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if lease != nil {
+		return nil, errors.New("unterminated lease")
+	}
+	return leases, nil
+}
+
+// parseISCTime parses a time in a lease file: "never", "<weekday>
+// <yyyy/mm/dd> <hh:mm:ss>" in UTC, or "epoch <seconds>".
+func parseISCTime(fields []string) (time.Time, error) {
+	switch {
+	case len(fields) == 1 && fields[0] == "never":
+		return time.Time{}, nil
+	case len(fields) == 2 && fields[0] == "epoch":
+		secs, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid time %q", strings.Join(fields, " "))
+		}
+		return time.Unix(secs, 0), nil
+	case len(fields) == 3:
+		return time.Parse("2006/01/02 15:04:05", fields[1]+" "+fields[2])
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", strings.Join(fields, " "))
+}
+
+// Cleanup stops watching the lease file.
+func (s *DHCPLeasesSource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the leased addresses.
+func (s *DHCPLeasesSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *DHCPLeasesSource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	dhcp_leases <path> {
+//	    format dnsmasq|isc
+//	    mac <address...>
+//	    host <host...>
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *DHCPLeasesSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	if !d.AllArgs(&s.Path) {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "format":
+			if !d.AllArgs(&s.Format) {
+				return d.ArgErr()
+			}
+		case "mac":
+			macs := d.RemainingArgs()
+			if len(macs) == 0 {
+				return d.ArgErr()
+			}
+			s.MACs = append(s.MACs, macs...)
+		case "host":
+			hosts := d.RemainingArgs()
+			if len(hosts) == 0 {
+				return d.ArgErr()
+			}
+			s.Hosts = append(s.Hosts, hosts...)
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown dhcp_leases option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*DHCPLeasesSource)(nil)
+	_ caddy.Provisioner       = (*DHCPLeasesSource)(nil)
+	_ caddy.CleanerUpper      = (*DHCPLeasesSource)(nil)
+	_ caddyfile.Unmarshaler   = (*DHCPLeasesSource)(nil)
+	_ caddyhttp.IPRangeSource = (*DHCPLeasesSource)(nil)
+)
@@ -0,0 +1,105 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+const dnsmasqLeases = `4102444800 aa:bb:cc:dd:ee:01 192.168.1.10 proxy-a 01:aa:bb:cc:dd:ee:01
+946684800 aa:bb:cc:dd:ee:02 192.168.1.11 proxy-b *
+0 AA:BB:CC:DD:EE:03 192.168.1.12 * *
+4102444800 aa:bb:cc:dd:ee:04 192.168.1.13 laptop *
+duid 00:01:00:01:2c:5e:1f:0a:aa:bb:cc:dd:ee:01
+4102444800 1234 fd00::10 proxy-a 00:01:00:01:2c:5e:1f:0a:aa:bb:cc:dd:ee:01
+`
+
+const iscLeases = `# The format of this file is documented in the dhcpd.leases(5) manual page.
+authoring-byte-order little-endian;
+
+lease 192.168.1.10 {
+  starts 4 2024/01/01 10:00:00;
+  ends 4 2024/01/01 22:00:00;
+  binding state active;
+  hardware ethernet aa:bb:cc:dd:ee:01;
+  client-hostname "proxy-a";
+}
+lease 192.168.1.11 {
+  starts 4 2024/01/01 10:00:00;
+  ends never;
+  binding state free;
+  hardware ethernet aa:bb:cc:dd:ee:02;
+}
+lease 192.168.1.12 {
+  ends epoch 4102444800; # 2100/01/01 00:00:00
+  binding state active;
+  hardware ethernet aa:bb:cc:dd:ee:03;
+}
+lease 192.168.1.10 {
+  starts 1 2099/12/28 10:00:00;
+  ends 5 2099/12/31 22:00:00;
+  binding state active;
+  hardware ethernet aa:bb:cc:dd:ee:01;
+  client-hostname "proxy-a";
+}
+`
+
+func TestDHCPLeasesSource(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"dnsmasq.leases": dnsmasqLeases, "dhcpd.leases": iscLeases} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	for _, test := range []*struct {
+		source DHCPLeasesSource
+		want   string
+	}{
+		// Expired leases are skipped, and leases without an expiry time
+		// don't expire.
+		{DHCPLeasesSource{Path: "dnsmasq.leases", Hosts: []string{"PROXY-A", "proxy-b"}, MACs: []string{"aa:bb:cc:dd:ee:03"}}, "[192.168.1.10/32 192.168.1.12/32 fd00::10/128]"},
+		// Free leases are skipped, and later leases replace earlier ones.
+		{DHCPLeasesSource{Path: "dhcpd.leases", MACs: []string{"AA-BB-CC-DD-EE-01", "aa:bb:cc:dd:ee:02", "aa:bb:cc:dd:ee:03"}}, "[192.168.1.10/32 192.168.1.12/32]"},
+		{DHCPLeasesSource{Path: "dhcpd.leases", Format: "isc", Hosts: []string{"proxy-a"}}, "[192.168.1.10/32]"},
+	} {
+		s := &test.source
+		s.Path = filepath.Join(dir, s.Path)
+		if err := s.Provision(ctx); err != nil {
+			t.Errorf("%s: %v", s.Path, err)
+			continue
+		}
+		if got := fmt.Sprint(s.GetIPRanges(nil)); got != test.want {
+			t.Errorf("%s: got %s, want %s", s.Path, got, test.want)
+		}
+		s.Cleanup()
+	}
+
+	wrong := DHCPLeasesSource{Path: filepath.Join(dir, "dhcpd.leases"), Format: "dnsmasq", Hosts: []string{"proxy-a"}}
+	if err := wrong.Provision(ctx); err == nil {
+		wrong.Cleanup()
+		t.Error("no error when parsing an ISC file as dnsmasq")
+	}
+}
+
+func TestUnmarshalDHCPLeasesSource(t *testing.T) {
+	var s DHCPLeasesSource
+	input := `dhcp_leases /var/lib/misc/dnsmasq.leases {
+		format dnsmasq
+		mac aa:bb:cc:dd:ee:01
+		host proxy-a proxy-b
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.Path, " ", s.Format, " ", s.MACs, " ", s.Hosts), "/var/lib/misc/dnsmasq.leases dnsmasq [aa:bb:cc:dd:ee:01] [proxy-a proxy-b]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
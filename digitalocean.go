@@ -0,0 +1,180 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(new(DigitalOceanSource))
+}
+
+const (
+	// The default refresh interval of the DigitalOcean source.
+	DefaultDigitalOceanInterval = caddy.Duration(24 * time.Hour)
+
+	// The URL of DigitalOcean's geofeed.
+	DefaultDigitalOceanURL = "https://digitalocean.com/geo/google.csv"
+)
+
+// DigitalOceanSource provides the ranges DigitalOcean publishes in its
+// geofeed, optionally only those of some regions.
+type DigitalOceanSource struct {
+	// The regions to include. Each matches the country code ("NL"), ISO
+	// 3166-2 subdivision ("US-NY") or city ("Amsterdam") of ranges.
+	// Defaults to all ranges. Case-insensitive.
+	Regions []string `json:"regions,omitempty"`
+
+	// The URL of the geofeed, in the format of RFC 8805. Defaults to
+	// DefaultDigitalOceanURL.
+	URL string `json:"url,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+}
+
+// CaddyModule returns the Caddy module information.
+func (*DigitalOceanSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.digitalocean",
+		New: func() caddy.Module { return new(DigitalOceanSource) },
+	}
+}
+
+// Provision fetches the ranges, and starts refreshing them.
+func (s *DigitalOceanSource) Provision(ctx caddy.Context) error {
+	if s.URL == "" {
+		s.URL = DefaultDigitalOceanURL
+	}
+	if err := s.SourceOptions.validate(DefaultDigitalOceanInterval); err != nil {
+		return err
+	}
+	return s.refresher.start(ctx, s.SourceOptions, sourceKey(s), s.fetch)
+}
+
+// fetch fetches the ranges.
+func (s *DigitalOceanSource) fetch(ctx context.Context) ([]netip.Prefix, error) {
+	data, err := fetchDocument(ctx, s.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.Comment = '#'
+	var prefixes []netip.Prefix
+	for row := 1; ; row++ {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !s.include(record[1:]) {
+			continue
+		}
+		prefix, err := parsePrefix(strings.TrimSpace(record[0]))
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", row, err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	if len(prefixes) == 0 {
+		return nil, errors.New("no DigitalOcean ranges match the regions")
+	}
+	return prefixes, nil
+}
+
+// include reports whether a range with the given geofeed location fields is
+// included.
+func (s *DigitalOceanSource) include(location []string) bool {
+	if len(s.Regions) == 0 {
+		return true
+	}
+	for i, field := range location {
+		// The fields after the city are postal codes.
+		if i < 3 && field != "" && matchesAny(s.Regions, strings.TrimSpace(field)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Cleanup stops refreshing the ranges.
+func (s *DigitalOceanSource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the selected DigitalOcean ranges.
+func (s *DigitalOceanSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *DigitalOceanSource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	digitalocean [<region...>] {
+//	    region <region...>
+//	    url <url>
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *DigitalOceanSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	s.Regions = append(s.Regions, d.RemainingArgs()...)
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "region":
+			regions := d.RemainingArgs()
+			if len(regions) == 0 {
+				return d.ArgErr()
+			}
+			s.Regions = append(s.Regions, regions...)
+		case "url":
+			if !d.AllArgs(&s.URL) {
+				return d.ArgErr()
+			}
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown digitalocean option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*DigitalOceanSource)(nil)
+	_ caddy.Provisioner       = (*DigitalOceanSource)(nil)
+	_ caddy.CleanerUpper      = (*DigitalOceanSource)(nil)
+	_ caddyfile.Unmarshaler   = (*DigitalOceanSource)(nil)
+	_ caddyhttp.IPRangeSource = (*DigitalOceanSource)(nil)
+)
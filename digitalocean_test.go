@@ -0,0 +1,65 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestDigitalOceanSource(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `# DigitalOcean geofeed
+104.131.0.0/18,US,US-NY,New York,10011
+5.101.96.0/21,NL,NL-NH,Amsterdam,1098 XG
+2a03:b0c0:0:1010::/64,NL,NL-NH,Amsterdam,1098 XG
+139.59.0.0/18,IN,IN-KA,Bengaluru,560100
+`)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	for _, test := range []struct {
+		regions []string
+		want    string
+	}{
+		{nil, "[5.101.96.0/21 104.131.0.0/18 139.59.0.0/18 2a03:b0c0:0:1010::/64]"},
+		{[]string{"nl"}, "[5.101.96.0/21 2a03:b0c0:0:1010::/64]"},
+		{[]string{"US-NY", "Bengaluru"}, "[104.131.0.0/18 139.59.0.0/18]"},
+	} {
+		s := DigitalOceanSource{URL: srv.URL, Regions: test.regions}
+		if err := s.Provision(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprint(s.GetIPRanges(nil)); got != test.want {
+			t.Errorf("regions %q: got %s, want %s", test.regions, got, test.want)
+		}
+		s.Cleanup()
+	}
+
+	// Postal codes don't match.
+	s := DigitalOceanSource{URL: srv.URL, Regions: []string{"10011"}}
+	if err := s.Provision(ctx); err == nil {
+		t.Error("no error when no ranges match")
+	}
+	s.Cleanup()
+}
+
+func TestUnmarshalDigitalOceanSource(t *testing.T) {
+	var s DigitalOceanSource
+	input := `digitalocean NL {
+		region DE
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.Regions), "[NL DE]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -1,22 +1,61 @@
 package dns
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"net"
+	"math/rand"
 	"net/http"
 	"net/netip"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/fvbommel/caddy-dns-ip-range/iprange"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 const (
 	DefaultInterval = caddy.Duration(time.Minute)
+
+	// The default maximum number of concurrent lookups of a range.
+	DefaultMaxConcurrentLookups = 4
+
+	// The default number of failed lookups in a row after which a host is
+	// reported as degraded.
+	DefaultFailureThreshold = 3
+
+	// The default number of distinct lookup results kept per host.
+	DefaultHistory = 50
+
+	// An interval meaning "resolve when provisioning and never refresh".
+	IntervalOnce = caddy.Duration(-1)
+)
+
+// Values for DNSRange.EmptyAnswer.
+const (
+	// Treat empty answers as errors.
+	EmptyAnswerError = "error"
+	// Keep the previous addresses.
+	EmptyAnswerKeep = "keep"
+	// Use an empty set of addresses.
+	EmptyAnswerEmpty = "empty"
+)
+
+// Values for DNSRange.ResolutionPolicy.
+const (
+	// Every host must resolve during provisioning.
+	ResolveAll = "all"
+	// At least one host must resolve during provisioning.
+	ResolveAny = "any"
 )
 
 func init() {
@@ -29,20 +68,253 @@ type DNSRange struct {
 	// A list of DNS names to look up.
 	Hosts []string `json:"hosts,omitempty"`
 
+	// The maximum number of hosts. Zero means no limit.
+	MaxHosts int `json:"max_hosts,omitempty"`
+
+	// The maximum number of resolved addresses of all hosts together.
+	// Updates that would exceed it are rejected. Zero means no limit.
+	MaxAddresses int `json:"max_addresses,omitempty"`
+
+	// Addresses or networks (CIDRs) to include as they are, in addition
+	// to the addresses of the hosts.
+	Static []string `json:"static,omitempty"`
+
+	// An optional name, which allows the remote_ip_dns matcher to refer to
+	// the range as "dns:<name>". Ranges of one config with the same name
+	// must have the same options.
+	Name string `json:"name,omitempty"`
+
+	// A label for the log messages of the range, which is appended to the
+	// logger name and added to each message as the "range" field. Defaults
+	// to Name.
+	LogName string `json:"log_name,omitempty"`
+
+	// The minimum level of the log messages of the range, such as "warn".
+	// This can only raise the level configured for Caddy's logs.
+	LogLevel string `json:"log_level,omitempty"`
+
 	// The refresh interval. Defaults to DefaultInterval.
+	// IntervalOnce (-1) resolves the hosts once, and never refreshes them.
 	Interval caddy.Duration `json:"interval,omitempty"`
 
-	// After provisioning, access to the addresses map is guarded by this mutex.
-	mu sync.RWMutex
+	// Randomly vary each wait between refreshes by up to this percentage of
+	// the interval, so that watchers don't all query the resolver at once.
+	Jitter int `json:"jitter,omitempty"`
+
+	// Drop the addresses of a host if they haven't been confirmed by a
+	// successful lookup for this long, even if refreshing keeps failing.
+	// Must be longer than the refresh intervals. Zero means no limit.
+	MaxAge caddy.Duration `json:"max_age,omitempty"`
+
+	// Reject updates that would add or remove more than this fraction
+	// (between 0 and 1) of the addresses of a host. Rejected updates are kept
+	// as pending until they're accepted through the admin API. Zero disables
+	// the check.
+	MaxChangeFraction float64 `json:"max_change_fraction,omitempty"`
+
+	// Whether provisioning requires "all" hosts to resolve, or just "any" of them.
+	// With "any", hosts that fail to resolve keep retrying in the background.
+	// Defaults to "all".
+	ResolutionPolicy string `json:"resolution_policy,omitempty"`
+
+	// What to do when a host exists, but has no A or AAAA records:
+	// "error" treats it like a lookup error, "keep" keeps the previous
+	// addresses and "empty" removes them. Defaults to "error".
+	//
+	// The system resolver can't tell such answers apart from non-existent
+	// hosts, so this only has an effect with other resolvers.
+	EmptyAnswer string `json:"empty_answer,omitempty"`
+
+	// Widen each resolved IPv4 address to the network with this prefix
+	// length, e.g. 24. Defaults to 32, the address itself.
+	IPv4Prefix int `json:"ipv4_prefix,omitempty"`
+
+	// Widen each resolved IPv6 address to the network with this prefix
+	// length, e.g. 64. Defaults to 128, the address itself.
+	IPv6Prefix int `json:"ipv6_prefix,omitempty"`
+
+	// Also return each IPv4 address and network in its IPv4-mapped IPv6
+	// form (::ffff:a.b.c.d), for consumers that see clients that way.
+	// Resolved IPv4-mapped addresses are always returned as plain IPv4.
+	EmitMapped bool `json:"emit_mapped,omitempty"`
+
+	// Leave out prefixes that are contained in others, e.g. an address of one
+	// host that's in the network of another host.
+	RemoveCovered bool `json:"remove_covered,omitempty"`
+
+	// Merge the adjacent and overlapping addresses of each host into the
+	// smallest list of covering prefixes (CIDRs).
+	Aggregate bool `json:"aggregate,omitempty"`
+
+	// Filters applied to the resolved addresses of all hosts.
+	Filter
+
+	// Per-host settings, keyed by host name.
+	HostOptions map[string]HostOptions `json:"host_options,omitempty"`
+
+	// Names of Caddy events that trigger an immediate refresh of all hosts.
+	// Reloading the config always resolves all hosts again.
+	RefreshOn []string `json:"refresh_on,omitempty"`
+
+	// If set, stop refreshing hosts when the range hasn't been used for this
+	// long. Refreshing resumes, starting with an immediate refresh, the next
+	// time the range is used.
+	IdleTimeout caddy.Duration `json:"idle_timeout,omitempty"`
+
+	// The maximum number of hosts that are looked up at the same time.
+	// Defaults to DefaultMaxConcurrentLookups.
+	MaxConcurrentLookups int `json:"max_concurrent_lookups,omitempty"`
+
+	// If set, how long the initial lookups may take in total while loading
+	// the config. Hosts that weren't resolved in time count as failed.
+	ProvisionTimeout caddy.Duration `json:"provision_timeout,omitempty"`
+
+	// The number of lookups of a host in a row that must fail before the
+	// host is reported as degraded. Defaults to DefaultFailureThreshold.
+	FailureThreshold int `json:"failure_threshold,omitempty"`
+
+	// If set, a host is also reported as degraded when its addresses
+	// haven't been confirmed by a lookup for this long.
+	StaleAfter caddy.Duration `json:"stale_after,omitempty"`
+
+	// The number of distinct lookup results to keep for each host, for the
+	// history endpoint of the admin API. Repeated results count once.
+	// Defaults to DefaultHistory; a negative value disables the history.
+	History int `json:"history,omitempty"`
+
+	// If set, the SOA serial of this zone is checked before each periodic
+	// refresh, and hosts are only looked up again when it has changed.
+	// This only makes sense if all hosts are in this zone.
+	SOAZone string `json:"soa_zone,omitempty"`
+
+	// The name server to query for the SOA serial, as host[:port].
+	// Defaults to the first name server in /etc/resolv.conf.
+	SOAServer string `json:"soa_server,omitempty"`
+
+	// Whether to refresh all hosts when the network configuration of this
+	// machine changes, since DNS answers often change at the same time.
+	// Supported on Linux, macOS and the BSDs.
+	RefreshOnNetworkChange bool `json:"refresh_on_network_change,omitempty"`
+
+	// Whether to emit a "dns_ip_range.changed" Caddy event when the
+	// addresses of hosts change.
+	EmitEvents bool `json:"emit_events,omitempty"`
+
+	// If set, change notifications are delayed by up to this long, and all
+	// changes within this period are sent as a single notification.
+	NotifyDebounce caddy.Duration `json:"notify_debounce,omitempty"`
+
+	// Whether to record a span for each lookup, exported over OTLP as
+	// configured by the standard OTEL_* environment variables.
+	Tracing bool `json:"tracing,omitempty"`
+
+	// HTTP requests to send when the addresses of hosts change, or when a
+	// host keeps failing to resolve.
+	Webhooks []*Webhook `json:"webhooks,omitempty"`
+
+	// How to resolve the hosts. Defaults to the system resolver.
+	Resolver *ResolverConfig `json:"resolver,omitempty"`
+
+	// Use the resolver of the dns_resolver app instead, which is shared
+	// with other modules. Can't be combined with Resolver.
+	UseGlobalResolver bool `json:"use_global_resolver,omitempty"`
+
+	// An optional liveness probe of the resolved addresses. Its results are
+	// only recorded, and don't change which addresses are returned.
+	Probe *ProbeConfig `json:"probe,omitempty"`
+
+	// Serializes rebuilds of the snapshot. The state of each host has its own
+	// lock.
+	mu sync.Mutex
+
+	// Serializes checking and applying updates if max_addresses is set, so
+	// that updates of several hosts can't exceed it together.
+	updateMu sync.Mutex
+
+	// The state of each configured host.
+	state map[string]*hostState
+
+	// The parsed static addresses.
+	static []netip.Prefix
+
+	// The merged addresses of all hosts, as returned by GetIPRanges.
+	// Replaced as a whole by rebuild, so that reading doesn't need a lock.
+	snapshot atomic.Pointer[snapshot]
+
+	// The configuration as loaded, before defaults were applied.
+	config []byte
+
+	// Looks up hosts. Set during provisioning, unless already set by tests.
+	resolver resolver
+
+	// The effective filter for each host.
+	filters map[string]*addrFilter
+
+	// Checks the SOA serial of SOAZone, if set.
+	soa *soaChecker
+
+	// Sends change notifications, if enabled.
+	notifier *notifier
+
+	// When GetIPRanges was last called, in Unix nanoseconds. Only tracked with an idle timeout.
+	lastUsed atomic.Int64
+
+	// Whether any watcher is paused because of the idle timeout.
+	paused atomic.Bool
+
+	// Schedules the refreshes of the hosts.
+	sched *scheduler
 
-	// Most recent resolved addresses of the configured hosts, stuffed into single-IP prefixes.
-	addresses map[string][]netip.Prefix
+	// The shared answer of each host, and what identifies their resolver.
+	answers   map[string]*sharedAnswer
+	answerKey any
+
+	// Identifies the answers obtained by this range.
+	id uint64
+
+	// Whether the shared answers and resolver cache were released.
+	released atomic.Bool
 
 	// Canceled when the module is being cleaned up.
 	ctx caddy.Context
 
 	// The logger.
 	logger *zap.Logger
+
+	// Records lookup spans, if tracing is enabled.
+	tracer trace.Tracer
+}
+
+// newLogger returns the logger of the range, derived from the module logger.
+func (d *DNSRange) newLogger(logger *zap.Logger) (*zap.Logger, error) {
+	if d.LogLevel != "" {
+		level, err := zapcore.ParseLevel(d.LogLevel)
+		if err != nil {
+			return nil, err
+		}
+		// Lowering the level isn't possible, and zap complains about it.
+		if level > zapcore.LevelOf(logger.Core()) {
+			logger = logger.WithOptions(zap.IncreaseLevel(level))
+		}
+	}
+
+	name := d.LogName
+	if name == "" {
+		name = d.Name
+	}
+	if name != "" {
+		logger = logger.Named(name).With(zap.String("range", name))
+	}
+	return logger, nil
+}
+
+// HostOptions contains settings for a single host, overriding the global ones.
+type HostOptions struct {
+	// The refresh interval for this host. Defaults to the global interval.
+	Interval caddy.Duration `json:"interval,omitempty"`
+
+	// Filters for this host. Unset fields inherit the global filter.
+	Filter
 }
 
 // CaddyModule returns the Caddy module information.
@@ -54,140 +326,632 @@ func (d *DNSRange) CaddyModule() caddy.ModuleInfo {
 }
 
 func (d *DNSRange) Provision(ctx caddy.Context) error {
-	d.logger = ctx.Logger()
+	d.config = caddyconfig.JSON(d, nil)
+	logger, err := d.newLogger(ctx.Logger())
+	if err != nil {
+		return err
+	}
+	d.logger = logger
 
 	// Sanity checks.
-	if len(d.Hosts) == 0 {
-		return errors.New("dns ip range: no host names provided")
+	if len(d.Hosts) == 0 && len(d.Static) == 0 {
+		return errors.New("dns ip range: no host names or static ranges provided")
+	}
+	if err := d.checkName(ctx); err != nil {
+		return err
 	}
 
-	if d.Interval < 0 {
+	if d.Interval < 0 && d.Interval != IntervalOnce {
 		return errors.New("interval cannot be negative")
 	}
 
+	if d.Jitter < 0 || d.Jitter > 100 {
+		return errors.New("jitter must be a percentage between 0 and 100")
+	}
+
+	switch d.ResolutionPolicy {
+	case "", ResolveAll, ResolveAny:
+	default:
+		return fmt.Errorf("unknown resolution policy %q", d.ResolutionPolicy)
+	}
+
+	switch d.EmptyAnswer {
+	case "", EmptyAnswerError, EmptyAnswerKeep, EmptyAnswerEmpty:
+	default:
+		return fmt.Errorf("unknown empty answer policy %q", d.EmptyAnswer)
+	}
+
+	if d.NotifyDebounce < 0 {
+		return errors.New("notify_debounce cannot be negative")
+	}
+
+	if d.IdleTimeout < 0 {
+		return errors.New("idle_timeout cannot be negative")
+	}
+
+	if d.FailureThreshold < 0 || d.StaleAfter < 0 {
+		return errors.New("failure_threshold and stale_after cannot be negative")
+	}
+
+	if d.MaxConcurrentLookups < 0 {
+		return errors.New("max_concurrent_lookups cannot be negative")
+	}
+
+	if d.ProvisionTimeout < 0 {
+		return errors.New("provision_timeout cannot be negative")
+	}
+
+	if d.MaxAge < 0 {
+		return errors.New("max_age cannot be negative")
+	}
+
+	if d.IPv4Prefix < 0 || d.IPv4Prefix > 32 {
+		return errors.New("ipv4_prefix must be between 0 and 32")
+	}
+
+	if d.IPv6Prefix < 0 || d.IPv6Prefix > 128 {
+		return errors.New("ipv6_prefix must be between 0 and 128")
+	}
+
+	if d.MaxHosts < 0 || d.MaxAddresses < 0 {
+		return errors.New("max_hosts and max_addresses cannot be negative")
+	}
+
+	if d.MaxChangeFraction < 0 || d.MaxChangeFraction > 1 {
+		return errors.New("max_change_fraction must be between 0 and 1")
+	}
+
+	if d.Probe != nil {
+		if err := d.Probe.validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, w := range d.Webhooks {
+		if err := w.validate(); err != nil {
+			return err
+		}
+	}
+
 	// Set defaults.
 	if d.Interval == 0 {
 		d.Interval = DefaultInterval
 	}
+	if d.MaxConcurrentLookups == 0 {
+		d.MaxConcurrentLookups = DefaultMaxConcurrentLookups
+	}
+	if d.FailureThreshold == 0 {
+		d.FailureThreshold = DefaultFailureThreshold
+	}
+	if d.History == 0 {
+		d.History = DefaultHistory
+	}
+	if d.ResolutionPolicy == "" {
+		d.ResolutionPolicy = ResolveAll
+	}
+	if d.EmptyAnswer == "" {
+		d.EmptyAnswer = EmptyAnswerError
+	}
 
 	// Initialize internal fields.
-	d.addresses = make(map[string][]netip.Prefix)
+	var resolverKey any = d.resolver
+	if d.resolver == nil {
+		var (
+			r   resolver
+			err error
+		)
+		if d.UseGlobalResolver {
+			if d.Resolver != nil {
+				return errResolverConflict
+			}
+			r, resolverKey, err = globalResolver(ctx)
+		} else {
+			r, err = d.Resolver.newResolver()
+			resolverKey = string(caddyconfig.JSON(d.Resolver, nil))
+		}
+		if err != nil {
+			return err
+		}
+		d.resolver = r
+	}
+	d.filters = make(map[string]*addrFilter, len(d.Hosts))
 	d.ctx = ctx
+	d.lastUsed.Store(time.Now().UnixNano())
 
-	// Perform initial lookups.
-	d.mu.Lock()
-	defer d.mu.Unlock()
+	// Remove duplicate hosts, which would otherwise get multiple watchers.
+	known := make(map[string]bool, len(d.Hosts))
+	hosts := d.Hosts[:0]
 	for _, host := range d.Hosts {
-		// Look up initial IPs and store them as prefixes
-		addresses, err := d.initialLookup(host)
+		if !known[host] {
+			known[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	d.Hosts = hosts
+	d.state = make(map[string]*hostState, len(d.Hosts))
+	for _, host := range d.Hosts {
+		d.state[host] = &hostState{historySize: d.History}
+	}
+	if d.MaxHosts > 0 && len(d.Hosts) > d.MaxHosts {
+		return fmt.Errorf("%d hosts configured, more than max_hosts (%d)", len(d.Hosts), d.MaxHosts)
+	}
+
+	for host, opts := range d.HostOptions {
+		if !known[host] {
+			return fmt.Errorf("host options given for unknown host %q", host)
+		}
+		if opts.Interval < 0 && opts.Interval != IntervalOnce {
+			return fmt.Errorf("interval for host %q cannot be negative", host)
+		}
+	}
+	for _, host := range d.Hosts {
+		filter, err := d.Filter.override(d.HostOptions[host].Filter).compile()
 		if err != nil {
-			return fmt.Errorf("error looking up DNS name %q: %w", host, err)
+			return fmt.Errorf("invalid filter for host %q: %w", host, err)
+		}
+		d.filters[host] = filter
+
+		if d.MaxAge > 0 && d.interval(host) < 0 {
+			return fmt.Errorf("max_age can't be used with host %q, which is only resolved once", host)
+		}
+		if d.MaxAge > 0 && d.interval(host) >= time.Duration(d.MaxAge) {
+			return fmt.Errorf("max_age must be longer than the interval of host %q", host)
 		}
+	}
+
+	static, err := parsePrefixes(d.Static)
+	if err != nil {
+		return fmt.Errorf("invalid static range: %w", err)
+	}
+	d.static = static
+
+	d.sched = newScheduler(d)
+	if err := d.acquireAnswers(resolverKey); err != nil {
+		return err
+	}
 
-		d.addresses[host] = addresses
+	if d.SOAZone != "" {
+		// Share each serial between watchers for half of the shortest interval.
+		var shortest time.Duration
+		for _, host := range d.Hosts {
+			if interval := d.interval(host); interval > 0 && (shortest == 0 || interval < shortest) {
+				shortest = interval
+			}
+		}
+		soa, err := newSOAChecker(d.SOAZone, d.SOAServer, shortest/2)
+		if err != nil {
+			return err
+		}
+		d.soa = soa
 	}
 
+	if err := d.subscribeRefresh(ctx); err != nil {
+		return err
+	}
+	if err := d.setupNotifier(ctx); err != nil {
+		return err
+	}
+	if err := d.setupTracing(); err != nil {
+		return fmt.Errorf("setting up tracing: %w", err)
+	}
+	if d.RefreshOnNetworkChange {
+		if err := d.refreshOnNetworkChange(); err != nil {
+			return fmt.Errorf("watching network changes: %w", err)
+		}
+	}
+
+	// Perform initial lookups.
+	lookupCtx := context.Context(ctx)
+	if d.ProvisionTimeout > 0 {
+		var cancel context.CancelFunc
+		lookupCtx, cancel = context.WithTimeout(ctx, time.Duration(d.ProvisionTimeout))
+		defer cancel()
+	}
+	results := d.initialLookups(lookupCtx)
+
+	var (
+		lastErr  error
+		resolved bool
+	)
+	for i, host := range d.Hosts {
+		addresses, err := results[i].prefixes, results[i].err
+		if err != nil {
+			err = fmt.Errorf("error looking up DNS name %q: %w", host, err)
+			if d.ResolutionPolicy == ResolveAll {
+				return err
+			}
+			d.logger.Warn("initial lookup failed, retrying in the background",
+				zap.String("host", host),
+				zap.Error(err))
+			lastErr = err
+			continue
+		}
+
+		h := d.state[host]
+		h.mu.Lock()
+		h.set(addresses)
+		h.mu.Unlock()
+		resolved = true
+	}
+	if n := d.countAddresses(); d.MaxAddresses > 0 && n > d.MaxAddresses {
+		return fmt.Errorf("hosts resolved to %d addresses, more than max_addresses (%d)", n, d.MaxAddresses)
+	}
+	d.publish()
+
+	if lastErr != nil && !resolved {
+		return lastErr
+	}
+
+	if d.Probe != nil {
+		go d.keepProbing()
+	}
+
+	d.register()
+
 	return nil
 }
 
-func (d *DNSRange) GetIPRanges(_ *http.Request) (result []netip.Prefix) {
-	d.mu.RLock()
-	defer d.mu.RUnlock()
+// Cleanup implements caddy.CleanerUpper.
+func (d *DNSRange) Cleanup() error {
+	d.unregister()
+	if !d.released.Swap(true) {
+		d.releaseAnswers()
+		if !d.UseGlobalResolver {
+			releaseResolver(d.resolver)
+		}
+		d.releaseTracing()
+	}
+	if d.notifier != nil {
+		d.notifier.stop()
+	}
+	return nil
+}
 
-	for _, addrs := range d.addresses {
-		result = append(result, addrs...)
+// GetIPRanges returns the addresses of all hosts, sorted and without
+// duplicates. The result is shared between callers, and must not be modified.
+func (d *DNSRange) GetIPRanges(_ *http.Request) []netip.Prefix {
+	d.used()
+	return d.current()
+}
+
+// healthy reports whether none of the hosts are degraded, as reported by
+// the health endpoint.
+func (d *DNSRange) healthy() bool {
+	return len(d.degradedHosts(time.Now())) == 0
+}
+
+// contains reports whether addr is in the range, without allocating.
+// IPv4-mapped addresses match the IPv4 addresses they represent.
+func (d *DNSRange) contains(addr netip.Addr) bool {
+	d.used()
+	if snap := d.currentSnapshot(); snap != nil {
+		return snap.set.Contains(addr)
+	}
+	return false
+}
+
+// used records that the range is in use, resuming refreshes if they were
+// paused because of the idle timeout.
+func (d *DNSRange) used() {
+	if d.IdleTimeout > 0 {
+		d.lastUsed.Store(time.Now().UnixNano())
+		if d.paused.CompareAndSwap(true, false) {
+			d.logger.Debug("range used again, resuming DNS watchers")
+			d.triggerRefresh("")
+		}
 	}
+}
 
-	return result
+// initialResult is the result of an initial lookup.
+type initialResult struct {
+	prefixes []netip.Prefix
+	err      error
 }
 
-func (d *DNSRange) initialLookup(host string) ([]netip.Prefix, error) {
-	prefixes, err := d.lookupHostPrefixes(host)
+// initialLookups looks up all hosts, up to MaxConcurrentLookups at a time. The
+// results are in the order of d.Hosts.
+func (d *DNSRange) initialLookups(ctx context.Context) []initialResult {
+	results := make([]initialResult, len(d.Hosts))
+	sem := make(chan struct{}, d.MaxConcurrentLookups)
+	var wg sync.WaitGroup
+	for i, host := range d.Hosts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, host string) {
+			defer wg.Done()
+			results[i].prefixes, results[i].err = d.initialLookup(ctx, host)
+			<-sem
+		}(i, host)
+	}
+	wg.Wait()
+	return results
+}
+
+func (d *DNSRange) initialLookup(ctx context.Context, host string) ([]netip.Prefix, error) {
+	// Loading a config always resolves the host again.
+	prefixes, err := d.lookupHostPrefixes(ctx, host, 0)
+	d.failingWebhooks(host, d.state[host].attempted(err), err)
+	// Initial results are applied as they are, or the config fails.
+	d.state[host].record(prefixes, err)
+	if errors.Is(err, errNoRecords) && d.EmptyAnswer == EmptyAnswerKeep {
+		// There's nothing to keep yet.
+		prefixes, err = []netip.Prefix{}, nil
+	}
 
 	// If we're successful, keep this host updated.
+	// If any host suffices, keep retrying failed ones too.
 	if err == nil {
-		go d.keepUpdated(host)
+		if d.interval(host) >= 0 {
+			d.sched.add(host, d.interval(host))
+		}
+	} else if d.ResolutionPolicy == ResolveAny {
+		d.sched.add(host, ttlAfterErr)
 	}
 
 	return prefixes, err
 }
 
-func (d *DNSRange) keepUpdated(host string) {
-	const ttlAfterErr = time.Minute
+// stale reports whether the addresses v of a host are older than the
+// maximum age.
+func (d *DNSRange) stale(v *hostView, now time.Time) bool {
+	return d.MaxAge > 0 && now.Sub(v.confirmed) > time.Duration(d.MaxAge)
+}
 
-	d.logger.Info("starting DNS watcher", zap.String("host", host))
+// expire drops the addresses of host if they're stale.
+func (d *DNSRange) expire(host string) {
+	h := d.state[host]
+	h.mu.Lock()
+	v := h.current()
+	expired := v != nil && d.stale(v, time.Now())
+	if expired {
+		h.drop()
+	}
+	h.mu.Unlock()
 
-	done := d.ctx.Done()
-	freq := time.Duration(d.Interval)
-	ticker := time.NewTicker(freq)
-	defer ticker.Stop()
+	if !expired {
+		return
+	}
+	d.publish()
+	d.logger.Warn("addresses not confirmed within max_age, dropping them",
+		zap.String("host", host),
+		zap.Duration("max_age", time.Duration(d.MaxAge)))
+	if len(v.addresses) > 0 && d.notifier != nil {
+		d.notifier.changed(host, v.addresses, []netip.Prefix{})
+	}
+}
 
-	for {
-		select {
-		case <-done:
-			d.logger.Info("stopping DNS watcher", zap.String("host", host))
-			return
-		case <-ticker.C:
-			// fall through
-		}
+// How long to wait before retrying a failed lookup.
+const ttlAfterErr = time.Minute
 
-		// Look up host.
-		prefixes, err := d.lookupHostPrefixes(host)
-		newFreq := time.Duration(d.Interval)
-		if err == nil {
-			d.mu.Lock()
-			d.addresses[host] = prefixes
-			d.mu.Unlock()
-		} else {
-			// TODO: Inspect error. Treat NXDOMAIN as empty result?
+// idle reports whether the range hasn't been used for longer than the idle timeout.
+func (d *DNSRange) idle() bool {
+	return d.IdleTimeout > 0 && time.Since(time.Unix(0, d.lastUsed.Load())) > time.Duration(d.IdleTimeout)
+}
 
-			// Log unhandled error
-			d.logger.Warn("DNS lookup error",
-				zap.String("host", host),
-				zap.Error(err))
+// pause marks the range as paused if it's idle, and reports whether the
+// refreshes of host should wait until the range is used again.
+func (d *DNSRange) pause(host string) bool {
+	if !d.idle() {
+		return false
+	}
+	d.paused.Store(true)
+	// The range may have been used just before we paused.
+	if !d.idle() {
+		return false
+	}
+	d.logger.Debug("pausing idle DNS watcher", zap.String("host", host))
+	return true
+}
 
-			// Check again after a while.
-			// TODO: Exponential backoff?
-			newFreq = ttlAfterErr
-		}
+// interval returns the refresh interval of host.
+func (d *DNSRange) interval(host string) time.Duration {
+	if interval := d.HostOptions[host].Interval; interval != 0 {
+		return time.Duration(interval)
+	}
+	return time.Duration(d.Interval)
+}
+
+// jittered randomly varies freq by up to the configured jitter percentage.
+func (d *DNSRange) jittered(freq time.Duration) time.Duration {
+	if d.Jitter == 0 {
+		return freq
+	}
+	max := int64(freq) * int64(d.Jitter) / 100
+	return freq + time.Duration(rand.Int63n(2*max+1)-max)
+}
+
+// refreshHost looks up the host of j again, and returns when to do so next.
+// A negative result means the host shouldn't be refreshed anymore.
+func (d *DNSRange) refreshHost(j *job, forced bool) time.Duration {
+	host := j.host
+	start := time.Now()
+	defer func() { refreshDuration.Observe(time.Since(start).Seconds()) }()
+	if forced {
+		d.logger.Debug("refresh requested", zap.String("host", host))
+	}
 
-		// Has the update frequency changed?
-		if newFreq != freq {
-			ticker.Reset(newFreq)
-			freq = newFreq
+	// Skip the lookup if the zone hasn't changed since the last one.
+	var (
+		newSerial uint32
+		soaErr    = errors.New("SOA check disabled")
+	)
+	if d.soa != nil {
+		newSerial, soaErr = d.soa.current(d.ctx)
+		if soaErr != nil {
+			d.logger.Debug("SOA query failed", zap.String("zone", d.SOAZone), zap.Error(soaErr))
+		} else if j.haveSerial && newSerial == j.serial && !forced {
+			d.logger.Debug("SOA serial unchanged, skipping lookup",
+				zap.String("host", host),
+				zap.Uint32("serial", j.serial))
+			return d.interval(host)
 		}
 	}
+
+	// Look up host. Another range may have done so recently, unless we're
+	// asked for fresh addresses.
+	reuse := d.interval(host)
+	if forced {
+		reuse = 0
+	}
+	prefixes, err := d.lookupHostPrefixes(d.ctx, host, reuse)
+	d.failingWebhooks(host, d.state[host].attempted(err), err)
+	j.serial, j.haveSerial = newSerial, err == nil && soaErr == nil
+	var rejected *rejectedUpdate
+	if errors.As(err, &rejected) {
+		d.hold(host, rejected.addresses, rejected.reason)
+		d.expire(host)
+	} else if errors.Is(err, errNoRecords) && d.EmptyAnswer == EmptyAnswerKeep {
+		d.logger.Debug("empty answer, keeping previous addresses", zap.String("host", host))
+		d.expire(host)
+	} else if err == nil {
+		err = d.update(host, prefixes)
+	} else {
+		// Empty answers only get here with the "error" empty_answer
+		// policy. NXDOMAIN is always an error, since the system resolver
+		// reports empty answers the same way.
+		d.logger.Warn("DNS lookup error",
+			zap.String("host", host),
+			zap.Error(err))
+
+		d.expire(host)
+		d.state[host].record(prefixes, err)
+
+		// Check again after a while.
+		// TODO: Exponential backoff?
+		return ttlAfterErr
+	}
+
+	d.state[host].record(prefixes, err)
+	return d.interval(host)
 }
 
-func (d *DNSRange) lookupHostPrefixes(host string) (prefixes []netip.Prefix, err error) {
-	ips, err := net.LookupHost(host)
+// lookupHostPrefixes looks up host and applies the options of the range to
+// the answer. Answers of other ranges up to reuse old are used as well.
+func (d *DNSRange) lookupHostPrefixes(ctx context.Context, host string, reuse time.Duration) (prefixes []netip.Prefix, err error) {
+	ctx, span := d.startLookupSpan(ctx, host)
+	addrs, err := d.lookupHost(ctx, host, reuse)
+	endLookupSpan(span, len(addrs), err)
+	if err == nil && len(addrs) == 0 {
+		err = errNoRecords
+	}
+	if errors.Is(err, errNoRecords) && d.EmptyAnswer == EmptyAnswerEmpty {
+		recordLookup(host, nil)
+		d.logger.Debug("empty answer", zap.String("host", host))
+		return []netip.Prefix{}, nil
+	}
+	if ctx.Err() == nil {
+		// Lookups canceled because of a config change aren't failures.
+		recordLookup(host, err)
+	}
 	if err != nil {
-		d.logger.Warn("DNS error", zap.Error(err))
+		if !errors.Is(err, errNoRecords) {
+			d.logger.Warn("DNS error", zap.Error(err))
+		}
 		return nil, err
 	}
 
-	prefixes = make([]netip.Prefix, 0, len(ips))
+	prefixes = make([]netip.Prefix, 0, len(addrs))
+	filter := d.filters[host]
 
-	for _, ip := range ips {
-		addr, err := netip.ParseAddr(ip)
-		if err == nil {
-			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
-		} else {
-			d.logger.Warn("ignoring invalid IP address", zap.String("ip", ip), zap.Error(err))
+	// With a rejecting filter, the whole answer is kept for review.
+	var (
+		all    []netip.Prefix
+		reject string
+	)
+
+	for _, addr := range addrs {
+		// Link-local IPv6 addresses may come with the zone of the interface
+		// they were found on. Prefixes can't have zones, and client addresses
+		// are matched without theirs, so the zone is dropped.
+		if addr.Zone() != "" {
+			d.logger.Debug("ignoring zone of IPv6 address",
+				zap.String("host", host),
+				zap.Stringer("ip", addr))
+			addr = addr.WithZone("")
+		}
+		// Client addresses of IPv4 connections are plain IPv4.
+		addr = addr.Unmap()
+		prefix := d.prefixOf(addr)
+		if filter.rejectUpdate && !containsPrefix(all, prefix) {
+			all = append(all, prefix)
+		}
+		if reason := filter.check(addr); reason != "" {
+			switch {
+			case filter.rejects(reason):
+				if reject == "" {
+					reject = addr.String() + ": " + reason
+				}
+			case reason == reasonExcluded:
+				d.logger.Debug("ignoring excluded IP address",
+					zap.String("host", host),
+					zap.Stringer("ip", addr))
+			default:
+				d.logger.Warn("ignoring filtered IP address",
+					zap.String("host", host),
+					zap.Stringer("ip", addr),
+					zap.String("reason", reason))
+			}
+			continue
+		}
+		if prefix.Bits() != addr.BitLen() && containsPrefix(prefixes, prefix) {
+			// Another address is in the same network.
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+	}
+
+	if reject != "" {
+		if d.Aggregate {
+			all = iprange.Aggregate(all)
 		}
+		sortPrefixes(all)
+		return nil, &rejectedUpdate{addresses: all, reason: reject}
+	}
+
+	// Widened networks may still contain excluded addresses.
+	if len(filter.exclude) > 0 && (d.IPv4Prefix != 0 || d.IPv6Prefix != 0) {
+		prefixes = filter.subtractExcluded(prefixes)
 	}
 
-	if len(prefixes) == 0 && cap(prefixes) != 0 {
-		return nil, errors.New("all returned IP addresses were invalid")
+	if d.Aggregate && len(prefixes) > 1 {
+		prefixes = iprange.Aggregate(prefixes)
 	}
 
+	// Resolvers may return addresses in any order.
+	sortPrefixes(prefixes)
+
 	d.logger.Debug("DNS results",
 		zap.String("host", host),
-		zap.Strings("addresses", ips))
+		zap.Stringers("addresses", addrs))
 
+	if h := d.state[host]; h != nil {
+		h.answered(addrs)
+	}
 	return prefixes, nil
 }
 
+// prefixOf returns the prefix containing addr, of the configured length.
+func (d *DNSRange) prefixOf(addr netip.Addr) netip.Prefix {
+	bits := addr.BitLen()
+	if addr.Is4() && d.IPv4Prefix != 0 {
+		bits = d.IPv4Prefix
+	} else if addr.Is6() && d.IPv6Prefix != 0 {
+		bits = d.IPv6Prefix
+	}
+	prefix, _ := addr.Prefix(bits)
+	return prefix
+}
+
+// containsPrefix reports whether prefixes contains p.
+func containsPrefix(prefixes []netip.Prefix, p netip.Prefix) bool {
+	for _, q := range prefixes {
+		if q == p {
+			return true
+		}
+	}
+	return false
+}
+
 // UnmarshalCaddyfile implements caddyfile.Unmarshaler.
 //
 // Example config, if you're running cloudflared on the same Docker bridge network as Caddy:
@@ -205,8 +969,36 @@ func (d *DNSRange) lookupHostPrefixes(host string) (prefixes []netip.Prefix, err
 //	    interval 1m
 //	}
 //
+// To succeed as long as at least one host resolves:
+//
+//	trusted_proxies dns proxy-1.example.com proxy-2.example.com {
+//	    resolution_policy any
+//	}
+//
 // Multiple host names are supported, all on the same line and/or
 // in multiple host directives.
+//
+// The refresh interval can be overridden per host:
+//
+//	trusted_proxies dns {
+//	    host cloudflared 15s
+//	    host proxy.corp.example.com {
+//	        interval 1h
+//	    }
+//	    host static.example.com {
+//	        # Resolve when loading the config, and never again.
+//	        interval once
+//	    }
+//	}
+//
+// Filters can be set for all hosts, and overridden in a block after a host directive:
+//
+//	trusted_proxies dns proxy.example.com {
+//	    reject private loopback
+//	    host internal-proxy {
+//	        reject none
+//	    }
+//	}
 func (m *DNSRange) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 	if !d.Next() {
 		return nil
@@ -218,32 +1010,393 @@ func (m *DNSRange) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 	for nesting := d.Nesting(); d.NextBlock(nesting); {
 		switch d.Val() {
 		case "host":
+			if err := m.unmarshalHost(d); err != nil {
+				return err
+			}
+
+		case "max_hosts", "max_addresses":
+			option := d.Val()
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			n, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return d.WrapErr(err)
+			}
+			if option == "max_hosts" {
+				m.MaxHosts = n
+			} else {
+				m.MaxAddresses = n
+			}
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+
+		case "static", "cidr":
 			args := d.RemainingArgs()
 			if len(args) == 0 {
 				return d.ArgErr()
 			}
-			m.Hosts = append(m.Hosts, args...)
+			m.Static = append(m.Static, args...)
+
+		case "name":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			m.Name = d.Val()
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+
+		case "log_name":
+			if !d.AllArgs(&m.LogName) {
+				return d.ArgErr()
+			}
+
+		case "log_level":
+			if !d.AllArgs(&m.LogLevel) {
+				return d.ArgErr()
+			}
 
 		case "interval":
 			if !d.NextArg() {
 				return d.Err("expected duration")
 			}
-			interval, err := caddy.ParseDuration(d.Val())
+			interval, err := parseInterval(d.Val())
+			if err != nil {
+				return d.WrapErr(err)
+			}
+			m.Interval = interval
+
+		case "jitter":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			jitter, err := strconv.Atoi(strings.TrimSuffix(d.Val(), "%"))
+			if err != nil {
+				return d.WrapErr(err)
+			}
+			m.Jitter = jitter
+
+		case "dns_json":
+			if !d.NextArg() {
+				return d.Err("expected URL")
+			}
+			if m.Resolver == nil {
+				m.Resolver = new(ResolverConfig)
+			}
+			m.Resolver.DNSJSON = d.Val()
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+
+		case "refresh_on":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return d.ArgErr()
+			}
+			m.RefreshOn = append(m.RefreshOn, args...)
+
+		case "idle_timeout":
+			if !d.NextArg() {
+				return d.Err("expected duration")
+			}
+			timeout, err := caddy.ParseDuration(d.Val())
 			if err != nil {
 				return d.WrapErr(err)
 			}
-			m.Interval = caddy.Duration(interval)
+			m.IdleTimeout = caddy.Duration(timeout)
+
+		case "max_concurrent_lookups":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			n, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return d.WrapErr(err)
+			}
+			m.MaxConcurrentLookups = n
+
+		case "provision_timeout":
+			if !d.NextArg() {
+				return d.Err("expected duration")
+			}
+			timeout, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.WrapErr(err)
+			}
+			m.ProvisionTimeout = caddy.Duration(timeout)
+
+		case "failure_threshold":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			n, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return d.WrapErr(err)
+			}
+			m.FailureThreshold = n
+
+		case "history":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			n, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return d.WrapErr(err)
+			}
+			m.History = n
+
+		case "stale_after":
+			if !d.NextArg() {
+				return d.Err("expected duration")
+			}
+			stale, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.WrapErr(err)
+			}
+			m.StaleAfter = caddy.Duration(stale)
+
+		case "soa_zone":
+			args := d.RemainingArgs()
+			if len(args) < 1 || len(args) > 2 {
+				return d.ArgErr()
+			}
+			m.SOAZone = args[0]
+			if len(args) == 2 {
+				m.SOAServer = args[1]
+			}
+
+		case "refresh_on_network_change":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			m.RefreshOnNetworkChange = true
+
+		case "tracing":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			m.Tracing = true
+
+		case "emit_events":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			m.EmitEvents = true
+
+		case "notify_debounce":
+			if !d.NextArg() {
+				return d.Err("expected duration")
+			}
+			debounce, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.WrapErr(err)
+			}
+			m.NotifyDebounce = caddy.Duration(debounce)
+
+		case "max_age":
+			if !d.NextArg() {
+				return d.Err("expected duration")
+			}
+			maxAge, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.WrapErr(err)
+			}
+			m.MaxAge = caddy.Duration(maxAge)
+
+		case "ipv4_prefix", "ipv6_prefix":
+			option := d.Val()
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			bits, err := strconv.Atoi(strings.TrimPrefix(d.Val(), "/"))
+			if err != nil {
+				return d.WrapErr(err)
+			}
+			if option == "ipv4_prefix" {
+				m.IPv4Prefix = bits
+			} else {
+				m.IPv6Prefix = bits
+			}
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+
+		case "emit_mapped":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			m.EmitMapped = true
+
+		case "remove_covered":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			m.RemoveCovered = true
+
+		case "aggregate":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			m.Aggregate = true
+
+		case "max_change_fraction":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			fraction, err := strconv.ParseFloat(d.Val(), 64)
+			if err != nil {
+				return d.WrapErr(err)
+			}
+			m.MaxChangeFraction = fraction
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+
+		case "empty_answer":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			m.EmptyAnswer = d.Val()
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+
+		case "resolver_mode":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			if m.Resolver == nil {
+				m.Resolver = new(ResolverConfig)
+			}
+			m.Resolver.Mode = d.Val()
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+
+		case "cache_size", "cache_ttl":
+			if m.Resolver == nil {
+				m.Resolver = new(ResolverConfig)
+			}
+			if _, err := m.Resolver.unmarshalResolver(d); err != nil {
+				return err
+			}
+
+		case "use_global_resolver":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			m.UseGlobalResolver = true
+
+		case "resolution_policy":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			m.ResolutionPolicy = d.Val()
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+
+		case "probe":
+			probe, err := unmarshalProbe(d)
+			if err != nil {
+				return err
+			}
+			m.Probe = probe
+
+		case "webhook":
+			w, err := unmarshalWebhook(d)
+			if err != nil {
+				return err
+			}
+			m.Webhooks = append(m.Webhooks, w)
+
+		default:
+			if _, err := m.Filter.unmarshalFilter(d); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// unmarshalHost parses a host directive:
+//
+//	host <name...> [<interval>] {
+//	    interval <duration>
+//	    <filter options>
+//	}
+//
+// If there are multiple arguments and the last one is a duration, it's
+// used as the interval for the preceding hosts.
+func (m *DNSRange) unmarshalHost(d *caddyfile.Dispenser) error {
+	args := d.RemainingArgs()
+	if len(args) == 0 {
+		return d.ArgErr()
+	}
+
+	var opts HostOptions
+	hasOpts := false
+	if len(args) > 1 {
+		if interval, err := caddy.ParseDuration(args[len(args)-1]); err == nil {
+			opts.Interval = caddy.Duration(interval)
+			hasOpts = true
+			args = args[:len(args)-1]
+		}
+	}
+	m.Hosts = append(m.Hosts, args...)
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		hasOpts = true
+		if d.Val() == "interval" {
+			if !d.NextArg() {
+				return d.Err("expected duration")
+			}
+			interval, err := parseInterval(d.Val())
+			if err != nil {
+				return d.WrapErr(err)
+			}
+			opts.Interval = interval
+			continue
+		}
+
+		ok, err := opts.unmarshalFilter(d)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return d.Errf("unknown host option %q", d.Val())
+		}
+	}
+
+	if hasOpts {
+		if m.HostOptions == nil {
+			m.HostOptions = make(map[string]HostOptions)
+		}
+		for _, host := range args {
+			m.HostOptions[host] = opts
 		}
-		// TODO: some way of specifying error handling for network errors/NXDOMAIN?
 	}
 
 	return nil
 }
 
+// parseInterval parses a refresh interval, which is either a duration or "once".
+func parseInterval(s string) (caddy.Duration, error) {
+	if s == "once" {
+		return IntervalOnce, nil
+	}
+	interval, err := caddy.ParseDuration(s)
+	return caddy.Duration(interval), err
+}
+
 // Interface guards
 var (
 	_ caddy.Module            = (*DNSRange)(nil)
 	_ caddy.Provisioner       = (*DNSRange)(nil)
+	_ caddy.CleanerUpper      = (*DNSRange)(nil)
 	_ caddyfile.Unmarshaler   = (*DNSRange)(nil)
 	_ caddyhttp.IPRangeSource = (*DNSRange)(nil)
 )
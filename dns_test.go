@@ -2,9 +2,19 @@ package dns
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func TestLookup(t *testing.T) {
@@ -32,3 +42,645 @@ func TestLookup(t *testing.T) {
 		}
 	}
 }
+
+func TestUnmarshalCaddyfile(t *testing.T) {
+	input := `dns proxy-1.example.com {
+		host proxy-2.example.com proxy-3.example.com
+		interval 30s
+		jitter 10%
+		resolution_policy any
+	}`
+
+	var r DNSRange
+	if err := r.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+
+	want := DNSRange{
+		Hosts:            []string{"proxy-1.example.com", "proxy-2.example.com", "proxy-3.example.com"},
+		Interval:         caddy.Duration(30 * time.Second),
+		Jitter:           10,
+		ResolutionPolicy: ResolveAny,
+	}
+	if !reflect.DeepEqual(r.Hosts, want.Hosts) {
+		t.Errorf("hosts: got %v, want %v", r.Hosts, want.Hosts)
+	}
+	if r.Interval != want.Interval {
+		t.Errorf("interval: got %v, want %v", r.Interval, want.Interval)
+	}
+	if r.Jitter != want.Jitter {
+		t.Errorf("jitter: got %d, want %d", r.Jitter, want.Jitter)
+	}
+	if r.ResolutionPolicy != want.ResolutionPolicy {
+		t.Errorf("resolution policy: got %q, want %q", r.ResolutionPolicy, want.ResolutionPolicy)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	r := DNSRange{Jitter: 20}
+	const freq = time.Minute
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 100; i++ {
+		got := r.jittered(freq)
+		if got < 48*time.Second || got > 72*time.Second {
+			t.Fatalf("jittered(%v) = %v, outside of 20%%", freq, got)
+		}
+		seen[got] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("jittered(%v) doesn't vary", freq)
+	}
+}
+
+func TestUnmarshalHostInterval(t *testing.T) {
+	input := `dns {
+		host cloudflared 15s
+		host proxy-1.corp proxy-2.corp {
+			interval 1h
+		}
+		host plain.example.com
+	}`
+
+	var r DNSRange
+	if err := r.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+
+	if want := []string{"cloudflared", "proxy-1.corp", "proxy-2.corp", "plain.example.com"}; !reflect.DeepEqual(r.Hosts, want) {
+		t.Errorf("hosts: got %v, want %v", r.Hosts, want)
+	}
+
+	r.Interval = DefaultInterval
+	for host, want := range map[string]time.Duration{
+		"cloudflared":       15 * time.Second,
+		"proxy-1.corp":      time.Hour,
+		"proxy-2.corp":      time.Hour,
+		"plain.example.com": time.Duration(DefaultInterval),
+	} {
+		if got := r.interval(host); got != want {
+			t.Errorf("interval(%s) = %v, want %v", host, got, want)
+		}
+	}
+}
+
+// provision provisions r using res, returning a function to cancel its context.
+// The module is cleaned up when the test ends.
+func provision(t *testing.T, r *DNSRange, res resolver) (context.CancelFunc, error) {
+	t.Helper()
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	r.resolver = res
+	t.Cleanup(func() { r.Cleanup() })
+	return cancel, r.Provision(ctx)
+}
+
+func TestResolutionPolicy(t *testing.T) {
+	res := &fakeResolver{hosts: map[string][]string{"up.example.com": {"192.0.2.1"}}}
+
+	r := &DNSRange{Hosts: []string{"up.example.com", "down.example.com"}}
+	cancel, err := provision(t, r, res)
+	cancel()
+	if err == nil {
+		t.Errorf("policy all: expected error")
+	}
+
+	r = &DNSRange{Hosts: []string{"up.example.com", "down.example.com"}, ResolutionPolicy: ResolveAny}
+	cancel, err = provision(t, r, res)
+	defer cancel()
+	if err != nil {
+		t.Fatalf("policy any: %v", err)
+	}
+	if got := r.GetIPRanges(nil); len(got) != 1 {
+		t.Errorf("policy any: got %v, want 1 prefix", got)
+	}
+
+	r = &DNSRange{Hosts: []string{"down.example.com"}, ResolutionPolicy: ResolveAny}
+	cancel, err = provision(t, r, res)
+	cancel()
+	if err == nil {
+		t.Errorf("policy any without results: expected error")
+	}
+}
+
+func TestEmptyAnswer(t *testing.T) {
+	res := &fakeResolver{
+		hosts: map[string][]string{"up.example.com": {"192.0.2.1"}},
+		errs:  map[string]error{"empty.example.com": errNoRecords},
+	}
+	hosts := []string{"up.example.com", "empty.example.com"}
+
+	r := DNSRange{Hosts: hosts}
+	cancel, err := provision(t, &r, res)
+	cancel()
+	if err == nil {
+		t.Errorf("policy error: expected error")
+	}
+
+	for _, policy := range []string{EmptyAnswerKeep, EmptyAnswerEmpty} {
+		r := DNSRange{Hosts: hosts, EmptyAnswer: policy}
+		cancel, err := provision(t, &r, res)
+		cancel()
+		if err != nil {
+			t.Errorf("policy %s: %v", policy, err)
+			continue
+		}
+		if got := r.GetIPRanges(nil); len(got) != 1 {
+			t.Errorf("policy %s: got %v, want 1 prefix", policy, got)
+		}
+	}
+}
+
+func TestManyHosts(t *testing.T) {
+	const n = 10000
+
+	// Generate a Caddyfile with hosts split over inline arguments,
+	// a long host directive and many repeated host blocks.
+	res := &fakeResolver{hosts: make(map[string][]string, n)}
+	var sb strings.Builder
+	sb.WriteString("dns")
+	for i := 0; i < n; i++ {
+		host := fmt.Sprintf("host-%d.example.com", i)
+		res.hosts[host] = []string{fmt.Sprintf("10.%d.%d.%d", i>>16, (i>>8)&0xff, i&0xff)}
+		switch {
+		case i < n/3:
+			sb.WriteString(" " + host)
+		case i == n/3:
+			sb.WriteString(" {\n\thost " + host)
+		case i < 2*n/3:
+			sb.WriteString(" " + host)
+		default:
+			sb.WriteString("\n\thost " + host + " {\n\t\treject loopback\n\t}")
+		}
+	}
+	sb.WriteString("\n\thost host-0.example.com\n}")
+
+	var r DNSRange
+	if err := r.UnmarshalCaddyfile(caddyfile.NewTestDispenser(sb.String())); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if len(r.Hosts) != n+1 {
+		t.Fatalf("got %d hosts, want %d", len(r.Hosts), n+1)
+	}
+
+	r.Interval = caddy.Duration(time.Hour)
+	cancel, err := provision(t, &r, res)
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.Hosts) != n {
+		t.Errorf("got %d hosts after removing duplicates, want %d", len(r.Hosts), n)
+	}
+	if got := r.GetIPRanges(nil); len(got) != n {
+		t.Errorf("got %d prefixes, want %d", len(got), n)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	res := &fakeResolver{hosts: map[string][]string{"proxy.example.com": {"192.0.2.1"}}}
+	r := DNSRange{
+		Hosts:       []string{"proxy.example.com"},
+		Interval:    caddy.Duration(time.Millisecond),
+		IdleTimeout: caddy.Duration(20 * time.Millisecond),
+	}
+	cancel, err := provision(t, &r, res)
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "watcher to pause", r.paused.Load)
+	paused := res.count()
+	time.Sleep(20 * time.Millisecond)
+	if n := res.count(); n != paused {
+		t.Errorf("%d lookups while paused", n-paused)
+	}
+
+	r.GetIPRanges(nil)
+	waitFor(t, "watcher to resume", func() bool { return res.count() > paused })
+}
+
+func TestIntervalOnce(t *testing.T) {
+	input := `dns static.example.com {
+		interval once
+	}`
+	var r DNSRange
+	if err := r.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if r.Interval != IntervalOnce {
+		t.Fatalf("interval: got %v, want %v", r.Interval, IntervalOnce)
+	}
+
+	res := &fakeResolver{hosts: map[string][]string{"static.example.com": {"192.0.2.1"}}}
+	cancel, err := provision(t, &r, res)
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.GetIPRanges(nil); len(got) != 1 {
+		t.Errorf("got %v, want 1 prefix", got)
+	}
+	if got := r.triggerRefresh(""); len(got) != 0 {
+		t.Errorf("triggered refresh of %v", got)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if n := res.count(); n != 1 {
+		t.Errorf("got %d lookups, want 1", n)
+	}
+}
+
+func TestMaxAge(t *testing.T) {
+	res := &fakeResolver{
+		hosts: map[string][]string{
+			"a.example.com": {"192.0.2.1"},
+			"b.example.com": {"192.0.2.2"},
+		},
+		errs: map[string]error{},
+	}
+	r := DNSRange{
+		Hosts:    []string{"a.example.com", "b.example.com"},
+		Interval: caddy.Duration(10 * time.Millisecond),
+		MaxAge:   caddy.Duration(50 * time.Millisecond),
+	}
+	cancel, err := provision(t, &r, res)
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res.mu.Lock()
+	res.errs["a.example.com"] = errors.New("SERVFAIL")
+	res.mu.Unlock()
+
+	waitFor(t, "stale addresses to be dropped", func() bool {
+		got := r.GetIPRanges(nil)
+		return len(got) == 1 && got[0].Addr().String() == "192.0.2.2"
+	})
+
+	bad := DNSRange{
+		Hosts:    []string{"a.example.com"},
+		Interval: caddy.Duration(time.Minute),
+		MaxAge:   caddy.Duration(time.Second),
+	}
+	cancel, err = provision(t, &bad, res)
+	defer cancel()
+	if err == nil {
+		t.Error("expected error for max_age shorter than interval")
+	}
+}
+
+func TestPrefixLength(t *testing.T) {
+	input := `dns proxy.example.com {
+		ipv4_prefix 24
+		ipv6_prefix /64
+	}`
+	var r DNSRange
+	if err := r.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if r.IPv4Prefix != 24 || r.IPv6Prefix != 64 {
+		t.Fatalf("got prefix lengths %d and %d", r.IPv4Prefix, r.IPv6Prefix)
+	}
+
+	res := &fakeResolver{hosts: map[string][]string{
+		"proxy.example.com": {"192.0.2.1", "192.0.2.200", "198.51.100.1", "2001:db8::1", "2001:db8::2"},
+	}}
+	cancel, err := provision(t, &r, res)
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, prefix := range r.GetIPRanges(nil) {
+		got = append(got, prefix.String())
+	}
+	want := []string{"192.0.2.0/24", "198.51.100.0/24", "2001:db8::/64"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	var addrs []string
+	for i := 64; i < 128; i++ {
+		addrs = append(addrs, fmt.Sprintf("192.0.2.%d", i))
+	}
+	addrs = append(addrs, "198.51.100.1")
+	res := &fakeResolver{hosts: map[string][]string{"proxy.example.com": addrs}}
+
+	r := DNSRange{Hosts: []string{"proxy.example.com"}, Aggregate: true}
+	cancel, err := provision(t, &r, res)
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, prefix := range r.GetIPRanges(nil) {
+		got = append(got, prefix.String())
+	}
+	want := []string{"192.0.2.64/26", "198.51.100.1/32"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestDeduplicate(t *testing.T) {
+	res := &fakeResolver{hosts: map[string][]string{
+		"a.example.com": {"192.0.2.1", "198.51.100.1"},
+		"b.example.com": {"192.0.2.1", "192.0.2.2"},
+		"c.example.com": {"198.51.100.0"},
+	}}
+	r := DNSRange{Hosts: []string{"a.example.com", "b.example.com"}}
+	cancel, err := provision(t, &r, res)
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, prefix := range r.GetIPRanges(nil) {
+		got = append(got, prefix.String())
+	}
+	want := []string{"192.0.2.1/32", "192.0.2.2/32", "198.51.100.1/32"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Give c a network containing addresses of a and b.
+	r2 := DNSRange{
+		Hosts:         []string{"a.example.com", "b.example.com", "c.example.com"},
+		RemoveCovered: true,
+	}
+	cancel, err = provision(t, &r2, res)
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2.update("c.example.com", []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")})
+
+	got = got[:0]
+	for _, prefix := range r2.GetIPRanges(nil) {
+		got = append(got, prefix.String())
+	}
+	want = []string{"192.0.2.0/24", "198.51.100.1/32"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("remove_covered: got %v, want %v", got, want)
+	}
+}
+
+func TestRemoveCovered(t *testing.T) {
+	var prefixes []netip.Prefix
+	for _, s := range []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.3/32", "11.0.0.1/32", "2001:db8::/32", "2001:db8::1/128", "::ffff:10.0.0.1/128"} {
+		prefixes = append(prefixes, netip.MustParsePrefix(s))
+	}
+	sortPrefixes(prefixes)
+
+	var got []string
+	for _, prefix := range removeCovered(prefixes) {
+		got = append(got, prefix.String())
+	}
+	want := []string{"10.0.0.0/8", "11.0.0.1/32", "::ffff:10.0.0.1/128", "2001:db8::/32"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestStatic(t *testing.T) {
+	input := `dns proxy.example.com {
+		static 10.0.0.0/8 192.0.2.1
+		cidr 2001:db8::/32
+	}`
+	var r DNSRange
+	if err := r.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+
+	res := &fakeResolver{hosts: map[string][]string{"proxy.example.com": {"192.0.2.1", "198.51.100.1"}}}
+	cancel, err := provision(t, &r, res)
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, prefix := range r.GetIPRanges(nil) {
+		got = append(got, prefix.String())
+	}
+	want := []string{"10.0.0.0/8", "192.0.2.1/32", "198.51.100.1/32", "2001:db8::/32"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Static ranges alone are fine too.
+	only := DNSRange{Static: []string{"10.0.0.0/8"}}
+	cancel, err = provision(t, &only, res)
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := only.GetIPRanges(nil); len(got) != 1 {
+		t.Errorf("static only: got %v", got)
+	}
+}
+
+func TestMappedAddresses(t *testing.T) {
+	res := &fakeResolver{hosts: map[string][]string{"proxy.example.com": {"::ffff:10.0.0.5", "2001:db8::1"}}}
+	r := DNSRange{Hosts: []string{"proxy.example.com"}, Static: []string{"192.0.2.0/24"}}
+	cancel, err := provision(t, &r, res)
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	str := func(prefixes []netip.Prefix) (result []string) {
+		for _, prefix := range prefixes {
+			result = append(result, prefix.String())
+		}
+		return result
+	}
+	want := []string{"10.0.0.5/32", "192.0.2.0/24", "2001:db8::1/128"}
+	if got := str(r.GetIPRanges(nil)); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	both := DNSRange{Hosts: []string{"proxy.example.com"}, Static: []string{"192.0.2.0/24"}, EmitMapped: true}
+	cancel, err = provision(t, &both, res)
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"10.0.0.5/32", "192.0.2.0/24", "::ffff:10.0.0.5/128", "::ffff:192.0.2.0/120", "2001:db8::1/128"}
+	if got := str(both.GetIPRanges(nil)); !reflect.DeepEqual(got, want) {
+		t.Errorf("emit_mapped: got %v, want %v", got, want)
+	}
+}
+
+func TestSortedOutput(t *testing.T) {
+	res := &fakeResolver{hosts: map[string][]string{
+		"a.example.com": {"192.0.2.9", "2001:db8::1", "192.0.2.1"},
+		"b.example.com": {"198.51.100.1", "10.0.0.1"},
+		"c.example.com": {"192.0.2.5"},
+	}}
+	r := DNSRange{
+		Hosts:    []string{"c.example.com", "a.example.com", "b.example.com"},
+		Interval: caddy.Duration(time.Millisecond),
+	}
+	cancel, err := provision(t, &r, res)
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"10.0.0.1/32", "192.0.2.1/32", "192.0.2.5/32", "192.0.2.9/32", "198.51.100.1/32", "2001:db8::1/128"}
+	for i := 0; i < 10; i++ {
+		// Shuffle the answers, which shouldn't change the output.
+		addrs := res.hosts["a.example.com"]
+		res.set("a.example.com", addrs[1], addrs[2], addrs[0])
+
+		var got []string
+		for _, prefix := range r.GetIPRanges(nil) {
+			got = append(got, prefix.String())
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+
+		host := r.state["a.example.com"].current().addresses
+		if host[0].String() != "192.0.2.1/32" {
+			t.Errorf("per-host addresses aren't sorted: %v", host)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+}
+
+func BenchmarkGetIPRanges(b *testing.B) {
+	res := &fakeResolver{hosts: make(map[string][]string)}
+	r := DNSRange{Interval: caddy.Duration(time.Hour)}
+	for i := 0; i < 100; i++ {
+		host := fmt.Sprintf("host-%d.example.com", i)
+		res.hosts[host] = []string{fmt.Sprintf("10.0.0.%d", i), fmt.Sprintf("10.0.1.%d", i)}
+		r.Hosts = append(r.Hosts, host)
+	}
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	r.resolver = res
+	if err := r.Provision(ctx); err != nil {
+		b.Fatal(err)
+	}
+	defer r.Cleanup()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if len(r.GetIPRanges(nil)) != 200 {
+				b.Fatal("wrong number of prefixes")
+			}
+		}
+	})
+}
+
+func TestGetIPRangesAllocs(t *testing.T) {
+	res := &fakeResolver{hosts: map[string][]string{
+		"a.example.com": {"192.0.2.1", "192.0.2.2"},
+		"b.example.com": {"198.51.100.1"},
+	}}
+	r := DNSRange{Hosts: []string{"a.example.com", "b.example.com"}, Interval: caddy.Duration(time.Hour)}
+	cancel, err := provision(t, &r, res)
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The merged result is computed when addresses change, not per call.
+	if allocs := testing.AllocsPerRun(100, func() { r.GetIPRanges(nil) }); allocs != 0 {
+		t.Errorf("GetIPRanges allocates %v times per call", allocs)
+	}
+
+	addr := netip.MustParseAddr("198.51.100.1")
+	if allocs := testing.AllocsPerRun(100, func() { r.contains(addr) }); allocs != 0 {
+		t.Errorf("contains allocates %v times per call", allocs)
+	}
+	if !r.contains(addr) || r.contains(netip.MustParseAddr("198.51.100.2")) {
+		t.Error("contains gives wrong results")
+	}
+}
+
+func TestProvisionTimeout(t *testing.T) {
+	res := &blockingResolver{release: make(chan struct{})}
+	defer close(res.release)
+
+	r := &DNSRange{
+		Hosts:            []string{"a.example.com", "b.example.com", "c.example.com"},
+		ProvisionTimeout: caddy.Duration(50 * time.Millisecond),
+	}
+	start := time.Now()
+	cancel, err := provision(t, r, res)
+	defer cancel()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("provisioning took %v", elapsed)
+	}
+
+	// All hosts were looked up at the same time.
+	waitFor(t, "all lookups to start", func() bool {
+		res.mu.Lock()
+		defer res.mu.Unlock()
+		return res.lookups == 3
+	})
+}
+
+func TestZonedAddresses(t *testing.T) {
+	res := &fakeResolver{hosts: map[string][]string{"router.example.com": {"fe80::1%eth0", "192.0.2.1"}}}
+	r := DNSRange{Hosts: []string{"router.example.com"}}
+	cancel, err := provision(t, &r, res)
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := fmt.Sprint(r.GetIPRanges(nil)), "[192.0.2.1/32 fe80::1/128]"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if !r.contains(netip.MustParseAddr("fe80::1")) {
+		t.Error("zoned address not matched without its zone")
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	base := zap.New(core).Named("http.ip_sources.dns")
+
+	r := DNSRange{Name: "proxies", LogName: "site-a", LogLevel: "warn"}
+	logger, err := r.newLogger(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("dropped")
+	logger.Warn("kept")
+
+	entries := logs.AllUntimed()
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	entry := entries[0]
+	if entry.Message != "kept" || entry.LoggerName != "http.ip_sources.dns.site-a" ||
+		entry.ContextMap()["range"] != "site-a" {
+		t.Errorf("got %+v", entry)
+	}
+
+	r = DNSRange{Name: "proxies"}
+	if logger, _ = r.newLogger(base); !logger.Core().Enabled(zapcore.DebugLevel) {
+		t.Error("debug messages dropped without log_level")
+	}
+
+	r = DNSRange{LogLevel: "loud"}
+	if _, err := r.newLogger(base); err == nil {
+		t.Error("no error for invalid log level")
+	}
+}
@@ -0,0 +1,318 @@
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(new(DockerSource))
+}
+
+const (
+	// The default refresh interval of the Docker source. Changes are picked
+	// up from the events API as they happen, so this is only a fallback.
+	DefaultDockerInterval = caddy.Duration(5 * time.Minute)
+
+	// The default Docker host, if DOCKER_HOST isn't set.
+	DefaultDockerHost = "unix:///var/run/docker.sock"
+)
+
+// The label Docker Compose sets to the service name of containers.
+const composeServiceLabel = "com.docker.compose.service"
+
+// DockerSource provides the IP addresses of Docker containers, selected by
+// container name, Compose service or network. It watches Docker's events, so
+// that changes apply as soon as containers start or stop.
+type DockerSource struct {
+	// The Docker API endpoint: "unix://<path>", "tcp://<host>:<port>" or an
+	// HTTP(S) URL. Defaults to $DOCKER_HOST, or else DefaultDockerHost.
+	Host string `json:"host,omitempty"`
+
+	// The names of the containers to include.
+	Containers []string `json:"containers,omitempty"`
+
+	// The Compose services whose containers to include.
+	Services []string `json:"services,omitempty"`
+
+	// The networks whose addresses to include. If no containers or
+	// services are set, all containers on these networks are included.
+	// Defaults to all networks.
+	Networks []string `json:"networks,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+	client    *dockerClient
+}
+
+// dockerContainer is a container, as listed by the API.
+type dockerContainer struct {
+	Names           []string          `json:"Names"`
+	Labels          map[string]string `json:"Labels"`
+	NetworkSettings struct {
+		Networks map[string]struct {
+			IPAddress         string `json:"IPAddress"`
+			GlobalIPv6Address string `json:"GlobalIPv6Address"`
+		} `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (*DockerSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.docker",
+		New: func() caddy.Module { return new(DockerSource) },
+	}
+}
+
+// Provision fetches the addresses, and starts watching for changes.
+func (s *DockerSource) Provision(ctx caddy.Context) error {
+	if len(s.Containers) == 0 && len(s.Services) == 0 && len(s.Networks) == 0 {
+		return errors.New("no containers, services or networks provided")
+	}
+	client, err := newDockerClient(s.Host)
+	if err != nil {
+		return err
+	}
+	s.client = client
+	if err := s.SourceOptions.validate(DefaultDockerInterval); err != nil {
+		return err
+	}
+	// Containers are fetched again on every start, since their addresses
+	// change when they restart, so the result isn't shared across reloads.
+	if err := s.refresher.start(ctx, s.SourceOptions, "", s.fetch); err != nil {
+		return err
+	}
+	s.refresher.watch(func(ctx context.Context) error {
+		return s.client.events(ctx, []string{"container", "network"}, s.refresher.refreshNow)
+	})
+	return nil
+}
+
+// fetch fetches the addresses of the selected containers.
+func (s *DockerSource) fetch(ctx context.Context) ([]netip.Prefix, error) {
+	var containers []dockerContainer
+	if err := s.client.get(ctx, "/containers/json", &containers); err != nil {
+		return nil, err
+	}
+
+	var strs []string
+	for _, c := range containers {
+		if !s.include(c) {
+			continue
+		}
+		for name, network := range c.NetworkSettings.Networks {
+			if len(s.Networks) > 0 && !matchesAny(s.Networks, name) {
+				continue
+			}
+			for _, addr := range []string{network.IPAddress, network.GlobalIPv6Address} {
+				if addr != "" {
+					strs = append(strs, addr)
+				}
+			}
+		}
+	}
+	return parsePrefixList(strs)
+}
+
+// include reports whether the container c is selected.
+func (s *DockerSource) include(c dockerContainer) bool {
+	if len(s.Containers) == 0 && len(s.Services) == 0 {
+		return true
+	}
+	if len(s.Containers) > 0 {
+		for _, name := range c.Names {
+			if matchesAny(s.Containers, strings.TrimPrefix(name, "/")) {
+				return true
+			}
+		}
+	}
+	if service, ok := c.Labels[composeServiceLabel]; ok && len(s.Services) > 0 {
+		return matchesAny(s.Services, service)
+	}
+	return false
+}
+
+// Cleanup stops refreshing the addresses.
+func (s *DockerSource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the addresses of the containers.
+func (s *DockerSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *DockerSource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	docker [<container...>] {
+//	    host <host>
+//	    container <name...>
+//	    service <service...>
+//	    network <network...>
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *DockerSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	s.Containers = append(s.Containers, d.RemainingArgs()...)
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "host":
+			if !d.AllArgs(&s.Host) {
+				return d.ArgErr()
+			}
+		case "container":
+			containers := d.RemainingArgs()
+			if len(containers) == 0 {
+				return d.ArgErr()
+			}
+			s.Containers = append(s.Containers, containers...)
+		case "service":
+			services := d.RemainingArgs()
+			if len(services) == 0 {
+				return d.ArgErr()
+			}
+			s.Services = append(s.Services, services...)
+		case "network":
+			networks := d.RemainingArgs()
+			if len(networks) == 0 {
+				return d.ArgErr()
+			}
+			s.Networks = append(s.Networks, networks...)
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown docker option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// dockerClient talks to the Docker API.
+type dockerClient struct {
+	base   string
+	client *http.Client
+}
+
+// newDockerClient returns a client for the Docker API at host, which
+// defaults to $DOCKER_HOST or DefaultDockerHost.
+func newDockerClient(host string) (*dockerClient, error) {
+	if host == "" {
+		host = os.Getenv("DOCKER_HOST")
+	}
+	if host == "" {
+		host = DefaultDockerHost
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Docker host %q: %w", host, err)
+	}
+
+	switch u.Scheme {
+	case "unix":
+		path := u.Path
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		}
+		return &dockerClient{base: "http://docker", client: &http.Client{Transport: transport}}, nil
+	case "tcp":
+		return &dockerClient{base: "http://" + u.Host, client: http.DefaultClient}, nil
+	case "http", "https":
+		return &dockerClient{base: strings.TrimSuffix(host, "/"), client: http.DefaultClient}, nil
+	}
+	return nil, fmt.Errorf("unsupported Docker host %q", host)
+}
+
+// do sends a GET request for path, and checks the status of the response.
+func (c *dockerClient) do(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("docker API %s: unexpected status %s", req.URL.Path, resp.Status)
+	}
+	return resp, nil
+}
+
+// get fetches path into v.
+func (c *dockerClient) get(ctx context.Context, path string, v any) error {
+	resp, err := c.do(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("docker API %s: %w", path, err)
+	}
+	return nil
+}
+
+// events streams the events of the given types, and calls changed for each
+// of them, until ctx is canceled or the stream fails.
+func (c *dockerClient) events(ctx context.Context, types []string, changed func()) error {
+	filters, err := json.Marshal(map[string][]string{"type": types})
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, "/events?filters="+url.QueryEscape(string(filters)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var event json.RawMessage
+		if err := dec.Decode(&event); err != nil {
+			return fmt.Errorf("docker events: %w", err)
+		}
+		changed()
+	}
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*DockerSource)(nil)
+	_ caddy.Provisioner       = (*DockerSource)(nil)
+	_ caddy.CleanerUpper      = (*DockerSource)(nil)
+	_ caddyfile.Unmarshaler   = (*DockerSource)(nil)
+	_ caddyhttp.IPRangeSource = (*DockerSource)(nil)
+)
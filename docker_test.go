@@ -0,0 +1,156 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// fakeDocker serves the parts of the Docker API used by the Docker source.
+type fakeDocker struct {
+	mu         sync.Mutex
+	containers string
+	events     chan string
+}
+
+func (f *fakeDocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/containers/json":
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		fmt.Fprint(w, f.containers)
+	case "/events":
+		if !strings.Contains(r.URL.Query().Get("filters"), "container") {
+			http.Error(w, "missing filter", http.StatusBadRequest)
+			return
+		}
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case event := <-f.events:
+				fmt.Fprintln(w, event)
+				w.(http.Flusher).Flush()
+			}
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeDocker) setContainers(containers string) {
+	f.mu.Lock()
+	f.containers = containers
+	f.mu.Unlock()
+}
+
+const testDockerContainers = `[
+	{"Names": ["/app-web-1"], "Labels": {"com.docker.compose.service": "web"}, "NetworkSettings": {"Networks": {
+		"app_default": {"IPAddress": "172.18.0.2", "GlobalIPv6Address": "fd00::2"},
+		"proxy": {"IPAddress": "172.19.0.2", "GlobalIPv6Address": ""}}}},
+	{"Names": ["/app-web-2"], "Labels": {"com.docker.compose.service": "web"}, "NetworkSettings": {"Networks": {
+		"app_default": {"IPAddress": "172.18.0.3", "GlobalIPv6Address": ""}}}},
+	{"Names": ["/db"], "Labels": {}, "NetworkSettings": {"Networks": {
+		"bridge": {"IPAddress": "172.17.0.2", "GlobalIPv6Address": ""}}}}
+]`
+
+func TestDockerSource(t *testing.T) {
+	docker := &fakeDocker{containers: testDockerContainers, events: make(chan string)}
+	srv := httptest.NewServer(docker)
+	defer srv.Close()
+	host := "tcp://" + srv.Listener.Addr().String()
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	for _, test := range []struct {
+		containers, services, networks []string
+		want                           string
+	}{
+		{[]string{"db"}, nil, nil, "[172.17.0.2/32]"},
+		{nil, []string{"web"}, nil, "[172.18.0.2/32 172.18.0.3/32 172.19.0.2/32 fd00::2/128]"},
+		{nil, []string{"web"}, []string{"proxy"}, "[172.19.0.2/32]"},
+		{[]string{"db"}, []string{"web"}, []string{"bridge"}, "[172.17.0.2/32]"},
+		{nil, nil, []string{"app_default"}, "[172.18.0.2/32 172.18.0.3/32 fd00::2/128]"},
+	} {
+		s := DockerSource{Host: host, Containers: test.containers, Services: test.services, Networks: test.networks}
+		if err := s.Provision(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprint(s.GetIPRanges(nil)); got != test.want {
+			t.Errorf("containers %q, services %q, networks %q: got %s, want %s",
+				test.containers, test.services, test.networks, got, test.want)
+		}
+		s.Cleanup()
+	}
+}
+
+func TestDockerSourceEvents(t *testing.T) {
+	docker := &fakeDocker{containers: testDockerContainers, events: make(chan string)}
+	srv := httptest.NewServer(docker)
+	defer srv.Close()
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	s := DockerSource{Host: "tcp://" + srv.Listener.Addr().String(), Containers: []string{"db"}}
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cleanup()
+
+	// The container restarts with another address.
+	docker.setContainers(`[{"Names": ["/db"], "NetworkSettings": {"Networks": {"bridge": {"IPAddress": "172.17.0.5"}}}}]`)
+	docker.events <- `{"Type": "container", "Action": "start", "Actor": {"Attributes": {"name": "db"}}}`
+
+	deadline := time.Now().Add(5 * time.Second)
+	for fmt.Sprint(s.GetIPRanges(nil)) != "[172.17.0.5/32]" {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %s after event", s.GetIPRanges(nil))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNewDockerClient(t *testing.T) {
+	for host, want := range map[string]string{
+		"unix:///run/docker.sock":  "http://docker",
+		"tcp://10.0.0.1:2375":      "http://10.0.0.1:2375",
+		"https://docker.internal/": "https://docker.internal",
+	} {
+		c, err := newDockerClient(host)
+		if err != nil {
+			t.Errorf("%s: %v", host, err)
+			continue
+		}
+		if c.base != want {
+			t.Errorf("%s: got base %q, want %q", host, c.base, want)
+		}
+	}
+	if _, err := newDockerClient("ssh://user@host"); err == nil {
+		t.Error("no error for ssh host")
+	}
+}
+
+func TestUnmarshalDockerSource(t *testing.T) {
+	var s DockerSource
+	input := `docker db {
+		host unix:///run/docker.sock
+		service web api
+		network proxy
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.Host, " ", s.Containers, s.Services, s.Networks), "unix:///run/docker.sock [db] [web api] [proxy]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
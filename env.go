@@ -0,0 +1,166 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(new(EnvSource))
+}
+
+// The default refresh interval of the env source. Caddy's environment
+// doesn't change while it runs, so by default it's read once.
+const DefaultEnvInterval = IntervalOnce
+
+// EnvSource provides the IP addresses and CIDRs in environment variables, or
+// in files holding such a value, like those mounted by the Kubernetes
+// downward API. Values are separated by commas or whitespace.
+type EnvSource struct {
+	// The environment variables to read. Each must be set, but may be empty.
+	Variables []string `json:"variables,omitempty"`
+
+	// The files to read.
+	Files []string `json:"files,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+}
+
+// CaddyModule returns the Caddy module information.
+func (*EnvSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.env",
+		New: func() caddy.Module { return new(EnvSource) },
+	}
+}
+
+// Provision reads the prefixes, and starts refreshing them if an interval
+// is set.
+func (s *EnvSource) Provision(ctx caddy.Context) error {
+	if len(s.Variables) == 0 && len(s.Files) == 0 {
+		return errors.New("no variables or files provided")
+	}
+	if err := s.SourceOptions.validate(DefaultEnvInterval); err != nil {
+		return err
+	}
+	// Reading is cheap, and files should be read again when the config is
+	// reloaded, so the result isn't shared.
+	return s.refresher.start(ctx, s.SourceOptions, "", s.fetch)
+}
+
+// fetch reads the variables and files.
+func (s *EnvSource) fetch(_ context.Context) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, name := range s.Variables {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("environment variable %s not set", name)
+		}
+		p, err := parseEnvList(value)
+		if err != nil {
+			return nil, fmt.Errorf("environment variable %s: %w", name, err)
+		}
+		prefixes = append(prefixes, p...)
+	}
+	for _, path := range s.Files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		p, err := parseEnvList(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		prefixes = append(prefixes, p...)
+	}
+	return prefixes, nil
+}
+
+// parseEnvList parses IP addresses and CIDRs separated by commas or
+// whitespace. Values may be quoted, as in downward API files.
+func parseEnvList(value string) ([]netip.Prefix, error) {
+	value = strings.Trim(strings.TrimSpace(value), `"'`)
+	fields := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	return parsePrefixList(fields)
+}
+
+// Cleanup stops refreshing the prefixes.
+func (s *EnvSource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the prefixes in the variables and files.
+func (s *EnvSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *EnvSource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	env [<variable...>] {
+//	    variable <variable...>
+//	    file <path...>
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *EnvSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	s.Variables = append(s.Variables, d.RemainingArgs()...)
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "variable":
+			variables := d.RemainingArgs()
+			if len(variables) == 0 {
+				return d.ArgErr()
+			}
+			s.Variables = append(s.Variables, variables...)
+		case "file":
+			files := d.RemainingArgs()
+			if len(files) == 0 {
+				return d.ArgErr()
+			}
+			s.Files = append(s.Files, files...)
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown env option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*EnvSource)(nil)
+	_ caddy.Provisioner       = (*EnvSource)(nil)
+	_ caddy.CleanerUpper      = (*EnvSource)(nil)
+	_ caddyfile.Unmarshaler   = (*EnvSource)(nil)
+	_ caddyhttp.IPRangeSource = (*EnvSource)(nil)
+)
@@ -0,0 +1,88 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestParseEnvList(t *testing.T) {
+	for _, test := range []struct {
+		value, want string
+	}{
+		{"", "[]"},
+		{"192.0.2.1", "[192.0.2.1/32]"},
+		{"192.0.2.1, 198.51.100.0/24,2001:db8::/32", "[192.0.2.1/32 198.51.100.0/24 2001:db8::/32]"},
+		{"192.0.2.1 192.0.2.2\n", "[192.0.2.1/32 192.0.2.2/32]"},
+		{`"10.0.0.0/8,172.16.0.0/12"`, "[10.0.0.0/8 172.16.0.0/12]"},
+	} {
+		got, err := parseEnvList(test.value)
+		if err != nil {
+			t.Errorf("%q: %v", test.value, err)
+		} else if fmt.Sprint(got) != test.want {
+			t.Errorf("%q: got %v, want %s", test.value, got, test.want)
+		}
+	}
+	if _, err := parseEnvList("192.0.2.1;192.0.2.2"); err == nil {
+		t.Error("no error for an invalid list")
+	}
+}
+
+func TestEnvSource(t *testing.T) {
+	t.Setenv("TRUSTED_RANGES", "10.0.0.0/8, 192.0.2.1")
+	t.Setenv("EMPTY_RANGES", "")
+	path := filepath.Join(t.TempDir(), "ranges")
+	if err := os.WriteFile(path, []byte(`"2001:db8::/32"`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	s := EnvSource{
+		Variables:     []string{"TRUSTED_RANGES", "EMPTY_RANGES"},
+		Files:         []string{path},
+		SourceOptions: SourceOptions{Interval: caddy.Duration(10 * time.Millisecond)},
+	}
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cleanup()
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[10.0.0.0/8 192.0.2.1/32 2001:db8::/32]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// Files are read again every interval.
+	if err := os.WriteFile(path, []byte("2001:db8:1::/48"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "change", func() bool {
+		return fmt.Sprint(s.GetIPRanges(nil)) == "[10.0.0.0/8 192.0.2.1/32 2001:db8:1::/48]"
+	})
+
+	unset := EnvSource{Variables: []string{"UNSET_RANGES"}}
+	if err := unset.Provision(ctx); err == nil {
+		unset.Cleanup()
+		t.Error("no error for an unset variable")
+	}
+}
+
+func TestUnmarshalEnvSource(t *testing.T) {
+	var s EnvSource
+	input := `env TRUSTED_RANGES {
+		variable EXTRA_RANGES
+		file /etc/podinfo/trusted-ranges
+		interval 1m
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.Variables, " ", s.Files, " ", time.Duration(s.Interval)), "[TRUSTED_RANGES EXTRA_RANGES] [/etc/podinfo/trusted-ranges] 1m0s"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
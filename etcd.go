@@ -0,0 +1,415 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(new(EtcdSource))
+}
+
+const (
+	// The default refresh interval of the etcd source. Changes are picked
+	// up from a watch as they happen, so this is only a fallback.
+	DefaultEtcdInterval = caddy.Duration(5 * time.Minute)
+
+	// The default etcd endpoint.
+	DefaultEtcdEndpoint = "http://127.0.0.1:2379"
+)
+
+// EtcdSource provides the IP addresses and CIDRs stored in an etcd key, or
+// in all keys with a prefix. Each value holds one or more of them, one per
+// line. It watches the keys, so that changes apply as they're written.
+type EtcdSource struct {
+	// The etcd endpoints, tried in order. Defaults to DefaultEtcdEndpoint.
+	Endpoints []string `json:"endpoints,omitempty"`
+
+	// The key to read.
+	Key string `json:"key"`
+
+	// Read all keys starting with Key, instead of only Key itself.
+	Prefix bool `json:"prefix,omitempty"`
+
+	// The user to authenticate as, if authentication is enabled.
+	Username string `json:"username,omitempty"`
+
+	// The password of the user. May use global placeholders like
+	// {env.ETCD_PASSWORD}.
+	Password string `json:"password,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+	client    *etcdClient
+
+	// The revision of the most recent fetch, to watch from.
+	mu       sync.Mutex
+	revision int64
+}
+
+// etcdKeyRange is a key or range of keys, as used by the gRPC gateway.
+// Keys are base64 encoded, which encoding/json does for byte slices.
+type etcdKeyRange struct {
+	Key      []byte `json:"key"`
+	RangeEnd []byte `json:"range_end,omitempty"`
+}
+
+// etcdHeader is the header of responses.
+type etcdHeader struct {
+	Revision string `json:"revision"`
+}
+
+// etcdRangeResponse is the response to a range request.
+type etcdRangeResponse struct {
+	Header etcdHeader `json:"header"`
+	KVs    []struct {
+		Value []byte `json:"value"`
+	} `json:"kvs"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (*EtcdSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.etcd",
+		New: func() caddy.Module { return new(EtcdSource) },
+	}
+}
+
+// Provision fetches the prefixes, and starts watching for changes.
+func (s *EtcdSource) Provision(ctx caddy.Context) error {
+	if s.Key == "" {
+		return errors.New("no key provided")
+	}
+	if err := s.SourceOptions.validate(DefaultEtcdInterval); err != nil {
+		return err
+	}
+	endpoints := s.Endpoints
+	if len(endpoints) == 0 {
+		endpoints = []string{DefaultEtcdEndpoint}
+	}
+	s.client = &etcdClient{
+		username: s.Username,
+		password: caddy.NewReplacer().ReplaceKnown(s.Password, ""),
+	}
+	for _, endpoint := range endpoints {
+		s.client.endpoints = append(s.client.endpoints, apiBase(endpoint))
+	}
+
+	// The result is kept up to date by a watch, which starts over after a
+	// reload, so it isn't shared.
+	if err := s.refresher.start(ctx, s.SourceOptions, "", s.fetch); err != nil {
+		return err
+	}
+	s.refresher.watch(func(ctx context.Context) error {
+		s.mu.Lock()
+		revision := s.revision
+		s.mu.Unlock()
+		return s.client.watch(ctx, s.keyRange(), revision+1, s.refresher.refreshNow)
+	})
+	return nil
+}
+
+// keyRange returns the keys to read.
+func (s *EtcdSource) keyRange() etcdKeyRange {
+	r := etcdKeyRange{Key: []byte(s.Key)}
+	if s.Prefix {
+		r.RangeEnd = prefixEnd(r.Key)
+	}
+	return r
+}
+
+// prefixEnd returns the end of the range of keys starting with prefix.
+func prefixEnd(prefix []byte) []byte {
+	end := bytes.Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	// All keys.
+	return []byte{0}
+}
+
+// fetch fetches the prefixes in the keys.
+func (s *EtcdSource) fetch(ctx context.Context) ([]netip.Prefix, error) {
+	var resp etcdRangeResponse
+	if err := s.client.post(ctx, "/v3/kv/range", s.keyRange(), &resp); err != nil {
+		return nil, err
+	}
+	var prefixes []netip.Prefix
+	for _, kv := range resp.KVs {
+		p, err := parseList(FormatText, kv.Value)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, p...)
+	}
+
+	revision, _ := strconv.ParseInt(resp.Header.Revision, 10, 64)
+	s.mu.Lock()
+	s.revision = revision
+	s.mu.Unlock()
+	return prefixes, nil
+}
+
+// Cleanup stops refreshing the prefixes.
+func (s *EtcdSource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the prefixes in the keys.
+func (s *EtcdSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *EtcdSource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	etcd <key> {
+//	    endpoint <url...>
+//	    prefix
+//	    username <username>
+//	    password <password>
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *EtcdSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	if !d.AllArgs(&s.Key) {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "endpoint":
+			endpoints := d.RemainingArgs()
+			if len(endpoints) == 0 {
+				return d.ArgErr()
+			}
+			s.Endpoints = append(s.Endpoints, endpoints...)
+		case "prefix":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			s.Prefix = true
+		case "username":
+			if !d.AllArgs(&s.Username) {
+				return d.ArgErr()
+			}
+		case "password":
+			if !d.AllArgs(&s.Password) {
+				return d.ArgErr()
+			}
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown etcd option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// etcdClient talks to the JSON gateway of etcd's v3 API.
+type etcdClient struct {
+	endpoints          []string
+	username, password string
+
+	// The authentication token, if authenticated.
+	mu    sync.Mutex
+	token string
+}
+
+// errEtcdUnauthorized is returned for requests rejected because of their
+// token, which may have expired.
+var errEtcdUnauthorized = errors.New("unauthorized")
+
+// post sends req to path, and decodes the response into resp, trying each
+// endpoint in turn.
+func (c *etcdClient) post(ctx context.Context, path string, req, resp any) error {
+	var err error
+	for _, endpoint := range c.endpoints {
+		var body *http.Response
+		body, err = c.send(ctx, endpoint, path, req)
+		if err != nil {
+			continue
+		}
+		err = json.NewDecoder(body.Body).Decode(resp)
+		body.Body.Close()
+		if err == nil {
+			return nil
+		}
+		err = fmt.Errorf("etcd %s: %w", path, err)
+	}
+	return err
+}
+
+// send sends req to path at endpoint, authenticating first if needed.
+func (c *etcdClient) send(ctx context.Context, endpoint, path string, req any) (*http.Response, error) {
+	resp, err := c.do(ctx, endpoint, path, req)
+	if errors.Is(err, errEtcdUnauthorized) && c.username != "" {
+		// Get a new token, and try again.
+		c.mu.Lock()
+		c.token = ""
+		c.mu.Unlock()
+		resp, err = c.do(ctx, endpoint, path, req)
+	}
+	return resp, err
+}
+
+// do sends req to path at endpoint, with a token if authentication is
+// configured.
+func (c *etcdClient) do(ctx context.Context, endpoint, path string, req any) (*http.Response, error) {
+	var token string
+	if c.username != "" {
+		var err error
+		if token, err = c.authenticate(ctx, endpoint); err != nil {
+			return nil, err
+		}
+	}
+	return c.request(ctx, endpoint, path, token, req)
+}
+
+// authenticate returns the authentication token, getting one from endpoint
+// if there's none yet.
+func (c *etcdClient) authenticate(ctx context.Context, endpoint string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" {
+		return c.token, nil
+	}
+	resp, err := c.request(ctx, endpoint, "/v3/auth/authenticate", "", map[string]string{
+		"name":     c.username,
+		"password": c.password,
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var auth struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&auth); err != nil || auth.Token == "" {
+		return "", fmt.Errorf("etcd authentication failed: %v", err)
+	}
+	c.token = auth.Token
+	return c.token, nil
+}
+
+// request sends a single request, and checks the status of the response.
+func (c *etcdClient) request(ctx context.Context, endpoint, path, token string, req any) (*http.Response, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("User-Agent", sourceUserAgent)
+	if token != "" {
+		r.Header.Set("Authorization", token)
+	}
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp, nil
+	case http.StatusUnauthorized:
+		resp.Body.Close()
+		return nil, fmt.Errorf("etcd %s: %w", path, errEtcdUnauthorized)
+	}
+	resp.Body.Close()
+	return nil, fmt.Errorf("etcd %s: unexpected status %s", path, resp.Status)
+}
+
+// watch watches the keys from the given revision, and calls changed when
+// they change, until ctx is canceled or the watch fails.
+func (c *etcdClient) watch(ctx context.Context, keys etcdKeyRange, revision int64, changed func()) error {
+	type createRequest struct {
+		etcdKeyRange
+		StartRevision int64 `json:"start_revision,string"`
+	}
+	req := map[string]createRequest{"create_request": {keys, revision}}
+	var (
+		resp *http.Response
+		err  error
+	)
+	for _, endpoint := range c.endpoints {
+		if resp, err = c.send(ctx, endpoint, "/v3/watch", req); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Result struct {
+				Events          []json.RawMessage `json:"events"`
+				Canceled        bool              `json:"canceled"`
+				CancelReason    string            `json:"cancel_reason"`
+				CompactRevision string            `json:"compact_revision"`
+			} `json:"result"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := dec.Decode(&msg); err != nil {
+			return fmt.Errorf("etcd watch: %w", err)
+		}
+		switch {
+		case msg.Error != nil:
+			return fmt.Errorf("etcd watch: %s", msg.Error.Message)
+		case msg.Result.Canceled:
+			reason := msg.Result.CancelReason
+			if msg.Result.CompactRevision != "" {
+				reason = "revision compacted"
+			}
+			return fmt.Errorf("etcd watch canceled: %s", strings.TrimSpace(reason))
+		case len(msg.Result.Events) > 0:
+			changed()
+		}
+	}
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*EtcdSource)(nil)
+	_ caddy.Provisioner       = (*EtcdSource)(nil)
+	_ caddy.CleanerUpper      = (*EtcdSource)(nil)
+	_ caddyfile.Unmarshaler   = (*EtcdSource)(nil)
+	_ caddyhttp.IPRangeSource = (*EtcdSource)(nil)
+)
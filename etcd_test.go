@@ -0,0 +1,173 @@
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// fakeEtcd serves the parts of etcd's JSON gateway used by the etcd source.
+type fakeEtcd struct {
+	mu       sync.Mutex
+	kvs      map[string]string
+	revision int64
+	tokens   int
+	watches  []string
+	events   chan struct{}
+}
+
+func (f *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.URL.Path == "/v3/auth/authenticate" {
+		f.mu.Lock()
+		f.tokens++
+		fmt.Fprintf(w, `{"token": "token-%d"}`, f.tokens)
+		f.mu.Unlock()
+		return
+	}
+	f.mu.Lock()
+	token := fmt.Sprintf("token-%d", f.tokens)
+	f.mu.Unlock()
+	if r.Header.Get("Authorization") != token {
+		http.Error(w, `{"error": "invalid auth token"}`, http.StatusUnauthorized)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/v3/kv/range":
+		var keys etcdKeyRange
+		if err := json.Unmarshal(body, &keys); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		var resp etcdRangeResponse
+		resp.Header.Revision = fmt.Sprint(f.revision)
+		for key, value := range f.kvs {
+			if key == string(keys.Key) || keys.RangeEnd != nil && key >= string(keys.Key) && key < string(keys.RangeEnd) {
+				resp.KVs = append(resp.KVs, struct {
+					Value []byte `json:"value"`
+				}{[]byte(value)})
+			}
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	case "/v3/watch":
+		f.mu.Lock()
+		f.watches = append(f.watches, string(body))
+		f.mu.Unlock()
+		fmt.Fprintln(w, `{"result": {"created": true}}`)
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-f.events:
+				fmt.Fprintln(w, `{"result": {"events": [{"kv": {}}]}}`)
+				w.(http.Flusher).Flush()
+			}
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeEtcd) put(key, value string) {
+	f.mu.Lock()
+	f.kvs[key] = value
+	f.revision++
+	f.mu.Unlock()
+}
+
+func TestEtcdSource(t *testing.T) {
+	etcd := &fakeEtcd{
+		kvs: map[string]string{
+			"/proxies/a": "192.0.2.1\n192.0.2.2 # second\n",
+			"/proxies/b": "198.51.100.0/24",
+			"/other":     "203.0.113.1",
+		},
+		revision: 7,
+		events:   make(chan struct{}),
+	}
+	srv := httptest.NewServer(etcd)
+	defer srv.Close()
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	s := EtcdSource{
+		// The first endpoint is down.
+		Endpoints: []string{"http://127.0.0.1:1", srv.URL},
+		Key:       "/proxies/",
+		Prefix:    true,
+		Username:  "caddy",
+		Password:  "secret",
+	}
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cleanup()
+
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[192.0.2.1/32 192.0.2.2/32 198.51.100.0/24]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// The watch starts after the revision that was read.
+	waitFor(t, "watch", func() bool {
+		etcd.mu.Lock()
+		defer etcd.mu.Unlock()
+		return len(etcd.watches) == 1
+	})
+	if got, want := etcd.watches[0], `"start_revision":"8"`; !strings.Contains(got, want) {
+		t.Errorf("got watch %s, want %s", got, want)
+	}
+
+	// Expired tokens are renewed.
+	etcd.mu.Lock()
+	etcd.tokens++
+	etcd.mu.Unlock()
+
+	etcd.put("/proxies/b", "")
+	etcd.events <- struct{}{}
+	waitFor(t, "change", func() bool { return fmt.Sprint(s.GetIPRanges(nil)) == "[192.0.2.1/32 192.0.2.2/32]" })
+}
+
+func TestPrefixEnd(t *testing.T) {
+	for prefix, want := range map[string]string{
+		"/proxies/": "/proxies0",
+		"a\xff":     "b",
+		"\xff\xff":  "\x00",
+	} {
+		if got := string(prefixEnd([]byte(prefix))); got != want {
+			t.Errorf("%q: got %q, want %q", prefix, got, want)
+		}
+	}
+}
+
+func TestUnmarshalEtcdSource(t *testing.T) {
+	var s EtcdSource
+	input := `etcd /proxies/ {
+		endpoint https://etcd-1:2379 https://etcd-2:2379
+		prefix
+		username caddy
+		password {env.ETCD_PASSWORD}
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.Key, " ", s.Endpoints, " ", s.Prefix, " ", s.Username, " ", s.Password), "/proxies/ [https://etcd-1:2379 https://etcd-2:2379] true caddy {env.ETCD_PASSWORD}"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,49 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyevents"
+	"go.uber.org/zap"
+)
+
+// refreshHandler refreshes all hosts of a DNSRange when an event fires.
+type refreshHandler struct {
+	d     *DNSRange
+	event string
+}
+
+// Handle implements caddyevents.Handler.
+func (h refreshHandler) Handle(context.Context, caddyevents.Event) error {
+	h.d.logger.Debug("refreshing on event", zap.String("event", h.event))
+	h.d.triggerRefresh("")
+	return nil
+}
+
+// subscribeRefresh subscribes to the events that should trigger a refresh.
+func (d *DNSRange) subscribeRefresh(ctx caddy.Context) error {
+	if len(d.RefreshOn) == 0 {
+		return nil
+	}
+
+	app, err := ctx.App("events")
+	if err != nil {
+		return err
+	}
+	events := app.(*caddyevents.App)
+
+	for _, name := range d.RefreshOn {
+		if err := events.On(name, refreshHandler{d, name}); err != nil {
+			return fmt.Errorf("subscribing to event %q: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddyevents.Handler = refreshHandler{}
+)
@@ -0,0 +1,29 @@
+package dns
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyevents"
+)
+
+func TestRefreshOnEvent(t *testing.T) {
+	res := &fakeResolver{hosts: map[string][]string{"proxy.example.com": {"192.0.2.1"}}}
+	r := DNSRange{Hosts: []string{"proxy.example.com"}, Interval: caddy.Duration(time.Hour)}
+	cancel, err := provision(t, &r, res)
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res.set("proxy.example.com", "192.0.2.2")
+	if err := (refreshHandler{&r, "deployed"}).Handle(context.Background(), caddyevents.Event{}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "refreshed address", func() bool {
+		ranges := r.GetIPRanges(nil)
+		return len(ranges) == 1 && ranges[0].Addr().String() == "192.0.2.2"
+	})
+}
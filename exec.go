@@ -0,0 +1,222 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(new(ExecSource))
+}
+
+// The default refresh interval of the exec source.
+const DefaultExecInterval = caddy.Duration(5 * time.Minute)
+
+// How much of the standard error of a failed command is reported.
+const maxExecStderr = 4 << 10
+
+// ExecSource provides the IP addresses and CIDRs a command prints on its
+// standard output.
+type ExecSource struct {
+	// The command to run, and its arguments. The command is looked up in the
+	// PATH if it has no slashes.
+	Command []string `json:"command"`
+
+	// The format of the output: "text" (one per line, the default), "json"
+	// (an array of strings) or "csv" (in the first column).
+	Format string `json:"format,omitempty"`
+
+	// Environment variables to set for the command. Values may use global
+	// placeholders like {env.TOKEN}.
+	Env map[string]string `json:"env,omitempty"`
+
+	// Run the command with only the variables in Env, instead of adding them
+	// to Caddy's environment.
+	ClearEnv bool `json:"clear_env,omitempty"`
+
+	// The working directory of the command. Defaults to Caddy's.
+	Dir string `json:"dir,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+	env       []string
+}
+
+// CaddyModule returns the Caddy module information.
+func (*ExecSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.exec",
+		New: func() caddy.Module { return new(ExecSource) },
+	}
+}
+
+// Provision runs the command, and starts running it periodically.
+func (s *ExecSource) Provision(ctx caddy.Context) error {
+	if len(s.Command) == 0 || s.Command[0] == "" {
+		return errors.New("no command provided")
+	}
+	if err := validateFormat(s.Format); err != nil {
+		return err
+	}
+	if err := s.SourceOptions.validate(DefaultExecInterval); err != nil {
+		return err
+	}
+
+	s.env = nil
+	if !s.ClearEnv {
+		s.env = os.Environ()
+	}
+	names := make([]string, 0, len(s.Env))
+	for name := range s.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	repl := caddy.NewReplacer()
+	for _, name := range names {
+		// Later entries win, so these override the inherited ones.
+		s.env = append(s.env, name+"="+repl.ReplaceKnown(s.Env[name], ""))
+	}
+	if s.env == nil {
+		// A nil environment means inheriting Caddy's.
+		s.env = []string{}
+	}
+	return s.refresher.start(ctx, s.SourceOptions, sourceKey(s), s.fetch)
+}
+
+// fetch runs the command, and parses its output. The command is killed when
+// the timeout runs out.
+func (s *ExecSource) fetch(ctx context.Context) ([]netip.Prefix, error) {
+	cmd := exec.CommandContext(ctx, s.Command[0], s.Command[1:]...)
+	cmd.Env = s.env
+	cmd.Dir = s.Dir
+	// Don't wait for children that keep the output open after a kill.
+	cmd.WaitDelay = time.Second
+	stdout := &limitedBuffer{limit: maxDocumentSize}
+	stderr := &limitedBuffer{limit: maxExecStderr, truncate: true}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("running %s: %v: %s", s.Command[0], err, msg)
+		}
+		return nil, fmt.Errorf("running %s: %w", s.Command[0], err)
+	}
+	return parseList(s.Format, stdout.Bytes())
+}
+
+// limitedBuffer is a buffer that holds up to limit bytes. Writing more fails,
+// or with truncate, drops the rest.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit    int
+	truncate bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); len(p) > room {
+		if !b.truncate {
+			return 0, fmt.Errorf("output larger than %d bytes", b.limit)
+		}
+		b.Buffer.Write(p[:room])
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// Cleanup stops running the command.
+func (s *ExecSource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the prefixes the command printed.
+func (s *ExecSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *ExecSource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	exec <command> [<args...>] {
+//	    format text|json|csv
+//	    env <name> <value>
+//	    clear_env
+//	    dir <directory>
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *ExecSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	s.Command = d.RemainingArgs()
+	if len(s.Command) == 0 {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "format":
+			if !d.AllArgs(&s.Format) {
+				return d.ArgErr()
+			}
+		case "env":
+			var name, value string
+			if !d.AllArgs(&name, &value) {
+				return d.ArgErr()
+			}
+			if s.Env == nil {
+				s.Env = make(map[string]string)
+			}
+			s.Env[name] = value
+		case "clear_env":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			s.ClearEnv = true
+		case "dir":
+			if !d.AllArgs(&s.Dir) {
+				return d.ArgErr()
+			}
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown exec option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*ExecSource)(nil)
+	_ caddy.Provisioner       = (*ExecSource)(nil)
+	_ caddy.CleanerUpper      = (*ExecSource)(nil)
+	_ caddyfile.Unmarshaler   = (*ExecSource)(nil)
+	_ caddyhttp.IPRangeSource = (*ExecSource)(nil)
+)
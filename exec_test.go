@@ -0,0 +1,90 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestExecSource(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	t.Setenv("EXEC_TEST_INHERITED", "192.0.2.9")
+	t.Setenv("EXEC_TEST_TOKEN", "192.0.2.3")
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "list.txt"), []byte("203.0.113.0/24\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []*struct {
+		source ExecSource
+		want   string
+	}{
+		{ExecSource{Command: []string{"sh", "-c", "echo 192.0.2.1; echo '198.51.100.0/24 # office'"}}, "[192.0.2.1/32 198.51.100.0/24]"},
+		{ExecSource{Command: []string{"sh", "-c", `echo '["2001:db8::/32"]'`}, Format: "json"}, "[2001:db8::/32]"},
+		{ExecSource{
+			Command: []string{"sh", "-c", "echo $EXEC_TEST_INHERITED; echo $EXEC_TEST_SET"},
+			Env:     map[string]string{"EXEC_TEST_SET": "{env.EXEC_TEST_TOKEN}"},
+		}, "[192.0.2.3/32 192.0.2.9/32]"},
+		{ExecSource{
+			Command:  []string{"/bin/sh", "-c", "echo ${EXEC_TEST_INHERITED:-192.0.2.4}; echo $EXEC_TEST_SET"},
+			Env:      map[string]string{"EXEC_TEST_SET": "{env.EXEC_TEST_TOKEN}"},
+			ClearEnv: true,
+		}, "[192.0.2.3/32 192.0.2.4/32]"},
+		{ExecSource{Command: []string{"cat", "list.txt"}, Dir: dir}, "[203.0.113.0/24]"},
+	} {
+		s := &test.source
+		if err := s.Provision(ctx); err != nil {
+			t.Errorf("%q: %v", s.Command, err)
+			continue
+		}
+		if got := fmt.Sprint(s.GetIPRanges(nil)); got != test.want {
+			t.Errorf("%q: got %s, want %s", s.Command, got, test.want)
+		}
+		s.Cleanup()
+	}
+}
+
+func TestExecSourceErrors(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	s := ExecSource{Command: []string{"sh", "-c", "echo inventory unavailable >&2; exit 3"}}
+	err := s.Provision(ctx)
+	if err == nil || !strings.Contains(err.Error(), "inventory unavailable") {
+		t.Errorf("got %v, want the standard error", err)
+	}
+
+	start := time.Now()
+	s = ExecSource{Command: []string{"sleep", "10"}, SourceOptions: SourceOptions{Timeout: caddy.Duration(100 * time.Millisecond)}}
+	if err := s.Provision(ctx); err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
+		t.Errorf("got %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("timing out took %v", elapsed)
+	}
+}
+
+func TestUnmarshalExecSource(t *testing.T) {
+	var s ExecSource
+	input := `exec /usr/local/bin/inventory --role proxy {
+		format csv
+		env INVENTORY_TOKEN {env.INVENTORY_TOKEN}
+		clear_env
+		dir /var/lib/inventory
+		timeout 10s
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.Command, " ", s.Format, " ", s.Env, " ", s.ClearEnv, " ", s.Dir, " ", time.Duration(s.Timeout)), "[/usr/local/bin/inventory --role proxy] csv map[INVENTORY_TOKEN:{env.INVENTORY_TOKEN}] true /var/lib/inventory 10s"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,227 @@
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"sync/atomic"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(new(FallbackSource))
+}
+
+// The default interval at which the fallback source checks the health of
+// its primary source.
+const DefaultFallbackInterval = caddy.Duration(10 * time.Second)
+
+// FallbackSource provides the ranges of its primary source, or those of its
+// secondary source while the primary has none, or has been unhealthy for
+// longer than a threshold.
+type FallbackSource struct {
+	// The source to use normally.
+	PrimaryRaw json.RawMessage `json:"primary,omitempty" caddy:"namespace=http.ip_sources inline_key=source"`
+
+	// The source to fall back to.
+	SecondaryRaw json.RawMessage `json:"secondary,omitempty" caddy:"namespace=http.ip_sources inline_key=source"`
+
+	// How long the primary source has to be unhealthy before falling back.
+	// If not set, it falls back as soon as the primary is found unhealthy.
+	After caddy.Duration `json:"after,omitempty"`
+
+	// How often to check the health of the primary source. Defaults to
+	// DefaultFallbackInterval.
+	Interval caddy.Duration `json:"interval,omitempty"`
+
+	primary, secondary caddyhttp.IPRangeSource
+	logger             *zap.Logger
+
+	// When the primary source was first found unhealthy, if it still is.
+	// Only used by check.
+	failingSince time.Time
+
+	// Whether the secondary source is used, regardless of the ranges of the
+	// primary.
+	failedOver atomic.Bool
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// CaddyModule returns the Caddy module information.
+func (*FallbackSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.fallback",
+		New: func() caddy.Module { return new(FallbackSource) },
+	}
+}
+
+// Provision loads the sources, which are cleaned up along with the config,
+// and starts checking the health of the primary.
+func (s *FallbackSource) Provision(ctx caddy.Context) error {
+	if s.PrimaryRaw == nil || s.SecondaryRaw == nil {
+		return errors.New("a primary and a secondary source are required")
+	}
+	if s.After < 0 {
+		return errors.New("after cannot be negative")
+	}
+	if s.Interval == 0 {
+		s.Interval = DefaultFallbackInterval
+	}
+	if s.Interval < 0 {
+		return fmt.Errorf("invalid interval %v", time.Duration(s.Interval))
+	}
+	s.logger = ctx.Logger()
+
+	primary, err := loadIPSources(ctx, s, "PrimaryRaw")
+	if err != nil {
+		return fmt.Errorf("primary: %w", err)
+	}
+	secondary, err := loadIPSources(ctx, s, "SecondaryRaw")
+	if err != nil {
+		return fmt.Errorf("secondary: %w", err)
+	}
+	s.primary, s.secondary = primary[0], secondary[0]
+
+	var runCtx context.Context
+	runCtx, s.cancel = context.WithCancel(ctx)
+	s.done = make(chan struct{})
+	go s.run(runCtx)
+	return nil
+}
+
+// run checks the health of the primary source every interval, until ctx is
+// canceled.
+func (s *FallbackSource) run(ctx context.Context) {
+	defer close(s.done)
+	ticker := time.NewTicker(time.Duration(s.Interval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.check(now)
+		}
+	}
+}
+
+// check updates whether to fall back, by the health of the primary source
+// at now.
+func (s *FallbackSource) check(now time.Time) {
+	if sourceHealthy(s.primary) {
+		s.failingSince = time.Time{}
+		if s.failedOver.Swap(false) {
+			s.logger.Info("primary source recovered")
+		}
+		return
+	}
+	if s.failingSince.IsZero() {
+		s.failingSince = now
+	}
+	if failing := now.Sub(s.failingSince); failing >= time.Duration(s.After) && !s.failedOver.Swap(true) {
+		s.logger.Warn("primary source unhealthy, falling back to the secondary", zap.Duration("failing", failing))
+	}
+}
+
+// Cleanup stops checking the health of the primary source.
+func (s *FallbackSource) Cleanup() error {
+	if s.cancel != nil {
+		s.cancel()
+		<-s.done
+	}
+	return nil
+}
+
+// GetIPRanges returns the ranges of the primary source, or of the secondary
+// if the primary has none, or has been unhealthy for too long.
+func (s *FallbackSource) GetIPRanges(r *http.Request) []netip.Prefix {
+	if !s.failedOver.Load() {
+		if prefixes := s.primary.GetIPRanges(r); len(prefixes) > 0 {
+			return prefixes
+		}
+	}
+	return s.secondary.GetIPRanges(r)
+}
+
+// healthy reports whether the source in use is healthy.
+func (s *FallbackSource) healthy() bool {
+	if s.failedOver.Load() {
+		return sourceHealthy(s.secondary)
+	}
+	return sourceHealthy(s.primary)
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	fallback {
+//	    primary <module> ...
+//	    secondary <module> ...
+//	    after <duration>
+//	    interval <duration>
+//	}
+//
+// where each source is an IP range source module, with the same syntax as
+// in trusted_proxies.
+func (s *FallbackSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch name := d.Val(); name {
+		case "primary", "secondary":
+			dst := &s.PrimaryRaw
+			if name == "secondary" {
+				dst = &s.SecondaryRaw
+			}
+			if *dst != nil {
+				return d.Errf("%s source already specified", name)
+			}
+			raw, err := unmarshalIPSource(d)
+			if err != nil {
+				return err
+			}
+			*dst = raw
+		case "after", "interval":
+			var val string
+			if !d.AllArgs(&val) {
+				return d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(val)
+			if err != nil {
+				return d.Errf("invalid %s %q: %v", name, val, err)
+			}
+			if name == "after" {
+				s.After = caddy.Duration(dur)
+			} else {
+				s.Interval = caddy.Duration(dur)
+			}
+		default:
+			return d.Errf("unknown fallback option %q", name)
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*FallbackSource)(nil)
+	_ caddy.Provisioner       = (*FallbackSource)(nil)
+	_ caddy.CleanerUpper      = (*FallbackSource)(nil)
+	_ caddyfile.Unmarshaler   = (*FallbackSource)(nil)
+	_ caddyhttp.IPRangeSource = (*FallbackSource)(nil)
+)
@@ -0,0 +1,102 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestFallbackSource(t *testing.T) {
+	srv, set := newListServer(t, "192.0.2.1\n")
+
+	var s FallbackSource
+	input := fmt.Sprintf(`fallback {
+		primary http %s
+		secondary static 198.51.100.0/24
+		after 5m
+		interval 1h
+	}`, srv.URL)
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cleanup()
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[192.0.2.1/32]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// The primary's ranges are used until it has been failing for long
+	// enough.
+	primary := s.primary.(*HTTPSource)
+	set(http.StatusInternalServerError, "")
+	if err := primary.refresher.refresh(); err == nil {
+		t.Fatal("no error for failed refresh")
+	}
+	now := time.Now()
+	for _, test := range []struct {
+		at   time.Duration
+		want string
+	}{
+		{0, "[192.0.2.1/32]"},
+		{4 * time.Minute, "[192.0.2.1/32]"},
+		{5 * time.Minute, "[198.51.100.0/24]"},
+	} {
+		s.check(now.Add(test.at))
+		if got := fmt.Sprint(s.GetIPRanges(nil)); got != test.want {
+			t.Errorf("after %v: got %s, want %s", test.at, got, test.want)
+		}
+	}
+
+	// An empty primary falls back as well.
+	set(http.StatusOK, "")
+	if err := primary.refresher.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	s.check(now.Add(6 * time.Minute))
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[198.51.100.0/24]"; got != want {
+		t.Errorf("with empty primary: got %s, want %s", got, want)
+	}
+
+	set(http.StatusOK, "192.0.2.2\n")
+	if err := primary.refresher.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[192.0.2.2/32]"; got != want {
+		t.Errorf("after recovery: got %s, want %s", got, want)
+	}
+
+	for _, input := range []string{
+		`fallback {
+			primary static 192.0.2.0/24
+		}`,
+		`fallback {
+			primary static 192.0.2.0/24
+			primary static 198.51.100.0/24
+		}`,
+		`fallback {
+			primary static 192.0.2.0/24
+			secondary static 198.51.100.0/24
+			after soon
+		}`,
+	} {
+		var s FallbackSource
+		err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input))
+		if err == nil {
+			err = s.Provision(ctx)
+			s.Cleanup()
+		}
+		if err == nil {
+			t.Errorf("%s: no error", input)
+		}
+	}
+}
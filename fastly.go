@@ -0,0 +1,134 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(new(FastlySource))
+}
+
+const (
+	// The default refresh interval of the Fastly source.
+	DefaultFastlyInterval = caddy.Duration(24 * time.Hour)
+
+	// The API endpoint listing Fastly's ranges.
+	DefaultFastlyURL = "https://api.fastly.com/public-ip-list"
+)
+
+// FastlySource provides the IPv4 and IPv6 ranges Fastly connects to origins
+// from.
+type FastlySource struct {
+	// The URL to fetch the ranges from, in the format of Fastly's API.
+	// Defaults to DefaultFastlyURL.
+	URL string `json:"url,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+}
+
+// fastlyIPs is the response of the Fastly API.
+type fastlyIPs struct {
+	IPv4 []string `json:"addresses"`
+	IPv6 []string `json:"ipv6_addresses"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (*FastlySource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.fastly",
+		New: func() caddy.Module { return new(FastlySource) },
+	}
+}
+
+// Provision fetches the ranges, and starts refreshing them.
+func (s *FastlySource) Provision(ctx caddy.Context) error {
+	if s.URL == "" {
+		s.URL = DefaultFastlyURL
+	}
+	if err := s.SourceOptions.validate(DefaultFastlyInterval); err != nil {
+		return err
+	}
+	return s.refresher.start(ctx, s.SourceOptions, sourceKey(s), s.fetch)
+}
+
+// fetch fetches the ranges.
+func (s *FastlySource) fetch(ctx context.Context) ([]netip.Prefix, error) {
+	var ips fastlyIPs
+	if err := fetchJSON(ctx, s.URL, nil, &ips); err != nil {
+		return nil, err
+	}
+	if len(ips.IPv4)+len(ips.IPv6) == 0 {
+		return nil, errors.New("fastly API returned no ranges")
+	}
+	return parsePrefixList(append(ips.IPv4, ips.IPv6...))
+}
+
+// Cleanup stops refreshing the ranges.
+func (s *FastlySource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns Fastly's ranges.
+func (s *FastlySource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *FastlySource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	fastly {
+//	    url <url>
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *FastlySource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "url":
+			if !d.AllArgs(&s.URL) {
+				return d.ArgErr()
+			}
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown fastly option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*FastlySource)(nil)
+	_ caddy.Provisioner       = (*FastlySource)(nil)
+	_ caddy.CleanerUpper      = (*FastlySource)(nil)
+	_ caddyfile.Unmarshaler   = (*FastlySource)(nil)
+	_ caddyhttp.IPRangeSource = (*FastlySource)(nil)
+)
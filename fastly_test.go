@@ -0,0 +1,37 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func TestFastlySource(t *testing.T) {
+	body := `{"addresses":["23.235.32.0/20","43.249.72.0/22"],"ipv6_addresses":["2a04:4e40::/32"]}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, body)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	s := FastlySource{URL: srv.URL}
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[23.235.32.0/20 43.249.72.0/22 2a04:4e40::/32]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	s.Cleanup()
+
+	body = `{"addresses":[],"ipv6_addresses":[]}`
+	s = FastlySource{URL: srv.URL, SourceOptions: SourceOptions{Interval: IntervalOnce}}
+	if err := s.Provision(ctx); err == nil {
+		t.Error("no error for empty list")
+	}
+	s.Cleanup()
+}
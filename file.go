@@ -0,0 +1,146 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(new(FileSource))
+}
+
+// The default refresh interval of the file source. Changes to the file are
+// picked up as they happen, so this is only a fallback.
+const DefaultFileInterval = caddy.Duration(5 * time.Minute)
+
+// FileSource provides the IP addresses and CIDRs in a local file. It watches
+// the file, so that changes apply without reloading the config.
+type FileSource struct {
+	// The path of the file.
+	Path string `json:"path"`
+
+	// The format of the list: "text" (one per line, the default), "json"
+	// (an array of strings) or "csv" (in the first column).
+	Format string `json:"format,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+}
+
+// CaddyModule returns the Caddy module information.
+func (*FileSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.file",
+		New: func() caddy.Module { return new(FileSource) },
+	}
+}
+
+// Provision reads the file, and starts watching it.
+func (s *FileSource) Provision(ctx caddy.Context) error {
+	if s.Path == "" {
+		return errors.New("no path provided")
+	}
+	if err := validateFormat(s.Format); err != nil {
+		return err
+	}
+	if err := s.SourceOptions.validate(DefaultFileInterval); err != nil {
+		return err
+	}
+	// Reading a local file is cheap, and changes made during a reload
+	// shouldn't be missed, so the result isn't shared.
+	if err := s.refresher.start(ctx, s.SourceOptions, "", s.fetch); err != nil {
+		return err
+	}
+	s.refresher.watch(func(ctx context.Context) error {
+		return watchFile(ctx, s.Path, s.refresher.refreshNow)
+	})
+	return nil
+}
+
+// fetch reads the prefixes in the file.
+func (s *FileSource) fetch(_ context.Context) ([]netip.Prefix, error) {
+	f, err := os.Open(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxDocumentSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxDocumentSize {
+		return nil, fmt.Errorf("reading %s: file larger than %d bytes", s.Path, maxDocumentSize)
+	}
+	return parseList(s.Format, data)
+}
+
+// Cleanup stops watching the file.
+func (s *FileSource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the prefixes in the file.
+func (s *FileSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *FileSource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	file <path> {
+//	    format text|json|csv
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *FileSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	if !d.AllArgs(&s.Path) {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "format":
+			if !d.AllArgs(&s.Format) {
+				return d.ArgErr()
+			}
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown file option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*FileSource)(nil)
+	_ caddy.Provisioner       = (*FileSource)(nil)
+	_ caddy.CleanerUpper      = (*FileSource)(nil)
+	_ caddyfile.Unmarshaler   = (*FileSource)(nil)
+	_ caddyhttp.IPRangeSource = (*FileSource)(nil)
+)
@@ -0,0 +1,78 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestFileSource(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "proxies.txt")
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("proxies.txt", "# Proxies\n192.0.2.1\n198.51.100.0/24 ; office\n")
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	s := FileSource{Path: path}
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cleanup()
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[192.0.2.1/32 198.51.100.0/24]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// The watch starts in the background, so keep writing until the change
+	// is seen.
+	waitFor(t, "write", func() bool {
+		write("proxies.txt", "192.0.2.2\n")
+		return fmt.Sprint(s.GetIPRanges(nil)) == "[192.0.2.2/32]"
+	})
+
+	// Files replaced by renaming are followed as well, and other files in
+	// the directory are ignored.
+	write("other.txt", "not a list\n")
+	write("proxies.txt.tmp", "2001:db8::1\n")
+	if err := os.Rename(filepath.Join(dir, "proxies.txt.tmp"), path); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "rename", func() bool { return fmt.Sprint(s.GetIPRanges(nil)) == "[2001:db8::1/128]" })
+
+	// A removed file keeps the previous prefixes.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[2001:db8::1/128]"; got != want {
+		t.Errorf("after removing: got %s, want %s", got, want)
+	}
+
+	missing := FileSource{Path: filepath.Join(dir, "missing.txt")}
+	if err := missing.Provision(ctx); err == nil {
+		missing.Cleanup()
+		t.Error("no error for a missing file")
+	}
+}
+
+func TestUnmarshalFileSource(t *testing.T) {
+	var s FileSource
+	input := `file /etc/caddy/proxies.json {
+		format json
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.Path, " ", s.Format), "/etc/caddy/proxies.json json"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
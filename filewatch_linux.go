@@ -0,0 +1,83 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// watchFile calls changed whenever the file at path is written, replaced or
+// removed, until ctx is canceled or the watch fails. On Linux, changes are
+// reported by inotify. The directory is watched rather than the file, so
+// that files replaced by renaming a new one over them are followed.
+func watchFile(ctx context.Context, path string, changed func()) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return os.NewSyscallError("inotify_init1", err)
+	}
+	// Using an *os.File makes reads use the runtime poller, so that closing
+	// the file interrupts them.
+	f := os.NewFile(uintptr(fd), "inotify")
+	defer f.Close()
+
+	dir, name := filepath.Split(filepath.Clean(path))
+	if dir == "" {
+		dir = "."
+	}
+	// Only completed writes count, so that half-written files aren't read.
+	mask := uint32(unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_MOVED_FROM | unix.IN_DELETE |
+		unix.IN_DELETE_SELF | unix.IN_MOVE_SELF)
+	if _, err := unix.InotifyAddWatch(fd, dir, mask); err != nil {
+		return fmt.Errorf("watching %s: %w", dir, os.NewSyscallError("inotify_add_watch", err))
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			f.Close()
+		case <-done:
+		}
+	}()
+
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			return err
+		}
+		for off := 0; off+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			nameStart := off + unix.SizeofInotifyEvent
+			off = nameStart + int(event.Len)
+			if off > n {
+				break
+			}
+			switch {
+			case event.Mask&unix.IN_Q_OVERFLOW != 0:
+				// Events were dropped, so the file may have changed.
+				changed()
+			case event.Mask&(unix.IN_DELETE_SELF|unix.IN_MOVE_SELF|unix.IN_IGNORED) != 0:
+				return errors.New("watching " + dir + ": directory removed")
+			case eventName(buf[nameStart:off]) == name:
+				changed()
+			}
+		}
+	}
+}
+
+// eventName returns the NUL-padded name of an inotify event.
+func eventName(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,38 @@
+//go:build !linux
+
+package dns
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// How often files are checked for changes, where they can't be watched.
+const fileWatchPollInterval = 2 * time.Second
+
+// watchFile calls changed whenever the file at path is written, replaced or
+// removed, until ctx is canceled. On this platform, its modification time and
+// size are checked every fileWatchPollInterval.
+func watchFile(ctx context.Context, path string, changed func()) error {
+	last, lastErr := os.Stat(path)
+	ticker := time.NewTicker(fileWatchPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		info, err := os.Stat(path)
+		switch {
+		case err != nil && lastErr != nil:
+			continue
+		case err != nil || lastErr != nil:
+			changed()
+		case !info.ModTime().Equal(last.ModTime()) || info.Size() != last.Size():
+			changed()
+		}
+		last, lastErr = info, err
+	}
+}
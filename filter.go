@@ -0,0 +1,240 @@
+package dns
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/fvbommel/caddy-dns-ip-range/iprange"
+)
+
+// Filter restricts which resolved addresses end up in the range.
+//
+// When used in per-host options, unset fields inherit the global value.
+type Filter struct {
+	// Addresses or networks (CIDRs) to remove from the results.
+	Exclude []string `json:"exclude,omitempty"`
+
+	// If set, addresses outside of these networks (CIDRs) are removed from the results.
+	ExpectWithin []string `json:"expect_within,omitempty"`
+
+	// Classes of addresses to remove from the results. Supported classes are
+	// "private", "loopback", "link_local", "multicast" and "unspecified".
+	// The special class "none" rejects nothing, which is useful to clear
+	// the global setting for a specific host.
+	Reject []string `json:"reject,omitempty"`
+
+	// What to do with addresses outside of ExpectWithin, or of a rejected
+	// class: FilterActionDrop (the default) removes them from the results,
+	// and FilterActionReject rejects the whole update, keeping the previous
+	// addresses. Excluded addresses are always just removed.
+	Action string `json:"action,omitempty"`
+}
+
+// Values for Filter.Action.
+const (
+	FilterActionDrop   = "drop"
+	FilterActionReject = "reject"
+)
+
+// override returns f with all fields set in other replaced by their values.
+func (f Filter) override(other Filter) Filter {
+	if other.Exclude != nil {
+		f.Exclude = other.Exclude
+	}
+	if other.ExpectWithin != nil {
+		f.ExpectWithin = other.ExpectWithin
+	}
+	if other.Reject != nil {
+		f.Reject = other.Reject
+	}
+	if other.Action != "" {
+		f.Action = other.Action
+	}
+	return f
+}
+
+// A set of address classes that can be rejected.
+type addrClass uint
+
+const (
+	classPrivate addrClass = 1 << iota
+	classLoopback
+	classLinkLocal
+	classMulticast
+	classUnspecified
+)
+
+var addrClassNames = map[string]addrClass{
+	"none":        0,
+	"private":     classPrivate,
+	"loopback":    classLoopback,
+	"link_local":  classLinkLocal,
+	"multicast":   classMulticast,
+	"unspecified": classUnspecified,
+}
+
+// The classes rejected by the reject_private shorthand.
+var rejectPrivateClasses = []string{"private", "loopback", "link_local", "unspecified"}
+
+// classify returns the classes addr belongs to.
+func classify(addr netip.Addr) (classes addrClass) {
+	if addr.IsPrivate() {
+		classes |= classPrivate
+	}
+	if addr.IsLoopback() {
+		classes |= classLoopback
+	}
+	if addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() {
+		classes |= classLinkLocal
+	}
+	if addr.IsMulticast() {
+		classes |= classMulticast
+	}
+	if addr.IsUnspecified() {
+		classes |= classUnspecified
+	}
+	return classes
+}
+
+// addrFilter is the parsed form of a Filter.
+type addrFilter struct {
+	exclude []netip.Prefix
+	within  []netip.Prefix
+	reject  addrClass
+
+	// Whether filtered addresses reject the whole update.
+	rejectUpdate bool
+}
+
+// compile parses the filter settings.
+func (f Filter) compile() (*addrFilter, error) {
+	var (
+		result addrFilter
+		err    error
+	)
+
+	if result.exclude, err = parsePrefixes(f.Exclude); err != nil {
+		return nil, fmt.Errorf("exclude: %w", err)
+	}
+	if result.within, err = parsePrefixes(f.ExpectWithin); err != nil {
+		return nil, fmt.Errorf("expect_within: %w", err)
+	}
+	for _, name := range f.Reject {
+		class, ok := addrClassNames[name]
+		if !ok {
+			return nil, fmt.Errorf("reject: unknown address class %q", name)
+		}
+		result.reject |= class
+	}
+	switch f.Action {
+	case "", FilterActionDrop:
+	case FilterActionReject:
+		result.rejectUpdate = true
+	default:
+		return nil, fmt.Errorf("unknown filter action %q", f.Action)
+	}
+
+	return &result, nil
+}
+
+// Reasons returned by addrFilter.check.
+const (
+	reasonClass    = "rejected address class"
+	reasonExcluded = "excluded"
+	reasonOutside  = "not within expected networks"
+)
+
+// check returns a description of why addr should be removed from the
+// results, or the empty string if it should be kept.
+func (f *addrFilter) check(addr netip.Addr) string {
+	switch {
+	case classify(addr)&f.reject != 0:
+		return reasonClass
+	case iprange.Contains(f.exclude, addr):
+		return reasonExcluded
+	case len(f.within) != 0 && !iprange.Contains(f.within, addr):
+		return reasonOutside
+	}
+	return ""
+}
+
+// rejects reports whether an address removed for reason rejects the whole update.
+func (f *addrFilter) rejects(reason string) bool {
+	return f.rejectUpdate && reason != reasonExcluded
+}
+
+// subtractExcluded removes the excluded addresses from prefixes, splitting
+// the prefixes that contain some of them.
+func (f *addrFilter) subtractExcluded(prefixes []netip.Prefix) []netip.Prefix {
+	result := prefixes[:0:0]
+	for _, p := range prefixes {
+		overlaps := false
+		for _, q := range f.exclude {
+			if p.Overlaps(q) {
+				overlaps = true
+				break
+			}
+		}
+		if overlaps {
+			result = append(result, iprange.Subtract([]netip.Prefix{p}, f.exclude)...)
+		} else {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// parsePrefixes parses a list of IP addresses and/or CIDRs.
+func parsePrefixes(strs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(strs))
+	for _, str := range strs {
+		prefix, err := caddyhttp.CIDRExpressionToPrefix(str)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, iprange.Normalize(prefix))
+	}
+	return prefixes, nil
+}
+
+// unmarshalFilter handles filter subdirectives. It returns false if the
+// current token is not a filter subdirective.
+func (f *Filter) unmarshalFilter(d *caddyfile.Dispenser) (bool, error) {
+	var dst *[]string
+	switch d.Val() {
+	case "exclude":
+		dst = &f.Exclude
+	case "expect_within":
+		dst = &f.ExpectWithin
+	case "reject":
+		dst = &f.Reject
+	case "reject_private":
+		// Shorthand for the classes that public names should never resolve to.
+		if d.NextArg() {
+			return true, d.ArgErr()
+		}
+		f.Reject = append(f.Reject, rejectPrivateClasses...)
+		return true, nil
+	case "filter_action":
+		if !d.NextArg() {
+			return true, d.ArgErr()
+		}
+		f.Action = d.Val()
+		if d.NextArg() {
+			return true, d.ArgErr()
+		}
+		return true, nil
+	default:
+		return false, nil
+	}
+
+	args := d.RemainingArgs()
+	if len(args) == 0 {
+		return true, d.ArgErr()
+	}
+	*dst = append(*dst, args...)
+
+	return true, nil
+}
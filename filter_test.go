@@ -0,0 +1,193 @@
+package dns
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestFilterCheck(t *testing.T) {
+	global := Filter{
+		Exclude:      []string{"203.0.113.7"},
+		ExpectWithin: []string{"203.0.113.0/24", "10.0.0.0/8", "2001:db8::/32"},
+		Reject:       []string{"private", "loopback"},
+	}
+	filter, err := global.compile()
+	if err != nil {
+		t.Fatalf("error compiling filter: %v", err)
+	}
+
+	tests := []struct {
+		addr string
+		keep bool
+	}{
+		{"203.0.113.1", true},
+		{"203.0.113.7", false},
+		{"198.51.100.1", false},
+		{"10.0.0.1", false},
+		{"2001:db8::1", true},
+	}
+	for _, test := range tests {
+		reason := filter.check(netip.MustParseAddr(test.addr))
+		if (reason == "") != test.keep {
+			t.Errorf("check(%s) = %q, want keep=%v", test.addr, reason, test.keep)
+		}
+	}
+
+	// Allow private addresses for a specific host.
+	filter, err = global.override(Filter{Reject: []string{"none"}}).compile()
+	if err != nil {
+		t.Fatalf("error compiling filter: %v", err)
+	}
+	if reason := filter.check(netip.MustParseAddr("10.0.0.1")); reason != "" {
+		t.Errorf("overridden filter rejected private address: %s", reason)
+	}
+	if reason := filter.check(netip.MustParseAddr("203.0.113.7")); reason == "" {
+		t.Errorf("overridden filter did not inherit exclude")
+	}
+}
+
+func TestFilterCompileErrors(t *testing.T) {
+	for _, f := range []Filter{
+		{Exclude: []string{"not-an-ip"}},
+		{ExpectWithin: []string{"10.0.0.0/33"}},
+		{Reject: []string{"bogus"}},
+		{Action: "ignore"},
+	} {
+		if _, err := f.compile(); err == nil {
+			t.Errorf("compile(%+v) succeeded, want error", f)
+		}
+	}
+}
+
+func TestUnmarshalHostOptions(t *testing.T) {
+	input := `dns public.example.com {
+		reject private
+		host internal-1 internal-2 {
+			reject none
+			exclude 10.0.0.1
+		}
+	}`
+
+	var r DNSRange
+	if err := r.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+
+	if want := []string{"private"}; !reflect.DeepEqual(r.Reject, want) {
+		t.Errorf("global reject: got %v, want %v", r.Reject, want)
+	}
+	want := HostOptions{Filter: Filter{Reject: []string{"none"}, Exclude: []string{"10.0.0.1"}}}
+	for _, host := range []string{"internal-1", "internal-2"} {
+		if got := r.HostOptions[host]; !reflect.DeepEqual(got, want) {
+			t.Errorf("options for %s: got %+v, want %+v", host, got, want)
+		}
+	}
+}
+
+func TestFilterActionReject(t *testing.T) {
+	input := `dns proxy.example.com {
+		expect_within 192.0.2.0/24
+		exclude 192.0.2.99
+		filter_action reject
+	}`
+	var r DNSRange
+	if err := r.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if r.Action != FilterActionReject {
+		t.Fatalf("action: got %q", r.Action)
+	}
+	r.Interval = caddy.Duration(time.Millisecond)
+
+	// Excluded addresses don't reject the update.
+	res := &fakeResolver{hosts: map[string][]string{"proxy.example.com": {"192.0.2.1", "192.0.2.99"}}}
+	cancel, err := provision(t, &r, res)
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Cleanup()
+	if got := r.GetIPRanges(nil); len(got) != 1 {
+		t.Fatalf("got %v, want 1 prefix", got)
+	}
+
+	// Addresses outside the expected networks do.
+	res.set("proxy.example.com", "192.0.2.2", "203.0.113.1")
+	waitFor(t, "pending update", func() bool { return pending(&r, "proxy.example.com") != nil })
+	got := r.GetIPRanges(nil)
+	if len(got) != 1 || got[0].Addr().String() != "192.0.2.1" {
+		t.Errorf("rejected update was applied: %v", got)
+	}
+	if p := pending(&r, "proxy.example.com"); len(p.Addresses) != 2 {
+		t.Errorf("pending: got %v, want the whole answer", p.Addresses)
+	}
+}
+
+func TestExcludeOnRefresh(t *testing.T) {
+	res := &fakeResolver{hosts: map[string][]string{"proxy.example.com": {"192.0.2.1"}}}
+	r := DNSRange{
+		Hosts:      []string{"proxy.example.com"},
+		Interval:   caddy.Duration(time.Millisecond),
+		IPv4Prefix: 30,
+		Filter:     Filter{Exclude: []string{"192.0.2.2", "198.51.100.0/24"}},
+	}
+	cancel, err := provision(t, &r, res)
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The excluded address is cut out of the widened network.
+	var got []string
+	for _, prefix := range r.GetIPRanges(nil) {
+		got = append(got, prefix.String())
+	}
+	if want := []string{"192.0.2.0/31", "192.0.2.3/32"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Addresses that show up later are filtered as well.
+	res.set("proxy.example.com", "198.51.100.1", "203.0.113.1")
+	waitFor(t, "refresh", func() bool {
+		got := r.GetIPRanges(nil)
+		return len(got) == 1 && got[0].String() == "203.0.113.0/30"
+	})
+}
+
+func TestRejectPrivate(t *testing.T) {
+	input := `dns public.example.com {
+		reject_private
+		host cloudflared {
+			reject none
+		}
+	}`
+	var r DNSRange
+	if err := r.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if !reflect.DeepEqual(r.Reject, rejectPrivateClasses) {
+		t.Errorf("reject: got %v", r.Reject)
+	}
+
+	filter, err := r.Filter.compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for addr, keep := range map[string]bool{
+		"10.0.0.1":    false,
+		"127.0.0.1":   false,
+		"169.254.0.1": false,
+		"0.0.0.0":     false,
+		"fd00::1":     false,
+		"203.0.113.1": true,
+	} {
+		if got := filter.check(netip.MustParseAddr(addr)) == ""; got != keep {
+			t.Errorf("%s: got keep=%v, want %v", addr, got, keep)
+		}
+	}
+}
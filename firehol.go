@@ -0,0 +1,296 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(new(FireHOLSource))
+}
+
+// The default refresh interval of FireHOL lists.
+const DefaultFireHOLInterval = caddy.Duration(time.Hour)
+
+// Where FireHOL's lists are downloaded from, overridden in tests.
+var fireholURL = "https://iplists.firehol.org/files/"
+
+// FireHOLSource provides the ranges in one or more of FireHOL's IP lists,
+// merged into one set. Each list is refreshed on its own, so that a list
+// that fails to download keeps its previous ranges without affecting the
+// others.
+type FireHOLSource struct {
+	// The lists to include. Defaults to only "firehol_level1".
+	Lists []*FireHOLList `json:"lists,omitempty"`
+
+	// The default options of the lists.
+	SourceOptions
+
+	logger *zap.Logger
+
+	// Retries the lists that failed when provisioning.
+	cancel   context.CancelFunc
+	retrying sync.WaitGroup
+
+	// The merged ranges of the lists, computed when they change.
+	merged atomic.Pointer[fireholMerged]
+}
+
+// fireholMerged is the union of the ranges of the lists, and the ranges of
+// each list it was computed from.
+type fireholMerged struct {
+	from     []*[]netip.Prefix
+	prefixes []netip.Prefix
+}
+
+// mergedFrom reports whether m was computed from the given ranges.
+func (m *fireholMerged) mergedFrom(from []*[]netip.Prefix) bool {
+	for i := range from {
+		if m.from[i] != from[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// FireHOLList is a list of a FireHOLSource.
+type FireHOLList struct {
+	// The name of the list, such as "firehol_level1" or "blocklist_de". The
+	// levels may also be given as "level1" and so on.
+	Name string `json:"name"`
+
+	// The URL of the list. Defaults to the .netset or .ipset file of the
+	// list, on iplists.firehol.org.
+	URL string `json:"url,omitempty"`
+
+	// The options of the list, which default to those of the source.
+	SourceOptions
+
+	refresher refresher
+}
+
+// CaddyModule returns the Caddy module information.
+func (*FireHOLSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.firehol",
+		New: func() caddy.Module { return new(FireHOLSource) },
+	}
+}
+
+// Provision fetches the lists, and starts refreshing them. It fails only if
+// all lists fail; the others are retried in the background.
+func (s *FireHOLSource) Provision(ctx caddy.Context) error {
+	if len(s.Lists) == 0 {
+		s.Lists = []*FireHOLList{{Name: "firehol_level1"}}
+	}
+	if err := s.SourceOptions.validate(DefaultFireHOLInterval); err != nil {
+		return err
+	}
+	for _, l := range s.Lists {
+		if l.Name == "" && l.URL == "" {
+			return errors.New("a list needs a name or URL")
+		}
+		if strings.HasPrefix(l.Name, "level") {
+			l.Name = "firehol_" + l.Name
+		}
+		if l.URL == "" {
+			ext := ".netset"
+			if !strings.HasPrefix(l.Name, "firehol_") {
+				ext = ".ipset"
+			}
+			l.URL = fireholURL + l.Name + ext
+		}
+		if l.Interval == 0 {
+			l.Interval = s.Interval
+		}
+		if l.Timeout == 0 {
+			l.Timeout = s.Timeout
+		}
+		if err := l.SourceOptions.validate(s.Interval); err != nil {
+			return fmt.Errorf("list %s: %w", l.Name, err)
+		}
+	}
+	s.logger = ctx.Logger()
+
+	var (
+		failed []*FireHOLList
+		errs   []error
+	)
+	for _, l := range s.Lists {
+		if err := l.start(ctx); err != nil {
+			failed = append(failed, l)
+			errs = append(errs, fmt.Errorf("list %s: %w", l.Name, err))
+		}
+	}
+	if len(failed) == len(s.Lists) {
+		return errors.Join(errs...)
+	}
+	if len(failed) > 0 {
+		s.logger.Warn("some lists failed, retrying them later", zap.Error(errors.Join(errs...)))
+		retryCtx, cancel := context.WithCancel(ctx)
+		s.cancel = cancel
+		s.retrying.Add(1)
+		go s.retry(retryCtx, ctx, failed)
+	}
+	return nil
+}
+
+// start fetches the list, and starts refreshing it. Lists with the same
+// configuration share their results, like sources do.
+func (l *FireHOLList) start(ctx caddy.Context) error {
+	key := "http.ip_sources.firehol " + string(caddyconfig.JSON(l, nil))
+	return l.refresher.start(ctx, l.SourceOptions, key, func(ctx context.Context) ([]netip.Prefix, error) {
+		data, err := fetchDocument(ctx, l.URL, nil)
+		if err != nil {
+			return nil, err
+		}
+		return parseList(FormatText, data)
+	})
+}
+
+// retry starts the lists that failed when provisioning, until they succeed
+// or ctx is canceled.
+func (s *FireHOLSource) retry(ctx context.Context, caddyCtx caddy.Context, failed []*FireHOLList) {
+	defer s.retrying.Done()
+	for len(failed) > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(ttlAfterErr):
+		}
+		remaining := failed[:0]
+		for _, l := range failed {
+			// Release the shared result of the failed start first.
+			l.refresher.stop()
+			if err := l.start(caddyCtx); err != nil {
+				if ctx.Err() == nil {
+					s.logger.Warn("fetching list failed, retrying later", zap.String("list", l.Name), zap.Error(err))
+				}
+				remaining = append(remaining, l)
+			}
+		}
+		failed = remaining
+	}
+}
+
+// Cleanup stops refreshing the lists.
+func (s *FireHOLSource) Cleanup() error {
+	if s.cancel != nil {
+		s.cancel()
+		s.retrying.Wait()
+	}
+	for _, l := range s.Lists {
+		l.refresher.stop()
+	}
+	return nil
+}
+
+// GetIPRanges returns the ranges in the lists.
+func (s *FireHOLSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	from := make([]*[]netip.Prefix, len(s.Lists))
+	for i, l := range s.Lists {
+		from[i] = l.refresher.prefixes.Load()
+	}
+	if m := s.merged.Load(); m != nil && m.mergedFrom(from) {
+		return m.prefixes
+	}
+	var prefixes []netip.Prefix
+	for _, p := range from {
+		if p != nil {
+			prefixes = append(prefixes, *p...)
+		}
+	}
+	// The lists overlap, as the levels include others.
+	prefixes = uniquePrefixes(prefixes)
+	s.merged.Store(&fireholMerged{from: from, prefixes: prefixes})
+	return prefixes
+}
+
+// healthy reports whether all lists are fetched, and their last refreshes
+// succeeded.
+func (s *FireHOLSource) healthy() bool {
+	for _, l := range s.Lists {
+		if l.refresher.prefixes.Load() == nil || !l.refresher.healthy() {
+			return false
+		}
+	}
+	return true
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	firehol [<list...>] {
+//	    list <name> [<url>] {
+//	        interval <duration>|once
+//	        timeout <duration>
+//	    }
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *FireHOLSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	for _, name := range d.RemainingArgs() {
+		s.Lists = append(s.Lists, &FireHOLList{Name: name})
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "list":
+			l := new(FireHOLList)
+			args := d.RemainingArgs()
+			switch len(args) {
+			case 2:
+				l.URL = args[1]
+				fallthrough
+			case 1:
+				l.Name = args[0]
+			default:
+				return d.ArgErr()
+			}
+			for listNesting := d.Nesting(); d.NextBlock(listNesting); {
+				ok, err := l.SourceOptions.unmarshalSourceOption(d)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					return d.Errf("unknown firehol list option %q", d.Val())
+				}
+			}
+			s.Lists = append(s.Lists, l)
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown firehol option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*FireHOLSource)(nil)
+	_ caddy.Provisioner       = (*FireHOLSource)(nil)
+	_ caddy.CleanerUpper      = (*FireHOLSource)(nil)
+	_ caddyfile.Unmarshaler   = (*FireHOLSource)(nil)
+	_ caddyhttp.IPRangeSource = (*FireHOLSource)(nil)
+)
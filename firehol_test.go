@@ -0,0 +1,88 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestFireHOLSource(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/firehol_level1.netset", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `#
+# firehol_level1
+#
+# ipv4 hash:net ipset
+#
+0.0.0.0/8
+192.0.2.0/24
+198.51.100.0/24
+`)
+	})
+	mux.HandleFunc("/blocklist_de.ipset", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "# blocklist_de\n198.51.100.7\n203.0.113.9\n")
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	defer func(u string) { fireholURL = u }(fireholURL)
+	fireholURL = srv.URL + "/"
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	s := FireHOLSource{Lists: []*FireHOLList{{Name: "level1"}, {Name: "blocklist_de"}}}
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[0.0.0.0/8 192.0.2.0/24 198.51.100.0/24 198.51.100.7/32 203.0.113.9/32]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	s.Cleanup()
+
+	// A broken list doesn't keep the others out.
+	s = FireHOLSource{Lists: []*FireHOLList{
+		{Name: "blocklist_de", SourceOptions: SourceOptions{Interval: caddy.Duration(time.Hour)}},
+		{Name: "missing"},
+	}}
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[198.51.100.7/32 203.0.113.9/32]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	s.Cleanup()
+
+	// Unless they're all broken.
+	s = FireHOLSource{Lists: []*FireHOLList{{Name: "missing"}, {URL: srv.URL + "/missing.netset"}}}
+	if err := s.Provision(ctx); err == nil {
+		t.Error("no error when all lists fail")
+	}
+	s.Cleanup()
+}
+
+func TestUnmarshalFireHOLSource(t *testing.T) {
+	var s FireHOLSource
+	input := `firehol level1 {
+		list level2 {
+			interval 30m
+		}
+		list spamhaus_drop https://example.com/drop.netset
+		interval 2h
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	var got string
+	for _, l := range s.Lists {
+		got += fmt.Sprint(l.Name, " ", l.URL, " ", time.Duration(l.Interval), "; ")
+	}
+	got += time.Duration(s.Interval).String()
+	if want := "level1  0s; level2  30m0s; spamhaus_drop https://example.com/drop.netset 0s; 2h0m0s"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
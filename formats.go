@@ -0,0 +1,243 @@
+package dns
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// Supported list formats.
+const (
+	// One IP address or CIDR per line. Anything after "#" or ";" is a
+	// comment, and only the first field of each line is used, so that
+	// annotated lists work as well.
+	FormatText = "text"
+
+	// A JSON array of IP addresses and CIDRs.
+	FormatJSON = "json"
+
+	// CSV with an IP address or CIDR in the first column. A header row is
+	// skipped.
+	FormatCSV = "csv"
+)
+
+// validateFormat checks that format is a supported list format.
+func validateFormat(format string) error {
+	switch format {
+	case "", FormatText, FormatJSON, FormatCSV:
+		return nil
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
+
+// parseList parses a list in the given format, which defaults to FormatText.
+func parseList(format string, data []byte) ([]netip.Prefix, error) {
+	switch format {
+	case FormatJSON:
+		return parseJSONList(data)
+	case FormatCSV:
+		return parseCSVList(data)
+	}
+	return parseTextList(data)
+}
+
+// parseTextList parses a list in FormatText.
+func parseTextList(data []byte) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.IndexAny(text, "#;"); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		prefix, err := parsePrefix(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, scanner.Err()
+}
+
+// parseJSONList parses a list in FormatJSON.
+func parseJSONList(data []byte) ([]netip.Prefix, error) {
+	var strs []string
+	if err := json.Unmarshal(data, &strs); err != nil {
+		return nil, err
+	}
+	return parsePrefixList(strs)
+}
+
+// validateJSONPointer checks that pointer is empty or a JSON pointer.
+func validateJSONPointer(pointer string) error {
+	if pointer != "" && !strings.HasPrefix(pointer, "/") {
+		return fmt.Errorf("invalid JSON pointer %q: must start with /", pointer)
+	}
+	return nil
+}
+
+// parseJSONPointer parses the IP addresses and CIDRs at pointer in a JSON
+// document, as a JSON pointer (RFC 6901) in which "*" stands for each
+// element of an array or object, such as "/data/*/ip". The value at the
+// pointer is a string or an array of them.
+func parseJSONPointer(data []byte, pointer string) ([]netip.Prefix, error) {
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	var path []string
+	if pointer != "" {
+		path = strings.Split(pointer[1:], "/")
+	}
+	unescape := strings.NewReplacer("~1", "/", "~0", "~")
+	for i, token := range path {
+		path[i] = unescape.Replace(token)
+	}
+	var strs []string
+	if err := collectJSONStrings(doc, path, &strs); err != nil {
+		return nil, fmt.Errorf("JSON pointer %s: %w", pointer, err)
+	}
+	return parsePrefixList(strs)
+}
+
+// collectJSONStrings appends the strings at path in v to strs.
+func collectJSONStrings(v any, path []string, strs *[]string) error {
+	if len(path) == 0 {
+		switch v := v.(type) {
+		case nil:
+		case string:
+			*strs = append(*strs, v)
+		case []any:
+			for _, elem := range v {
+				if err := collectJSONStrings(elem, nil, strs); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("%v is not a string", v)
+		}
+		return nil
+	}
+
+	token, rest := path[0], path[1:]
+	switch v := v.(type) {
+	case map[string]any:
+		if token == "*" {
+			for _, elem := range v {
+				if err := collectJSONStrings(elem, rest, strs); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		elem, ok := v[token]
+		if !ok {
+			return fmt.Errorf("no member %q", token)
+		}
+		return collectJSONStrings(elem, rest, strs)
+	case []any:
+		if token == "*" {
+			for _, elem := range v {
+				if err := collectJSONStrings(elem, rest, strs); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		i, err := strconv.Atoi(token)
+		if err != nil || i < 0 || i >= len(v) {
+			return fmt.Errorf("no element %q", token)
+		}
+		return collectJSONStrings(v[i], rest, strs)
+	}
+	return fmt.Errorf("no member %q in %v", token, v)
+}
+
+// parseCSVList parses a list in FormatCSV.
+func parseCSVList(data []byte) ([]netip.Prefix, error) {
+	return parseCSVColumn(data, "")
+}
+
+// parseCSVColumn parses a list in FormatCSV, with the IP addresses and CIDRs
+// in the given column instead of the first: a number counting from 1, or the
+// name of a column in the header row.
+func parseCSVColumn(data []byte, column string) ([]netip.Prefix, error) {
+	index, named := 0, false
+	if column != "" {
+		n, err := strconv.Atoi(column)
+		switch {
+		case err != nil:
+			named = true
+		case n < 1:
+			return nil, fmt.Errorf("invalid CSV column %d", n)
+		default:
+			index = n - 1
+		}
+	}
+
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.Comment = '#'
+	var prefixes []netip.Prefix
+	for row := 1; ; row++ {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return prefixes, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if named && row == 1 {
+			index = -1
+			for i, name := range record {
+				if strings.EqualFold(strings.TrimSpace(name), column) {
+					index = i
+					break
+				}
+			}
+			if index < 0 {
+				return nil, fmt.Errorf("no CSV column %q", column)
+			}
+			continue
+		}
+		var field string
+		if index < len(record) {
+			field = strings.TrimSpace(record[index])
+		}
+		prefix, err := parsePrefix(field)
+		if err != nil {
+			if row == 1 && !named {
+				// A header.
+				continue
+			}
+			return nil, fmt.Errorf("row %d: %w", row, err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+}
+
+// parsePrefixList parses IP addresses and CIDRs, like parsePrefixes, but with
+// errors suitable for fetched lists.
+func parsePrefixList(strs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(strs))
+	for _, s := range strs {
+		prefix, err := parsePrefix(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
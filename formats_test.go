@@ -0,0 +1,90 @@
+package dns
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseList(t *testing.T) {
+	tests := []struct {
+		format, data string
+		want         string
+		wantErr      bool
+	}{
+		{FormatText, "# proxies\n192.0.2.1\n\n198.51.100.0/24 ; SBL123\n  2001:db8::/32 extra fields\n", "[192.0.2.1/32 198.51.100.0/24 2001:db8::/32]", false},
+		{"", "192.0.2.1\r\n", "[192.0.2.1/32]", false},
+		{FormatText, "192.0.2.1\nproxy.example.com\n", "", true},
+		{FormatJSON, `["192.0.2.1", "2001:db8::/32"]`, "[192.0.2.1/32 2001:db8::/32]", false},
+		{FormatJSON, `{"prefixes": []}`, "", true},
+		{FormatCSV, "cidr,name\n192.0.2.0/24,office\n# comment\n198.51.100.1,vpn\n", "[192.0.2.0/24 198.51.100.1/32]", false},
+		{FormatCSV, "192.0.2.0/24\nbogus\n", "", true},
+	}
+	for _, test := range tests {
+		prefixes, err := parseList(test.format, []byte(test.data))
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s %q: no error", test.format, test.data)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %q: %v", test.format, test.data, err)
+		} else if got := fmt.Sprint(prefixes); got != test.want {
+			t.Errorf("%s %q: got %s, want %s", test.format, test.data, got, test.want)
+		}
+	}
+}
+
+func TestParseCSVColumn(t *testing.T) {
+	data := "name,ip,score\nscanner,192.0.2.1,90\n# comment\nbot,198.51.100.0/24,75\n"
+	for _, column := range []string{"2", "ip", "IP"} {
+		prefixes, err := parseCSVColumn([]byte(data), column)
+		if err != nil {
+			t.Errorf("column %s: %v", column, err)
+		} else if got, want := fmt.Sprint(prefixes), "[192.0.2.1/32 198.51.100.0/24]"; got != want {
+			t.Errorf("column %s: got %s, want %s", column, got, want)
+		}
+	}
+	for _, column := range []string{"0", "3", "address"} {
+		if _, err := parseCSVColumn([]byte(data), column); err == nil {
+			t.Errorf("column %s: no error", column)
+		}
+	}
+}
+
+func TestParseJSONPointer(t *testing.T) {
+	data := `{
+		"data": [
+			{"ipAddress": "192.0.2.1", "score": 100},
+			{"ipAddress": "198.51.100.7", "score": 90}
+		],
+		"ranges": {"v4": ["203.0.113.0/24"], "v6": ["2001:db8::/32"], "v/x": null}
+	}`
+	tests := []struct {
+		pointer, want string
+		wantErr       bool
+	}{
+		{"/data/*/ipAddress", "[192.0.2.1/32 198.51.100.7/32]", false},
+		{"/data/1/ipAddress", "[198.51.100.7/32]", false},
+		{"/ranges/v4", "[203.0.113.0/24]", false},
+		{"/ranges/*", "[203.0.113.0/24 2001:db8::/32]", false},
+		{"/ranges/v~1x", "[]", false},
+		{"/data/*/score", "", true},
+		{"/data/2/ipAddress", "", true},
+		{"/missing", "", true},
+	}
+	for _, test := range tests {
+		prefixes, err := parseJSONPointer([]byte(data), test.pointer)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: no error", test.pointer)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.pointer, err)
+		} else if got := fmt.Sprint(uniquePrefixes(prefixes)); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.pointer, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(new(GatewaySource))
+}
+
+// The default refresh interval of the gateway source. Where the network
+// configuration can be watched, changes apply right away, so this is only a
+// fallback.
+const DefaultGatewayInterval = caddy.Duration(time.Minute)
+
+// Lists the default gateways, overridden in tests.
+var listGateways = systemGateways
+
+// GatewaySource provides the addresses of the system's default gateways.
+type GatewaySource struct {
+	SourceOptions
+
+	refresher refresher
+}
+
+// CaddyModule returns the Caddy module information.
+func (*GatewaySource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.gateway",
+		New: func() caddy.Module { return new(GatewaySource) },
+	}
+}
+
+// Provision looks up the gateways, and starts watching for changes.
+func (s *GatewaySource) Provision(ctx caddy.Context) error {
+	if err := s.SourceOptions.validate(DefaultGatewayInterval); err != nil {
+		return err
+	}
+	// As with the interface source, the result isn't shared.
+	if err := s.refresher.start(ctx, s.SourceOptions, "", s.fetch); err != nil {
+		return err
+	}
+	if err := watchNetwork(s.refresher.ctx, s.refresher.refreshNow); err != nil {
+		ctx.Logger().Debug("can't watch network changes, only refreshing every interval", zap.Error(err))
+	}
+	return nil
+}
+
+// fetch looks up the gateways.
+func (s *GatewaySource) fetch(_ context.Context) ([]netip.Prefix, error) {
+	gateways, err := listGateways()
+	if err != nil {
+		return nil, err
+	}
+	if len(gateways) == 0 {
+		return nil, errors.New("no default gateway")
+	}
+	prefixes := make([]netip.Prefix, 0, len(gateways))
+	for _, addr := range gateways {
+		// Zones don't matter for matching client addresses.
+		addr = addr.WithZone("").Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// Cleanup stops refreshing the gateways.
+func (s *GatewaySource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the addresses of the gateways.
+func (s *GatewaySource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *GatewaySource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	gateway {
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *GatewaySource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		ok, err := s.SourceOptions.unmarshalSourceOption(d)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return d.Errf("unknown gateway option %q", d.Val())
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*GatewaySource)(nil)
+	_ caddy.Provisioner       = (*GatewaySource)(nil)
+	_ caddy.CleanerUpper      = (*GatewaySource)(nil)
+	_ caddyfile.Unmarshaler   = (*GatewaySource)(nil)
+	_ caddyhttp.IPRangeSource = (*GatewaySource)(nil)
+)
@@ -0,0 +1,68 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package dns
+
+import (
+	"net/netip"
+
+	"golang.org/x/net/route"
+	"golang.org/x/sys/unix"
+)
+
+// systemGateways lists the gateways of the default routes. On macOS and the
+// BSDs, the routes are dumped from the routing socket.
+func systemGateways() ([]netip.Addr, error) {
+	rib, err := route.FetchRIB(unix.AF_UNSPEC, route.RIBTypeRoute, 0)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := route.ParseRIB(route.RIBTypeRoute, rib)
+	if err != nil {
+		return nil, err
+	}
+	var gateways []netip.Addr
+	for _, msg := range msgs {
+		m, ok := msg.(*route.RouteMessage)
+		if !ok || m.Flags&(unix.RTF_UP|unix.RTF_GATEWAY) != unix.RTF_UP|unix.RTF_GATEWAY || len(m.Addrs) <= unix.RTAX_GATEWAY {
+			continue
+		}
+		if !isDefaultRoute(m) {
+			continue
+		}
+		switch gw := m.Addrs[unix.RTAX_GATEWAY].(type) {
+		case *route.Inet4Addr:
+			gateways = append(gateways, netip.AddrFrom4(gw.IP))
+		case *route.Inet6Addr:
+			gateways = append(gateways, netip.AddrFrom16(gw.IP))
+		}
+	}
+	return gateways, nil
+}
+
+// isDefaultRoute reports whether a route is a default route: one to the
+// unspecified address, with an empty netmask.
+func isDefaultRoute(m *route.RouteMessage) bool {
+	var dst netip.Addr
+	switch a := m.Addrs[unix.RTAX_DST].(type) {
+	case *route.Inet4Addr:
+		dst = netip.AddrFrom4(a.IP)
+	case *route.Inet6Addr:
+		dst = netip.AddrFrom16(a.IP)
+	default:
+		return false
+	}
+	if !dst.IsUnspecified() {
+		return false
+	}
+	if len(m.Addrs) <= unix.RTAX_NETMASK || m.Addrs[unix.RTAX_NETMASK] == nil {
+		// Host routes have no netmask, but then the flag says so.
+		return m.Flags&unix.RTF_HOST == 0
+	}
+	switch mask := m.Addrs[unix.RTAX_NETMASK].(type) {
+	case *route.Inet4Addr:
+		return netip.AddrFrom4(mask.IP).IsUnspecified()
+	case *route.Inet6Addr:
+		return netip.AddrFrom16(mask.IP).IsUnspecified()
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package dns
+
+import (
+	"net/netip"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// systemGateways lists the gateways of the default routes in the main routing
+// table. On Linux, the routes are dumped with netlink.
+func systemGateways() ([]netip.Addr, error) {
+	rib, err := syscall.NetlinkRIB(unix.RTM_GETROUTE, unix.AF_UNSPEC)
+	if err != nil {
+		return nil, os.NewSyscallError("netlink", err)
+	}
+	msgs, err := syscall.ParseNetlinkMessage(rib)
+	if err != nil {
+		return nil, err
+	}
+	var gateways []netip.Addr
+	for _, m := range msgs {
+		if m.Header.Type != unix.RTM_NEWROUTE || len(m.Data) < unix.SizeofRtMsg {
+			continue
+		}
+		rt := (*unix.RtMsg)(unsafe.Pointer(&m.Data[0]))
+		if rt.Dst_len != 0 || rt.Table != unix.RT_TABLE_MAIN || rt.Type != unix.RTN_UNICAST {
+			continue
+		}
+		attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+		if err != nil {
+			return nil, err
+		}
+		for _, attr := range attrs {
+			if attr.Attr.Type != unix.RTA_GATEWAY {
+				continue
+			}
+			if addr, ok := netip.AddrFromSlice(attr.Value); ok {
+				gateways = append(gateways, addr)
+			}
+		}
+	}
+	return gateways, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package dns
+
+import (
+	"errors"
+	"net/netip"
+)
+
+// systemGateways reports that the default gateways can't be looked up on
+// this platform.
+func systemGateways() ([]netip.Addr, error) {
+	return nil, errors.New("not supported on this platform")
+}
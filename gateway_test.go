@@ -0,0 +1,63 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestSystemGateways(t *testing.T) {
+	gateways, err := systemGateways()
+	if err != nil {
+		t.Skipf("can't look up gateways here: %v", err)
+	}
+	for _, gw := range gateways {
+		if !gw.IsValid() || gw.IsUnspecified() {
+			t.Errorf("invalid gateway %v", gw)
+		}
+	}
+}
+
+func TestGatewaySource(t *testing.T) {
+	defer func(f func() ([]netip.Addr, error)) { listGateways = f }(listGateways)
+	gateways := []netip.Addr{netip.MustParseAddr("192.168.1.1"), netip.MustParseAddr("fe80::1%eth0")}
+	listGateways = func() ([]netip.Addr, error) { return gateways, nil }
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	s := GatewaySource{}
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cleanup()
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[192.168.1.1/32 fe80::1/128]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// Without a gateway, there's nothing to trust.
+	gateways = nil
+	none := GatewaySource{}
+	if err := none.Provision(ctx); err == nil {
+		none.Cleanup()
+		t.Error("no error without a default gateway")
+	}
+}
+
+func TestUnmarshalGatewaySource(t *testing.T) {
+	var s GatewaySource
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(`gateway {
+		interval 5m
+	}`)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.Interval), "300000000000"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(`gateway eth0`)); err == nil {
+		t.Error("no error for an argument")
+	}
+}
@@ -0,0 +1,190 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(new(GCPSource))
+}
+
+// Supported values for GCPSource.Feed.
+const (
+	// The ranges of Google Cloud customer resources, per scope (region).
+	GCPFeedCloud = "cloud"
+
+	// All ranges of Google, including those of Google Cloud load
+	// balancers and Cloud Armor.
+	GCPFeedGoog = "goog"
+)
+
+// The default refresh interval of the Google Cloud source.
+const DefaultGCPInterval = caddy.Duration(6 * time.Hour)
+
+// The URLs of the feeds.
+var gcpFeedURLs = map[string]string{
+	GCPFeedCloud: "https://www.gstatic.com/ipranges/cloud.json",
+	GCPFeedGoog:  "https://www.gstatic.com/ipranges/goog.json",
+}
+
+// GCPSource provides the ranges Google publishes in cloud.json or goog.json,
+// optionally only those of some scopes.
+type GCPSource struct {
+	// The feed: "cloud" (the default) or "goog".
+	Feed string `json:"feed,omitempty"`
+
+	// The scopes to include, such as "us-central1" or "global". Only the
+	// cloud feed has scopes. Defaults to all of them. Case-insensitive.
+	Scopes []string `json:"scopes,omitempty"`
+
+	// The URL to fetch the feed from. Defaults to Google's URL of the feed.
+	URL string `json:"url,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+}
+
+// gcpIPRanges is the content of cloud.json and goog.json, which has no
+// scopes. Google's and Bing's crawler lists have the same format.
+type gcpIPRanges struct {
+	Prefixes []struct {
+		IPv4Prefix string `json:"ipv4Prefix"`
+		IPv6Prefix string `json:"ipv6Prefix"`
+		Scope      string `json:"scope"`
+	} `json:"prefixes"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (*GCPSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.gcp",
+		New: func() caddy.Module { return new(GCPSource) },
+	}
+}
+
+// Provision fetches the ranges, and starts refreshing them.
+func (s *GCPSource) Provision(ctx caddy.Context) error {
+	if s.Feed == "" {
+		s.Feed = GCPFeedCloud
+	}
+	switch s.Feed {
+	case GCPFeedCloud:
+	case GCPFeedGoog:
+		if len(s.Scopes) > 0 {
+			return errors.New("the goog feed has no scopes")
+		}
+	default:
+		return fmt.Errorf("unknown feed %q", s.Feed)
+	}
+	if s.URL == "" {
+		s.URL = gcpFeedURLs[s.Feed]
+	}
+	if err := s.SourceOptions.validate(DefaultGCPInterval); err != nil {
+		return err
+	}
+	return s.refresher.start(ctx, s.SourceOptions, sourceKey(s), s.fetch)
+}
+
+// fetch fetches the ranges.
+func (s *GCPSource) fetch(ctx context.Context) ([]netip.Prefix, error) {
+	var ranges gcpIPRanges
+	if err := fetchJSON(ctx, s.URL, nil, &ranges); err != nil {
+		return nil, err
+	}
+	var strs []string
+	for _, p := range ranges.Prefixes {
+		if !matchesAny(s.Scopes, p.Scope) {
+			continue
+		}
+		if p.IPv4Prefix != "" {
+			strs = append(strs, p.IPv4Prefix)
+		}
+		if p.IPv6Prefix != "" {
+			strs = append(strs, p.IPv6Prefix)
+		}
+	}
+	if len(strs) == 0 {
+		return nil, errors.New("no Google Cloud ranges match the scopes")
+	}
+	return parsePrefixList(strs)
+}
+
+// Cleanup stops refreshing the ranges.
+func (s *GCPSource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the selected Google Cloud ranges.
+func (s *GCPSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *GCPSource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	gcp [cloud|goog] {
+//	    scope <scope...>
+//	    url <url>
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *GCPSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	if d.NextArg() {
+		s.Feed = d.Val()
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "scope":
+			scopes := d.RemainingArgs()
+			if len(scopes) == 0 {
+				return d.ArgErr()
+			}
+			s.Scopes = append(s.Scopes, scopes...)
+		case "url":
+			if !d.AllArgs(&s.URL) {
+				return d.ArgErr()
+			}
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown gcp option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*GCPSource)(nil)
+	_ caddy.Provisioner       = (*GCPSource)(nil)
+	_ caddy.CleanerUpper      = (*GCPSource)(nil)
+	_ caddyfile.Unmarshaler   = (*GCPSource)(nil)
+	_ caddyhttp.IPRangeSource = (*GCPSource)(nil)
+)
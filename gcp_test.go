@@ -0,0 +1,90 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestGCPSource(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cloud.json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{
+			"syncToken": "1680000000000",
+			"creationTime": "2023-03-28T10:00:00.000000",
+			"prefixes": [
+				{"ipv4Prefix": "34.1.208.0/20", "service": "Google Cloud", "scope": "africa-south1"},
+				{"ipv4Prefix": "35.186.0.0/17", "service": "Google Cloud", "scope": "us-central1"},
+				{"ipv6Prefix": "2600:1900:4000::/44", "service": "Google Cloud", "scope": "us-central1"}
+			]
+		}`)
+	})
+	mux.HandleFunc("/goog.json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{
+			"syncToken": "1680000000000",
+			"creationTime": "2023-03-28T10:00:00.000000",
+			"prefixes": [{"ipv4Prefix": "8.8.4.0/24"}, {"ipv6Prefix": "2001:4860::/32"}]
+		}`)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	for _, test := range []struct {
+		feed   string
+		scopes []string
+		want   string
+	}{
+		{"", nil, "[34.1.208.0/20 35.186.0.0/17 2600:1900:4000::/44]"},
+		{"cloud", []string{"US-CENTRAL1"}, "[35.186.0.0/17 2600:1900:4000::/44]"},
+		{"goog", nil, "[8.8.4.0/24 2001:4860::/32]"},
+	} {
+		feed := test.feed
+		if feed == "" {
+			feed = "cloud"
+		}
+		s := GCPSource{Feed: test.feed, Scopes: test.scopes, URL: srv.URL + "/" + feed + ".json"}
+		if err := s.Provision(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprint(s.GetIPRanges(nil)); got != test.want {
+			t.Errorf("feed %q, scopes %q: got %s, want %s", test.feed, test.scopes, got, test.want)
+		}
+		s.Cleanup()
+	}
+
+	for _, s := range []*GCPSource{
+		{Feed: "goog", Scopes: []string{"global"}},
+		{Feed: "aws"},
+		{URL: srv.URL + "/cloud.json", Scopes: []string{"mars-north1"}},
+	} {
+		if err := s.Provision(ctx); err == nil {
+			t.Errorf("no error for feed %q, scopes %q", s.Feed, s.Scopes)
+		}
+		s.Cleanup()
+	}
+}
+
+func TestUnmarshalGCPSource(t *testing.T) {
+	var s GCPSource
+	input := `gcp cloud {
+		scope us-central1 europe-west1
+		interval 12h
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.Feed, " ", s.Scopes), "cloud [us-central1 europe-west1]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if err := new(GCPSource).UnmarshalCaddyfile(caddyfile.NewTestDispenser("gcp cloud goog")); err == nil {
+		t.Error("no error for two feeds")
+	}
+}
@@ -0,0 +1,169 @@
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(new(GitHubSource))
+}
+
+const (
+	// The default refresh interval of the GitHub source.
+	DefaultGitHubInterval = caddy.Duration(time.Hour)
+
+	// The URL of GitHub's meta API.
+	DefaultGitHubURL = "https://api.github.com/meta"
+)
+
+// GitHubSource provides the ranges of some categories of GitHub's meta API,
+// such as "hooks", "actions" or "pages".
+type GitHubSource struct {
+	// The categories to include, as named by the API. Required.
+	Categories []string `json:"categories"`
+
+	// The URL of the meta API. Defaults to DefaultGitHubURL; GitHub
+	// Enterprise Server has its own.
+	URL string `json:"url,omitempty"`
+
+	// Header fields to send, such as an Authorization header with a token
+	// to raise the rate limit. Values may use global placeholders like
+	// {env.GITHUB_TOKEN}.
+	Headers map[string]string `json:"headers,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+}
+
+// CaddyModule returns the Caddy module information.
+func (*GitHubSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.github",
+		New: func() caddy.Module { return new(GitHubSource) },
+	}
+}
+
+// Provision fetches the ranges, and starts refreshing them.
+func (s *GitHubSource) Provision(ctx caddy.Context) error {
+	if len(s.Categories) == 0 {
+		return errors.New("no categories provided")
+	}
+	if s.URL == "" {
+		s.URL = DefaultGitHubURL
+	}
+	if err := s.SourceOptions.validate(DefaultGitHubInterval); err != nil {
+		return err
+	}
+	header := replaceHeaders(s.Headers)
+	header.Set("Accept", "application/vnd.github+json")
+	return s.refresher.start(ctx, s.SourceOptions, sourceKey(s), func(ctx context.Context) ([]netip.Prefix, error) {
+		return s.fetch(ctx, header)
+	})
+}
+
+// fetch fetches the ranges.
+func (s *GitHubSource) fetch(ctx context.Context, header http.Header) ([]netip.Prefix, error) {
+	// The response also has fields that aren't lists of ranges, so only the
+	// selected categories are parsed.
+	var meta map[string]json.RawMessage
+	if err := fetchJSON(ctx, s.URL, header, &meta); err != nil {
+		return nil, err
+	}
+	var strs []string
+	for _, category := range s.Categories {
+		raw, ok := meta[category]
+		if !ok {
+			return nil, fmt.Errorf("unknown GitHub meta category %q", category)
+		}
+		var ranges []string
+		if err := json.Unmarshal(raw, &ranges); err != nil {
+			return nil, fmt.Errorf("GitHub meta category %q is not a list of ranges", category)
+		}
+		strs = append(strs, ranges...)
+	}
+	if len(strs) == 0 {
+		return nil, errors.New("GitHub meta API returned no ranges")
+	}
+	return parsePrefixList(strs)
+}
+
+// Cleanup stops refreshing the ranges.
+func (s *GitHubSource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the ranges of the selected categories.
+func (s *GitHubSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *GitHubSource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	github [<category...>] {
+//	    category <category...>
+//	    url <url>
+//	    header <name> <value>
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *GitHubSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	s.Categories = append(s.Categories, d.RemainingArgs()...)
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "category":
+			categories := d.RemainingArgs()
+			if len(categories) == 0 {
+				return d.ArgErr()
+			}
+			s.Categories = append(s.Categories, categories...)
+		case "url":
+			if !d.AllArgs(&s.URL) {
+				return d.ArgErr()
+			}
+		case "header":
+			if err := unmarshalHeader(d, &s.Headers); err != nil {
+				return err
+			}
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown github option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*GitHubSource)(nil)
+	_ caddy.Provisioner       = (*GitHubSource)(nil)
+	_ caddy.CleanerUpper      = (*GitHubSource)(nil)
+	_ caddyfile.Unmarshaler   = (*GitHubSource)(nil)
+	_ caddyhttp.IPRangeSource = (*GitHubSource)(nil)
+)
@@ -0,0 +1,66 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func newGitHubMetaServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != "application/vnd.github+json" {
+			t.Errorf("Accept: got %q", r.Header.Get("Accept"))
+		}
+		fmt.Fprint(w, `{
+			"verifiable_password_authentication": true,
+			"ssh_key_fingerprints": {"SHA256_ED25519": "+DiY3wvvV6TuJJhbpZisF/zLDA0zPMSvHdkr4UvCOqU"},
+			"hooks": ["192.30.252.0/22", "2a0a:a440::/29"],
+			"pages": ["185.199.108.0/22"],
+			"actions": []
+		}`)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestGitHubSource(t *testing.T) {
+	srv := newGitHubMetaServer(t)
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	s := GitHubSource{Categories: []string{"hooks", "pages", "actions"}, URL: srv.URL}
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[185.199.108.0/22 192.30.252.0/22 2a0a:a440::/29]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	s.Cleanup()
+
+	for _, categories := range [][]string{nil, {"copilot"}, {"ssh_key_fingerprints"}, {"actions"}} {
+		s := GitHubSource{Categories: categories, URL: srv.URL}
+		if err := s.Provision(ctx); err == nil {
+			t.Errorf("no error for categories %q", categories)
+		}
+		s.Cleanup()
+	}
+}
+
+func TestUnmarshalGitHubSource(t *testing.T) {
+	var s GitHubSource
+	input := `github hooks {
+		category pages actions
+		header Authorization "Bearer {env.GITHUB_TOKEN}"
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.Categories, s.Headers), "[hooks pages actions] map[Authorization:Bearer {env.GITHUB_TOKEN}]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,90 @@
+package dns
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Values for health.Status.
+const (
+	HealthOK       = "ok"
+	HealthDegraded = "degraded"
+)
+
+// health is the health of all ranges, as reported by the health endpoint.
+type health struct {
+	Status string `json:"status"`
+
+	// The hosts that are degraded, sorted by host and range.
+	Degraded []degradedHost `json:"degraded,omitempty"`
+}
+
+// degradedHost is a host whose addresses can't be relied on.
+type degradedHost struct {
+	Host   string `json:"host"`
+	Range  string `json:"range,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// currentHealth returns the health of all ranges.
+func currentHealth() health {
+	var degraded []degradedHost
+	now := time.Now()
+	instancesMu.Lock()
+	for d := range instances {
+		degraded = append(degraded, d.degradedHosts(now)...)
+	}
+	instancesMu.Unlock()
+
+	if len(degraded) == 0 {
+		return health{Status: HealthOK}
+	}
+	sort.Slice(degraded, func(i, j int) bool {
+		if degraded[i].Host != degraded[j].Host {
+			return degraded[i].Host < degraded[j].Host
+		}
+		return degraded[i].Range < degraded[j].Range
+	})
+	return health{Status: HealthDegraded, Degraded: degraded}
+}
+
+// degradedHosts returns the hosts of d that failed to resolve at least
+// FailureThreshold times in a row, or whose addresses are older than
+// StaleAfter.
+func (d *DNSRange) degradedHosts(now time.Time) []degradedHost {
+	var degraded []degradedHost
+	for _, host := range d.Hosts {
+		if reason := d.degradedReason(host, now); reason != "" {
+			degraded = append(degraded, degradedHost{Host: host, Range: d.Name, Reason: reason})
+		}
+	}
+	return degraded
+}
+
+// degradedReason returns why host is degraded, or "" if it isn't.
+func (d *DNSRange) degradedReason(host string, now time.Time) string {
+	h := d.state[host]
+	h.mu.Lock()
+	failures := h.failures
+	h.mu.Unlock()
+	if d.FailureThreshold > 0 && failures >= d.FailureThreshold {
+		return fmt.Sprintf("%d lookups in a row failed", failures)
+	}
+
+	// Hosts that are resolved only once, or not refreshed while the range
+	// is idle, aren't expected to be confirmed.
+	if d.StaleAfter <= 0 || d.interval(host) < 0 {
+		return ""
+	}
+	if status, _ := d.sched.status(host); status == schedulePaused {
+		return ""
+	}
+	// Hosts without addresses are left to the failure threshold.
+	if v := h.current(); v != nil {
+		if age := now.Sub(v.confirmed); age > time.Duration(d.StaleAfter) {
+			return fmt.Sprintf("addresses not confirmed for %s", age.Round(time.Second))
+		}
+	}
+	return ""
+}
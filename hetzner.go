@@ -0,0 +1,212 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(new(HetznerSource))
+}
+
+// The default refresh interval of the Hetzner Cloud source.
+const DefaultHetznerInterval = caddy.Duration(time.Minute)
+
+// The Hetzner Cloud API, overridden in tests.
+var hetznerAPIURL = "https://api.hetzner.cloud/v1"
+
+// HetznerSource provides the addresses of the servers in a Hetzner Cloud
+// project, optionally only those matching a label selector.
+type HetznerSource struct {
+	// The API token of the project. Defaults to $HCLOUD_TOKEN. May use global
+	// placeholders like {env.HETZNER_TOKEN}.
+	Token string `json:"token,omitempty"`
+
+	// A label selector the servers must match, such as "role=proxy".
+	LabelSelector string `json:"label_selector,omitempty"`
+
+	// Which addresses to provide: "public", "private" or "all". Defaults to
+	// "all".
+	Addresses string `json:"addresses,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+	header    http.Header
+}
+
+// hetznerServers is a page of servers.
+type hetznerServers struct {
+	Servers []struct {
+		PublicNet struct {
+			IPv4 *struct {
+				IP string `json:"ip"`
+			} `json:"ipv4"`
+			IPv6 *struct {
+				IP string `json:"ip"`
+			} `json:"ipv6"`
+		} `json:"public_net"`
+		PrivateNet []struct {
+			IP string `json:"ip"`
+		} `json:"private_net"`
+	} `json:"servers"`
+	Meta struct {
+		Pagination struct {
+			NextPage *int `json:"next_page"`
+		} `json:"pagination"`
+	} `json:"meta"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (*HetznerSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.hetzner",
+		New: func() caddy.Module { return new(HetznerSource) },
+	}
+}
+
+// Provision fetches the addresses, and starts refreshing them.
+func (s *HetznerSource) Provision(ctx caddy.Context) error {
+	token := s.Token
+	if token == "" {
+		token = os.Getenv("HCLOUD_TOKEN")
+	}
+	token = caddy.NewReplacer().ReplaceKnown(token, "")
+	if token == "" {
+		return errors.New("no API token provided")
+	}
+	switch s.Addresses {
+	case "":
+		s.Addresses = "all"
+	case "all", "public", "private":
+	default:
+		return fmt.Errorf("invalid addresses %q", s.Addresses)
+	}
+	if err := s.SourceOptions.validate(DefaultHetznerInterval); err != nil {
+		return err
+	}
+	s.header = http.Header{"Authorization": {"Bearer " + token}}
+	return s.refresher.start(ctx, s.SourceOptions, sourceKey(s), s.fetch)
+}
+
+// fetch fetches the addresses of the servers, a page at a time.
+func (s *HetznerSource) fetch(ctx context.Context) ([]netip.Prefix, error) {
+	public := s.Addresses == "all" || s.Addresses == "public"
+	private := s.Addresses == "all" || s.Addresses == "private"
+
+	var strs []string
+	for page := 1; ; {
+		query := url.Values{"page": {strconv.Itoa(page)}, "per_page": {"50"}}
+		if s.LabelSelector != "" {
+			query.Set("label_selector", s.LabelSelector)
+		}
+		var servers hetznerServers
+		if err := fetchJSON(ctx, hetznerAPIURL+"/servers?"+query.Encode(), s.header, &servers); err != nil {
+			return nil, err
+		}
+		for _, server := range servers.Servers {
+			if public && server.PublicNet.IPv4 != nil {
+				strs = append(strs, server.PublicNet.IPv4.IP)
+			}
+			// Servers get a whole /64.
+			if public && server.PublicNet.IPv6 != nil {
+				strs = append(strs, server.PublicNet.IPv6.IP)
+			}
+			if private {
+				for _, n := range server.PrivateNet {
+					strs = append(strs, n.IP)
+				}
+			}
+		}
+		next := servers.Meta.Pagination.NextPage
+		if next == nil || *next <= page {
+			break
+		}
+		page = *next
+	}
+	return parsePrefixList(strs)
+}
+
+// Cleanup stops refreshing the addresses.
+func (s *HetznerSource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the addresses of the servers.
+func (s *HetznerSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *HetznerSource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	hetzner [<label selector>] {
+//	    token <token>
+//	    label_selector <selector>
+//	    addresses public|private|all
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *HetznerSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	if d.NextArg() {
+		s.LabelSelector = d.Val()
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "token":
+			if !d.AllArgs(&s.Token) {
+				return d.ArgErr()
+			}
+		case "label_selector":
+			if !d.AllArgs(&s.LabelSelector) {
+				return d.ArgErr()
+			}
+		case "addresses":
+			if !d.AllArgs(&s.Addresses) {
+				return d.ArgErr()
+			}
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown hetzner option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*HetznerSource)(nil)
+	_ caddy.Provisioner       = (*HetznerSource)(nil)
+	_ caddy.CleanerUpper      = (*HetznerSource)(nil)
+	_ caddyfile.Unmarshaler   = (*HetznerSource)(nil)
+	_ caddyhttp.IPRangeSource = (*HetznerSource)(nil)
+)
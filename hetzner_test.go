@@ -0,0 +1,73 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestHetznerSource(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path != "/servers" || r.Header.Get("Authorization") != "Bearer secret" || q.Get("label_selector") != "role=proxy" {
+			http.NotFound(w, r)
+			return
+		}
+		switch q.Get("page") {
+		case "1":
+			fmt.Fprint(w, `{"servers": [
+				{"public_net": {"ipv4": {"ip": "203.0.113.1"}, "ipv6": {"ip": "2001:db8:1::/64"}}, "private_net": [{"ip": "10.0.0.2"}]}
+			], "meta": {"pagination": {"next_page": 2}}}`)
+		case "2":
+			fmt.Fprint(w, `{"servers": [
+				{"public_net": {"ipv4": null, "ipv6": {"ip": "2001:db8:2::/64"}}, "private_net": []}
+			], "meta": {"pagination": {"next_page": null}}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	defer func(u string) { hetznerAPIURL = u }(hetznerAPIURL)
+	hetznerAPIURL = srv.URL
+	t.Setenv("HCLOUD_TOKEN", "secret")
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	for _, test := range []struct {
+		addresses string
+		want      string
+	}{
+		{"", "[10.0.0.2/32 203.0.113.1/32 2001:db8:1::/64 2001:db8:2::/64]"},
+		{"public", "[203.0.113.1/32 2001:db8:1::/64 2001:db8:2::/64]"},
+		{"private", "[10.0.0.2/32]"},
+	} {
+		s := HetznerSource{LabelSelector: "role=proxy", Addresses: test.addresses}
+		if err := s.Provision(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprint(s.GetIPRanges(nil)); got != test.want {
+			t.Errorf("addresses %q: got %s, want %s", test.addresses, got, test.want)
+		}
+		s.Cleanup()
+	}
+}
+
+func TestUnmarshalHetznerSource(t *testing.T) {
+	var s HetznerSource
+	input := `hetzner role=proxy,env!=staging {
+		token {env.HETZNER_TOKEN}
+		addresses private
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.LabelSelector, " ", s.Token, " ", s.Addresses), "role=proxy,env!=staging {env.HETZNER_TOKEN} private"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,188 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fvbommel/caddy-dns-ip-range/iprange"
+)
+
+// hostState is the state of a single host. Each host has its own lock, so
+// that a slow update of one host never blocks the others, and readers don't
+// take it at all. The hosts of a range are fixed when it's provisioned, so
+// the map holding them needs no lock of its own.
+type hostState struct {
+	// Serializes changes to the host.
+	mu sync.Mutex
+
+	// The current addresses, or nil if the host hasn't been resolved.
+	// Replaced as a whole, so that reading doesn't need the lock.
+	view atomic.Pointer[hostView]
+
+	// An update rejected by safety checks, if any. Guarded by mu.
+	pending *pendingUpdate
+
+	// When the host was last looked up, the error of that lookup if it
+	// failed, and the number of consecutive failed lookups. Guarded by mu.
+	lastLookup time.Time
+	lastError  string
+	failures   int
+
+	// The most recent distinct lookup results, oldest first, and how many
+	// to keep. Guarded by mu.
+	history     []historyEntry
+	historySize int
+
+	// The addresses returned by the most recent successful lookup, before
+	// prefix lengths were applied, and whether each of the probed ones was
+	// reachable when last probed. Guarded by mu.
+	answer []netip.Addr
+	alive  map[netip.Addr]bool
+}
+
+// hostView is an immutable copy of the addresses of a host.
+type hostView struct {
+	addresses []netip.Prefix
+
+	// When the addresses were last confirmed by a lookup.
+	confirmed time.Time
+}
+
+// current returns the addresses of the host, or nil if it hasn't been
+// resolved.
+func (h *hostState) current() *hostView {
+	return h.view.Load()
+}
+
+// set replaces the addresses of the host, and drops any pending update.
+// The caller must hold h.mu.
+func (h *hostState) set(prefixes []netip.Prefix) {
+	h.view.Store(&hostView{addresses: prefixes, confirmed: time.Now()})
+	h.pending = nil
+}
+
+// confirm records that a lookup returned the current addresses again, and
+// drops any pending update. The caller must hold h.mu.
+func (h *hostState) confirm() {
+	if v := h.view.Load(); v != nil {
+		h.view.Store(&hostView{addresses: v.addresses, confirmed: time.Now()})
+	}
+	h.pending = nil
+}
+
+// answered records the addresses returned by a successful lookup.
+func (h *hostState) answered(addrs []netip.Addr) {
+	h.mu.Lock()
+	h.answer = append(h.answer[:0:0], addrs...)
+	h.mu.Unlock()
+}
+
+// probeTargets returns the addresses to probe: those of the most recent
+// answer that are in the current addresses of the host.
+func (h *hostState) probeTargets() []netip.Addr {
+	v := h.current()
+	if v == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var addrs []netip.Addr
+	for _, addr := range h.answer {
+		if iprange.Contains(v.addresses, addr) {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// drop forgets the addresses of the host. The caller must hold h.mu.
+func (h *hostState) drop() {
+	h.view.Store(nil)
+}
+
+// attempted records whether a lookup of the host failed, and returns the
+// number of consecutive failed lookups.
+func (h *hostState) attempted(err error) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastLookup = time.Now()
+	if err == nil {
+		h.lastError, h.failures = "", 0
+	} else {
+		h.lastError = err.Error()
+		h.failures++
+	}
+	return h.failures
+}
+
+// record adds the outcome of the last lookup of the host to the history,
+// once it's known whether its addresses were applied. Updates held back by
+// safety checks are recorded with the reason as their error.
+func (h *hostState) record(prefixes []netip.Prefix, err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remember(h.lastLookup, prefixes, err)
+}
+
+// historyEntry is a lookup result of a host, as returned by one or more
+// lookups in a row.
+type historyEntry struct {
+	// When the result was first and last returned.
+	From     time.Time `json:"from"`
+	LastSeen time.Time `json:"last_seen"`
+
+	// The number of lookups in a row that returned it.
+	Lookups int `json:"lookups"`
+
+	// The addresses, after applying the options of the range. For updates
+	// rejected by safety checks, these are the rejected addresses.
+	Addresses []netip.Prefix `json:"addresses"`
+
+	// The error, if the lookup failed or its result was rejected.
+	Error string `json:"error,omitempty"`
+}
+
+// remember adds a lookup result to the history. Repeated results only
+// extend the latest entry, so that the history covers as much time as
+// possible. The caller must hold h.mu.
+func (h *hostState) remember(at time.Time, prefixes []netip.Prefix, err error) {
+	if h.historySize <= 0 {
+		return
+	}
+	entry := historyEntry{From: at, LastSeen: at, Lookups: 1, Addresses: prefixes}
+	if err != nil {
+		entry.Error = err.Error()
+		var rejected *rejectedUpdate
+		if errors.As(err, &rejected) {
+			entry.Addresses = rejected.addresses
+		}
+	}
+
+	if n := len(h.history); n > 0 {
+		last := &h.history[n-1]
+		if last.Error == entry.Error && samePrefixes(last.Addresses, entry.Addresses) {
+			last.LastSeen = at
+			last.Lookups++
+			return
+		}
+		if n >= h.historySize {
+			h.history = append(h.history[:0], h.history[n-h.historySize+1:]...)
+		}
+	}
+	h.history = append(h.history, entry)
+}
+
+// publish rebuilds the merged addresses after the addresses of some host or
+// their confirmation time changed.
+func (d *DNSRange) publish() {
+	d.mu.Lock()
+	d.rebuild()
+	d.mu.Unlock()
+}
@@ -0,0 +1,192 @@
+package dns
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(new(HostsFileSource))
+}
+
+// The default hosts file.
+const DefaultHostsFile = "/etc/hosts"
+
+// HostsFileSource provides the addresses of host names in a hosts file. It
+// watches the file, so that changes apply as tooling writes them.
+type HostsFileSource struct {
+	// The host names to look up.
+	Hosts []string `json:"hosts"`
+
+	// The path of the hosts file. Defaults to DefaultHostsFile.
+	Path string `json:"path,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+}
+
+// CaddyModule returns the Caddy module information.
+func (*HostsFileSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.hosts_file",
+		New: func() caddy.Module { return new(HostsFileSource) },
+	}
+}
+
+// Provision reads the hosts file, and starts watching it.
+func (s *HostsFileSource) Provision(ctx caddy.Context) error {
+	if len(s.Hosts) == 0 {
+		return errors.New("no hosts provided")
+	}
+	if s.Path == "" {
+		s.Path = DefaultHostsFile
+	}
+	if err := s.SourceOptions.validate(DefaultFileInterval); err != nil {
+		return err
+	}
+	// As with the file source, the result isn't shared, so that changes
+	// made during a reload aren't missed.
+	if err := s.refresher.start(ctx, s.SourceOptions, "", s.fetch); err != nil {
+		return err
+	}
+	s.refresher.watch(func(ctx context.Context) error {
+		return watchFile(ctx, s.Path, s.refresher.refreshNow)
+	})
+	return nil
+}
+
+// fetch reads the addresses of the hosts from the file.
+func (s *HostsFileSource) fetch(_ context.Context) ([]netip.Prefix, error) {
+	f, err := os.Open(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxDocumentSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxDocumentSize {
+		return nil, fmt.Errorf("reading %s: file larger than %d bytes", s.Path, maxDocumentSize)
+	}
+	return parseHostsFile(data, s.Hosts)
+}
+
+// parseHostsFile returns the addresses of the hosts in a hosts file, in which
+// each line holds an address and its names, and "#" starts a comment. Names
+// are case-insensitive.
+func parseHostsFile(data []byte, hosts []string) ([]netip.Prefix, error) {
+	wanted := make(map[string]bool, len(hosts))
+	for _, host := range hosts {
+		wanted[strings.ToLower(strings.TrimSuffix(host, "."))] = true
+	}
+
+	var prefixes []netip.Prefix
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) < 2 {
+			continue
+		}
+		match := false
+		for _, name := range fields[1:] {
+			if wanted[strings.ToLower(strings.TrimSuffix(name, "."))] {
+				match = true
+				break
+			}
+		}
+		if !match {
+			continue
+		}
+		addr, err := netip.ParseAddr(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		// Zones, as in fe80::1%lo0, don't matter for matching client addresses.
+		addr = addr.WithZone("").Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return prefixes, nil
+}
+
+// Cleanup stops watching the hosts file.
+func (s *HostsFileSource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the addresses of the hosts.
+func (s *HostsFileSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *HostsFileSource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	hosts_file [<host...>] {
+//	    host <host...>
+//	    path <path>
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *HostsFileSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	s.Hosts = append(s.Hosts, d.RemainingArgs()...)
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "host":
+			hosts := d.RemainingArgs()
+			if len(hosts) == 0 {
+				return d.ArgErr()
+			}
+			s.Hosts = append(s.Hosts, hosts...)
+		case "path":
+			if !d.AllArgs(&s.Path) {
+				return d.ArgErr()
+			}
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown hosts_file option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*HostsFileSource)(nil)
+	_ caddy.Provisioner       = (*HostsFileSource)(nil)
+	_ caddy.CleanerUpper      = (*HostsFileSource)(nil)
+	_ caddyfile.Unmarshaler   = (*HostsFileSource)(nil)
+	_ caddyhttp.IPRangeSource = (*HostsFileSource)(nil)
+)
@@ -0,0 +1,77 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestParseHostsFile(t *testing.T) {
+	data := []byte(`127.0.0.1 localhost
+# 192.0.2.9 proxy-a.internal
+192.0.2.1	proxy-a.internal proxy-a # managed by Ansible
+192.0.2.2 Proxy-B.Internal.
+fe80::1%lo0 proxy-a
+::ffff:192.0.2.3 proxy-c.internal
+not-an-address other.internal
+`)
+	got, err := parseHostsFile(data, []string{"proxy-a", "proxy-b.internal", "PROXY-C.internal."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[192.0.2.1/32 192.0.2.2/32 fe80::1/128 192.0.2.3/32]"; fmt.Sprint(got) != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+
+	if _, err := parseHostsFile(data, []string{"other.internal"}); err == nil {
+		t.Error("no error for an invalid address")
+	}
+}
+
+func TestHostsFileSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("127.0.0.1 localhost\n192.0.2.1 proxy.internal\n")
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	s := HostsFileSource{Hosts: []string{"proxy.internal"}, Path: path}
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cleanup()
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[192.0.2.1/32]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// The watch starts in the background, so keep writing until the change
+	// is seen.
+	waitFor(t, "change", func() bool {
+		write("192.0.2.2 proxy.internal\n2001:db8::2 proxy.internal\n")
+		return fmt.Sprint(s.GetIPRanges(nil)) == "[192.0.2.2/32 2001:db8::2/128]"
+	})
+}
+
+func TestUnmarshalHostsFileSource(t *testing.T) {
+	var s HostsFileSource
+	input := `hosts_file proxy-a.internal {
+		host proxy-b.internal proxy-c.internal
+		path /etc/hosts.proxies
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.Hosts, " ", s.Path), "[proxy-a.internal proxy-b.internal proxy-c.internal] /etc/hosts.proxies"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,84 @@
+package dns
+
+import (
+	"errors"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func TestHostLocks(t *testing.T) {
+	res := &fakeResolver{hosts: map[string][]string{
+		"a.example.com": {"192.0.2.1"},
+		"b.example.com": {"198.51.100.1"},
+	}}
+	r := DNSRange{Hosts: []string{"a.example.com", "b.example.com"}, Interval: caddy.Duration(time.Hour)}
+	cancel, err := provision(t, &r, res)
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// While one host is being changed, the other can still be updated and read.
+	a := r.state["a.example.com"]
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.update("b.example.com", []netip.Prefix{netip.MustParsePrefix("198.51.100.2/32")})
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("update blocked by the lock of another host")
+	}
+
+	want := []netip.Prefix{netip.MustParsePrefix("192.0.2.1/32"), netip.MustParsePrefix("198.51.100.2/32")}
+	if got := r.GetIPRanges(nil); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	a := []netip.Prefix{netip.MustParsePrefix("192.0.2.1/32")}
+	b := []netip.Prefix{netip.MustParsePrefix("192.0.2.2/32")}
+	lookupErr := errors.New("lookup failed")
+
+	h := hostState{historySize: 3}
+	start := time.Date(2026, 1, 1, 3, 0, 0, 0, time.UTC)
+	results := []struct {
+		prefixes []netip.Prefix
+		err      error
+	}{
+		{a, nil}, {a, nil}, {nil, lookupErr}, {b, nil}, {b, nil}, {nil, &rejectedUpdate{addresses: a, reason: "too many changes"}},
+	}
+	for i, result := range results {
+		h.remember(start.Add(time.Duration(i)*time.Minute), result.prefixes, result.err)
+	}
+
+	// The first entry was dropped, and repeated results were combined.
+	want := []historyEntry{
+		{From: start.Add(2 * time.Minute), LastSeen: start.Add(2 * time.Minute), Lookups: 1, Error: "lookup failed"},
+		{From: start.Add(3 * time.Minute), LastSeen: start.Add(4 * time.Minute), Lookups: 2, Addresses: b},
+		{From: start.Add(5 * time.Minute), LastSeen: start.Add(5 * time.Minute), Lookups: 1, Addresses: a, Error: "update rejected: too many changes"},
+	}
+	if !reflect.DeepEqual(h.history, want) {
+		t.Errorf("got %+v, want %+v", h.history, want)
+	}
+
+	d := DNSRange{Hosts: []string{"proxy.example.com"}, state: map[string]*hostState{"proxy.example.com": &h}}
+	if got := d.hostHistory("proxy.example.com", start.Add(200*time.Second)).Entries; !reflect.DeepEqual(got, want[1:2]) {
+		t.Errorf("entry at 03:03:20: got %+v, want %+v", got, want[1])
+	}
+	if got := d.hostHistory("proxy.example.com", start).Entries; len(got) != 0 {
+		t.Errorf("entry before history: got %+v", got)
+	}
+	if got := d.hostHistory("proxy.example.com", time.Time{}).Entries; len(got) != 3 || !reflect.DeepEqual(got[0], want[2]) {
+		t.Errorf("full history: got %+v, want newest first", got)
+	}
+}
@@ -0,0 +1,291 @@
+package dns
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(new(HTTPSource))
+}
+
+// The default refresh interval of the HTTP source.
+const DefaultHTTPSourceInterval = caddy.Duration(time.Hour)
+
+// The maximum size of a fetched document.
+const maxDocumentSize = 64 << 20
+
+// The User-Agent of requests made by sources.
+const sourceUserAgent = "caddy-dns-ip-range"
+
+// HTTPSource provides the IP addresses and CIDRs in a list fetched from a URL.
+type HTTPSource struct {
+	// The URL of the list.
+	URL string `json:"url"`
+
+	// The format of the list: "text" (one per line, the default), "json"
+	// (an array of strings) or "csv" (in the first column).
+	Format string `json:"format,omitempty"`
+
+	// The column of the list in the CSV format: a number counting from 1,
+	// or the name of a column in the header row.
+	Column string `json:"column,omitempty"`
+
+	// Where the list is in the JSON format, as a JSON pointer in which "*"
+	// stands for each element of an array or object, such as "/data/*/ip".
+	// Defaults to the whole document.
+	JSONPointer string `json:"json_pointer,omitempty"`
+
+	// Header fields to send, such as Authorization. Values may use global
+	// placeholders like {env.TOKEN}.
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// A token to send as a bearer token. May use global placeholders.
+	BearerToken string `json:"bearer_token,omitempty"`
+
+	// The user and password to authenticate with, using basic
+	// authentication. May use global placeholders.
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+}
+
+// CaddyModule returns the Caddy module information.
+func (*HTTPSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.http",
+		New: func() caddy.Module { return new(HTTPSource) },
+	}
+}
+
+// Provision fetches the list, and starts refreshing it.
+func (s *HTTPSource) Provision(ctx caddy.Context) error {
+	if s.URL == "" {
+		return errors.New("no URL provided")
+	}
+	if err := validateFormat(s.Format); err != nil {
+		return err
+	}
+	if s.Column != "" && s.Format != FormatCSV {
+		return errors.New("a column requires the csv format")
+	}
+	if err := validateJSONPointer(s.JSONPointer); err != nil {
+		return err
+	}
+	if s.JSONPointer != "" && s.Format != FormatJSON {
+		return errors.New("a JSON pointer requires the json format")
+	}
+	if s.BearerToken != "" && s.Username != "" {
+		return errors.New("cannot use both a bearer token and basic authentication")
+	}
+	if err := s.SourceOptions.validate(DefaultHTTPSourceInterval); err != nil {
+		return err
+	}
+	header := replaceHeaders(s.Headers)
+	repl := caddy.NewReplacer()
+	if s.BearerToken != "" {
+		header.Set("Authorization", "Bearer "+repl.ReplaceKnown(s.BearerToken, ""))
+	}
+	if s.Username != "" {
+		auth := repl.ReplaceKnown(s.Username, "") + ":" + repl.ReplaceKnown(s.Password, "")
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(auth)))
+	}
+	return s.refresher.start(ctx, s.SourceOptions, sourceKey(s), func(ctx context.Context) ([]netip.Prefix, error) {
+		data, err := fetchDocument(ctx, s.URL, header)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case s.Column != "":
+			return parseCSVColumn(data, s.Column)
+		case s.JSONPointer != "":
+			return parseJSONPointer(data, s.JSONPointer)
+		}
+		return parseList(s.Format, data)
+	})
+}
+
+// Cleanup stops refreshing the list.
+func (s *HTTPSource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the prefixes in the list.
+func (s *HTTPSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *HTTPSource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	http <url> {
+//	    format text|json [<pointer>]|csv [<column>]
+//	    header <name> <value>
+//	    bearer_token <token>
+//	    basic_auth <username> <password>
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *HTTPSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	if !d.AllArgs(&s.URL) {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "format":
+			args := d.RemainingArgs()
+			switch {
+			case len(args) == 1:
+			case len(args) == 2 && args[0] == FormatCSV:
+				s.Column = args[1]
+			case len(args) == 2 && args[0] == FormatJSON:
+				s.JSONPointer = args[1]
+			default:
+				return d.ArgErr()
+			}
+			s.Format = args[0]
+		case "header":
+			if err := unmarshalHeader(d, &s.Headers); err != nil {
+				return err
+			}
+		case "bearer_token":
+			if !d.AllArgs(&s.BearerToken) {
+				return d.ArgErr()
+			}
+		case "basic_auth":
+			if !d.AllArgs(&s.Username, &s.Password) {
+				return d.ArgErr()
+			}
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown http option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// unmarshalHeader parses a "header <name> <value>" subdirective into headers.
+func unmarshalHeader(d *caddyfile.Dispenser, headers *map[string]string) error {
+	var name, value string
+	if !d.AllArgs(&name, &value) {
+		return d.ArgErr()
+	}
+	if *headers == nil {
+		*headers = make(map[string]string)
+	}
+	(*headers)[name] = value
+	return nil
+}
+
+// replaceHeaders returns the header fields, with global placeholders replaced.
+func replaceHeaders(headers map[string]string) http.Header {
+	repl := caddy.NewReplacer()
+	header := make(http.Header, len(headers))
+	for name, value := range headers {
+		header.Set(name, repl.ReplaceKnown(value, ""))
+	}
+	return header
+}
+
+// fetchDocument fetches the document at url, sending the given header fields.
+func fetchDocument(ctx context.Context, url string, header http.Header) ([]byte, error) {
+	return fetchDocumentWith(ctx, http.DefaultClient, url, header)
+}
+
+// fetchDocumentWith is like fetchDocument, using client.
+func fetchDocumentWith(ctx context.Context, client *http.Client, url string, header http.Header) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", sourceUserAgent)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: unexpected status %s", req.URL.Redacted(), resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxDocumentSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxDocumentSize {
+		return nil, fmt.Errorf("fetching %s: document larger than %d bytes", req.URL.Redacted(), maxDocumentSize)
+	}
+	return data, nil
+}
+
+// fetchJSON fetches the JSON document at rawURL into v.
+func fetchJSON(ctx context.Context, rawURL string, header http.Header, v any) error {
+	return fetchJSONWith(ctx, http.DefaultClient, rawURL, header, v)
+}
+
+// fetchJSONWith is like fetchJSON, using client.
+func fetchJSONWith(ctx context.Context, client *http.Client, rawURL string, header http.Header, v any) error {
+	data, err := fetchDocumentWith(ctx, client, rawURL, header)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("parsing %s: %w", redactURL(rawURL), err)
+	}
+	return nil
+}
+
+// redactURL returns rawURL with the password replaced by "xxxxx", for use in
+// errors.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "invalid URL"
+	}
+	return u.Redacted()
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*HTTPSource)(nil)
+	_ caddy.Provisioner       = (*HTTPSource)(nil)
+	_ caddy.CleanerUpper      = (*HTTPSource)(nil)
+	_ caddyfile.Unmarshaler   = (*HTTPSource)(nil)
+	_ caddyhttp.IPRangeSource = (*HTTPSource)(nil)
+)
@@ -0,0 +1,173 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestHTTPSource(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		body = "192.0.2.1\n192.0.2.0/24\n192.0.2.1\n"
+		code = http.StatusOK
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		w.WriteHeader(code)
+		fmt.Fprint(w, body)
+	}))
+	defer srv.Close()
+	t.Setenv("HTTP_SOURCE_TEST_TOKEN", "secret")
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	s := HTTPSource{
+		URL:           srv.URL,
+		Headers:       map[string]string{"Authorization": "Bearer {env.HTTP_SOURCE_TEST_TOKEN}"},
+		SourceOptions: SourceOptions{Interval: caddy.Duration(time.Hour)},
+	}
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cleanup()
+
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[192.0.2.0/24 192.0.2.1/32]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// Failed refreshes keep the previous prefixes.
+	mu.Lock()
+	code = http.StatusInternalServerError
+	mu.Unlock()
+	if err := s.refresher.refresh(); err == nil {
+		t.Error("no error for failed fetch")
+	}
+	if got := len(s.GetIPRanges(nil)); got != 2 {
+		t.Errorf("got %d prefixes after failed refresh, want 2", got)
+	}
+	if s.healthy() {
+		t.Error("healthy after failed refresh")
+	}
+
+	mu.Lock()
+	code, body = http.StatusOK, "2001:db8::1\n"
+	mu.Unlock()
+	s.refresher.refreshNow()
+	waitFor(t, "refresh", func() bool { return fmt.Sprint(s.GetIPRanges(nil)) == "[2001:db8::1/128]" })
+	if !s.healthy() {
+		t.Error("unhealthy after successful refresh")
+	}
+}
+
+func TestHTTPSourceProvisionError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	s := HTTPSource{URL: srv.URL}
+	if err := s.Provision(ctx); err == nil {
+		t.Error("no error when the list can't be fetched")
+	}
+	s.Cleanup()
+}
+
+func TestHTTPSourceBasicAuth(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "feed" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"indicators":[{"value":"192.0.2.7"},{"value":"198.51.100.0/24"}]}`)
+	}))
+	defer srv.Close()
+	t.Setenv("HTTP_SOURCE_TEST_PASSWORD", "secret")
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	s := HTTPSource{
+		URL:         srv.URL,
+		Format:      FormatJSON,
+		JSONPointer: "/indicators/*/value",
+		Username:    "feed",
+		Password:    "{env.HTTP_SOURCE_TEST_PASSWORD}",
+	}
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cleanup()
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[192.0.2.7/32 198.51.100.0/24]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	bad := HTTPSource{URL: srv.URL, Format: FormatText, JSONPointer: "/indicators"}
+	if err := bad.Provision(ctx); err == nil {
+		bad.Cleanup()
+		t.Error("no error for a JSON pointer without the json format")
+	}
+}
+
+func TestFetchJSONRedactsURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "not JSON")
+	}))
+	defer srv.Close()
+
+	u := strings.Replace(srv.URL, "://", "://user:secret@", 1)
+	var v any
+	err := fetchJSON(context.Background(), u, nil, &v)
+	if err == nil {
+		t.Fatal("no error for invalid JSON")
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("error %q contains the password", err)
+	}
+}
+
+func TestUnmarshalHTTPSourceAuth(t *testing.T) {
+	input := `http https://example.com/feed.csv {
+		format csv indicator
+		basic_auth feed {env.FEED_PASSWORD}
+	}`
+
+	var s HTTPSource
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.Format, " ", s.Column, " ", s.Username, " ", s.Password), "csv indicator feed {env.FEED_PASSWORD}"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestUnmarshalHTTPSource(t *testing.T) {
+	input := `http https://example.com/ranges.json {
+		format json
+		header Authorization "Bearer {env.TOKEN}"
+		interval 6h
+		timeout 10s
+	}`
+
+	var s HTTPSource
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if s.URL != "https://example.com/ranges.json" || s.Format != FormatJSON ||
+		s.Headers["Authorization"] != "Bearer {env.TOKEN}" ||
+		s.Interval != caddy.Duration(6*time.Hour) || s.Timeout != caddy.Duration(10*time.Second) {
+		t.Errorf("got %+v", s.SourceOptions)
+	}
+}
@@ -0,0 +1,170 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(new(InterfaceSource))
+}
+
+// The default refresh interval of the interface source. Where the network
+// configuration can be watched, changes apply right away, so this is only a
+// fallback.
+const DefaultInterfaceInterval = caddy.Duration(time.Minute)
+
+// Lists the addresses of a network interface, overridden in tests.
+var interfaceAddrs = func(name string) ([]net.Addr, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("interface %s: %w", name, err)
+	}
+	return iface.Addrs()
+}
+
+// InterfaceSource provides the subnets of the addresses assigned to local
+// network interfaces, or only the addresses themselves.
+type InterfaceSource struct {
+	// The names of the interfaces, such as "docker0".
+	Interfaces []string `json:"interfaces"`
+
+	// Provide the addresses of the interfaces, instead of their subnets.
+	AddressesOnly bool `json:"addresses_only,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+}
+
+// CaddyModule returns the Caddy module information.
+func (*InterfaceSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.interface",
+		New: func() caddy.Module { return new(InterfaceSource) },
+	}
+}
+
+// Provision lists the subnets, and starts watching for changes.
+func (s *InterfaceSource) Provision(ctx caddy.Context) error {
+	if len(s.Interfaces) == 0 {
+		return errors.New("no interfaces provided")
+	}
+	if err := s.SourceOptions.validate(DefaultInterfaceInterval); err != nil {
+		return err
+	}
+	// Listing is cheap, and changes seen while watching the network are
+	// missed across a reload, so the result isn't shared.
+	if err := s.refresher.start(ctx, s.SourceOptions, "", s.fetch); err != nil {
+		return err
+	}
+	if err := watchNetwork(s.refresher.ctx, s.refresher.refreshNow); err != nil {
+		ctx.Logger().Debug("can't watch network changes, only refreshing every interval", zap.Error(err))
+	}
+	return nil
+}
+
+// fetch lists the subnets or addresses of the interfaces.
+func (s *InterfaceSource) fetch(_ context.Context) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, name := range s.Interfaces {
+		addrs, err := interfaceAddrs(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			ip, ok := netip.AddrFromSlice(ipnet.IP)
+			// Every IPv6 interface has the same link-local subnet.
+			if !ok || ip.Unmap().IsLinkLocalUnicast() {
+				continue
+			}
+			ip = ip.Unmap()
+			bits, _ := ipnet.Mask.Size()
+			if ip.Is4() && bits > 32 {
+				bits -= 96
+			}
+			p := netip.PrefixFrom(ip, bits)
+			if s.AddressesOnly {
+				p = netip.PrefixFrom(ip, ip.BitLen())
+			}
+			prefixes = append(prefixes, p.Masked())
+		}
+	}
+	return prefixes, nil
+}
+
+// Cleanup stops refreshing the subnets.
+func (s *InterfaceSource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the subnets or addresses of the interfaces.
+func (s *InterfaceSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *InterfaceSource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	interface <name...> {
+//	    addresses_only
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *InterfaceSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	s.Interfaces = d.RemainingArgs()
+	if len(s.Interfaces) == 0 {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "addresses_only":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			s.AddressesOnly = true
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown interface option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*InterfaceSource)(nil)
+	_ caddy.Provisioner       = (*InterfaceSource)(nil)
+	_ caddy.CleanerUpper      = (*InterfaceSource)(nil)
+	_ caddyfile.Unmarshaler   = (*InterfaceSource)(nil)
+	_ caddyhttp.IPRangeSource = (*InterfaceSource)(nil)
+)
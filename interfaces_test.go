@@ -0,0 +1,92 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestInterfaceSource(t *testing.T) {
+	defer func(f func(string) ([]net.Addr, error)) { interfaceAddrs = f }(interfaceAddrs)
+	interfaceAddrs = func(name string) ([]net.Addr, error) {
+		cidr := func(s string) *net.IPNet {
+			ip, ipnet, _ := net.ParseCIDR(s)
+			ipnet.IP = ip
+			return ipnet
+		}
+		switch name {
+		case "docker0":
+			return []net.Addr{cidr("172.17.0.1/16"), cidr("fd00:dead:beef::1/64"), cidr("fe80::42:acff:fe11:1/64")}, nil
+		case "tailscale0":
+			return []net.Addr{cidr("100.101.102.103/32")}, nil
+		}
+		return nil, errors.New("no such network interface")
+	}
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	for _, test := range []*struct {
+		source InterfaceSource
+		want   string
+	}{
+		{InterfaceSource{Interfaces: []string{"docker0", "tailscale0"}}, "[100.101.102.103/32 172.17.0.0/16 fd00:dead:beef::/64]"},
+		{InterfaceSource{Interfaces: []string{"docker0"}, AddressesOnly: true}, "[172.17.0.1/32 fd00:dead:beef::1/128]"},
+	} {
+		s := &test.source
+		if err := s.Provision(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprint(s.GetIPRanges(nil)); got != test.want {
+			t.Errorf("%q: got %s, want %s", s.Interfaces, got, test.want)
+		}
+		s.Cleanup()
+	}
+
+	missing := InterfaceSource{Interfaces: []string{"wg9"}}
+	if err := missing.Provision(ctx); err == nil {
+		missing.Cleanup()
+		t.Error("no error for a missing interface")
+	}
+}
+
+func TestInterfaceSourceLoopback(t *testing.T) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback == 0 {
+			continue
+		}
+		ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+		defer cancel()
+		s := InterfaceSource{Interfaces: []string{iface.Name}}
+		if err := s.Provision(ctx); err != nil {
+			t.Fatal(err)
+		}
+		defer s.Cleanup()
+		if got := fmt.Sprint(s.GetIPRanges(nil)); got != "[127.0.0.0/8 ::1/128]" && got != "[127.0.0.0/8]" {
+			t.Errorf("%s: got %s", iface.Name, got)
+		}
+		return
+	}
+	t.Skip("no loopback interface")
+}
+
+func TestUnmarshalInterfaceSource(t *testing.T) {
+	var s InterfaceSource
+	input := `interface docker0 tailscale0 {
+		addresses_only
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.Interfaces, " ", s.AddressesOnly), "[docker0 tailscale0] true"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,102 @@
+package dns
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/netip"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/fvbommel/caddy-dns-ip-range/iprange"
+)
+
+func init() {
+	caddy.RegisterModule(new(IntersectSource))
+}
+
+// IntersectSource provides the addresses that are in the ranges of all of
+// its sources, to trust only addresses that several sources agree on.
+type IntersectSource struct {
+	// The sources to intersect.
+	SourcesRaw []json.RawMessage `json:"sources,omitempty" caddy:"namespace=http.ip_sources inline_key=source"`
+
+	sources      []caddyhttp.IPRangeSource
+	intersection derivedRanges
+}
+
+// CaddyModule returns the Caddy module information.
+func (*IntersectSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.intersect",
+		New: func() caddy.Module { return new(IntersectSource) },
+	}
+}
+
+// Provision loads the sources, which are cleaned up along with the config.
+func (s *IntersectSource) Provision(ctx caddy.Context) error {
+	if len(s.SourcesRaw) < 2 {
+		return errors.New("at least two sources are required")
+	}
+	sources, err := loadIPSources(ctx, s, "SourcesRaw")
+	if err != nil {
+		return err
+	}
+	s.sources = sources
+	return nil
+}
+
+// GetIPRanges returns the addresses in the ranges of all sources.
+func (s *IntersectSource) GetIPRanges(r *http.Request) []netip.Prefix {
+	return s.intersection.get(s.sources, r, func(from [][]netip.Prefix) []netip.Prefix {
+		prefixes := from[0]
+		for _, p := range from[1:] {
+			prefixes = iprange.Intersect(prefixes, p)
+		}
+		return prefixes
+	})
+}
+
+// healthy reports whether all sources are healthy.
+func (s *IntersectSource) healthy() bool {
+	return allHealthy(s.sources)
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	intersect {
+//	    source <module> ...
+//	}
+//
+// where each source is an IP range source module, with the same syntax as
+// in trusted_proxies.
+func (s *IntersectSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		if d.Val() != "source" {
+			return d.Errf("unknown intersect option %q", d.Val())
+		}
+		raw, err := unmarshalIPSource(d)
+		if err != nil {
+			return err
+		}
+		s.SourcesRaw = append(s.SourcesRaw, raw)
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*IntersectSource)(nil)
+	_ caddy.Provisioner       = (*IntersectSource)(nil)
+	_ caddyfile.Unmarshaler   = (*IntersectSource)(nil)
+	_ caddyhttp.IPRangeSource = (*IntersectSource)(nil)
+)
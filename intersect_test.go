@@ -0,0 +1,55 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestIntersectSource(t *testing.T) {
+	var s IntersectSource
+	input := `intersect {
+		source static 100.64.0.0/10 fd7a:115c:a1e0::/48
+		source static 100.100.1.2 192.0.2.1 fd7a:115c:a1e0::/64
+		source combine {
+			source static 100.100.0.0/16
+			source static fd7a:115c:a1e0::1
+		}
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[100.100.1.2/32 fd7a:115c:a1e0::1/128]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { s.GetIPRanges(nil) }); allocs != 0 {
+		t.Errorf("GetIPRanges allocates %v times per call", allocs)
+	}
+
+	for _, input := range []string{
+		`intersect {
+			source static 192.0.2.0/24
+		}`,
+		`intersect {
+			static 192.0.2.0/24
+		}`,
+	} {
+		var s IntersectSource
+		err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input))
+		if err == nil {
+			err = s.Provision(ctx)
+		}
+		if err == nil {
+			t.Errorf("%s: no error", input)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+// Package iprange provides arithmetic on sets of IP prefixes (CIDRs).
+//
+// All functions treat a slice of prefixes as the set of addresses covered by
+// any of them. IPv4 and IPv6 addresses are kept apart, except that
+// IPv4-mapped IPv6 prefixes are converted to plain IPv4 by Normalize.
+package iprange
+
+import (
+	"net/netip"
+	"sort"
+)
+
+// Normalize returns the canonical form of a prefix: IPv4-mapped IPv6
+// prefixes are unmapped to IPv4, zones are dropped and host bits are
+// masked off. Invalid prefixes are returned unchanged.
+func Normalize(p netip.Prefix) netip.Prefix {
+	if !p.IsValid() {
+		return p
+	}
+
+	addr, bits := p.Addr().WithZone(""), p.Bits()
+	if addr.Is4In6() && bits >= 96 {
+		addr, bits = addr.Unmap(), bits-96
+	}
+
+	return netip.PrefixFrom(addr, bits).Masked()
+}
+
+// Contains reports whether addr is contained in any of the prefixes.
+func Contains(prefixes []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap().WithZone("")
+	for _, p := range prefixes {
+		if Normalize(p).Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Set is an immutable set of addresses, built from prefixes for quick
+// containment checks. A nil Set is empty.
+type Set struct {
+	ranges []addrRange
+}
+
+// NewSet returns the set of addresses covered by prefixes.
+// Invalid prefixes are dropped.
+func NewSet(prefixes []netip.Prefix) *Set {
+	return &Set{ranges: toRanges(prefixes)}
+}
+
+// Contains reports whether addr is in the set. It takes logarithmic time in
+// the number of prefixes, and doesn't allocate.
+func (s *Set) Contains(addr netip.Addr) bool {
+	if s == nil {
+		return false
+	}
+	addr = addr.Unmap().WithZone("")
+	// Find the last range starting at or before addr.
+	lo, hi := 0, len(s.ranges)
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if addr.Less(s.ranges[mid].first) {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	return lo > 0 && !s.ranges[lo-1].last.Less(addr)
+}
+
+// Aggregate returns the smallest sorted list of prefixes covering exactly the
+// same addresses as the input. Duplicate, overlapping and adjacent prefixes
+// are merged. Invalid prefixes are dropped. The input is not modified.
+func Aggregate(prefixes []netip.Prefix) []netip.Prefix {
+	return fromRanges(toRanges(prefixes))
+}
+
+// Subtract returns the addresses covered by base but not by remove, as the
+// smallest sorted list of prefixes. Invalid prefixes are dropped.
+func Subtract(base, remove []netip.Prefix) []netip.Prefix {
+	have, drop := toRanges(base), toRanges(remove)
+
+	var result []addrRange
+	for _, r := range have {
+		for _, d := range drop {
+			if d.last.Less(r.first) || r.last.Less(d.first) {
+				// No overlap.
+				continue
+			}
+			if r.first.Less(d.first) {
+				// Keep the part before the removed range.
+				result = append(result, addrRange{r.first, d.first.Prev()})
+			}
+			if !d.last.Less(r.last) {
+				// Nothing left of this range.
+				r.first = netip.Addr{}
+				break
+			}
+			r.first = d.last.Next()
+		}
+		if r.first.IsValid() {
+			result = append(result, r)
+		}
+	}
+
+	return fromRanges(result)
+}
+
+// Intersect returns the addresses covered by both a and b, as the smallest
+// sorted list of prefixes. Invalid prefixes are dropped.
+func Intersect(a, b []netip.Prefix) []netip.Prefix {
+	ra, rb := toRanges(a), toRanges(b)
+
+	var result []addrRange
+	for i, j := 0, 0; i < len(ra) && j < len(rb); {
+		r := addrRange{ra[i].first, ra[i].last}
+		if r.first.Less(rb[j].first) {
+			r.first = rb[j].first
+		}
+		if rb[j].last.Less(r.last) {
+			r.last = rb[j].last
+		}
+		// Families are ordered, so ranges of different ones don't overlap.
+		if !r.last.Less(r.first) {
+			result = append(result, r)
+		}
+		// The range ending first can't overlap any later ones.
+		if ra[i].last.Less(rb[j].last) {
+			i++
+		} else {
+			j++
+		}
+	}
+
+	return fromRanges(result)
+}
+
+// FromRange returns the smallest sorted list of prefixes covering the
+// addresses from first to last, inclusive. It returns nil if they're of
+// different families, or last comes before first.
+func FromRange(first, last netip.Addr) []netip.Prefix {
+	first, last = first.Unmap(), last.Unmap()
+	if !first.IsValid() || first.BitLen() != last.BitLen() || last.Less(first) {
+		return nil
+	}
+	return appendRangePrefixes(nil, first, last)
+}
+
+// An inclusive range of addresses of the same family.
+type addrRange struct {
+	first, last netip.Addr
+}
+
+// toRanges converts prefixes into sorted, merged, non-adjacent ranges.
+func toRanges(prefixes []netip.Prefix) []addrRange {
+	ranges := make([]addrRange, 0, len(prefixes))
+	for _, p := range prefixes {
+		p = Normalize(p)
+		if !p.IsValid() {
+			continue
+		}
+		ranges = append(ranges, addrRange{p.Addr(), lastAddr(p)})
+	}
+
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].first.Less(ranges[j].first)
+	})
+
+	merged := ranges[:0]
+	for _, r := range ranges {
+		if n := len(merged); n > 0 {
+			cur := &merged[n-1]
+			// Next() returns an invalid address when cur.last is the highest
+			// address of its family, in which case nothing can follow.
+			next := cur.last.Next()
+			if cur.first.BitLen() == r.first.BitLen() && (!next.IsValid() || !next.Less(r.first)) {
+				if cur.last.Less(r.last) {
+					cur.last = r.last
+				}
+				continue
+			}
+		}
+		merged = append(merged, r)
+	}
+
+	return merged
+}
+
+// fromRanges converts sorted ranges into the minimal list of prefixes.
+func fromRanges(ranges []addrRange) []netip.Prefix {
+	var result []netip.Prefix
+	for _, r := range ranges {
+		result = appendRangePrefixes(result, r.first, r.last)
+	}
+	return result
+}
+
+// appendRangePrefixes appends the minimal list of prefixes covering the
+// addresses from first to last (inclusive) to dst.
+func appendRangePrefixes(dst []netip.Prefix, first, last netip.Addr) []netip.Prefix {
+	for {
+		// Find the shortest prefix starting at first that doesn't extend past last.
+		p := netip.PrefixFrom(first, first.BitLen())
+		for bits := first.BitLen() - 1; bits >= 0; bits-- {
+			q := netip.PrefixFrom(first, bits).Masked()
+			if q.Addr() != first || last.Less(lastAddr(q)) {
+				break
+			}
+			p = q
+		}
+		dst = append(dst, p)
+
+		end := lastAddr(p)
+		if end == last {
+			return dst
+		}
+		first = end.Next()
+	}
+}
+
+// lastAddr returns the highest address contained in p.
+func lastAddr(p netip.Prefix) netip.Addr {
+	addr := p.Masked().Addr()
+	if addr.Is4() {
+		b := addr.As4()
+		setHostBits(b[:], p.Bits())
+		return netip.AddrFrom4(b)
+	}
+	b := addr.As16()
+	setHostBits(b[:], p.Bits())
+	return netip.AddrFrom16(b)
+}
+
+// setHostBits sets all bits after the first bits bits of b.
+func setHostBits(b []byte, bits int) {
+	for i := bits; i < len(b)*8; i++ {
+		b[i/8] |= 0x80 >> (i % 8)
+	}
+}
@@ -0,0 +1,164 @@
+package iprange
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func prefixes(strs ...string) []netip.Prefix {
+	result := make([]netip.Prefix, 0, len(strs))
+	for _, s := range strs {
+		result = append(result, netip.MustParsePrefix(s))
+	}
+	return result
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"10.1.2.3/8", "10.0.0.0/8"},
+		{"::ffff:10.0.0.5/128", "10.0.0.5/32"},
+		{"::ffff:10.0.0.0/104", "10.0.0.0/8"},
+		{"2001:db8::1/32", "2001:db8::/32"},
+	}
+	for _, test := range tests {
+		got := Normalize(netip.MustParsePrefix(test.in))
+		if got != netip.MustParsePrefix(test.want) {
+			t.Errorf("Normalize(%s) = %s, want %s", test.in, got, test.want)
+		}
+	}
+}
+
+func TestContains(t *testing.T) {
+	ps := prefixes("10.0.0.0/8", "192.0.2.1/32", "2001:db8::/32")
+	set := NewSet(ps)
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		{"10.255.255.255", true},
+		{"9.255.255.255", false},
+		{"::ffff:10.1.2.3", true},
+		{"11.0.0.1", false},
+		{"192.0.2.1", true},
+		{"192.0.2.2", false},
+		{"255.255.255.255", false},
+		{"::a00:1", false},
+		{"2001:db8::1", true},
+		{"2001:db8::1%eth0", true},
+		{"2001:db9::1", false},
+	}
+	for _, test := range tests {
+		addr := netip.MustParseAddr(test.addr)
+		if got := Contains(ps, addr); got != test.want {
+			t.Errorf("Contains(%s) = %v, want %v", test.addr, got, test.want)
+		}
+		if got := set.Contains(addr); got != test.want {
+			t.Errorf("Set.Contains(%s) = %v, want %v", test.addr, got, test.want)
+		}
+	}
+
+	var empty *Set
+	if empty.Contains(netip.MustParseAddr("10.0.0.1")) {
+		t.Error("nil set contains an address")
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	tests := []struct {
+		name     string
+		in, want []netip.Prefix
+	}{
+		{"empty", nil, nil},
+		{"duplicates", prefixes("10.0.0.1/32", "10.0.0.1/32"), prefixes("10.0.0.1/32")},
+		{"adjacent", prefixes("10.0.0.1/32", "10.0.0.0/32"), prefixes("10.0.0.0/31")},
+		{"covered", prefixes("10.0.0.0/8", "10.1.0.0/16"), prefixes("10.0.0.0/8")},
+		{"unaligned", prefixes("10.0.0.1/32", "10.0.0.2/31"), prefixes("10.0.0.1/32", "10.0.0.2/31")},
+		{"mixed families", prefixes("2001:db8::/33", "10.0.0.0/9", "2001:db8:8000::/33", "10.128.0.0/9"),
+			prefixes("10.0.0.0/8", "2001:db8::/32")},
+		{"everything", prefixes("0.0.0.0/1", "128.0.0.0/1", "255.255.255.255/32"), prefixes("0.0.0.0/0")},
+	}
+	for _, test := range tests {
+		if got := Aggregate(test.in); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: Aggregate(%v) = %v, want %v", test.name, test.in, got, test.want)
+		}
+	}
+}
+
+func TestAggregateConsecutive(t *testing.T) {
+	var in []netip.Prefix
+	addr := netip.MustParseAddr("192.0.2.64")
+	for i := 0; i < 64; i++ {
+		in = append(in, netip.PrefixFrom(addr, 32))
+		addr = addr.Next()
+	}
+	want := prefixes("192.0.2.64/26")
+	if got := Aggregate(in); !reflect.DeepEqual(got, want) {
+		t.Errorf("Aggregate = %v, want %v", got, want)
+	}
+}
+
+func TestSubtract(t *testing.T) {
+	tests := []struct {
+		name               string
+		base, remove, want []netip.Prefix
+	}{
+		{"nothing removed", prefixes("10.0.0.0/8"), nil, prefixes("10.0.0.0/8")},
+		{"exact", prefixes("10.0.0.1/32"), prefixes("10.0.0.1/32"), nil},
+		{"hole", prefixes("10.0.0.0/30"), prefixes("10.0.0.1/32"),
+			prefixes("10.0.0.0/32", "10.0.0.2/31")},
+		{"two holes", prefixes("10.0.0.0/29"), prefixes("10.0.0.1/32", "10.0.0.4/31"),
+			prefixes("10.0.0.0/32", "10.0.0.2/31", "10.0.0.6/31")},
+		{"other family", prefixes("10.0.0.0/8"), prefixes("::/0"), prefixes("10.0.0.0/8")},
+		{"superset", prefixes("10.1.0.0/16"), prefixes("10.0.0.0/8"), nil},
+	}
+	for _, test := range tests {
+		if got := Subtract(test.base, test.remove); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: Subtract(%v, %v) = %v, want %v", test.name, test.base, test.remove, got, test.want)
+		}
+	}
+}
+
+func TestIntersect(t *testing.T) {
+	tests := []struct {
+		name       string
+		a, b, want []netip.Prefix
+	}{
+		{"empty", prefixes("10.0.0.0/8"), nil, nil},
+		{"subset", prefixes("10.0.0.0/8"), prefixes("10.1.0.0/16"), prefixes("10.1.0.0/16")},
+		{"partial", prefixes("10.0.0.0/30", "10.0.0.8/30"), prefixes("10.0.0.2/31", "10.0.0.6/31", "10.0.0.8/31"),
+			prefixes("10.0.0.2/31", "10.0.0.8/31")},
+		{"other family", prefixes("10.0.0.0/8"), prefixes("::/0"), nil},
+		{"mixed", prefixes("10.0.0.0/8", "2001:db8::/32"), prefixes("::ffff:10.0.0.0/104", "2001:db8:1::/48", "192.0.2.0/24"),
+			prefixes("10.0.0.0/8", "2001:db8:1::/48")},
+	}
+	for _, test := range tests {
+		if got := Intersect(test.a, test.b); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: Intersect(%v, %v) = %v, want %v", test.name, test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestFromRange(t *testing.T) {
+	tests := []struct {
+		first, last string
+		want        []netip.Prefix
+	}{
+		{"192.0.2.0", "192.0.2.255", prefixes("192.0.2.0/24")},
+		{"192.0.2.1", "192.0.2.6", prefixes("192.0.2.1/32", "192.0.2.2/31", "192.0.2.4/31", "192.0.2.6/32")},
+		{"2001:db8::", "2001:db8:1:ffff:ffff:ffff:ffff:ffff", prefixes("2001:db8::/47")},
+		{"::ffff:10.0.0.0", "10.0.0.3", prefixes("10.0.0.0/30")},
+		{"192.0.2.7", "192.0.2.7", prefixes("192.0.2.7/32")},
+		{"192.0.2.7", "192.0.2.6", nil},
+		{"192.0.2.0", "2001:db8::", nil},
+	}
+	for _, test := range tests {
+		got := FromRange(netip.MustParseAddr(test.first), netip.MustParseAddr(test.last))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("FromRange(%s, %s) = %v, want %v", test.first, test.last, got, test.want)
+		}
+	}
+}
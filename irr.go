@@ -0,0 +1,263 @@
+package dns
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(new(IRRSource))
+}
+
+// The default refresh interval of the IRR source.
+const DefaultIRRInterval = caddy.Duration(24 * time.Hour)
+
+// The default IRRd server.
+const DefaultIRRServer = "whois.radb.net:43"
+
+// IRRSource provides the prefixes of the route and route6 objects in
+// Internet Routing Registries for some origin ASes or as-sets, as used to
+// build BGP filters. It queries an IRRd server over whois.
+type IRRSource struct {
+	// The origin ASes, such as "AS64500", or as-sets, such as "AS-EXAMPLE",
+	// which are expanded recursively.
+	Objects []string `json:"objects"`
+
+	// The address of the IRRd server. Defaults to whois.radb.net:43.
+	Server string `json:"server,omitempty"`
+
+	// The registries to query, such as "RADB" or "RIPE". Defaults to all
+	// those the server has.
+	Sources []string `json:"sources,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+}
+
+// CaddyModule returns the Caddy module information.
+func (*IRRSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.irr",
+		New: func() caddy.Module { return new(IRRSource) },
+	}
+}
+
+// Provision queries the prefixes, and starts refreshing them.
+func (s *IRRSource) Provision(ctx caddy.Context) error {
+	if len(s.Objects) == 0 {
+		return errors.New("no ASes or as-sets provided")
+	}
+	if s.Server == "" {
+		s.Server = DefaultIRRServer
+	}
+	if _, _, err := net.SplitHostPort(s.Server); err != nil {
+		s.Server = net.JoinHostPort(s.Server, "43")
+	}
+	if err := s.SourceOptions.validate(DefaultIRRInterval); err != nil {
+		return err
+	}
+	return s.refresher.start(ctx, s.SourceOptions, sourceKey(s), s.fetch)
+}
+
+// fetch expands the as-sets, and queries the routes of all ASes. An AS may
+// have no routes, but if none of them do, the objects are likely wrong.
+func (s *IRRSource) fetch(ctx context.Context) ([]netip.Prefix, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.Server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	c := &irrConn{Conn: conn, r: bufio.NewReader(conn)}
+
+	// Keep the connection open for multiple queries.
+	if _, err := fmt.Fprint(c, "!!\n"); err != nil {
+		return nil, err
+	}
+	if len(s.Sources) > 0 {
+		if _, err := c.query("!s" + strings.Join(s.Sources, ",")); err != nil {
+			return nil, err
+		}
+	}
+
+	var asns []uint32
+	seen := make(map[uint32]bool)
+	add := func(asn uint32) {
+		if !seen[asn] {
+			seen[asn] = true
+			asns = append(asns, asn)
+		}
+	}
+	for _, obj := range s.Objects {
+		if asn, err := parseASN(obj); err == nil {
+			add(asn)
+			continue
+		}
+		members, err := c.query("!i" + obj + ",1")
+		if err != nil {
+			return nil, fmt.Errorf("as-set %s: %w", obj, err)
+		}
+		for _, member := range strings.Fields(members) {
+			asn, err := parseASN(member)
+			if err != nil {
+				return nil, fmt.Errorf("as-set %s: %w", obj, err)
+			}
+			add(asn)
+		}
+	}
+
+	var strs []string
+	for _, asn := range asns {
+		for _, q := range []string{"!g", "!6"} {
+			routes, err := c.query(fmt.Sprintf("%sAS%d", q, asn))
+			if err != nil {
+				return nil, fmt.Errorf("routes of AS%d: %w", asn, err)
+			}
+			strs = append(strs, strings.Fields(routes)...)
+		}
+	}
+	if len(strs) == 0 {
+		return nil, errors.New("no route objects found")
+	}
+	prefixes, err := parsePrefixList(strs)
+	if err != nil {
+		return nil, err
+	}
+	return uniquePrefixes(prefixes), nil
+}
+
+// Cleanup stops refreshing the prefixes.
+func (s *IRRSource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the prefixes of the route objects.
+func (s *IRRSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *IRRSource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	irr <as-or-as-set...> {
+//	    server <host[:port]>
+//	    sources <registry...>
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *IRRSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	s.Objects = append(s.Objects, d.RemainingArgs()...)
+	if len(s.Objects) == 0 {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "server":
+			if !d.AllArgs(&s.Server) {
+				return d.ArgErr()
+			}
+		case "sources":
+			sources := d.RemainingArgs()
+			if len(sources) == 0 {
+				return d.ArgErr()
+			}
+			s.Sources = append(s.Sources, sources...)
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown irr option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// irrConn is a connection speaking the IRRd query protocol.
+type irrConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// query sends a query, and returns its answer. Keys that aren't found
+// answer nothing.
+func (c *irrConn) query(q string) (string, error) {
+	if _, err := fmt.Fprintf(c, "%s\n", q); err != nil {
+		return "", err
+	}
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	switch {
+	case line == "C", line == "D":
+		return "", nil
+	case strings.HasPrefix(line, "F"):
+		return "", fmt.Errorf("IRRd error: %s", strings.TrimSpace(line[1:]))
+	case !strings.HasPrefix(line, "A"):
+		return "", fmt.Errorf("unexpected IRRd response %q", line)
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 || n > maxDocumentSize {
+		return "", fmt.Errorf("unexpected IRRd response %q", line)
+	}
+	answer := make([]byte, n)
+	if _, err := io.ReadFull(c.r, answer); err != nil {
+		return "", err
+	}
+	// The answer is followed by a line with C.
+	end, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if end = strings.TrimSpace(end); end == "" {
+		// The length didn't count the newline after the answer.
+		if end, err = c.r.ReadString('\n'); err != nil {
+			return "", err
+		}
+		end = strings.TrimSpace(end)
+	}
+	if end != "C" {
+		return "", fmt.Errorf("unexpected IRRd response %q", end)
+	}
+	return string(answer), nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*IRRSource)(nil)
+	_ caddy.Provisioner       = (*IRRSource)(nil)
+	_ caddy.CleanerUpper      = (*IRRSource)(nil)
+	_ caddyfile.Unmarshaler   = (*IRRSource)(nil)
+	_ caddyhttp.IPRangeSource = (*IRRSource)(nil)
+)
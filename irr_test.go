@@ -0,0 +1,113 @@
+package dns
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// fakeIRRd answers IRRd queries from a map of answers, until the test ends.
+func fakeIRRd(t *testing.T, answers map[string]string) (addr string, queries func() []string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	var (
+		mu   sync.Mutex
+		seen []string
+	)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					q := scanner.Text()
+					mu.Lock()
+					seen = append(seen, q)
+					mu.Unlock()
+					answer, ok := answers[q]
+					switch {
+					case q == "!!":
+					case strings.HasPrefix(q, "!s"):
+						fmt.Fprint(conn, "C\n")
+					case !ok:
+						fmt.Fprint(conn, "D\n")
+					default:
+						fmt.Fprintf(conn, "A%d\n%s\nC\n", len(answer)+1, answer)
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String(), func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), seen...)
+	}
+}
+
+func TestIRRSource(t *testing.T) {
+	addr, queries := fakeIRRd(t, map[string]string{
+		"!iAS-EXAMPLE,1": "AS64500 AS64501",
+		"!gAS64500":      "192.0.2.0/24 198.51.100.0/24",
+		"!6AS64500":      "2001:db8::/32",
+		"!gAS64501":      "192.0.2.0/24",
+		"!gAS64502":      "203.0.113.0/24",
+	})
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	for _, test := range []*struct {
+		IRRSource
+		want string
+	}{
+		{IRRSource{Objects: []string{"AS-EXAMPLE"}, Server: addr}, "[192.0.2.0/24 198.51.100.0/24 2001:db8::/32]"},
+		{IRRSource{Objects: []string{"AS64502", "AS64501"}, Server: addr, Sources: []string{"RADB", "RIPE"}}, "[192.0.2.0/24 203.0.113.0/24]"},
+	} {
+		if err := test.Provision(ctx); err != nil {
+			t.Errorf("%v: %v", test.Objects, err)
+			continue
+		}
+		if got := fmt.Sprint(test.GetIPRanges(nil)); got != test.want {
+			t.Errorf("%v: got %s, want %s", test.Objects, got, test.want)
+		}
+		test.Cleanup()
+	}
+	if got, want := fmt.Sprint(queries()[6:8]), "[!! !sRADB,RIPE]"; got != want {
+		t.Errorf("got queries %s, want %s", got, want)
+	}
+
+	s := IRRSource{Objects: []string{"AS-MISSING"}, Server: addr}
+	if err := s.Provision(ctx); err == nil {
+		t.Error("no error for an unknown as-set")
+	}
+	s.Cleanup()
+}
+
+func TestUnmarshalIRRSource(t *testing.T) {
+	var s IRRSource
+	input := `irr AS-EXAMPLE AS64500 {
+		server whois.ripe.net
+		sources RIPE
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.Objects, " ", s.Server, " ", s.Sources), "[AS-EXAMPLE AS64500] whois.ripe.net [RIPE]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,510 @@
+package dns
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"gopkg.in/yaml.v3"
+)
+
+func init() {
+	caddy.RegisterModule(new(KubernetesSource))
+}
+
+// The default refresh interval of the Kubernetes source. Changes are picked
+// up from a watch as they happen, so this is only a fallback.
+const DefaultKubernetesInterval = caddy.Duration(5 * time.Minute)
+
+// How long a watch request lasts before it's started again.
+const kubeWatchTimeout = 5 * time.Minute
+
+// Where the service account of a pod is mounted.
+var kubeServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// KubernetesSource provides the pod IPs of a Kubernetes Service, from its
+// EndpointSlices. It watches them, so that changes apply as soon as pods
+// become ready or go away, without waiting for DNS.
+type KubernetesSource struct {
+	// The name of the Service.
+	Service string `json:"service"`
+
+	// The namespace of the Service. Defaults to the namespace of the pod
+	// Caddy runs in, or that of the kubeconfig context.
+	Namespace string `json:"namespace,omitempty"`
+
+	// The kubeconfig file to use. Inside a cluster, the service account of
+	// the pod is used by default; otherwise $KUBECONFIG or ~/.kube/config.
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+
+	// The kubeconfig context to use. Defaults to the current context.
+	Context string `json:"context,omitempty"`
+
+	// Include endpoints that aren't ready, such as pods that are starting
+	// or terminating.
+	IncludeNotReady bool `json:"include_not_ready,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+	client    *kubeClient
+}
+
+// kubeEndpointSlices is a list of EndpointSlices.
+type kubeEndpointSlices struct {
+	Items []kubeEndpointSlice `json:"items"`
+}
+
+// kubeEndpointSlice is the part of an EndpointSlice used by the source.
+type kubeEndpointSlice struct {
+	AddressType string `json:"addressType"`
+	Endpoints   []struct {
+		Addresses  []string `json:"addresses"`
+		Conditions struct {
+			Ready *bool `json:"ready"`
+		} `json:"conditions"`
+	} `json:"endpoints"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (*KubernetesSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.kubernetes",
+		New: func() caddy.Module { return new(KubernetesSource) },
+	}
+}
+
+// Provision fetches the addresses, and starts watching for changes.
+func (s *KubernetesSource) Provision(ctx caddy.Context) error {
+	if s.Service == "" {
+		return errors.New("no service provided")
+	}
+	client, namespace, err := newKubeClient(s.Kubeconfig, s.Context)
+	if err != nil {
+		return err
+	}
+	s.client = client
+	if s.Namespace == "" {
+		s.Namespace = namespace
+	}
+	if err := s.SourceOptions.validate(DefaultKubernetesInterval); err != nil {
+		return err
+	}
+	// Pods come and go all the time, so the result isn't shared across
+	// reloads.
+	if err := s.refresher.start(ctx, s.SourceOptions, "", s.fetch); err != nil {
+		return err
+	}
+	s.refresher.watch(func(ctx context.Context) error {
+		// Watches end after a while, and are started again right away.
+		for ctx.Err() == nil {
+			start := time.Now()
+			if err := s.client.watch(ctx, s.path(), s.refresher.refreshNow); err != nil {
+				return err
+			}
+			if time.Since(start) < time.Second && ctx.Err() == nil {
+				return errors.New("kubernetes watch ended right away")
+			}
+		}
+		return nil
+	})
+	return nil
+}
+
+// path returns the API path of the EndpointSlices of the service.
+func (s *KubernetesSource) path() string {
+	return "/apis/discovery.k8s.io/v1/namespaces/" + url.PathEscape(s.Namespace) +
+		"/endpointslices?labelSelector=" + url.QueryEscape("kubernetes.io/service-name="+s.Service)
+}
+
+// fetch fetches the addresses of the endpoints of the service.
+func (s *KubernetesSource) fetch(ctx context.Context) ([]netip.Prefix, error) {
+	var slices kubeEndpointSlices
+	if err := s.client.get(ctx, s.path(), &slices); err != nil {
+		return nil, err
+	}
+
+	var strs []string
+	for _, slice := range slices.Items {
+		if slice.AddressType != "IPv4" && slice.AddressType != "IPv6" {
+			// FQDN endpoints are for DNS.
+			continue
+		}
+		for _, endpoint := range slice.Endpoints {
+			// Unknown readiness counts as ready.
+			if ready := endpoint.Conditions.Ready; ready != nil && !*ready && !s.IncludeNotReady {
+				continue
+			}
+			strs = append(strs, endpoint.Addresses...)
+		}
+	}
+	return parsePrefixList(strs)
+}
+
+// Cleanup stops refreshing the addresses.
+func (s *KubernetesSource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the addresses of the endpoints.
+func (s *KubernetesSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *KubernetesSource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	kubernetes <service> {
+//	    namespace <namespace>
+//	    kubeconfig <path>
+//	    context <context>
+//	    include_not_ready
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *KubernetesSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	if !d.AllArgs(&s.Service) {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "namespace":
+			if !d.AllArgs(&s.Namespace) {
+				return d.ArgErr()
+			}
+		case "kubeconfig":
+			if !d.AllArgs(&s.Kubeconfig) {
+				return d.ArgErr()
+			}
+		case "context":
+			if !d.AllArgs(&s.Context) {
+				return d.ArgErr()
+			}
+		case "include_not_ready":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			s.IncludeNotReady = true
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown kubernetes option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// kubeClient talks to the Kubernetes API.
+type kubeClient struct {
+	base   string
+	client *http.Client
+
+	// The bearer token, or the file to read it from before each request,
+	// since service account tokens are rotated.
+	token     string
+	tokenFile string
+}
+
+// kubeconfig is the part of a kubeconfig file used by the source.
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Contexts       []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster   string `yaml:"cluster"`
+			User      string `yaml:"user"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+	Clusters []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string         `yaml:"token"`
+			TokenFile             string         `yaml:"tokenFile"`
+			ClientCertificate     string         `yaml:"client-certificate"`
+			ClientCertificateData string         `yaml:"client-certificate-data"`
+			ClientKey             string         `yaml:"client-key"`
+			ClientKeyData         string         `yaml:"client-key-data"`
+			Exec                  map[string]any `yaml:"exec"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+}
+
+// newKubeClient returns a client for the cluster Caddy runs in or, if a
+// kubeconfig file is given or Caddy runs outside a cluster, the cluster of
+// the given kubeconfig context. It also returns the default namespace.
+func newKubeClient(configFile, contextName string) (*kubeClient, string, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if configFile == "" && host != "" {
+		return newInClusterClient(net.JoinHostPort(host, port))
+	}
+
+	if configFile == "" {
+		configFile = os.Getenv("KUBECONFIG")
+		if i := strings.IndexRune(configFile, filepath.ListSeparator); i >= 0 {
+			configFile = configFile[:i]
+		}
+	}
+	if configFile == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, "", err
+		}
+		configFile = filepath.Join(home, ".kube", "config")
+	}
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil, "", err
+	}
+	var config kubeconfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, "", fmt.Errorf("parsing %s: %w", configFile, err)
+	}
+	// Relative paths are relative to the kubeconfig file.
+	dir := filepath.Dir(configFile)
+	resolve := func(path string) string {
+		if path == "" || filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(dir, path)
+	}
+
+	if contextName == "" {
+		contextName = config.CurrentContext
+	}
+	var cluster, user, namespace string
+	found := false
+	for _, c := range config.Contexts {
+		if c.Name == contextName {
+			cluster, user, namespace, found = c.Context.Cluster, c.Context.User, c.Context.Namespace, true
+		}
+	}
+	if !found {
+		return nil, "", fmt.Errorf("context %q not found in %s", contextName, configFile)
+	}
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	c := &kubeClient{}
+	tlsConfig := &tls.Config{}
+	found = false
+	for _, cl := range config.Clusters {
+		if cl.Name != cluster {
+			continue
+		}
+		found = true
+		c.base = strings.TrimSuffix(cl.Cluster.Server, "/")
+		tlsConfig.InsecureSkipVerify = cl.Cluster.InsecureSkipTLSVerify
+		ca, err := kubeData(cl.Cluster.CertificateAuthorityData, resolve(cl.Cluster.CertificateAuthority))
+		if err != nil {
+			return nil, "", err
+		}
+		if ca != nil {
+			if tlsConfig.RootCAs, err = kubeCertPool(ca); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+	if !found {
+		return nil, "", fmt.Errorf("cluster %q not found in %s", cluster, configFile)
+	}
+	for _, u := range config.Users {
+		if u.Name != user {
+			continue
+		}
+		if u.User.Exec != nil {
+			return nil, "", fmt.Errorf("user %q: exec credential plugins are not supported", user)
+		}
+		c.token, c.tokenFile = u.User.Token, resolve(u.User.TokenFile)
+		cert, err := kubeData(u.User.ClientCertificateData, resolve(u.User.ClientCertificate))
+		if err != nil {
+			return nil, "", err
+		}
+		key, err := kubeData(u.User.ClientKeyData, resolve(u.User.ClientKey))
+		if err != nil {
+			return nil, "", err
+		}
+		if cert != nil {
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return nil, "", fmt.Errorf("user %q: %w", user, err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{pair}
+		}
+	}
+
+	c.client = &http.Client{Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig}}
+	return c, namespace, nil
+}
+
+// newInClusterClient returns a client that uses the service account of the
+// pod Caddy runs in.
+func newInClusterClient(hostport string) (*kubeClient, string, error) {
+	ca, err := os.ReadFile(filepath.Join(kubeServiceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, "", err
+	}
+	pool, err := kubeCertPool(ca)
+	if err != nil {
+		return nil, "", err
+	}
+	namespace, err := os.ReadFile(filepath.Join(kubeServiceAccountDir, "namespace"))
+	if err != nil {
+		return nil, "", err
+	}
+	return &kubeClient{
+		base:      "https://" + hostport,
+		client:    &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}},
+		tokenFile: filepath.Join(kubeServiceAccountDir, "token"),
+	}, strings.TrimSpace(string(namespace)), nil
+}
+
+// kubeData returns the base64-encoded data, or else the content of file.
+// It returns nil if neither is set.
+func kubeData(data, file string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if file != "" {
+		return os.ReadFile(file)
+	}
+	return nil, nil
+}
+
+// kubeCertPool returns a pool with the PEM-encoded certificates in pem.
+func kubeCertPool(pem []byte) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificates in Kubernetes CA data")
+	}
+	return pool, nil
+}
+
+// do sends a GET request for path, and checks the status of the response.
+func (c *kubeClient) do(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	token := c.token
+	if c.tokenFile != "" {
+		data, err := os.ReadFile(c.tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set("User-Agent", sourceUserAgent)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("kubernetes API %s: unexpected status %s", req.URL.Path, resp.Status)
+	}
+	return resp, nil
+}
+
+// get fetches path into v.
+func (c *kubeClient) get(ctx context.Context, path string, v any) error {
+	resp, err := c.do(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("kubernetes API %s: %w", path, err)
+	}
+	return nil
+}
+
+// watch watches the list at path, and calls changed for each event, until
+// the server ends the watch, ctx is canceled or the watch fails. The watch
+// starts with an event for each existing object.
+func (c *kubeClient) watch(ctx context.Context, path string, changed func()) error {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	resp, err := c.do(ctx, fmt.Sprintf("%s%swatch=1&timeoutSeconds=%d", path, sep, int(kubeWatchTimeout.Seconds())))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, maxDocumentSize)
+	for scanner.Scan() {
+		var event struct {
+			Type   string `json:"type"`
+			Object struct {
+				Message string `json:"message"`
+			} `json:"object"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return fmt.Errorf("kubernetes watch: %w", err)
+		}
+		if event.Type == "ERROR" {
+			return fmt.Errorf("kubernetes watch: %s", event.Object.Message)
+		}
+		changed()
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("kubernetes watch: %w", err)
+	}
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*KubernetesSource)(nil)
+	_ caddy.Provisioner       = (*KubernetesSource)(nil)
+	_ caddy.CleanerUpper      = (*KubernetesSource)(nil)
+	_ caddyfile.Unmarshaler   = (*KubernetesSource)(nil)
+	_ caddyhttp.IPRangeSource = (*KubernetesSource)(nil)
+)
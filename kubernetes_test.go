@@ -0,0 +1,175 @@
+package dns
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// fakeKubernetes serves the EndpointSlices of a service.
+type fakeKubernetes struct {
+	mu     sync.Mutex
+	slices string
+	events chan string
+}
+
+func (f *fakeKubernetes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.URL.Path != "/apis/discovery.k8s.io/v1/namespaces/edge/endpointslices" ||
+		r.URL.Query().Get("labelSelector") != "kubernetes.io/service-name=cloudflared" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.URL.Query().Get("watch") == "" {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		fmt.Fprintf(w, `{"items": [%s]}`, f.slices)
+		return
+	}
+	w.(http.Flusher).Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-f.events:
+			fmt.Fprintln(w, event)
+			w.(http.Flusher).Flush()
+		}
+	}
+}
+
+func (f *fakeKubernetes) setSlices(slices string) {
+	f.mu.Lock()
+	f.slices = slices
+	f.mu.Unlock()
+}
+
+// writeKubeconfig writes a kubeconfig for server, and returns its path.
+func writeKubeconfig(t *testing.T, server string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config")
+	config := `
+apiVersion: v1
+kind: Config
+current-context: test
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+    namespace: edge
+clusters:
+- name: test
+  cluster:
+    server: ` + server + `
+users:
+- name: test
+  user:
+    token: secret
+`
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestKubernetesSource(t *testing.T) {
+	kube := &fakeKubernetes{
+		slices: `
+			{"addressType": "IPv4", "endpoints": [
+				{"addresses": ["10.1.0.5"], "conditions": {"ready": true}},
+				{"addresses": ["10.1.0.6"], "conditions": {"ready": false}},
+				{"addresses": ["10.1.0.7"], "conditions": {}}]},
+			{"addressType": "FQDN", "endpoints": [{"addresses": ["pod.example.com"]}]}`,
+		events: make(chan string),
+	}
+	srv := httptest.NewServer(kube)
+	defer srv.Close()
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	config := writeKubeconfig(t, srv.URL)
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	s := KubernetesSource{Service: "cloudflared", Kubeconfig: config}
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cleanup()
+
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[10.1.0.5/32 10.1.0.7/32]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// A pod is replaced during a rollout.
+	kube.setSlices(`{"addressType": "IPv4", "endpoints": [
+		{"addresses": ["10.1.0.7"], "conditions": {"ready": true}},
+		{"addresses": ["10.1.0.8"], "conditions": {"ready": true}}]}`)
+	kube.events <- `{"type": "MODIFIED", "object": {"kind": "EndpointSlice"}}`
+	waitFor(t, "watch event", func() bool {
+		return fmt.Sprint(s.GetIPRanges(nil)) == "[10.1.0.7/32 10.1.0.8/32]"
+	})
+}
+
+func TestKubernetesInCluster(t *testing.T) {
+	kube := &fakeKubernetes{slices: `{"addressType": "IPv6", "endpoints": [{"addresses": ["fd00::5"]}]}`}
+	srv := httptest.NewTLSServer(kube)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	old := kubeServiceAccountDir
+	kubeServiceAccountDir = dir
+	defer func() { kubeServiceAccountDir = old }()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	for name, content := range map[string][]byte{
+		"ca.crt":    ca,
+		"namespace": []byte("edge\n"),
+		"token":     []byte("secret\n"),
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	host, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	t.Setenv("KUBERNETES_SERVICE_HOST", host)
+	t.Setenv("KUBERNETES_SERVICE_PORT", port)
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	s := KubernetesSource{Service: "cloudflared", SourceOptions: SourceOptions{Interval: IntervalOnce}}
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cleanup()
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[fd00::5/128]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestUnmarshalKubernetesSource(t *testing.T) {
+	var s KubernetesSource
+	input := `kubernetes cloudflared {
+		namespace edge
+		kubeconfig /etc/caddy/kubeconfig
+		context prod
+		include_not_ready
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.Service, " ", s.Namespace, " ", s.Kubeconfig, " ", s.Context, " ", s.IncludeNotReady), "cloudflared edge /etc/caddy/kubeconfig prod true"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
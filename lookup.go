@@ -0,0 +1,215 @@
+package dns
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+// How long a shared lookup may take. Shared lookups don't use the context of
+// any one caller, so that a module being cleaned up doesn't cancel the
+// lookups of others.
+const sharedLookupTimeout = 30 * time.Second
+
+// lookups coalesces concurrent lookups of the same host with the same resolver
+// configuration, by all ranges. Hosts referenced in many blocks are looked up
+// only once.
+var lookups lookupGroup
+
+// lookupGroup coalesces concurrent identical lookups.
+type lookupGroup struct {
+	mu    sync.Mutex
+	calls map[lookupKey]*lookupCall
+}
+
+// lookupKey identifies a lookup. Resolvers are identified as in answerKey,
+// since each range creates its own resolver from its configuration.
+type lookupKey struct {
+	resolver any
+	host     string
+}
+
+// lookupCall is a lookup in progress.
+type lookupCall struct {
+	done  chan struct{}
+	addrs []netip.Addr
+	err   error
+}
+
+// lookupHost looks up host using r, identified by resolverKey, or waits for
+// an identical lookup that's already in progress. The result is shared, and
+// must not be modified.
+func (g *lookupGroup) lookupHost(ctx context.Context, r resolver, resolverKey any, host string) ([]netip.Addr, error) {
+	key := lookupKey{resolver: resolverKey, host: host}
+
+	g.mu.Lock()
+	c, ok := g.calls[key]
+	if ok {
+		lookupsCoalesced.Inc()
+	} else {
+		if g.calls == nil {
+			g.calls = make(map[lookupKey]*lookupCall)
+		}
+		c = &lookupCall{done: make(chan struct{})}
+		g.calls[key] = c
+		go g.run(key, r, c)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.addrs, c.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// run performs a lookup using r and hands the result to everyone waiting for
+// it.
+func (g *lookupGroup) run(key lookupKey, r resolver, c *lookupCall) {
+	ctx, cancel := context.WithTimeout(context.Background(), sharedLookupTimeout)
+	defer cancel()
+	start := time.Now()
+	c.addrs, c.err = r.LookupNetIP(ctx, "ip", key.host)
+	if _, cached := r.(*cachingResolver); !cached {
+		// Caching resolvers time the queries they pass on themselves.
+		observeLookup(r, key.host, start)
+	}
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	close(c.done)
+}
+
+// answers holds the most recent answer for each host and resolver, shared by
+// all ranges. Entries are reference counted, and survive config reloads as
+// long as the new config uses them too, so that the ranges of the old and
+// new config keep following each other.
+var answers = caddy.NewUsagePool()
+
+// rangeIDs numbers the ranges, to tell who got an answer.
+var rangeIDs atomic.Uint64
+
+// answerKey identifies a shared answer. Resolvers are identified by their
+// configuration, which stays the same across reloads, or by identity when
+// set directly. Other options are applied by each range to the raw answer.
+type answerKey struct {
+	resolver any
+	host     string
+}
+
+// sharedAnswer is the most recent successful lookup of a host.
+type sharedAnswer struct {
+	mu    sync.Mutex
+	addrs []netip.Addr
+	at    time.Time
+	by    uint64
+
+	// Ranges to tell about new answers, by id.
+	followers map[uint64]func()
+}
+
+// Destruct implements caddy.Destructor.
+func (*sharedAnswer) Destruct() error { return nil }
+
+// get returns the answer, if another range got it less than maxAge ago.
+// A range never reuses its own answers, since it only asks when it wants a
+// new one.
+func (a *sharedAnswer) get(id uint64, maxAge time.Duration) ([]netip.Addr, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.by == 0 || a.by == id || time.Since(a.at) >= maxAge {
+		return nil, false
+	}
+	return a.addrs, true
+}
+
+// set records an answer obtained by the range with the given id, and tells
+// the other ranges using it.
+func (a *sharedAnswer) set(id uint64, addrs []netip.Addr) {
+	a.mu.Lock()
+	a.addrs, a.at, a.by = addrs, time.Now(), id
+	notify := make([]func(), 0, len(a.followers))
+	for other, fn := range a.followers {
+		if other != id {
+			notify = append(notify, fn)
+		}
+	}
+	a.mu.Unlock()
+
+	for _, fn := range notify {
+		fn()
+	}
+}
+
+// follow calls fn whenever another range than id gets a new answer.
+func (a *sharedAnswer) follow(id uint64, fn func()) {
+	a.mu.Lock()
+	if a.followers == nil {
+		a.followers = make(map[uint64]func())
+	}
+	a.followers[id] = fn
+	a.mu.Unlock()
+}
+
+// unfollow stops calling the function registered by follow.
+func (a *sharedAnswer) unfollow(id uint64) {
+	a.mu.Lock()
+	delete(a.followers, id)
+	a.mu.Unlock()
+}
+
+// acquireAnswers takes a reference to the shared answer of each host. When
+// another range gets a new answer, the host is refreshed with it, so that
+// ranges sharing a host look it up about once per the shortest of their
+// intervals instead of each on its own schedule.
+func (d *DNSRange) acquireAnswers(resolverKey any) error {
+	d.id = rangeIDs.Add(1)
+	d.answers = make(map[string]*sharedAnswer, len(d.Hosts))
+	for _, host := range d.Hosts {
+		val, _, err := answers.LoadOrNew(answerKey{resolverKey, host}, func() (caddy.Destructor, error) {
+			return new(sharedAnswer), nil
+		})
+		if err != nil {
+			return err
+		}
+		a := val.(*sharedAnswer)
+		host := host
+		a.follow(d.id, func() { d.sched.follow(host) })
+		d.answers[host] = a
+	}
+	d.answerKey = resolverKey
+	return nil
+}
+
+// releaseAnswers drops the references taken by acquireAnswers.
+func (d *DNSRange) releaseAnswers() {
+	for host, a := range d.answers {
+		a.unfollow(d.id)
+		answers.Delete(answerKey{d.answerKey, host})
+	}
+}
+
+// lookupHost looks up host, unless another range using the same resolver got
+// an answer less than maxAge ago.
+func (d *DNSRange) lookupHost(ctx context.Context, host string, maxAge time.Duration) ([]netip.Addr, error) {
+	a := d.answers[host]
+	if a != nil && maxAge > 0 {
+		if addrs, ok := a.get(d.id, maxAge); ok {
+			d.logger.Debug("reusing shared answer", zap.String("host", host))
+			return addrs, nil
+		}
+	}
+
+	addrs, err := lookups.lookupHost(ctx, d.resolver, d.answerKey, host)
+	if err == nil && a != nil {
+		a.set(d.id, addrs)
+	}
+	return addrs, err
+}
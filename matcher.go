@@ -0,0 +1,178 @@
+package dns
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/fvbommel/caddy-dns-ip-range/iprange"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(MatchRemoteIPDNS{})
+}
+
+// MatchRemoteIPDNS matches requests by the remote IP address, like the
+// remote_ip matcher. In addition to IPs and CIDRs, it accepts references to
+// named DNS ranges in the form "dns:<name>", so that configs built around
+// remote_ip can use dynamic ranges by changing the matcher name, and IP range
+// sources such as the github source.
+type MatchRemoteIPDNS struct {
+	// The IPs or CIDR ranges to match.
+	Ranges []string `json:"ranges,omitempty"`
+
+	// The names of DNS ranges to match, as set by their name option.
+	Names []string `json:"names,omitempty"`
+
+	// IP range sources whose ranges to match.
+	SourcesRaw []json.RawMessage `json:"sources,omitempty" caddy:"namespace=http.ip_sources inline_key=source"`
+
+	// If true, prefer the first IP in the request's X-Forwarded-For
+	// header, like the remote_ip matcher does.
+	Forwarded bool `json:"forwarded,omitempty"`
+
+	// Matches the static ranges.
+	static caddyhttp.MatchRemoteIP
+
+	sources []caddyhttp.IPRangeSource
+
+	logger *zap.Logger
+}
+
+// CaddyModule returns the Caddy module information.
+func (MatchRemoteIPDNS) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.matchers.remote_ip_dns",
+		New: func() caddy.Module { return new(MatchRemoteIPDNS) },
+	}
+}
+
+// UnmarshalCaddyfile sets up the matcher from Caddyfile tokens. Syntax:
+//
+//	remote_ip_dns [forwarded] <ranges...> {
+//	    source <module> ...
+//	}
+//
+// where ranges are IPs, CIDRs, "private_ranges" or "dns:<name>". Each source
+// is an IP range source module, with the same syntax as in trusted_proxies.
+func (m *MatchRemoteIPDNS) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		for d.NextArg() {
+			switch val := d.Val(); {
+			case val == "forwarded":
+				if len(m.Ranges) > 0 || len(m.Names) > 0 {
+					return d.Err("if used, 'forwarded' must be first argument")
+				}
+				m.Forwarded = true
+			case val == "private_ranges":
+				m.Ranges = append(m.Ranges, caddyhttp.PrivateRangesCIDR()...)
+			case strings.HasPrefix(val, "dns:"):
+				name := strings.TrimPrefix(val, "dns:")
+				if name == "" {
+					return d.Errf("missing range name in %q", val)
+				}
+				m.Names = append(m.Names, name)
+			default:
+				m.Ranges = append(m.Ranges, val)
+			}
+		}
+		for nesting := d.Nesting(); d.NextBlock(nesting); {
+			if d.Val() != "source" {
+				return d.Errf("unknown remote_ip_dns option %q", d.Val())
+			}
+			raw, err := unmarshalIPSource(d)
+			if err != nil {
+				return err
+			}
+			m.SourcesRaw = append(m.SourcesRaw, raw)
+		}
+	}
+	return nil
+}
+
+// Provision parses the static ranges, and loads the sources. Named ranges
+// are looked up when matching, since they may be provisioned later, and
+// replaced on reload.
+func (m *MatchRemoteIPDNS) Provision(ctx caddy.Context) error {
+	m.logger = ctx.Logger()
+	m.static = caddyhttp.MatchRemoteIP{Ranges: m.Ranges, Forwarded: m.Forwarded}
+	if err := m.static.Provision(ctx); err != nil {
+		return err
+	}
+
+	if m.SourcesRaw != nil {
+		sources, err := loadIPSources(ctx, m, "SourcesRaw")
+		if err != nil {
+			return err
+		}
+		m.sources = sources
+	}
+	return nil
+}
+
+// Match returns true if the remote IP of r is in one of the ranges.
+func (m MatchRemoteIPDNS) Match(r *http.Request) bool {
+	if len(m.Ranges) > 0 && m.static.Match(r) {
+		return true
+	}
+	if len(m.Names) == 0 && len(m.sources) == 0 {
+		return false
+	}
+
+	addr, err := remoteAddr(r, m.Forwarded)
+	if err != nil {
+		m.logger.Error("getting client IP", zap.Error(err))
+		return false
+	}
+	for _, name := range m.Names {
+		d := namedRange(name)
+		if d == nil {
+			m.logger.Debug("unknown DNS range", zap.String("name", name))
+			continue
+		}
+		if d.contains(addr) {
+			return true
+		}
+	}
+	for _, source := range m.sources {
+		if iprange.Contains(source.GetIPRanges(r), addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteAddr returns the remote IP address of r, the same way the remote_ip
+// matcher determines it, without the zone.
+func remoteAddr(r *http.Request, forwarded bool) (netip.Addr, error) {
+	remote := r.RemoteAddr
+	if forwarded {
+		if fwdFor := r.Header.Get("X-Forwarded-For"); fwdFor != "" {
+			remote = strings.TrimSpace(strings.Split(fwdFor, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(remote)
+	if err != nil {
+		host = remote // Probably didn't have a port.
+	}
+	host, _, _ = strings.Cut(host, "%")
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("invalid remote address %q: %w", remote, err)
+	}
+	return addr, nil
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner        = (*MatchRemoteIPDNS)(nil)
+	_ caddyfile.Unmarshaler    = (*MatchRemoteIPDNS)(nil)
+	_ caddyhttp.RequestMatcher = MatchRemoteIPDNS{}
+)
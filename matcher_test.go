@@ -0,0 +1,145 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestMatchRemoteIPDNS(t *testing.T) {
+	var m MatchRemoteIPDNS
+	input := `remote_ip_dns 198.51.100.0/24 dns:edge-proxies dns:unknown`
+	if err := m.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	want := MatchRemoteIPDNS{
+		Ranges: []string{"198.51.100.0/24"},
+		Names:  []string{"edge-proxies", "unknown"},
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Fatalf("got %+v, want %+v", m, want)
+	}
+
+	res := &fakeResolver{hosts: map[string][]string{"edge.example.com": {"192.0.2.1"}}}
+	r := DNSRange{Name: "edge-proxies", Hosts: []string{"edge.example.com"}, Interval: caddy.Duration(time.Hour)}
+	cancel, err := provision(t, &r, res)
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Cleanup()
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	if err := m.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for remote, want := range map[string]bool{
+		"192.0.2.1:1234":    true,
+		"192.0.2.2:1234":    false,
+		"198.51.100.7:1234": true,
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remote
+		if got := m.Match(req); got != want {
+			t.Errorf("%s: got %v, want %v", remote, got, want)
+		}
+	}
+
+	// Cleaning up the range makes its name unavailable.
+	r.Cleanup()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	if m.Match(req) {
+		t.Error("matched cleaned up range")
+	}
+}
+
+func TestMatchRemoteIPDNSSource(t *testing.T) {
+	srv := newGitHubMetaServer(t)
+	var m MatchRemoteIPDNS
+	input := fmt.Sprintf(`remote_ip_dns 198.51.100.0/24 {
+		source github hooks {
+			url %s
+		}
+	}`, srv.URL)
+	if err := m.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprintf("%s", m.SourcesRaw), fmt.Sprintf(`[{"categories":["hooks"],"source":"github","url":"%s"}]`, srv.URL); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	if err := m.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for remote, want := range map[string]bool{
+		"192.30.252.1:1234":       true,
+		"[2a0a:a440::1]:1234":     true,
+		"185.199.108.1:1234":      false,
+		"198.51.100.7:1234":       true,
+		"[::ffff:192.30.252.1]:1": true,
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remote
+		if got := m.Match(req); got != want {
+			t.Errorf("%s: got %v, want %v", remote, got, want)
+		}
+	}
+
+	for _, input := range []string{
+		"remote_ip_dns {\n unknown\n }",
+		"remote_ip_dns {\n source\n }",
+		"remote_ip_dns {\n source nonexistent\n }",
+	} {
+		if err := new(MatchRemoteIPDNS).UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err == nil {
+			t.Errorf("%q: no error", input)
+		}
+	}
+}
+
+func TestDuplicateRangeNames(t *testing.T) {
+	res := &fakeResolver{hosts: map[string][]string{
+		"a.example.com": {"192.0.2.1"},
+		"b.example.com": {"192.0.2.2"},
+	}}
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	newRange := func(host string) *DNSRange {
+		r := &DNSRange{Name: "proxies", Hosts: []string{host}, Interval: caddy.Duration(time.Hour), resolver: res}
+		t.Cleanup(func() { r.Cleanup() })
+		return r
+	}
+
+	// The same range in several servers is fine, another one isn't.
+	if err := newRange("a.example.com").Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := newRange("a.example.com").Provision(ctx); err != nil {
+		t.Errorf("identical range: %v", err)
+	}
+	if err := newRange("b.example.com").Provision(ctx); err == nil {
+		t.Error("no error for conflicting range in the same config")
+	}
+
+	// A new config replaces the range.
+	reloaded, cancelReloaded := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancelReloaded()
+	r := newRange("b.example.com")
+	if err := r.Provision(reloaded); err != nil {
+		t.Fatalf("range of new config: %v", err)
+	}
+	if namedRange("proxies") != r {
+		t.Error("range of new config didn't replace the old one")
+	}
+}
@@ -0,0 +1,197 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const metricsNamespace, metricsSubsystem = "caddy", "dns_ip_range"
+
+// Metrics of lookups and the resolver cache.
+var (
+	lookupsCoalesced = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "lookups_coalesced_total",
+		Help:      "Number of lookups that waited for an identical lookup in progress instead of querying the resolver.",
+	})
+	cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "cache_requests_total",
+		Help:      "Number of lookups answered by the resolver cache (hit) or passed on (miss).",
+	}, []string{"result"})
+	cacheEvictions = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "cache_evictions_total",
+		Help:      "Number of entries evicted from the resolver cache because it was full.",
+	})
+	cacheEntries = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "cache_entries",
+		Help:      "Number of entries in resolver caches.",
+	})
+)
+
+// Metrics of the hosts of all ranges. Hosts configured in several ranges
+// share their series.
+var (
+	hostLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "lookups_total",
+		Help:      "Number of lookups of each host, including answers shared by other ranges.",
+	}, []string{"host"})
+	hostLookupFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "lookup_failures_total",
+		Help:      "Number of failed lookups of each host, by class of error.",
+	}, []string{"host", "class"})
+	hostAddresses = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "host_addresses",
+		Help:      "Number of addresses each host currently resolves to.",
+	}, []string{"host"})
+	hostLastSuccess = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "last_success_timestamp_seconds",
+		Help:      "When each host was last looked up successfully, as a Unix timestamp.",
+	}, []string{"host"})
+	lookupDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "lookup_duration_seconds",
+		Help:      "How long queries to the resolver took for each host, by transport. Cached and shared answers aren't included.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"host", "transport"})
+	addressAlive = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "address_alive",
+		Help:      "Whether each resolved address of each host was reachable when last probed (1) or not (0), if probing is enabled.",
+	}, []string{"host", "address"})
+	refreshDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "refresh_duration_seconds",
+		Help:      "How long refreshes of hosts took, including applying the result.",
+		Buckets:   prometheus.DefBuckets,
+	})
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "degraded_hosts",
+		Help:      "Number of hosts that keep failing to resolve or whose addresses are stale, counted once per range.",
+	}, func() float64 {
+		return float64(len(currentHealth().Degraded))
+	})
+)
+
+// Classes of lookup errors, for the lookup_failures_total metric.
+const (
+	errorClassNotFound  = "not_found"
+	errorClassNoRecords = "no_records"
+	errorClassTimeout   = "timeout"
+	errorClassTemporary = "temporary"
+	errorClassOther     = "other"
+)
+
+var errorClasses = []string{errorClassNotFound, errorClassNoRecords, errorClassTimeout, errorClassTemporary, errorClassOther}
+
+// errorClass returns the class of a lookup error.
+func errorClass(err error) string {
+	var dnsErr *net.DNSError
+	isDNSErr := errors.As(err, &dnsErr)
+	switch {
+	case errors.Is(err, errNoRecords):
+		return errorClassNoRecords
+	case errors.Is(err, context.DeadlineExceeded), isDNSErr && dnsErr.IsTimeout:
+		return errorClassTimeout
+	case isDNSErr && dnsErr.IsNotFound:
+		return errorClassNotFound
+	case isDNSErr && dnsErr.IsTemporary:
+		return errorClassTemporary
+	}
+	return errorClassOther
+}
+
+// Transports of resolvers, for the lookup_duration_seconds metric.
+const (
+	// The system resolver, or Go's built-in one. Both use plain DNS.
+	transportDNS = "dns"
+	// DNS-JSON over HTTPS.
+	transportDoH = "doh"
+	// DNS-JSON over plain HTTP.
+	transportHTTP = "http"
+	// Anything else, such as resolvers used in tests.
+	transportOther = "other"
+)
+
+var transports = []string{transportDNS, transportDoH, transportHTTP, transportOther}
+
+// transportOf returns how r queries name servers.
+func transportOf(r resolver) string {
+	switch r := r.(type) {
+	case *net.Resolver:
+		return transportDNS
+	case *dnsJSONResolver:
+		if r.url.Scheme == "https" {
+			return transportDoH
+		}
+		return transportHTTP
+	}
+	return transportOther
+}
+
+// observeLookup records the duration of a query of host to r, which started
+// at start.
+func observeLookup(r resolver, host string, start time.Time) {
+	lookupDuration.WithLabelValues(host, transportOf(r)).Observe(time.Since(start).Seconds())
+}
+
+// recordLookup updates the metrics of host after a lookup.
+func recordLookup(host string, err error) {
+	hostLookups.WithLabelValues(host).Inc()
+	if err != nil {
+		hostLookupFailures.WithLabelValues(host, errorClass(err)).Inc()
+	} else {
+		hostLastSuccess.WithLabelValues(host).SetToCurrentTime()
+	}
+}
+
+// forgetHostMetrics removes the series of the hosts of d that no other range
+// uses. The caller must hold instancesMu, and d must not be registered.
+func (d *DNSRange) forgetHostMetrics() {
+	for _, host := range d.Hosts {
+		used := false
+		for other := range instances {
+			if other.state[host] != nil {
+				used = true
+				break
+			}
+		}
+		if used {
+			continue
+		}
+		hostLookups.DeleteLabelValues(host)
+		for _, class := range errorClasses {
+			hostLookupFailures.DeleteLabelValues(host, class)
+		}
+		hostAddresses.DeleteLabelValues(host)
+		hostLastSuccess.DeleteLabelValues(host)
+		addressAlive.DeletePartialMatch(prometheus.Labels{"host": host})
+		for _, transport := range transports {
+			lookupDuration.DeleteLabelValues(host, transport)
+		}
+	}
+}
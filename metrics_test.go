@@ -0,0 +1,98 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// value returns the value of a counter or gauge, or the number of samples
+// of a histogram.
+func value(m any) float64 {
+	var pb dto.Metric
+	if err := m.(prometheus.Metric).Write(&pb); err != nil {
+		panic(err)
+	}
+	switch {
+	case pb.Counter != nil:
+		return pb.Counter.GetValue()
+	case pb.Histogram != nil:
+		return float64(pb.Histogram.GetSampleCount())
+	}
+	return pb.Gauge.GetValue()
+}
+
+func TestErrorClass(t *testing.T) {
+	for err, want := range map[error]string{
+		errNoRecords:                                            errorClassNoRecords,
+		fmt.Errorf("lookup: %w", errNoRecords):                  errorClassNoRecords,
+		context.DeadlineExceeded:                                errorClassTimeout,
+		&net.DNSError{Err: "timeout", IsTimeout: true}:          errorClassTimeout,
+		&net.DNSError{Err: "no such host", IsNotFound: true}:    errorClassNotFound,
+		&net.DNSError{Err: "server failure", IsTemporary: true}: errorClassTemporary,
+		fmt.Errorf("something else"):                            errorClassOther,
+	} {
+		if got := errorClass(err); got != want {
+			t.Errorf("errorClass(%v) = %s, want %s", err, got, want)
+		}
+	}
+}
+
+func TestTransportOf(t *testing.T) {
+	for config, want := range map[string]string{
+		"":                              transportDNS,
+		"https://dns.google/resolve":    transportDoH,
+		"http://127.0.0.1:8053/resolve": transportHTTP,
+	} {
+		r, err := (&ResolverConfig{DNSJSON: config}).newResolver()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := transportOf(r); got != want {
+			t.Errorf("%q: got transport %s, want %s", config, got, want)
+		}
+	}
+}
+
+func TestHostMetrics(t *testing.T) {
+	const host = "metrics.example.com"
+	res := &fakeResolver{hosts: map[string][]string{host: {"192.0.2.1", "192.0.2.2"}}}
+	r := DNSRange{Hosts: []string{host}, Interval: caddy.Duration(time.Hour)}
+	cancel, err := provision(t, &r, res)
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := value(hostLookups.WithLabelValues(host)); got != 1 {
+		t.Errorf("lookups: got %v, want 1", got)
+	}
+	if got := value(hostAddresses.WithLabelValues(host)); got != 2 {
+		t.Errorf("addresses: got %v, want 2", got)
+	}
+	if got := value(hostLastSuccess.WithLabelValues(host)); got < float64(time.Now().Add(-time.Minute).Unix()) {
+		t.Errorf("last success: got %v", got)
+	}
+	if got := value(lookupDuration.WithLabelValues(host, transportOther)); got != 1 {
+		t.Errorf("lookup durations: got %v samples, want 1", got)
+	}
+
+	// Failures are counted by class.
+	delete(res.hosts, host)
+	r.triggerRefresh(host)
+	waitFor(t, "failed refresh", func() bool {
+		return value(hostLookupFailures.WithLabelValues(host, errorClassNotFound)) == 1
+	})
+
+	// The series are removed when the host isn't used anymore.
+	r.Cleanup()
+	if hostLookups.DeleteLabelValues(host) || hostAddresses.DeleteLabelValues(host) {
+		t.Error("metrics of unused host weren't removed")
+	}
+}
@@ -0,0 +1,521 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/fvbommel/caddy-dns-ip-range/iprange"
+)
+
+func init() {
+	caddy.RegisterModule(new(MMDBSource))
+}
+
+// The default refresh interval of the MMDB source. Changes to the database
+// are picked up as they happen, so this is only a fallback.
+const DefaultMMDBInterval = caddy.Duration(time.Hour)
+
+// MMDBSource provides the networks of some countries or autonomous systems
+// in a MaxMind DB, such as GeoLite2-Country or GeoLite2-ASN. It watches the
+// file, so that updates apply without reloading the config.
+type MMDBSource struct {
+	// The path of the database.
+	Path string `json:"path"`
+
+	// The countries of the networks to include, as ISO 3166-1 alpha-2 codes
+	// such as "NL". Networks without a country are taken to be in their
+	// registered country.
+	Countries []string `json:"countries,omitempty"`
+
+	// The autonomous systems of the networks to include, such as "AS13335".
+	ASNs []string `json:"asns,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+	asns      map[uint64]bool
+
+	// The file read last, to skip reading it again when it hasn't changed.
+	// Only used by fetch, which doesn't run concurrently.
+	modTime time.Time
+	size    int64
+}
+
+// CaddyModule returns the Caddy module information.
+func (*MMDBSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.mmdb",
+		New: func() caddy.Module { return new(MMDBSource) },
+	}
+}
+
+// Provision reads the database, and starts watching it.
+func (s *MMDBSource) Provision(ctx caddy.Context) error {
+	if s.Path == "" {
+		return errors.New("no path provided")
+	}
+	if len(s.Countries) == 0 && len(s.ASNs) == 0 {
+		return errors.New("no countries or AS numbers provided")
+	}
+	s.asns = make(map[uint64]bool, len(s.ASNs))
+	for _, asn := range s.ASNs {
+		n, err := parseASN(asn)
+		if err != nil {
+			return err
+		}
+		s.asns[uint64(n)] = true
+	}
+	if err := s.SourceOptions.validate(DefaultMMDBInterval); err != nil {
+		return err
+	}
+	// Changes made during a reload shouldn't be missed, so the result isn't
+	// shared.
+	if err := s.refresher.start(ctx, s.SourceOptions, "", s.fetch); err != nil {
+		return err
+	}
+	s.refresher.watch(func(ctx context.Context) error {
+		return watchFile(ctx, s.Path, s.refresher.refreshNow)
+	})
+	return nil
+}
+
+// fetch reads the matching networks from the database, if it changed.
+func (s *MMDBSource) fetch(_ context.Context) ([]netip.Prefix, error) {
+	info, err := os.Stat(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	if info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return nil, errUnchanged
+	}
+	data, err := os.ReadFile(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	db, err := openMMDB(data)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", s.Path, err)
+	}
+
+	// Many networks share their record, so each is only looked at once.
+	matches := make(map[int]bool)
+	var prefixes []netip.Prefix
+	err = db.networks(func(p netip.Prefix, offset int) error {
+		match, ok := matches[offset]
+		if !ok {
+			record, _, err := db.data.decode(offset, 0)
+			if err != nil {
+				return err
+			}
+			match = s.include(record)
+			matches[offset] = match
+		}
+		if match {
+			prefixes = append(prefixes, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", s.Path, err)
+	}
+	if len(prefixes) == 0 {
+		return nil, fmt.Errorf("no networks in %s match", s.Path)
+	}
+	s.modTime, s.size = info.ModTime(), info.Size()
+	// Neighboring networks with other details, such as cities, are merged.
+	return iprange.Aggregate(prefixes), nil
+}
+
+// include reports whether the networks with the given record are selected.
+func (s *MMDBSource) include(record any) bool {
+	m, _ := record.(map[string]any)
+	if len(s.Countries) > 0 {
+		country, ok := mmdbLookup(m, "country", "iso_code").(string)
+		if !ok {
+			country, _ = mmdbLookup(m, "registered_country", "iso_code").(string)
+		}
+		if country != "" && matchesAny(s.Countries, country) {
+			return true
+		}
+	}
+	asn, ok := mmdbLookup(m, "autonomous_system_number").(uint64)
+	return ok && s.asns[asn]
+}
+
+// mmdbLookup returns the value at the path of keys in nested maps, or nil.
+func mmdbLookup(m map[string]any, path ...string) any {
+	var v any = m
+	for _, key := range path {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = m[key]
+	}
+	return v
+}
+
+// Cleanup stops watching the database.
+func (s *MMDBSource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the matching networks.
+func (s *MMDBSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *MMDBSource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	mmdb <path> {
+//	    country <code...>
+//	    asn <asn...>
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *MMDBSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	if !d.AllArgs(&s.Path) {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "country":
+			countries := d.RemainingArgs()
+			if len(countries) == 0 {
+				return d.ArgErr()
+			}
+			s.Countries = append(s.Countries, countries...)
+		case "asn":
+			asns := d.RemainingArgs()
+			if len(asns) == 0 {
+				return d.ArgErr()
+			}
+			s.ASNs = append(s.ASNs, asns...)
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown mmdb option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// mmdbMetadataStart marks the start of the metadata of a database.
+var mmdbMetadataStart = []byte("\xab\xcd\xefMaxMind.com")
+
+// errInvalidMMDB is returned for malformed databases.
+var errInvalidMMDB = errors.New("invalid MaxMind DB")
+
+// mmdb is a MaxMind DB: a binary search tree of networks, pointing into a
+// data section with their records.
+type mmdb struct {
+	tree       []byte
+	data       mmdbDecoder
+	nodeCount  int
+	recordSize int
+	ipVersion  int
+}
+
+// openMMDB parses the metadata of a database.
+func openMMDB(buf []byte) (*mmdb, error) {
+	i := bytes.LastIndex(buf, mmdbMetadataStart)
+	if i < 0 {
+		return nil, errInvalidMMDB
+	}
+	meta, _, err := mmdbDecoder(buf[i+len(mmdbMetadataStart):]).decode(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := meta.(map[string]any)
+	nodeCount, _ := m["node_count"].(uint64)
+	recordSize, _ := m["record_size"].(uint64)
+	ipVersion, _ := m["ip_version"].(uint64)
+	if major, _ := m["binary_format_major_version"].(uint64); major != 2 {
+		return nil, fmt.Errorf("unsupported MaxMind DB format version %d", major)
+	}
+	if recordSize != 24 && recordSize != 28 && recordSize != 32 || ipVersion != 4 && ipVersion != 6 {
+		return nil, errInvalidMMDB
+	}
+	// The tree is followed by 16 zero bytes, and then the data section.
+	treeSize := nodeCount * recordSize / 4
+	if treeSize+16 > uint64(i) {
+		return nil, errInvalidMMDB
+	}
+	return &mmdb{
+		tree:       buf[:treeSize],
+		data:       mmdbDecoder(buf[treeSize+16 : i]),
+		nodeCount:  int(nodeCount),
+		recordSize: int(recordSize),
+		ipVersion:  int(ipVersion),
+	}, nil
+}
+
+// record returns the left (0) or right (1) record of a node.
+func (db *mmdb) record(node, bit int) int {
+	b := db.tree[node*db.recordSize/4:]
+	switch db.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+	case 28:
+		// The middle byte holds the high bits of both records.
+		if bit == 0 {
+			return int(b[3]&0xf0)<<20 | int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+		}
+		return int(b[3]&0x0f)<<24 | int(b[4])<<16 | int(b[5])<<8 | int(b[6])
+	}
+	return int(binary.BigEndian.Uint32(b[bit*4:]))
+}
+
+// networks calls fn for each network in the database with the offset of its
+// record in the data section. In IPv6 databases, IPv4 networks are in
+// ::/96, and reported as IPv4; the aliases of that subtree, such as
+// ::ffff:0:0/96, are skipped.
+func (db *mmdb) networks(fn func(p netip.Prefix, offset int) error) error {
+	bits := 32
+	ipv4Start := -1
+	if db.ipVersion == 6 {
+		bits = 128
+		node := 0
+		for i := 0; i < 96 && node < db.nodeCount; i++ {
+			node = db.record(node, 0)
+		}
+		ipv4Start = node
+	}
+
+	var (
+		addr  [16]byte
+		visit func(node, depth int) error
+	)
+	visit = func(node, depth int) error {
+		if depth >= bits {
+			return errInvalidMMDB
+		}
+		defer func() { addr[depth/8] &^= 0x80 >> (depth % 8) }()
+		for bit := 0; bit < 2; bit++ {
+			if bit == 1 {
+				addr[depth/8] |= 0x80 >> (depth % 8)
+			}
+			rec := db.record(node, bit)
+			switch {
+			case rec < db.nodeCount:
+				if rec == ipv4Start && !(depth+1 == 96 && addr == [16]byte{}) {
+					continue
+				}
+				if err := visit(rec, depth+1); err != nil {
+					return err
+				}
+			case rec > db.nodeCount:
+				offset := rec - db.nodeCount - 16
+				if offset < 0 || offset >= len(db.data) {
+					return errInvalidMMDB
+				}
+				if err := fn(db.prefix(addr, depth+1), offset); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return visit(0, 0)
+}
+
+// prefix returns the network of the first bits of addr.
+func (db *mmdb) prefix(addr [16]byte, bits int) netip.Prefix {
+	if db.ipVersion == 4 {
+		return netip.PrefixFrom(netip.AddrFrom4([4]byte(addr[:4])), bits)
+	}
+	if bits >= 96 && [12]byte(addr[:12]) == [12]byte{} {
+		return netip.PrefixFrom(netip.AddrFrom4([4]byte(addr[12:])), bits-96)
+	}
+	return netip.PrefixFrom(netip.AddrFrom16(addr), bits)
+}
+
+// mmdbDecoder decodes values in the data section of a database, or in its
+// metadata.
+type mmdbDecoder []byte
+
+// Data types of values.
+const (
+	mmdbPointer = 1
+	mmdbString  = 2
+	mmdbDouble  = 3
+	mmdbBytes   = 4
+	mmdbUint16  = 5
+	mmdbUint32  = 6
+	mmdbMap     = 7
+	mmdbInt32   = 8
+	mmdbUint64  = 9
+	mmdbUint128 = 10
+	mmdbArray   = 11
+	mmdbBool    = 14
+	mmdbFloat   = 15
+)
+
+// decode decodes the value at offset, and returns it with the offset of the
+// next value. Maps decode to map[string]any, arrays to []any, unsigned
+// integers up to 64 bits to uint64, and larger ones to []byte.
+func (d mmdbDecoder) decode(offset, depth int) (any, int, error) {
+	if depth > 32 {
+		// Pointers can make cycles.
+		return nil, 0, errInvalidMMDB
+	}
+	b, offset, err := d.take(offset, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	ctrl := b[0]
+	typ := int(ctrl >> 5)
+	if typ == mmdbPointer {
+		n := int(ctrl>>3&3) + 1
+		if b, offset, err = d.take(offset, n); err != nil {
+			return nil, 0, err
+		}
+		p := 0
+		if n < 4 {
+			p = int(ctrl & 7)
+		}
+		for _, c := range b {
+			p = p<<8 | int(c)
+		}
+		p += [...]int{0, 2048, 526336, 0}[n-1]
+		v, _, err := d.decode(p, depth+1)
+		return v, offset, err
+	}
+	if typ == 0 {
+		// An extended type.
+		if b, offset, err = d.take(offset, 1); err != nil {
+			return nil, 0, err
+		}
+		typ = 7 + int(b[0])
+	}
+	size := int(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if b, offset, err = d.take(offset, n); err != nil {
+			return nil, 0, err
+		}
+		size = 0
+		for _, c := range b {
+			size = size<<8 | int(c)
+		}
+		size += [...]int{29, 285, 65821}[n-1]
+	}
+
+	switch typ {
+	case mmdbMap:
+		m := make(map[string]any, size)
+		for i := 0; i < size; i++ {
+			var k, v any
+			if k, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, errInvalidMMDB
+			}
+			if v, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+		}
+		return m, offset, nil
+	case mmdbArray:
+		var a []any
+		for i := 0; i < size; i++ {
+			var v any
+			if v, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+		}
+		return a, offset, nil
+	case mmdbBool:
+		return size != 0, offset, nil
+	}
+
+	if b, offset, err = d.take(offset, size); err != nil {
+		return nil, 0, err
+	}
+	switch typ {
+	case mmdbString:
+		return string(b), offset, nil
+	case mmdbBytes:
+		return bytes.Clone(b), offset, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, errInvalidMMDB
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, errInvalidMMDB
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), offset, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64, mmdbInt32:
+		if size > 8 {
+			return nil, 0, errInvalidMMDB
+		}
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		if typ == mmdbInt32 {
+			return int32(uint32(n)), offset, nil
+		}
+		return n, offset, nil
+	case mmdbUint128:
+		if size <= 8 {
+			var n uint64
+			for _, c := range b {
+				n = n<<8 | uint64(c)
+			}
+			return n, offset, nil
+		}
+		return bytes.Clone(b), offset, nil
+	}
+	return nil, 0, fmt.Errorf("%w: unknown data type %d", errInvalidMMDB, typ)
+}
+
+// take returns the n bytes at offset, and the offset after them.
+func (d mmdbDecoder) take(offset, n int) ([]byte, int, error) {
+	if offset < 0 || n > len(d)-offset {
+		return nil, 0, errInvalidMMDB
+	}
+	return d[offset : offset+n], offset + n, nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*MMDBSource)(nil)
+	_ caddy.Provisioner       = (*MMDBSource)(nil)
+	_ caddy.CleanerUpper      = (*MMDBSource)(nil)
+	_ caddyfile.Unmarshaler   = (*MMDBSource)(nil)
+	_ caddyhttp.IPRangeSource = (*MMDBSource)(nil)
+)
@@ -0,0 +1,178 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// mmdbTestPointer encodes as a pointer to an offset in the data section.
+type mmdbTestPointer int
+
+// mmdbEncode encodes the values used in tests.
+func mmdbEncode(v any) []byte {
+	switch v := v.(type) {
+	case string:
+		return append([]byte{mmdbString<<5 | byte(len(v))}, v...)
+	case uint64:
+		b := bytes.TrimLeft(binary.BigEndian.AppendUint64(nil, v), "\x00")
+		return append([]byte{mmdbUint32<<5 | byte(len(b))}, b...)
+	case mmdbTestPointer:
+		return []byte{mmdbPointer<<5 | byte(v>>8&7), byte(v)}
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b := []byte{mmdbMap<<5 | byte(len(v))}
+		for _, k := range keys {
+			b = append(b, mmdbEncode(k)...)
+			b = append(b, mmdbEncode(v[k])...)
+		}
+		return b
+	}
+	panic(fmt.Sprintf("can't encode %T", v))
+}
+
+// writeTestMMDB writes an IPv6 database with 24-bit records to path, for
+// networks with their records. The first record in the data section is nl,
+// for records to point to.
+func writeTestMMDB(t *testing.T, path string, networks []string, records []map[string]any) {
+	t.Helper()
+	nl := map[string]any{"iso_code": "NL"}
+	data := mmdbEncode(nl)
+
+	// Records are node numbers, 0 for none or the negated data offset
+	// minus one.
+	nodes := [][2]int{{}}
+	insert := func(addr [16]byte, bits, record int) {
+		node := 0
+		for i := 0; i < bits; i++ {
+			bit := int(addr[i/8] >> (7 - i%8) & 1)
+			if i == bits-1 {
+				nodes[node][bit] = record
+				break
+			}
+			if nodes[node][bit] <= 0 {
+				nodes = append(nodes, [2]int{})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+	}
+	for i, n := range networks {
+		p := netip.MustParsePrefix(n)
+		addr, bits := p.Addr().As16(), p.Bits()
+		if p.Addr().Is4() {
+			addr, bits = [16]byte{}, bits+96
+			copy(addr[12:], p.Addr().AsSlice())
+		}
+		insert(addr, bits, -len(data)-1)
+		data = append(data, mmdbEncode(records[i])...)
+	}
+	ipv4 := 0
+	for i := 0; i < 96; i++ {
+		ipv4 = nodes[ipv4][0]
+	}
+	insert(netip.MustParseAddr("::ffff:0:0").As16(), 96, ipv4)
+
+	var buf []byte
+	for _, node := range nodes {
+		for _, record := range node {
+			switch {
+			case record == 0:
+				record = len(nodes)
+			case record < 0:
+				record = len(nodes) + 16 - record - 1
+			}
+			buf = append(buf, byte(record>>16), byte(record>>8), byte(record))
+		}
+	}
+	buf = append(buf, make([]byte, 16)...)
+	buf = append(buf, data...)
+	buf = append(buf, mmdbMetadataStart...)
+	buf = append(buf, mmdbEncode(map[string]any{
+		"binary_format_major_version": uint64(2),
+		"ip_version":                  uint64(6),
+		"node_count":                  uint64(len(nodes)),
+		"record_size":                 uint64(24),
+	})...)
+	if err := os.WriteFile(path, buf, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMMDBSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "GeoLite2.mmdb")
+	writeTestMMDB(t, path,
+		[]string{"192.0.2.0/25", "192.0.2.128/25", "198.51.100.0/24", "2001:db8::/32"},
+		[]map[string]any{
+			{"country": mmdbTestPointer(0), "autonomous_system_number": uint64(64500)},
+			{"country": mmdbTestPointer(0), "city": map[string]any{"geoname_id": uint64(2759794)}},
+			{"registered_country": mmdbTestPointer(0)},
+			{"country": map[string]any{"iso_code": "DE"}, "autonomous_system_number": uint64(64501)},
+		})
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	for _, test := range []*struct {
+		MMDBSource
+		want string
+	}{
+		{MMDBSource{Path: path, Countries: []string{"nl"}}, "[192.0.2.0/24 198.51.100.0/24]"},
+		{MMDBSource{Path: path, ASNs: []string{"AS64501"}}, "[2001:db8::/32]"},
+		{MMDBSource{Path: path, Countries: []string{"DE"}, ASNs: []string{"64500"}}, "[192.0.2.0/25 2001:db8::/32]"},
+	} {
+		if err := test.Provision(ctx); err != nil {
+			t.Errorf("%v %v: %v", test.Countries, test.ASNs, err)
+			continue
+		}
+		if got := fmt.Sprint(test.GetIPRanges(nil)); got != test.want {
+			t.Errorf("%v %v: got %s, want %s", test.Countries, test.ASNs, got, test.want)
+		}
+		test.Cleanup()
+	}
+
+	s := MMDBSource{Path: path, Countries: []string{"NL"}}
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cleanup()
+	waitFor(t, "update", func() bool {
+		writeTestMMDB(t, path, []string{"203.0.113.0/24"}, []map[string]any{{"country": mmdbTestPointer(0)}})
+		return fmt.Sprint(s.GetIPRanges(nil)) == "[203.0.113.0/24]"
+	})
+
+	if err := os.WriteFile(path, []byte("not a database"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	invalid := MMDBSource{Path: path, Countries: []string{"NL"}}
+	if err := invalid.Provision(ctx); err == nil {
+		t.Error("no error for an invalid database")
+	}
+	invalid.Cleanup()
+}
+
+func TestUnmarshalMMDBSource(t *testing.T) {
+	var s MMDBSource
+	input := `mmdb /var/lib/GeoIP/GeoLite2-Country.mmdb {
+		country NL BE
+		asn AS13335
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.Path, " ", s.Countries, " ", s.ASNs), "/var/lib/GeoIP/GeoLite2-Country.mmdb [NL BE] [AS13335]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
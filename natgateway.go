@@ -0,0 +1,423 @@
+package dns
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(new(NATGatewaySource))
+}
+
+// The default refresh interval of the NAT gateway source. NAT-PMP gateways
+// announce changes as they happen, so with them this is only a fallback.
+const DefaultNATGatewayInterval = caddy.Duration(5 * time.Minute)
+
+// Supported protocols to ask the NAT gateway with.
+const (
+	NATProtocolPMP  = "nat-pmp"
+	NATProtocolUPnP = "upnp"
+)
+
+// The NAT-PMP port of gateways, the SSDP address that UPnP devices are
+// discovered at, and how NAT-PMP announcements are received. Overridden in
+// tests.
+var (
+	natpmpPort       = 5351
+	ssdpAddress      = "239.255.255.250:1900"
+	listenNATPMPNews = func() (net.PacketConn, error) {
+		return net.ListenMulticastUDP("udp4", nil, &net.UDPAddr{IP: net.IPv4(224, 0, 0, 1), Port: 5350})
+	}
+)
+
+// NATGatewaySource provides the external address of the local NAT gateway,
+// such as a home router, asking it with NAT-PMP or UPnP IGD.
+type NATGatewaySource struct {
+	// The protocol to use: "nat-pmp" or "upnp". Defaults to trying NAT-PMP
+	// first, and then UPnP.
+	Protocol string `json:"protocol,omitempty"`
+
+	// The address of the gateway, for NAT-PMP. Defaults to the default IPv4
+	// gateway. UPnP gateways are discovered instead.
+	Gateway string `json:"gateway,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+
+	// The control URL and service type of the UPnP service found last, to
+	// skip discovery. Only used by fetch, which doesn't run concurrently.
+	upnpControlURL, upnpService string
+}
+
+// CaddyModule returns the Caddy module information.
+func (*NATGatewaySource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.nat_gateway",
+		New: func() caddy.Module { return new(NATGatewaySource) },
+	}
+}
+
+// Provision asks for the external address, and starts refreshing it.
+func (s *NATGatewaySource) Provision(ctx caddy.Context) error {
+	switch s.Protocol {
+	case "", NATProtocolPMP, NATProtocolUPnP:
+	default:
+		return fmt.Errorf("unknown protocol %q", s.Protocol)
+	}
+	if s.Gateway != "" {
+		if _, err := netip.ParseAddr(s.Gateway); err != nil {
+			return err
+		}
+	}
+	if err := s.SourceOptions.validate(DefaultNATGatewayInterval); err != nil {
+		return err
+	}
+	// Announcements seen while watching are missed across a reload, so the
+	// result isn't shared.
+	if err := s.refresher.start(ctx, s.SourceOptions, "", s.fetch); err != nil {
+		return err
+	}
+	if s.Protocol != NATProtocolUPnP {
+		s.refresher.watch(s.watchNATPMP)
+	}
+	return nil
+}
+
+// fetch asks the gateway for its external address.
+func (s *NATGatewaySource) fetch(ctx context.Context) ([]netip.Prefix, error) {
+	var (
+		addr netip.Addr
+		err  error
+	)
+	switch s.Protocol {
+	case NATProtocolPMP:
+		addr, err = s.fetchNATPMP(ctx)
+	case NATProtocolUPnP:
+		addr, err = s.fetchUPnP(ctx)
+	default:
+		// NAT-PMP answers quickly, or its port is closed.
+		pmpCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		addr, err = s.fetchNATPMP(pmpCtx)
+		cancel()
+		if err != nil {
+			var upnpErr error
+			if addr, upnpErr = s.fetchUPnP(ctx); upnpErr != nil {
+				err = errors.Join(err, upnpErr)
+			} else {
+				err = nil
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []netip.Prefix{netip.PrefixFrom(addr, addr.BitLen())}, nil
+}
+
+// gateway returns the address of the NAT-PMP gateway.
+func (s *NATGatewaySource) gateway() (netip.Addr, error) {
+	if s.Gateway != "" {
+		return netip.ParseAddr(s.Gateway)
+	}
+	gateways, err := listGateways()
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	for _, gw := range gateways {
+		if gw.Unmap().Is4() {
+			return gw.Unmap(), nil
+		}
+	}
+	return netip.Addr{}, errors.New("no default IPv4 gateway")
+}
+
+// fetchNATPMP asks the gateway for its external address with NAT-PMP.
+func (s *NATGatewaySource) fetchNATPMP(ctx context.Context) (netip.Addr, error) {
+	gw, err := s.gateway()
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	// The request is the version and opcode 0; the response has opcode 128.
+	address := net.JoinHostPort(gw.String(), strconv.Itoa(natpmpPort))
+	resp, err := udpRoundTrip(ctx, "udp4", address, []byte{0, 0}, 250*time.Millisecond, func(resp []byte) bool {
+		return len(resp) >= 2 && resp[0] == 0 && resp[1] == 128
+	})
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("NAT-PMP gateway %s: %w", gw, err)
+	}
+	addr, err := parseNATPMPResponse(resp)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("NAT-PMP gateway %s: %w", gw, err)
+	}
+	return addr, nil
+}
+
+// parseNATPMPResponse returns the external address in a NAT-PMP response:
+// the version, opcode, result code, seconds since the gateway started, and
+// the address.
+func parseNATPMPResponse(resp []byte) (netip.Addr, error) {
+	if len(resp) < 12 {
+		return netip.Addr{}, errors.New("truncated response")
+	}
+	if code := binary.BigEndian.Uint16(resp[2:]); code != 0 {
+		return netip.Addr{}, fmt.Errorf("result code %d", code)
+	}
+	addr := netip.AddrFrom4([4]byte(resp[8:12]))
+	if addr.IsUnspecified() {
+		// The gateway has no external address yet.
+		return netip.Addr{}, errors.New("no external address")
+	}
+	return addr, nil
+}
+
+// watchNATPMP refreshes the address when the gateway announces a change, as
+// NAT-PMP gateways do by multicasting their response, until ctx is canceled
+// or listening fails.
+func (s *NATGatewaySource) watchNATPMP(ctx context.Context) error {
+	conn, err := listenNATPMPNews()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		udp, ok := from.(*net.UDPAddr)
+		if !ok || n < 12 || buf[0] != 0 || buf[1] != 128 {
+			continue
+		}
+		// Anyone on the network can send these, but only the gateway counts.
+		if gw, err := s.gateway(); err == nil && udp.AddrPort().Addr().Unmap() == gw {
+			s.refresher.refreshNow()
+		}
+	}
+}
+
+// fetchUPnP asks the gateway for its external address with UPnP, discovering
+// it first if needed.
+func (s *NATGatewaySource) fetchUPnP(ctx context.Context) (netip.Addr, error) {
+	if s.upnpControlURL != "" {
+		addr, err := upnpExternalAddress(ctx, s.upnpControlURL, s.upnpService)
+		if err == nil {
+			return addr, nil
+		}
+		// The gateway may have moved, or restarted with other URLs.
+		s.upnpControlURL = ""
+	}
+	controlURL, service, err := discoverUPnP(ctx)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("discovering UPnP gateway: %w", err)
+	}
+	addr, err := upnpExternalAddress(ctx, controlURL, service)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	s.upnpControlURL, s.upnpService = controlURL, service
+	return addr, nil
+}
+
+// upnpDevice is a device in a UPnP device description, in which the WAN
+// connection services are nested a few devices deep.
+type upnpDevice struct {
+	Services []struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []upnpDevice `xml:"deviceList>device"`
+}
+
+// findService returns the first WAN connection service of the device or the
+// devices in it.
+func (d *upnpDevice) findService() (controlURL, serviceType string) {
+	for _, s := range d.Services {
+		if strings.HasPrefix(s.ServiceType, "urn:schemas-upnp-org:service:WANIPConnection:") ||
+			strings.HasPrefix(s.ServiceType, "urn:schemas-upnp-org:service:WANPPPConnection:") {
+			return s.ControlURL, s.ServiceType
+		}
+	}
+	for i := range d.Devices {
+		if controlURL, serviceType := d.Devices[i].findService(); controlURL != "" {
+			return controlURL, serviceType
+		}
+	}
+	return "", ""
+}
+
+// discoverUPnP finds an Internet gateway device with SSDP, and returns the
+// control URL and type of its WAN connection service.
+func discoverUPnP(ctx context.Context) (controlURL, serviceType string, err error) {
+	const target = "urn:schemas-upnp-org:device:InternetGatewayDevice:1"
+	req := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: 239.255.255.250:1900\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n" +
+		"ST: " + target + "\r\n\r\n"
+	var location string
+	_, err = udpRoundTrip(ctx, "udp4", ssdpAddress, []byte(req), time.Second, func(resp []byte) bool {
+		r, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(resp)), nil)
+		if err != nil || r.StatusCode != http.StatusOK || r.Header.Get("ST") != target {
+			return false
+		}
+		location = r.Header.Get("Location")
+		return location != ""
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	data, err := fetchDocument(ctx, location, nil)
+	if err != nil {
+		return "", "", err
+	}
+	var desc struct {
+		URLBase string     `xml:"URLBase"`
+		Device  upnpDevice `xml:"device"`
+	}
+	if err := xml.Unmarshal(data, &desc); err != nil {
+		return "", "", fmt.Errorf("device description: %w", err)
+	}
+	controlURL, serviceType = desc.Device.findService()
+	if controlURL == "" {
+		return "", "", errors.New("no WAN connection service")
+	}
+	base := location
+	if desc.URLBase != "" {
+		base = desc.URLBase
+	}
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return "", "", err
+	}
+	ref, err := url.Parse(controlURL)
+	if err != nil {
+		return "", "", err
+	}
+	return baseURL.ResolveReference(ref).String(), serviceType, nil
+}
+
+// upnpExternalAddress calls the GetExternalIPAddress action of a WAN
+// connection service.
+func upnpExternalAddress(ctx context.Context, controlURL, serviceType string) (netip.Addr, error) {
+	body := `<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:GetExternalIPAddress xmlns:u="` + serviceType + `"/></s:Body></s:Envelope>`
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, controlURL, strings.NewReader(body))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+serviceType+`#GetExternalIPAddress"`)
+	req.Header.Set("User-Agent", sourceUserAgent)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return netip.Addr{}, fmt.Errorf("UPnP GetExternalIPAddress: unexpected status %s", resp.Status)
+	}
+	var envelope struct {
+		Address string `xml:"Body>GetExternalIPAddressResponse>NewExternalIPAddress"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return netip.Addr{}, fmt.Errorf("UPnP GetExternalIPAddress: %w", err)
+	}
+	addr, err := netip.ParseAddr(strings.TrimSpace(envelope.Address))
+	if err != nil || addr.IsUnspecified() {
+		return netip.Addr{}, fmt.Errorf("UPnP GetExternalIPAddress: no external address %q", envelope.Address)
+	}
+	return addr.Unmap(), nil
+}
+
+// Cleanup stops refreshing the address.
+func (s *NATGatewaySource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the external address of the gateway.
+func (s *NATGatewaySource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *NATGatewaySource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	nat_gateway [nat-pmp|upnp] {
+//	    gateway <address>
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *NATGatewaySource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	if d.NextArg() {
+		s.Protocol = d.Val()
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "gateway":
+			if !d.AllArgs(&s.Gateway) {
+				return d.ArgErr()
+			}
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown nat_gateway option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*NATGatewaySource)(nil)
+	_ caddy.Provisioner       = (*NATGatewaySource)(nil)
+	_ caddy.CleanerUpper      = (*NATGatewaySource)(nil)
+	_ caddyfile.Unmarshaler   = (*NATGatewaySource)(nil)
+	_ caddyhttp.IPRangeSource = (*NATGatewaySource)(nil)
+)
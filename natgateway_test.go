@@ -0,0 +1,207 @@
+package dns
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// natpmpResponse returns a response to an external address request.
+func natpmpResponse(addr [4]byte) []byte {
+	resp := make([]byte, 12)
+	resp[1] = 128
+	binary.BigEndian.PutUint32(resp[4:], 1234)
+	copy(resp[8:], addr[:])
+	return resp
+}
+
+func TestNATGatewaySourceNATPMP(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var external atomic.Value
+	external.Store([4]byte{203, 0, 113, 5})
+	go func() {
+		buf := make([]byte, 1500)
+		for i := 0; ; i++ {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			// Drop the first request, to test retransmission.
+			if i == 0 || n != 2 || buf[0] != 0 || buf[1] != 0 {
+				continue
+			}
+			conn.WriteTo(natpmpResponse(external.Load().([4]byte)), from)
+		}
+	}()
+
+	news := make(chan net.Addr, 1)
+	defer func(p int, f func() (net.PacketConn, error)) { natpmpPort, listenNATPMPNews = p, f }(natpmpPort, listenNATPMPNews)
+	natpmpPort = conn.LocalAddr().(*net.UDPAddr).Port
+	listenNATPMPNews = func() (net.PacketConn, error) {
+		c, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		if err == nil {
+			news <- c.LocalAddr()
+		}
+		return c, err
+	}
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	s := NATGatewaySource{Protocol: NATProtocolPMP, Gateway: "127.0.0.1"}
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cleanup()
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[203.0.113.5/32]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// The gateway announces its new address.
+	external.Store([4]byte{198, 51, 100, 7})
+	if _, err := conn.WriteTo(natpmpResponse([4]byte{198, 51, 100, 7}), <-news); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the new address", func() bool {
+		return fmt.Sprint(s.GetIPRanges(nil)) == "[198.51.100.7/32]"
+	})
+}
+
+func TestParseNATPMPResponse(t *testing.T) {
+	resp := natpmpResponse([4]byte{203, 0, 113, 5})
+	if addr, err := parseNATPMPResponse(resp); err != nil || addr.String() != "203.0.113.5" {
+		t.Errorf("got %v, %v", addr, err)
+	}
+	// A result code of 3 means the gateway is not connected yet.
+	resp[3] = 3
+	if _, err := parseNATPMPResponse(resp); err == nil {
+		t.Error("no error for a failure")
+	}
+	if _, err := parseNATPMPResponse(natpmpResponse([4]byte{})); err == nil {
+		t.Error("no error without an external address")
+	}
+	if _, err := parseNATPMPResponse(resp[:8]); err == nil {
+		t.Error("no error for a truncated response")
+	}
+}
+
+func TestNATGatewaySourceUPnP(t *testing.T) {
+	const service = "urn:schemas-upnp-org:service:WANIPConnection:1"
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rootDesc.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+    <serviceList>
+      <service><serviceType>urn:schemas-upnp-org:service:Layer3Forwarding:1</serviceType><controlURL>/ctl/L3F</controlURL></service>
+    </serviceList>
+    <deviceList>
+      <device>
+        <deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+        <deviceList>
+          <device>
+            <deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+            <serviceList>
+              <service><serviceType>`+service+`</serviceType><controlURL>/ctl/IPConn</controlURL></service>
+            </serviceList>
+          </device>
+        </deviceList>
+      </device>
+    </deviceList>
+  </device>
+</root>`)
+	})
+	mux.HandleFunc("/ctl/IPConn", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("SOAPAction") != `"`+service+`#GetExternalIPAddress"` || !bytes.Contains(body, []byte("GetExternalIPAddress")) {
+			http.Error(w, "unexpected action", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+  <s:Body>
+    <u:GetExternalIPAddressResponse xmlns:u="`+service+`">
+      <NewExternalIPAddress>203.0.113.9</NewExternalIPAddress>
+    </u:GetExternalIPAddressResponse>
+  </s:Body>
+</s:Envelope>`)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf[:n])))
+			if err != nil || req.Method != "M-SEARCH" {
+				continue
+			}
+			// Devices of other types answer too.
+			conn.WriteTo([]byte("HTTP/1.1 200 OK\r\nST: upnp:rootdevice\r\nLOCATION: http://192.0.2.1/\r\n\r\n"), from)
+			resp := strings.Join([]string{
+				"HTTP/1.1 200 OK",
+				"CACHE-CONTROL: max-age=120",
+				"ST: " + req.Header.Get("ST"),
+				"LOCATION: " + srv.URL + "/rootDesc.xml",
+				"", "",
+			}, "\r\n")
+			conn.WriteTo([]byte(resp), from)
+		}
+	}()
+	defer func(a string) { ssdpAddress = a }(ssdpAddress)
+	ssdpAddress = conn.LocalAddr().String()
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	s := NATGatewaySource{Protocol: NATProtocolUPnP}
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cleanup()
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[203.0.113.9/32]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if got, want := s.upnpControlURL, srv.URL+"/ctl/IPConn"; got != want {
+		t.Errorf("got control URL %s, want %s", got, want)
+	}
+}
+
+func TestUnmarshalNATGatewaySource(t *testing.T) {
+	var s NATGatewaySource
+	input := `nat_gateway nat-pmp {
+		gateway 192.168.1.1
+		interval 1h
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.Protocol, " ", s.Gateway, " ", time.Duration(s.Interval)), "nat-pmp 192.168.1.1 1h0m0s"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,168 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(new(NeighborsSource))
+}
+
+// The default refresh interval of the neighbors source.
+const DefaultNeighborsInterval = caddy.Duration(30 * time.Second)
+
+// Lists the neighbor table, overridden in tests.
+var listNeighbors = systemNeighbors
+
+// NeighborsSource provides the addresses in the kernel's neighbor table (the
+// ARP table for IPv4, NDP for IPv6) of devices with the given MAC addresses,
+// or of all neighbors.
+type NeighborsSource struct {
+	// The MAC addresses of the devices to include. Defaults to all
+	// neighbors.
+	MACs []string `json:"macs,omitempty"`
+
+	// Only include neighbors on this network interface, such as "eth0".
+	Interface string `json:"interface,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+	macs      map[string]bool
+}
+
+// neighbor is an entry of the neighbor table.
+type neighbor struct {
+	addr netip.Addr
+	mac  string
+
+	// The index of the network interface.
+	ifindex int
+}
+
+// CaddyModule returns the Caddy module information.
+func (*NeighborsSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.neighbors",
+		New: func() caddy.Module { return new(NeighborsSource) },
+	}
+}
+
+// Provision lists the neighbors, and starts refreshing them.
+func (s *NeighborsSource) Provision(ctx caddy.Context) error {
+	s.macs = make(map[string]bool, len(s.MACs))
+	for _, mac := range s.MACs {
+		hw, err := net.ParseMAC(mac)
+		if err != nil {
+			return err
+		}
+		s.macs[hw.String()] = true
+	}
+	if err := s.SourceOptions.validate(DefaultNeighborsInterval); err != nil {
+		return err
+	}
+	// The table changes all the time, so the result isn't shared.
+	return s.refresher.start(ctx, s.SourceOptions, "", s.fetch)
+}
+
+// fetch lists the addresses of the selected neighbors.
+func (s *NeighborsSource) fetch(_ context.Context) ([]netip.Prefix, error) {
+	ifindex := 0
+	if s.Interface != "" {
+		iface, err := net.InterfaceByName(s.Interface)
+		if err != nil {
+			return nil, err
+		}
+		ifindex = iface.Index
+	}
+	neighbors, err := listNeighbors()
+	if err != nil {
+		return nil, err
+	}
+	prefixes := []netip.Prefix{}
+	for _, n := range neighbors {
+		if ifindex != 0 && n.ifindex != ifindex {
+			continue
+		}
+		if len(s.macs) > 0 && !s.macs[n.mac] {
+			continue
+		}
+		// Zones don't matter for matching client addresses.
+		addr := n.addr.WithZone("").Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// Cleanup stops refreshing the neighbors.
+func (s *NeighborsSource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the addresses of the neighbors.
+func (s *NeighborsSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *NeighborsSource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	neighbors [<mac...>] {
+//	    mac <mac...>
+//	    interface <name>
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *NeighborsSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	s.MACs = append(s.MACs, d.RemainingArgs()...)
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "mac":
+			macs := d.RemainingArgs()
+			if len(macs) == 0 {
+				return d.ArgErr()
+			}
+			s.MACs = append(s.MACs, macs...)
+		case "interface":
+			if !d.AllArgs(&s.Interface) {
+				return d.ArgErr()
+			}
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown neighbors option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*NeighborsSource)(nil)
+	_ caddy.Provisioner       = (*NeighborsSource)(nil)
+	_ caddy.CleanerUpper      = (*NeighborsSource)(nil)
+	_ caddyfile.Unmarshaler   = (*NeighborsSource)(nil)
+	_ caddyhttp.IPRangeSource = (*NeighborsSource)(nil)
+)
@@ -0,0 +1,47 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package dns
+
+import (
+	"net"
+	"net/netip"
+
+	"golang.org/x/net/route"
+	"golang.org/x/sys/unix"
+)
+
+// systemNeighbors lists the resolved entries of the neighbor table. On macOS
+// and the BSDs, they're the routes with link-layer information.
+func systemNeighbors() ([]neighbor, error) {
+	rib, err := route.FetchRIB(unix.AF_UNSPEC, unix.NET_RT_FLAGS, unix.RTF_LLINFO)
+	if err != nil {
+		return nil, err
+	}
+	// The messages are the same as those of a route dump.
+	msgs, err := route.ParseRIB(route.RIBTypeRoute, rib)
+	if err != nil {
+		return nil, err
+	}
+	var neighbors []neighbor
+	for _, msg := range msgs {
+		m, ok := msg.(*route.RouteMessage)
+		if !ok || len(m.Addrs) <= unix.RTAX_GATEWAY {
+			continue
+		}
+		n := neighbor{ifindex: m.Index}
+		switch dst := m.Addrs[unix.RTAX_DST].(type) {
+		case *route.Inet4Addr:
+			n.addr = netip.AddrFrom4(dst.IP)
+		case *route.Inet6Addr:
+			n.addr = netip.AddrFrom16(dst.IP)
+		}
+		// Unresolved entries have no link-layer address yet.
+		if link, ok := m.Addrs[unix.RTAX_GATEWAY].(*route.LinkAddr); ok && len(link.Addr) > 0 {
+			n.mac = net.HardwareAddr(link.Addr).String()
+		}
+		if n.addr.IsValid() && n.mac != "" {
+			neighbors = append(neighbors, n)
+		}
+	}
+	return neighbors, nil
+}
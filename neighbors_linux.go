@@ -0,0 +1,63 @@
+package dns
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// The states of usable neighbor entries. Incomplete and failed entries have
+// no working link-layer address.
+const usableNeighborStates = unix.NUD_REACHABLE | unix.NUD_STALE | unix.NUD_DELAY | unix.NUD_PROBE | unix.NUD_PERMANENT
+
+// systemNeighbors lists the usable entries of the neighbor table. On Linux,
+// it's dumped with netlink.
+func systemNeighbors() ([]neighbor, error) {
+	rib, err := syscall.NetlinkRIB(unix.RTM_GETNEIGH, unix.AF_UNSPEC)
+	if err != nil {
+		return nil, os.NewSyscallError("netlink", err)
+	}
+	msgs, err := syscall.ParseNetlinkMessage(rib)
+	if err != nil {
+		return nil, err
+	}
+	var neighbors []neighbor
+	for _, m := range msgs {
+		if m.Header.Type != unix.RTM_NEWNEIGH || len(m.Data) < unix.SizeofNdMsg {
+			continue
+		}
+		nd := (*unix.NdMsg)(unsafe.Pointer(&m.Data[0]))
+		if nd.State&usableNeighborStates == 0 {
+			continue
+		}
+		n := neighbor{ifindex: int(nd.Ifindex)}
+		for b := m.Data[unix.SizeofNdMsg:]; len(b) >= unix.SizeofRtAttr; {
+			attr := (*unix.RtAttr)(unsafe.Pointer(&b[0]))
+			l := int(attr.Len)
+			if l < unix.SizeofRtAttr || l > len(b) {
+				break
+			}
+			value := b[unix.SizeofRtAttr:l]
+			switch attr.Type {
+			case unix.NDA_DST:
+				n.addr, _ = netip.AddrFromSlice(value)
+			case unix.NDA_LLADDR:
+				n.mac = net.HardwareAddr(value).String()
+			}
+			// Attributes are aligned to 4 bytes.
+			l = (l + unix.NLA_ALIGNTO - 1) &^ (unix.NLA_ALIGNTO - 1)
+			if l > len(b) {
+				break
+			}
+			b = b[l:]
+		}
+		if n.addr.IsValid() && n.mac != "" {
+			neighbors = append(neighbors, n)
+		}
+	}
+	return neighbors, nil
+}
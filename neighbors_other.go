@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package dns
+
+import "errors"
+
+// systemNeighbors reports that the neighbor table can't be read on this
+// platform.
+func systemNeighbors() ([]neighbor, error) {
+	return nil, errors.New("not supported on this platform")
+}
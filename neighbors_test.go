@@ -0,0 +1,74 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestSystemNeighbors(t *testing.T) {
+	neighbors, err := systemNeighbors()
+	if err != nil {
+		t.Skipf("can't read the neighbor table here: %v", err)
+	}
+	for _, n := range neighbors {
+		if !n.addr.IsValid() || n.mac == "" {
+			t.Errorf("incomplete neighbor %+v", n)
+		}
+	}
+}
+
+func TestNeighborsSource(t *testing.T) {
+	defer func(f func() ([]neighbor, error)) { listNeighbors = f }(listNeighbors)
+	listNeighbors = func() ([]neighbor, error) {
+		return []neighbor{
+			{netip.MustParseAddr("192.168.1.10"), "aa:bb:cc:dd:ee:01", 2},
+			{netip.MustParseAddr("fe80::a8bb:ccff:fedd:ee01%eth0"), "aa:bb:cc:dd:ee:01", 2},
+			{netip.MustParseAddr("192.168.1.11"), "aa:bb:cc:dd:ee:02", 2},
+			{netip.MustParseAddr("10.0.0.5"), "aa:bb:cc:dd:ee:03", 3},
+		}, nil
+	}
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	for _, test := range []*struct {
+		source NeighborsSource
+		want   string
+	}{
+		{NeighborsSource{}, "[10.0.0.5/32 192.168.1.10/32 192.168.1.11/32 fe80::a8bb:ccff:fedd:ee01/128]"},
+		{NeighborsSource{MACs: []string{"AA-BB-CC-DD-EE-01", "aa:bb:cc:dd:ee:03"}}, "[10.0.0.5/32 192.168.1.10/32 fe80::a8bb:ccff:fedd:ee01/128]"},
+	} {
+		s := &test.source
+		if err := s.Provision(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprint(s.GetIPRanges(nil)); got != test.want {
+			t.Errorf("%q: got %s, want %s", s.MACs, got, test.want)
+		}
+		s.Cleanup()
+	}
+
+	bad := NeighborsSource{MACs: []string{"not-a-mac"}}
+	if err := bad.Provision(ctx); err == nil {
+		bad.Cleanup()
+		t.Error("no error for an invalid MAC address")
+	}
+}
+
+func TestUnmarshalNeighborsSource(t *testing.T) {
+	var s NeighborsSource
+	input := `neighbors aa:bb:cc:dd:ee:01 {
+		mac aa:bb:cc:dd:ee:02
+		interface eth0
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.MACs, " ", s.Interface), "[aa:bb:cc:dd:ee:01 aa:bb:cc:dd:ee:02] eth0"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
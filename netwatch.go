@@ -0,0 +1,36 @@
+package dns
+
+import (
+	"sync"
+	"time"
+)
+
+// How long to wait after a network change before refreshing. Changes tend to
+// come in bursts, and the resolver may need a moment to catch up as well.
+const netSettleDelay = 2 * time.Second
+
+// refreshOnNetworkChange refreshes all hosts shortly after the network changes.
+func (d *DNSRange) refreshOnNetworkChange() error {
+	var (
+		mu      sync.Mutex
+		pending bool
+	)
+	return watchNetwork(d.ctx, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if pending {
+			return
+		}
+		pending = true
+		time.AfterFunc(netSettleDelay, func() {
+			mu.Lock()
+			pending = false
+			mu.Unlock()
+
+			if d.ctx.Err() == nil {
+				d.logger.Info("network changed, refreshing all hosts")
+				d.triggerRefresh("")
+			}
+		})
+	})
+}
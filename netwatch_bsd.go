@@ -0,0 +1,75 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package dns
+
+import (
+	"context"
+	"os"
+
+	"golang.org/x/net/route"
+	"golang.org/x/sys/unix"
+)
+
+// watchNetwork calls changed whenever a network interface, address or route
+// changes, until ctx is canceled. On macOS and the BSDs, changes are reported
+// by the routing socket, which is also where SystemConfiguration learns
+// about them.
+func watchNetwork(ctx context.Context, changed func()) error {
+	fd, err := unix.Socket(unix.AF_ROUTE, unix.SOCK_RAW, unix.AF_UNSPEC)
+	if err != nil {
+		return os.NewSyscallError("socket", err)
+	}
+	unix.CloseOnExec(fd)
+	if err := unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return os.NewSyscallError("setnonblock", err)
+	}
+
+	// Using an *os.File makes reads use the runtime poller, so that closing
+	// the file interrupts them.
+	f := os.NewFile(uintptr(fd), "route")
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+
+	go func() {
+		buf := make([]byte, os.Getpagesize())
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				return
+			}
+			if routeChanged(buf[:n]) {
+				changed()
+			}
+		}
+	}()
+
+	return nil
+}
+
+// routeChanged reports whether a message read from the routing socket is
+// about a change of interfaces, addresses or routes. The kernel also reports
+// every neighbor it resolves as a route, which doesn't count.
+func routeChanged(b []byte) bool {
+	msgs, err := route.ParseRIB(route.RIBTypeRoute, b)
+	if err != nil {
+		// Something changed, even if we can't tell what.
+		return true
+	}
+	for _, msg := range msgs {
+		switch msg := msg.(type) {
+		case *route.InterfaceMessage, *route.InterfaceAddrMessage:
+			return true
+		case *route.RouteMessage:
+			switch msg.Type {
+			case unix.RTM_ADD, unix.RTM_DELETE, unix.RTM_CHANGE:
+				if msg.Flags&unix.RTF_LLINFO == 0 {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// watchNetwork calls changed whenever a network interface, address or route
+// changes, until ctx is canceled. On Linux, changes are reported by netlink.
+func watchNetwork(ctx context.Context, changed func()) error {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, unix.NETLINK_ROUTE)
+	if err != nil {
+		return os.NewSyscallError("socket", err)
+	}
+
+	groups := unix.RTMGRP_LINK |
+		unix.RTMGRP_IPV4_IFADDR | unix.RTMGRP_IPV6_IFADDR |
+		unix.RTMGRP_IPV4_ROUTE | unix.RTMGRP_IPV6_ROUTE
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: uint32(groups)}); err != nil {
+		unix.Close(fd)
+		return os.NewSyscallError("bind", err)
+	}
+
+	// Using an *os.File makes reads use the runtime poller, so that closing
+	// the file interrupts them.
+	f := os.NewFile(uintptr(fd), "netlink")
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+
+	go func() {
+		buf := make([]byte, os.Getpagesize())
+		for {
+			// We don't care about the details, any message means something changed.
+			// ENOBUFS means we didn't keep up, so something changed as well.
+			if _, err := f.Read(buf); err != nil && !errors.Is(err, unix.ENOBUFS) {
+				return
+			}
+			changed()
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package dns
+
+import (
+	"context"
+	"errors"
+)
+
+// watchNetwork reports that network changes can't be watched on this
+// platform.
+func watchNetwork(ctx context.Context, changed func()) error {
+	return errors.New("not supported on this platform")
+}
@@ -0,0 +1,15 @@
+package dns
+
+import (
+	"context"
+	"testing"
+)
+
+func TestWatchNetwork(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := watchNetwork(ctx, func() {}); err != nil {
+		cancel()
+		t.Skipf("can't watch network changes here: %v", err)
+	}
+	cancel()
+}
@@ -0,0 +1,222 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(new(NomadSource))
+}
+
+const (
+	// The default refresh interval of the Nomad source. Changes are picked
+	// up with blocking queries as they happen, so this is only a fallback.
+	DefaultNomadInterval = caddy.Duration(5 * time.Minute)
+
+	// The default Nomad agent, if NOMAD_ADDR isn't set.
+	DefaultNomadAddress = "http://127.0.0.1:4646"
+)
+
+// NomadSource provides the addresses of the allocations of a service
+// registered with Nomad's native service discovery. It watches the service
+// with blocking queries, so that changes apply as they happen.
+type NomadSource struct {
+	// The address of the Nomad agent. Defaults to $NOMAD_ADDR, or else
+	// DefaultNomadAddress.
+	Address string `json:"address,omitempty"`
+
+	// The name of the service.
+	Service string `json:"service"`
+
+	// A tag the registrations must have.
+	Tag string `json:"tag,omitempty"`
+
+	// A filter expression the registrations must match, such as
+	// `Datacenter == "ams"`.
+	Filter string `json:"filter,omitempty"`
+
+	// The namespace of the service. Defaults to $NOMAD_NAMESPACE, or else
+	// the default namespace.
+	Namespace string `json:"namespace,omitempty"`
+
+	// The ACL token to use. Defaults to $NOMAD_TOKEN. May use global
+	// placeholders like {env.NOMAD_TOKEN}.
+	Token string `json:"token,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+	url       string
+	header    http.Header
+}
+
+// nomadRegistration is a service registration returned by the API.
+type nomadRegistration struct {
+	Address string `json:"Address"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (*NomadSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.nomad",
+		New: func() caddy.Module { return new(NomadSource) },
+	}
+}
+
+// Provision fetches the addresses, and starts watching for changes.
+func (s *NomadSource) Provision(ctx caddy.Context) error {
+	if s.Service == "" {
+		return errors.New("no service provided")
+	}
+	if err := s.SourceOptions.validate(DefaultNomadInterval); err != nil {
+		return err
+	}
+
+	address := s.Address
+	if address == "" {
+		address = os.Getenv("NOMAD_ADDR")
+	}
+	if address == "" {
+		address = DefaultNomadAddress
+	}
+	query := make(url.Values)
+	if s.Tag != "" {
+		query.Set("tag", s.Tag)
+	}
+	if s.Filter != "" {
+		query.Set("filter", s.Filter)
+	}
+	namespace := s.Namespace
+	if namespace == "" {
+		namespace = os.Getenv("NOMAD_NAMESPACE")
+	}
+	if namespace != "" {
+		query.Set("namespace", namespace)
+	}
+	s.url = apiBase(address) + "/v1/service/" + url.PathEscape(s.Service)
+	if len(query) > 0 {
+		s.url += "?" + query.Encode()
+	}
+
+	token := s.Token
+	if token == "" {
+		token = os.Getenv("NOMAD_TOKEN")
+	}
+	s.header = make(http.Header)
+	if token != "" {
+		s.header.Set("X-Nomad-Token", caddy.NewReplacer().ReplaceKnown(token, ""))
+	}
+
+	// The result is kept up to date by blocking queries, which start over
+	// after a reload, so it isn't shared.
+	if err := s.refresher.start(ctx, s.SourceOptions, "", s.fetch); err != nil {
+		return err
+	}
+	s.refresher.watch(func(ctx context.Context) error {
+		return watchBlocking(ctx, s.url, s.header, "X-Nomad-Index", s.refresher.refreshNow)
+	})
+	return nil
+}
+
+// fetch fetches the addresses of the registrations.
+func (s *NomadSource) fetch(ctx context.Context) ([]netip.Prefix, error) {
+	var registrations []nomadRegistration
+	if err := fetchJSON(ctx, s.url, s.header, &registrations); err != nil {
+		return nil, err
+	}
+	strs := make([]string, 0, len(registrations))
+	for _, r := range registrations {
+		strs = append(strs, r.Address)
+	}
+	return parsePrefixList(strs)
+}
+
+// Cleanup stops refreshing the addresses.
+func (s *NomadSource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the addresses of the registrations.
+func (s *NomadSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *NomadSource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	nomad <service> {
+//	    address <address>
+//	    tag <tag>
+//	    filter <expression>
+//	    namespace <namespace>
+//	    token <token>
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *NomadSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	if !d.AllArgs(&s.Service) {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "address":
+			if !d.AllArgs(&s.Address) {
+				return d.ArgErr()
+			}
+		case "tag":
+			if !d.AllArgs(&s.Tag) {
+				return d.ArgErr()
+			}
+		case "filter":
+			if !d.AllArgs(&s.Filter) {
+				return d.ArgErr()
+			}
+		case "namespace":
+			if !d.AllArgs(&s.Namespace) {
+				return d.ArgErr()
+			}
+		case "token":
+			if !d.AllArgs(&s.Token) {
+				return d.ArgErr()
+			}
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown nomad option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*NomadSource)(nil)
+	_ caddy.Provisioner       = (*NomadSource)(nil)
+	_ caddy.CleanerUpper      = (*NomadSource)(nil)
+	_ caddyfile.Unmarshaler   = (*NomadSource)(nil)
+	_ caddyhttp.IPRangeSource = (*NomadSource)(nil)
+)
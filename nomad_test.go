@@ -0,0 +1,68 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestNomadSource(t *testing.T) {
+	nomad := newFakeBlocking("X-Nomad-Index", `[{"Address": "10.0.2.1"}, {"Address": "10.0.2.2"}]`)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/service/edge" || r.Header.Get("X-Nomad-Token") != "secret" {
+			http.NotFound(w, r)
+			return
+		}
+		q := r.URL.Query()
+		if q.Get("tag") != "proxy" || q.Get("filter") != `Datacenter == "ams"` || q.Get("namespace") != "prod" {
+			http.Error(w, "unexpected query "+r.URL.RawQuery, http.StatusBadRequest)
+			return
+		}
+		nomad.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	t.Setenv("NOMAD_ADDR", srv.URL)
+	t.Setenv("NOMAD_NAMESPACE", "prod")
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	s := NomadSource{Service: "edge", Tag: "proxy", Filter: `Datacenter == "ams"`, Token: "secret"}
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cleanup()
+
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[10.0.2.1/32 10.0.2.2/32]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	waitFor(t, "blocking query", func() bool {
+		nomad.mu.Lock()
+		defer nomad.mu.Unlock()
+		return len(nomad.queries) >= 3
+	})
+	nomad.set(`[{"Address": "10.0.2.3"}]`)
+	waitFor(t, "change", func() bool { return fmt.Sprint(s.GetIPRanges(nil)) == "[10.0.2.3/32]" })
+}
+
+func TestUnmarshalNomadSource(t *testing.T) {
+	var s NomadSource
+	input := `nomad edge {
+		address http://nomad.internal:4646
+		tag proxy
+		filter "Datacenter == \"ams\""
+		namespace prod
+		token {env.NOMAD_TOKEN}
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.Service, " ", s.Address, " ", s.Tag, " ", s.Filter, " ", s.Namespace, " ", s.Token), `edge http://nomad.internal:4646 proxy Datacenter == "ams" prod {env.NOMAD_TOKEN}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
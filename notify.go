@@ -0,0 +1,171 @@
+package dns
+
+import (
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyevents"
+)
+
+// The name of the event emitted when the addresses of hosts change.
+const ChangedEvent = "dns_ip_range.changed"
+
+// hostChange describes a change of the addresses of a host, from old to
+// addresses.
+type hostChange struct {
+	host      string
+	old       []netip.Prefix
+	addresses []netip.Prefix
+}
+
+// notifier batches changes and passes them on, at most once per debounce period.
+type notifier struct {
+	debounce time.Duration
+	send     func([]hostChange)
+
+	mu      sync.Mutex
+	pending map[string]hostChange
+	timer   *time.Timer
+	stopped bool
+}
+
+// changed records a change of the addresses of host from old to addresses.
+// Without a debounce period, the change is sent immediately. Otherwise, it's
+// sent along with all other changes at the end of the debounce period, which
+// starts at the first change. Several changes of a host in one period are
+// combined into one, from the addresses before the first to those after the
+// last.
+func (n *notifier) changed(host string, old, addresses []netip.Prefix) {
+	change := hostChange{host: host, old: old, addresses: addresses}
+	if n.debounce <= 0 {
+		n.send([]hostChange{change})
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.stopped {
+		return
+	}
+	if n.pending == nil {
+		n.pending = make(map[string]hostChange)
+	}
+	if prev, ok := n.pending[host]; ok {
+		change.old = prev.old
+	}
+	n.pending[host] = change
+	if n.timer == nil {
+		n.timer = time.AfterFunc(n.debounce, n.flush)
+	}
+}
+
+// flush sends all pending changes.
+func (n *notifier) flush() {
+	n.mu.Lock()
+	changes := make([]hostChange, 0, len(n.pending))
+	for _, change := range n.pending {
+		// Changes that were undone within the period are not sent.
+		if !samePrefixes(change.old, change.addresses) {
+			changes = append(changes, change)
+		}
+	}
+	n.pending = nil
+	n.timer = nil
+	stopped := n.stopped
+	n.mu.Unlock()
+
+	if stopped || len(changes) == 0 {
+		return
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].host < changes[j].host })
+	n.send(changes)
+}
+
+// stop discards pending changes and prevents further notifications.
+func (n *notifier) stop() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.stopped = true
+	if n.timer != nil {
+		n.timer.Stop()
+	}
+}
+
+// setupNotifier creates the notifier for the configured outputs, if any.
+func (d *DNSRange) setupNotifier(ctx caddy.Context) error {
+	if !d.EmitEvents && len(d.Webhooks) == 0 {
+		return nil
+	}
+
+	var events *caddyevents.App
+	if d.EmitEvents {
+		app, err := ctx.App("events")
+		if err != nil {
+			return err
+		}
+		events = app.(*caddyevents.App)
+	}
+
+	d.notifier = &notifier{
+		debounce: time.Duration(d.NotifyDebounce),
+		send: func(changes []hostChange) {
+			if events != nil {
+				events.Emit(d.ctx, ChangedEvent, changedEventData(changes))
+			}
+			d.changedWebhooks(changes)
+		},
+	}
+
+	return nil
+}
+
+// changedEventData returns the data for a ChangedEvent.
+func changedEventData(changes []hostChange) map[string]any {
+	hosts := make([]string, 0, len(changes))
+	addresses := make(map[string][]string, len(changes))
+	added := make(map[string][]string, len(changes))
+	removed := make(map[string][]string, len(changes))
+	for _, change := range changes {
+		hosts = append(hosts, change.host)
+		plus, minus := diffPrefixes(change.old, change.addresses)
+		addresses[change.host] = prefixStrings(change.addresses)
+		added[change.host] = prefixStrings(plus)
+		removed[change.host] = prefixStrings(minus)
+	}
+	return map[string]any{
+		"hosts":     hosts,
+		"addresses": addresses,
+		"added":     added,
+		"removed":   removed,
+	}
+}
+
+// prefixStrings formats prefixes as strings.
+func prefixStrings(prefixes []netip.Prefix) []string {
+	strs := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		strs = append(strs, prefix.String())
+	}
+	return strs
+}
+
+// samePrefixes reports whether a and b contain the same prefixes, in any order.
+func samePrefixes(a, b []netip.Prefix) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := make(map[netip.Prefix]int, len(a))
+	for _, p := range a {
+		counts[p]++
+	}
+	for _, p := range b {
+		if counts[p] == 0 {
+			return false
+		}
+		counts[p]--
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+package dns
+
+import (
+	"net/netip"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNotifierDebounce(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		batches [][]hostChange
+	)
+	n := notifier{
+		debounce: 50 * time.Millisecond,
+		send: func(changes []hostChange) {
+			mu.Lock()
+			defer mu.Unlock()
+			batches = append(batches, changes)
+		},
+	}
+	defer n.stop()
+
+	a := []netip.Prefix{netip.MustParsePrefix("192.0.2.1/32")}
+	b := []netip.Prefix{netip.MustParsePrefix("192.0.2.2/32")}
+	n.changed("b.example.com", nil, a)
+	n.changed("a.example.com", nil, a)
+	n.changed("b.example.com", a, b)
+	n.changed("c.example.com", a, b)
+	n.changed("c.example.com", b, a)
+
+	waitFor(t, "notification", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(batches) > 0
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := [][]hostChange{{
+		{host: "a.example.com", addresses: a},
+		{host: "b.example.com", addresses: b},
+	}}
+	if !reflect.DeepEqual(batches, want) {
+		t.Errorf("got %v, want %v", batches, want)
+	}
+}
+
+func TestChangedEventData(t *testing.T) {
+	data := changedEventData([]hostChange{
+		{
+			host:      "proxy.example.com",
+			old:       []netip.Prefix{netip.MustParsePrefix("192.0.2.1/32"), netip.MustParsePrefix("192.0.2.2/32")},
+			addresses: []netip.Prefix{netip.MustParsePrefix("192.0.2.1/32"), netip.MustParsePrefix("192.0.2.3/32")},
+		},
+	})
+	want := map[string]any{
+		"hosts":     []string{"proxy.example.com"},
+		"addresses": map[string][]string{"proxy.example.com": {"192.0.2.1/32", "192.0.2.3/32"}},
+		"added":     map[string][]string{"proxy.example.com": {"192.0.2.3/32"}},
+		"removed":   map[string][]string{"proxy.example.com": {"192.0.2.2/32"}},
+	}
+	if !reflect.DeepEqual(data, want) {
+		t.Errorf("got %v, want %v", data, want)
+	}
+}
+
+func TestSamePrefixes(t *testing.T) {
+	a := netip.MustParsePrefix("192.0.2.1/32")
+	b := netip.MustParsePrefix("192.0.2.2/32")
+	tests := []struct {
+		x, y []netip.Prefix
+		want bool
+	}{
+		{nil, nil, true},
+		{[]netip.Prefix{a, b}, []netip.Prefix{b, a}, true},
+		{[]netip.Prefix{a, a}, []netip.Prefix{a, b}, false},
+		{[]netip.Prefix{a}, nil, false},
+	}
+	for _, test := range tests {
+		if got := samePrefixes(test.x, test.y); got != test.want {
+			t.Errorf("samePrefixes(%v, %v) = %v, want %v", test.x, test.y, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(new(OCISource))
+}
+
+const (
+	// The default refresh interval of the OCI source.
+	DefaultOCIInterval = caddy.Duration(24 * time.Hour)
+
+	// The URL of the list of OCI ranges.
+	DefaultOCIURL = "https://docs.oracle.com/en-us/iaas/tools/public_ip_ranges.json"
+)
+
+// OCISource provides the ranges Oracle Cloud Infrastructure publishes in
+// public_ip_ranges.json, optionally only those of some regions and tags.
+type OCISource struct {
+	// The regions to include, such as "us-phoenix-1". Defaults to all of
+	// them. Case-insensitive.
+	Regions []string `json:"regions,omitempty"`
+
+	// The tags to include: "OCI", "OSN" or "OBJECT_STORAGE". A range is
+	// included if it has one of them. Defaults to all ranges.
+	// Case-insensitive.
+	Tags []string `json:"tags,omitempty"`
+
+	// The URL to fetch the ranges from, in the format of
+	// public_ip_ranges.json. Defaults to DefaultOCIURL.
+	URL string `json:"url,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+}
+
+// ociIPRanges is the content of public_ip_ranges.json.
+type ociIPRanges struct {
+	Regions []struct {
+		Region string `json:"region"`
+		CIDRs  []struct {
+			CIDR string   `json:"cidr"`
+			Tags []string `json:"tags"`
+		} `json:"cidrs"`
+	} `json:"regions"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (*OCISource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.oci",
+		New: func() caddy.Module { return new(OCISource) },
+	}
+}
+
+// Provision fetches the ranges, and starts refreshing them.
+func (s *OCISource) Provision(ctx caddy.Context) error {
+	if s.URL == "" {
+		s.URL = DefaultOCIURL
+	}
+	if err := s.SourceOptions.validate(DefaultOCIInterval); err != nil {
+		return err
+	}
+	return s.refresher.start(ctx, s.SourceOptions, sourceKey(s), s.fetch)
+}
+
+// fetch fetches the ranges.
+func (s *OCISource) fetch(ctx context.Context) ([]netip.Prefix, error) {
+	var ranges ociIPRanges
+	if err := fetchJSON(ctx, s.URL, nil, &ranges); err != nil {
+		return nil, err
+	}
+	var strs []string
+	for _, region := range ranges.Regions {
+		if !matchesAny(s.Regions, region.Region) {
+			continue
+		}
+		for _, cidr := range region.CIDRs {
+			if s.includeTags(cidr.Tags) {
+				strs = append(strs, cidr.CIDR)
+			}
+		}
+	}
+	if len(strs) == 0 {
+		return nil, errors.New("no OCI ranges match the regions and tags")
+	}
+	return parsePrefixList(strs)
+}
+
+// includeTags reports whether a range with the given tags is included.
+func (s *OCISource) includeTags(tags []string) bool {
+	if len(s.Tags) == 0 {
+		return true
+	}
+	for _, tag := range tags {
+		if matchesAny(s.Tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// Cleanup stops refreshing the ranges.
+func (s *OCISource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the selected OCI ranges.
+func (s *OCISource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *OCISource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	oci {
+//	    region <region...>
+//	    tag <tag...>
+//	    url <url>
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *OCISource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "region":
+			regions := d.RemainingArgs()
+			if len(regions) == 0 {
+				return d.ArgErr()
+			}
+			s.Regions = append(s.Regions, regions...)
+		case "tag":
+			tags := d.RemainingArgs()
+			if len(tags) == 0 {
+				return d.ArgErr()
+			}
+			s.Tags = append(s.Tags, tags...)
+		case "url":
+			if !d.AllArgs(&s.URL) {
+				return d.ArgErr()
+			}
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown oci option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*OCISource)(nil)
+	_ caddy.Provisioner       = (*OCISource)(nil)
+	_ caddy.CleanerUpper      = (*OCISource)(nil)
+	_ caddyfile.Unmarshaler   = (*OCISource)(nil)
+	_ caddyhttp.IPRangeSource = (*OCISource)(nil)
+)
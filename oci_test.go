@@ -0,0 +1,72 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestOCISource(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{
+			"last_updated_timestamp": "2023-03-27T19:40:00.000000",
+			"regions": [
+				{"region": "us-phoenix-1", "cidrs": [
+					{"cidr": "129.146.0.0/21", "tags": ["OCI"]},
+					{"cidr": "134.70.16.0/22", "tags": ["OSN", "OBJECT_STORAGE"]}
+				]},
+				{"region": "eu-frankfurt-1", "cidrs": [
+					{"cidr": "130.61.0.0/16", "tags": ["OCI"]}
+				]}
+			]
+		}`)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	for _, test := range []struct {
+		regions, tags []string
+		want          string
+	}{
+		{nil, nil, "[129.146.0.0/21 130.61.0.0/16 134.70.16.0/22]"},
+		{[]string{"US-PHOENIX-1"}, nil, "[129.146.0.0/21 134.70.16.0/22]"},
+		{nil, []string{"oci"}, "[129.146.0.0/21 130.61.0.0/16]"},
+		{[]string{"us-phoenix-1"}, []string{"OBJECT_STORAGE"}, "[134.70.16.0/22]"},
+	} {
+		s := OCISource{URL: srv.URL, Regions: test.regions, Tags: test.tags}
+		if err := s.Provision(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprint(s.GetIPRanges(nil)); got != test.want {
+			t.Errorf("regions %q, tags %q: got %s, want %s", test.regions, test.tags, got, test.want)
+		}
+		s.Cleanup()
+	}
+
+	s := OCISource{URL: srv.URL, Regions: []string{"eu-frankfurt-1"}, Tags: []string{"OSN"}}
+	if err := s.Provision(ctx); err == nil {
+		t.Error("no error when no ranges match")
+	}
+	s.Cleanup()
+}
+
+func TestUnmarshalOCISource(t *testing.T) {
+	var s OCISource
+	input := `oci {
+		region us-phoenix-1 us-ashburn-1
+		tag OCI
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.Regions, s.Tags), "[us-phoenix-1 us-ashburn-1] [OCI]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,286 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Supported values for ProbeConfig.Method.
+const (
+	ProbeTCP  = "tcp"
+	ProbeICMP = "icmp"
+)
+
+const (
+	DefaultProbeInterval = caddy.Duration(30 * time.Second)
+	DefaultProbeTimeout  = caddy.Duration(3 * time.Second)
+)
+
+// ProbeConfig configures a liveness probe of the resolved addresses.
+//
+// Probe results are informational only: they are recorded in the module state,
+// but never change which addresses are returned.
+type ProbeConfig struct {
+	// The probe method: "tcp" (connect to Port) or "icmp" (echo request).
+	// ICMP probes use unprivileged datagram sockets, which on Linux must be
+	// allowed by the net.ipv4.ping_group_range sysctl.
+	Method string `json:"method,omitempty"`
+
+	// The port to connect to for TCP probes.
+	Port int `json:"port,omitempty"`
+
+	// How often to probe. Defaults to DefaultProbeInterval.
+	Interval caddy.Duration `json:"interval,omitempty"`
+
+	// How long to wait for a response. Defaults to DefaultProbeTimeout.
+	Timeout caddy.Duration `json:"timeout,omitempty"`
+}
+
+// validate checks the configuration and sets defaults.
+func (p *ProbeConfig) validate() error {
+	switch p.Method {
+	case ProbeTCP:
+		if p.Port <= 0 || p.Port > 65535 {
+			return fmt.Errorf("invalid TCP probe port %d", p.Port)
+		}
+	case ProbeICMP:
+		if p.Port != 0 {
+			return errors.New("ICMP probes don't use a port")
+		}
+	default:
+		return fmt.Errorf("unknown probe method %q", p.Method)
+	}
+
+	if p.Interval < 0 || p.Timeout < 0 {
+		return errors.New("probe interval and timeout cannot be negative")
+	}
+	if p.Interval == 0 {
+		p.Interval = DefaultProbeInterval
+	}
+	if p.Timeout == 0 {
+		p.Timeout = DefaultProbeTimeout
+	}
+
+	return nil
+}
+
+// probe checks whether addr is reachable.
+func (p *ProbeConfig) probe(ctx context.Context, addr netip.Addr) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(p.Timeout))
+	defer cancel()
+
+	if p.Method == ProbeICMP {
+		return pingICMP(ctx, addr)
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", netip.AddrPortFrom(addr, uint16(p.Port)).String())
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// pingICMP sends an ICMP echo request to addr and waits for the reply.
+func pingICMP(ctx context.Context, addr netip.Addr) error {
+	network, listen, proto := "udp4", "0.0.0.0", 1
+	var reqType, replyType icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+	if !addr.Is4() {
+		network, listen, proto = "udp6", "::", 58
+		reqType, replyType = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+	}
+
+	conn, err := icmp.ListenPacket(network, listen)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+	}
+
+	msg := icmp.Message{
+		Type: reqType,
+		Body: &icmp.Echo{ID: os.Getpid() & 0xffff, Seq: 1, Data: []byte("caddy-dns-ip-range")},
+	}
+	req, err := msg.Marshal(nil)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.WriteTo(req, &net.UDPAddr{IP: addr.AsSlice(), Zone: addr.Zone()}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		reply, err := icmp.ParseMessage(proto, buf[:n])
+		if err == nil && reply.Type == replyType {
+			return nil
+		}
+	}
+}
+
+// keepProbing probes all current addresses until the module is cleaned up.
+func (d *DNSRange) keepProbing() {
+	ticker := time.NewTicker(time.Duration(d.Probe.Interval))
+	defer ticker.Stop()
+
+	for {
+		d.probeAll()
+
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probeAll probes the resolved addresses of all hosts, up to
+// MaxConcurrentLookups at a time, and records the results.
+func (d *DNSRange) probeAll() {
+	type target struct {
+		host string
+		addr netip.Addr
+	}
+	var targets []target
+	for host, h := range d.state {
+		for _, addr := range h.probeTargets() {
+			targets = append(targets, target{host, addr})
+		}
+	}
+
+	results := make([]error, len(targets))
+	workers := d.MaxConcurrentLookups
+	if workers < 1 {
+		workers = 1
+	}
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, addr netip.Addr) {
+			defer wg.Done()
+			results[i] = d.Probe.probe(d.ctx, addr)
+			<-sem
+		}(i, t.addr)
+	}
+	wg.Wait()
+
+	if d.ctx.Err() != nil {
+		return
+	}
+
+	alive := make(map[string]map[netip.Addr]bool, len(d.state))
+	errs := make(map[target]error, len(targets))
+	for i, t := range targets {
+		errs[t] = results[i]
+		up := results[i] == nil
+		if alive[t.host] == nil {
+			alive[t.host] = make(map[netip.Addr]bool)
+		}
+		alive[t.host][t.addr] = up
+	}
+	for host, h := range d.state {
+		h.mu.Lock()
+		prev := h.alive
+		h.alive = alive[host]
+		h.mu.Unlock()
+
+		for addr := range prev {
+			if _, ok := alive[host][addr]; !ok {
+				addressAlive.DeleteLabelValues(host, addr.String())
+			}
+		}
+		for addr, up := range alive[host] {
+			if was, known := prev[addr]; !known || was != up {
+				if up {
+					d.logger.Info("address is reachable",
+						zap.String("host", host),
+						zap.Stringer("ip", addr))
+				} else {
+					d.logger.Info("address is unreachable",
+						zap.String("host", host),
+						zap.Stringer("ip", addr),
+						zap.Error(errs[target{host, addr}]))
+				}
+			}
+			value := 0.0
+			if up {
+				value = 1
+			}
+			addressAlive.WithLabelValues(host, addr.String()).Set(value)
+		}
+	}
+}
+
+// unmarshalProbe parses the probe subdirective:
+//
+//	probe tcp <port> | icmp {
+//	    interval <duration>
+//	    timeout <duration>
+//	}
+func unmarshalProbe(d *caddyfile.Dispenser) (*ProbeConfig, error) {
+	var p ProbeConfig
+	if !d.NextArg() {
+		return nil, d.ArgErr()
+	}
+	p.Method = d.Val()
+	if p.Method == ProbeTCP {
+		if !d.NextArg() {
+			return nil, d.Err("expected port")
+		}
+		port, err := strconv.Atoi(d.Val())
+		if err != nil {
+			return nil, d.WrapErr(err)
+		}
+		p.Port = port
+	}
+	if d.NextArg() {
+		return nil, d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		var dst *caddy.Duration
+		switch d.Val() {
+		case "interval":
+			dst = &p.Interval
+		case "timeout":
+			dst = &p.Timeout
+		default:
+			return nil, d.Errf("unknown probe option %q", d.Val())
+		}
+		if !d.NextArg() {
+			return nil, d.Err("expected duration")
+		}
+		dur, err := caddy.ParseDuration(d.Val())
+		if err != nil {
+			return nil, d.WrapErr(err)
+		}
+		*dst = caddy.Duration(dur)
+	}
+
+	return &p, nil
+}
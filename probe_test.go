@@ -0,0 +1,94 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+func TestProbeTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	port := ln.Addr().(*net.TCPAddr).Port
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	r := DNSRange{
+		Probe:  &ProbeConfig{Method: ProbeTCP, Port: port},
+		state:  map[string]*hostState{"localhost": new(hostState)},
+		ctx:    ctx,
+		logger: zap.NewNop(),
+	}
+	if err := r.Probe.validate(); err != nil {
+		t.Fatal(err)
+	}
+	// The resolved address is probed, not the network it was widened to.
+	r.state["localhost"].set([]netip.Prefix{netip.MustParsePrefix("127.0.0.0/24")})
+	r.state["localhost"].answered([]netip.Addr{netip.MustParseAddr("127.0.0.1")})
+	r.rebuild()
+
+	alive := func() map[netip.Addr]bool {
+		h := r.state["localhost"]
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		return h.alive
+	}
+	r.probeAll()
+	if got := alive(); len(got) != 1 || !got[netip.MustParseAddr("127.0.0.1")] {
+		t.Errorf("listening address: got %v, want it reachable", got)
+	}
+
+	ln.Close()
+	r.probeAll()
+	if got := alive(); len(got) != 1 || got[netip.MustParseAddr("127.0.0.1")] {
+		t.Errorf("closed address: got %v, want it unreachable", got)
+	}
+
+	// Probing must not affect the returned ranges.
+	if got := r.GetIPRanges(nil); len(got) != 1 {
+		t.Errorf("probe changed returned ranges: %v", got)
+	}
+}
+
+func TestUnmarshalProbe(t *testing.T) {
+	input := `dns proxy.example.com {
+		probe tcp 443 {
+			interval 10s
+			timeout 1s
+		}
+	}`
+
+	var r DNSRange
+	if err := r.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+
+	want := ProbeConfig{
+		Method:   ProbeTCP,
+		Port:     443,
+		Interval: caddy.Duration(10 * time.Second),
+		Timeout:  caddy.Duration(time.Second),
+	}
+	if r.Probe == nil || *r.Probe != want {
+		t.Errorf("probe: got %+v, want %+v", r.Probe, want)
+	}
+}
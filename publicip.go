@@ -0,0 +1,358 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(new(PublicIPSource))
+}
+
+const (
+	// The default refresh interval of the public IP source.
+	DefaultPublicIPInterval = caddy.Duration(5 * time.Minute)
+
+	// The default STUN server.
+	DefaultSTUNServer = "stun.cloudflare.com:3478"
+)
+
+// The magic cookie of STUN messages, from RFC 5389.
+const stunMagicCookie = 0x2112A442
+
+// PublicIPSource provides this machine's public addresses, as seen by STUN
+// servers or HTTPS endpoints that echo the client's address.
+type PublicIPSource struct {
+	// The STUN servers to ask, as host:port, tried in order. Defaults to
+	// DefaultSTUNServer, unless URLs are set.
+	STUNServers []string `json:"stun_servers,omitempty"`
+
+	// URLs that respond with the client's address as text, such as
+	// https://api64.ipify.org, tried after the STUN servers.
+	URLs []string `json:"urls,omitempty"`
+
+	// The IP version to discover the address of: 4 or 6. Defaults to both,
+	// leaving out versions without connectivity.
+	IPVersion int `json:"ip_version,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+}
+
+// CaddyModule returns the Caddy module information.
+func (*PublicIPSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.public_ip",
+		New: func() caddy.Module { return new(PublicIPSource) },
+	}
+}
+
+// Provision discovers the addresses, and starts refreshing them.
+func (s *PublicIPSource) Provision(ctx caddy.Context) error {
+	switch s.IPVersion {
+	case 0, 4, 6:
+	default:
+		return fmt.Errorf("invalid IP version %d", s.IPVersion)
+	}
+	if len(s.STUNServers) == 0 && len(s.URLs) == 0 {
+		s.STUNServers = []string{DefaultSTUNServer}
+	}
+	if err := s.SourceOptions.validate(DefaultPublicIPInterval); err != nil {
+		return err
+	}
+	return s.refresher.start(ctx, s.SourceOptions, sourceKey(s), s.fetch)
+}
+
+// fetch discovers the address of each IP version.
+func (s *PublicIPSource) fetch(ctx context.Context) ([]netip.Prefix, error) {
+	versions := []int{4, 6}
+	if s.IPVersion != 0 {
+		versions = []int{s.IPVersion}
+	}
+	var (
+		prefixes []netip.Prefix
+		errs     []error
+	)
+	for _, version := range versions {
+		addr, err := s.discover(ctx, version)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("IPv%d: %w", version, err))
+			continue
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	if len(prefixes) == 0 {
+		return nil, errors.Join(errs...)
+	}
+	return prefixes, nil
+}
+
+// discover asks the STUN servers and then the URLs for the address of the
+// given IP version, until one answers.
+func (s *PublicIPSource) discover(ctx context.Context, version int) (netip.Addr, error) {
+	network := fmt.Sprint("udp", version)
+	var errs []error
+	for _, server := range s.STUNServers {
+		addr, err := stunMappedAddress(ctx, network, server)
+		if err == nil && addr.Is4() == (version == 4) {
+			return addr, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("got %v", addr)
+		}
+		errs = append(errs, fmt.Errorf("STUN server %s: %w", server, err))
+	}
+
+	var d net.Dialer
+	client := &http.Client{Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: func(ctx context.Context, _, address string) (net.Conn, error) {
+			return d.DialContext(ctx, fmt.Sprint("tcp", version), address)
+		},
+	}}
+	defer client.CloseIdleConnections()
+	for _, u := range s.URLs {
+		data, err := fetchDocumentWith(ctx, client, u, nil)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		addr, err := netip.ParseAddr(string(bytes.TrimSpace(data)))
+		if err == nil && addr.Unmap().Is4() == (version == 4) {
+			return addr.Unmap(), nil
+		}
+		if err == nil {
+			err = fmt.Errorf("got %v", addr)
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", redactURL(u), err))
+	}
+	return netip.Addr{}, errors.Join(errs...)
+}
+
+// stunMappedAddress sends a STUN binding request to server, and returns the
+// address it saw the request come from.
+func stunMappedAddress(ctx context.Context, network, server string) (netip.Addr, error) {
+	// A binding request is just a header: the type, the length of the
+	// attributes, the magic cookie and a transaction ID.
+	req := make([]byte, 20)
+	binary.BigEndian.PutUint16(req[0:], 0x0001)
+	binary.BigEndian.PutUint32(req[4:], stunMagicCookie)
+	if _, err := rand.Read(req[8:]); err != nil {
+		return netip.Addr{}, err
+	}
+	resp, err := udpRoundTrip(ctx, network, server, req, 500*time.Millisecond, func(resp []byte) bool {
+		return len(resp) >= 20 && bytes.Equal(resp[8:20], req[8:20])
+	})
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return parseSTUNResponse(resp)
+}
+
+// udpRoundTrip sends req to address, and returns the first response for which
+// match returns true. Since UDP may drop packets, the request is sent again
+// whenever no response comes in time, waiting twice as long each time, until
+// the deadline of ctx. Responses may come from another address than req was
+// sent to, as they do for multicast requests.
+func udpRoundTrip(ctx context.Context, network, address string, req []byte, wait time.Duration, match func([]byte) bool) ([]byte, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip"+strings.TrimPrefix(network, "udp"), host)
+	if err != nil {
+		return nil, err
+	}
+	portnum, err := net.DefaultResolver.LookupPort(ctx, network, port)
+	if err != nil {
+		return nil, err
+	}
+	to := net.UDPAddrFromAddrPort(netip.AddrPortFrom(ips[0].Unmap(), uint16(portnum)))
+	conn, err := net.ListenPacket(network, "")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	buf := make([]byte, 1500)
+	for ; ; wait *= 2 {
+		if _, err := conn.WriteTo(req, to); err != nil {
+			return nil, err
+		}
+		readDeadline := time.Now().Add(wait)
+		if !deadline.IsZero() && deadline.Before(readDeadline) {
+			readDeadline = deadline
+		}
+		_ = conn.SetReadDeadline(readDeadline)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() && readDeadline != deadline {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if match(buf[:n]) {
+				return buf[:n], nil
+			}
+		}
+	}
+}
+
+// parseSTUNResponse returns the mapped address in a binding response.
+func parseSTUNResponse(msg []byte) (netip.Addr, error) {
+	if typ := binary.BigEndian.Uint16(msg[0:]); typ != 0x0101 {
+		return netip.Addr{}, fmt.Errorf("unexpected STUN message type %#04x", typ)
+	}
+	length := int(binary.BigEndian.Uint16(msg[2:]))
+	if 20+length > len(msg) {
+		return netip.Addr{}, errors.New("truncated STUN message")
+	}
+	var mapped netip.Addr
+	for attrs := msg[20 : 20+length]; len(attrs) >= 4; {
+		typ := binary.BigEndian.Uint16(attrs[0:])
+		l := int(binary.BigEndian.Uint16(attrs[2:]))
+		if 4+l > len(attrs) {
+			break
+		}
+		value := attrs[4 : 4+l]
+		switch typ {
+		case 0x0020, 0x8020: // XOR-MAPPED-ADDRESS, and its type in old drafts
+			if addr, ok := stunAddress(value, msg[4:20]); ok {
+				return addr, nil
+			}
+		case 0x0001: // MAPPED-ADDRESS, from servers predating RFC 5389
+			if addr, ok := stunAddress(value, nil); ok {
+				mapped = addr
+			}
+		}
+		// Attributes are padded to 4 bytes.
+		l = (l + 3) &^ 3
+		if 4+l > len(attrs) {
+			break
+		}
+		attrs = attrs[4+l:]
+	}
+	if mapped.IsValid() {
+		return mapped, nil
+	}
+	return netip.Addr{}, errors.New("no mapped address in STUN response")
+}
+
+// stunAddress decodes an address attribute. If xor is set, the address is
+// XORed with it: the magic cookie and the transaction ID.
+func stunAddress(value, xor []byte) (netip.Addr, bool) {
+	if len(value) < 4 {
+		return netip.Addr{}, false
+	}
+	ip := bytes.Clone(value[4:])
+	if !(value[1] == 0x01 && len(ip) == 4) && !(value[1] == 0x02 && len(ip) == 16) {
+		return netip.Addr{}, false
+	}
+	if xor != nil {
+		for i := range ip {
+			ip[i] ^= xor[i]
+		}
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	return addr, ok
+}
+
+// Cleanup stops refreshing the addresses.
+func (s *PublicIPSource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the public addresses.
+func (s *PublicIPSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *PublicIPSource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	public_ip {
+//	    stun <host:port...>
+//	    url <url...>
+//	    ip_version 4|6
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *PublicIPSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "stun":
+			servers := d.RemainingArgs()
+			if len(servers) == 0 {
+				return d.ArgErr()
+			}
+			s.STUNServers = append(s.STUNServers, servers...)
+		case "url":
+			urls := d.RemainingArgs()
+			if len(urls) == 0 {
+				return d.ArgErr()
+			}
+			s.URLs = append(s.URLs, urls...)
+		case "ip_version":
+			var version string
+			if !d.AllArgs(&version) {
+				return d.ArgErr()
+			}
+			switch strings.TrimPrefix(version, "v") {
+			case "4":
+				s.IPVersion = 4
+			case "6":
+				s.IPVersion = 6
+			default:
+				return d.Errf("invalid IP version %q", version)
+			}
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown public_ip option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*PublicIPSource)(nil)
+	_ caddy.Provisioner       = (*PublicIPSource)(nil)
+	_ caddy.CleanerUpper      = (*PublicIPSource)(nil)
+	_ caddyfile.Unmarshaler   = (*PublicIPSource)(nil)
+	_ caddyhttp.IPRangeSource = (*PublicIPSource)(nil)
+)
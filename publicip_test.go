@@ -0,0 +1,138 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// stunResponse returns a binding response to req, with the address as the
+// given attribute type.
+func stunResponse(req []byte, attrType uint16, addr netip.Addr) []byte {
+	ip := addr.AsSlice()
+	family := byte(0x01)
+	if addr.Is6() {
+		family = 0x02
+	}
+	if attrType != 0x0001 {
+		for i := range ip {
+			ip[i] ^= req[4+i]
+		}
+	}
+	value := append([]byte{0, family, 0x12, 0x34}, ip...)
+	msg := make([]byte, 24, 24+len(value))
+	binary.BigEndian.PutUint16(msg[0:], 0x0101)
+	binary.BigEndian.PutUint16(msg[2:], uint16(4+len(value)))
+	copy(msg[4:20], req[4:20])
+	binary.BigEndian.PutUint16(msg[20:], attrType)
+	binary.BigEndian.PutUint16(msg[22:], uint16(len(value)))
+	return append(msg, value...)
+}
+
+func TestParseSTUNResponse(t *testing.T) {
+	req := make([]byte, 20)
+	binary.BigEndian.PutUint32(req[4:], stunMagicCookie)
+	copy(req[8:], "transaction!")
+	for _, test := range []struct {
+		attrType uint16
+		addr     string
+	}{
+		{0x0020, "203.0.113.5"},
+		{0x0020, "2001:db8::5"},
+		{0x8020, "203.0.113.5"},
+		{0x0001, "198.51.100.7"},
+	} {
+		got, err := parseSTUNResponse(stunResponse(req, test.attrType, netip.MustParseAddr(test.addr)))
+		if err != nil {
+			t.Errorf("%#04x %s: %v", test.attrType, test.addr, err)
+		} else if got.String() != test.addr {
+			t.Errorf("%#04x: got %v, want %s", test.attrType, got, test.addr)
+		}
+	}
+	if _, err := parseSTUNResponse(req); err == nil {
+		t.Error("no error for a request")
+	}
+}
+
+func TestPublicIPSourceSTUN(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for i := 0; ; i++ {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			// Drop the first request, to test retransmission.
+			if i == 0 || n != 20 || binary.BigEndian.Uint16(buf) != 0x0001 {
+				continue
+			}
+			conn.WriteTo(stunResponse(buf[:n], 0x0020, netip.MustParseAddr("203.0.113.5")), from)
+		}
+	}()
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	s := PublicIPSource{STUNServers: []string{conn.LocalAddr().String()}, IPVersion: 4}
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cleanup()
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[203.0.113.5/32]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestPublicIPSourceURL(t *testing.T) {
+	echo := "198.51.100.7\n"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, echo)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	s := PublicIPSource{URLs: []string{srv.URL}, IPVersion: 4}
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cleanup()
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[198.51.100.7/32]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// An address of the wrong version doesn't count.
+	echo = "2001:db8::7"
+	wrong := PublicIPSource{URLs: []string{srv.URL + "/ip"}, IPVersion: 4}
+	if err := wrong.Provision(ctx); err == nil {
+		wrong.Cleanup()
+		t.Error("no error for an IPv6 address")
+	}
+}
+
+func TestUnmarshalPublicIPSource(t *testing.T) {
+	var s PublicIPSource
+	input := `public_ip {
+		stun stun.example.com:3478
+		url https://api64.ipify.org
+		ip_version 6
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.STUNServers, " ", s.URLs, " ", s.IPVersion), "[stun.example.com:3478] [https://api64.ipify.org] 6"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
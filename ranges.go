@@ -0,0 +1,134 @@
+package dns
+
+import (
+	"net/netip"
+	"sort"
+	"time"
+
+	"github.com/fvbommel/caddy-dns-ip-range/iprange"
+)
+
+// rebuild recomputes the merged static addresses and addresses of all hosts
+// returned by GetIPRanges. It must be called whenever the addresses or their
+// confirmation times change. The caller must hold d.mu.
+func (d *DNSRange) rebuild() {
+	now := time.Now()
+	var size int
+	if old := d.snapshot.Load(); old != nil {
+		size = len(old.prefixes)
+	}
+	seen := make(map[netip.Prefix]struct{}, size)
+	ranges := make([]netip.Prefix, 0, size)
+	var expires time.Time
+	for _, prefix := range d.static {
+		if _, ok := seen[prefix]; !ok {
+			seen[prefix] = struct{}{}
+			ranges = append(ranges, prefix)
+		}
+	}
+	for host, h := range d.state {
+		v := h.current()
+		if v == nil || d.stale(v, now) {
+			hostAddresses.WithLabelValues(host).Set(0)
+			continue
+		}
+		hostAddresses.WithLabelValues(host).Set(float64(len(v.addresses)))
+		if d.MaxAge > 0 {
+			if t := v.confirmed.Add(time.Duration(d.MaxAge)); expires.IsZero() || t.Before(expires) {
+				expires = t
+			}
+		}
+		for _, prefix := range v.addresses {
+			if _, ok := seen[prefix]; !ok {
+				seen[prefix] = struct{}{}
+				ranges = append(ranges, prefix)
+			}
+		}
+	}
+
+	if d.EmitMapped {
+		for _, prefix := range ranges {
+			if prefix.Addr().Is4() {
+				mapped := netip.PrefixFrom(netip.AddrFrom16(prefix.Addr().As16()), 96+prefix.Bits())
+				if _, ok := seen[mapped]; !ok {
+					seen[mapped] = struct{}{}
+					ranges = append(ranges, mapped)
+				}
+			}
+		}
+	}
+
+	sortPrefixes(ranges)
+	if d.RemoveCovered {
+		ranges = removeCovered(ranges)
+	}
+
+	// Don't let callers append to the shared slice.
+	d.snapshot.Store(&snapshot{
+		prefixes: ranges[:len(ranges):len(ranges)],
+		set:      iprange.NewSet(ranges),
+		expires:  expires,
+	})
+}
+
+// snapshot is an immutable copy of the merged addresses of a range.
+type snapshot struct {
+	prefixes []netip.Prefix
+
+	// The same addresses, for checking whether they contain an address.
+	set *iprange.Set
+
+	// When the addresses of some host become stale, if ever.
+	expires time.Time
+}
+
+// current returns the merged addresses of all hosts, without locking unless
+// they have to be rebuilt. The result is shared, and must not be modified.
+func (d *DNSRange) current() []netip.Prefix {
+	if snap := d.currentSnapshot(); snap != nil {
+		return snap.prefixes
+	}
+	return nil
+}
+
+// currentSnapshot returns the current snapshot, rebuilding it first if the
+// addresses of some host became stale. It returns nil before provisioning.
+func (d *DNSRange) currentSnapshot() *snapshot {
+	snap := d.snapshot.Load()
+	if snap == nil || snap.expires.IsZero() || time.Now().Before(snap.expires) {
+		return snap
+	}
+
+	// The addresses of some host became stale.
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.snapshot.Load() == snap {
+		d.rebuild()
+	}
+	return d.snapshot.Load()
+}
+
+// removeCovered removes the prefixes that are contained in others from a
+// sorted list of distinct prefixes, in place.
+func removeCovered(prefixes []netip.Prefix) []netip.Prefix {
+	result := prefixes[:0]
+	for _, p := range prefixes {
+		// Prefixes either nest or don't overlap at all, so any prefix
+		// covering p sorts just before it, or before ones it covers too.
+		if n := len(result); n > 0 && result[n-1].Bits() <= p.Bits() && result[n-1].Contains(p.Addr()) {
+			continue
+		}
+		result = append(result, p)
+	}
+	return result
+}
+
+// sortPrefixes sorts prefixes by address, then by length.
+func sortPrefixes(prefixes []netip.Prefix) {
+	sort.Slice(prefixes, func(i, j int) bool {
+		if c := prefixes[i].Addr().Compare(prefixes[j].Addr()); c != 0 {
+			return c < 0
+		}
+		return prefixes[i].Bits() < prefixes[j].Bits()
+	})
+}
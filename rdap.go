@@ -0,0 +1,227 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/fvbommel/caddy-dns-ip-range/iprange"
+)
+
+func init() {
+	caddy.RegisterModule(new(RDAPSource))
+}
+
+// The default refresh interval of the RDAP source. Allocations rarely
+// change.
+const DefaultRDAPInterval = caddy.Duration(7 * 24 * time.Hour)
+
+// The RDAP servers of the RIRs, by the suffix of their handles. Handles
+// without a known suffix are looked up at ARIN, whose handles have none.
+var rdapServers = map[string]string{
+	"":        "https://rdap.arin.net/registry",
+	"ARIN":    "https://rdap.arin.net/registry",
+	"RIPE":    "https://rdap.db.ripe.net",
+	"AP":      "https://rdap.apnic.net",
+	"LACNIC":  "https://rdap.lacnic.net/rdap",
+	"AFRINIC": "https://rdap.afrinic.net/rdap",
+}
+
+// RDAPSource provides the netblocks registered to organizations, looked up
+// with RDAP by the handles of the organizations at their RIR.
+type RDAPSource struct {
+	// The handles of the organizations, such as "GOGL" or "ORG-GL1-RIPE".
+	Handles []string `json:"handles"`
+
+	// The base URL of the RDAP server. Defaults to the server of the RIR
+	// the handle belongs to, by its suffix.
+	Server string `json:"server,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+}
+
+// rdapNetwork is an IP network object.
+type rdapNetwork struct {
+	StartAddress string `json:"startAddress"`
+	EndAddress   string `json:"endAddress"`
+	CIDRs        []struct {
+		V4Prefix string `json:"v4prefix"`
+		V6Prefix string `json:"v6prefix"`
+		Length   int    `json:"length"`
+	} `json:"cidr0_cidrs"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (*RDAPSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.rdap",
+		New: func() caddy.Module { return new(RDAPSource) },
+	}
+}
+
+// Provision fetches the netblocks, and starts refreshing them.
+func (s *RDAPSource) Provision(ctx caddy.Context) error {
+	if len(s.Handles) == 0 {
+		return errors.New("no handles provided")
+	}
+	if err := s.SourceOptions.validate(DefaultRDAPInterval); err != nil {
+		return err
+	}
+	return s.refresher.start(ctx, s.SourceOptions, sourceKey(s), s.fetch)
+}
+
+// fetch fetches the netblocks of all organizations.
+func (s *RDAPSource) fetch(ctx context.Context) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, handle := range s.Handles {
+		networks, err := s.networks(ctx, handle)
+		if err != nil {
+			return nil, fmt.Errorf("RDAP entity %s: %w", handle, err)
+		}
+		for _, n := range networks {
+			p, err := n.prefixes()
+			if err != nil {
+				return nil, fmt.Errorf("RDAP entity %s: %w", handle, err)
+			}
+			prefixes = append(prefixes, p...)
+		}
+	}
+	return prefixes, nil
+}
+
+// networks returns the networks of an organization. ARIN lists them in the
+// entity; other servers may support reverse searches for them (RFC 9536).
+func (s *RDAPSource) networks(ctx context.Context, handle string) ([]rdapNetwork, error) {
+	server := s.Server
+	if server == "" {
+		var suffix string
+		if i := strings.LastIndexByte(handle, '-'); i >= 0 {
+			suffix = strings.ToUpper(handle[i+1:])
+		}
+		if server = rdapServers[suffix]; server == "" {
+			server = rdapServers[""]
+		}
+	}
+	server = strings.TrimSuffix(server, "/")
+	header := http.Header{"Accept": {"application/rdap+json"}}
+
+	var entity struct {
+		Networks []rdapNetwork `json:"networks"`
+	}
+	if err := fetchJSON(ctx, server+"/entity/"+url.PathEscape(handle), header, &entity); err != nil {
+		return nil, err
+	}
+	if len(entity.Networks) > 0 {
+		return entity.Networks, nil
+	}
+
+	var search struct {
+		Results []rdapNetwork `json:"ipSearchResults"`
+	}
+	query := url.Values{"handle": {handle}}
+	if err := fetchJSON(ctx, server+"/ips/reverse_search/entity?"+query.Encode(), header, &search); err != nil {
+		return nil, fmt.Errorf("no networks listed, and searching them failed: %w", err)
+	}
+	if len(search.Results) == 0 {
+		return nil, errors.New("no networks found")
+	}
+	return search.Results, nil
+}
+
+// prefixes returns the prefixes of the network, as listed by the cidr0
+// extension, or computed from its address range.
+func (n *rdapNetwork) prefixes() ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, c := range n.CIDRs {
+		addr := c.V4Prefix
+		if addr == "" {
+			addr = c.V6Prefix
+		}
+		p, err := parsePrefix(fmt.Sprintf("%s/%d", addr, c.Length))
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, p)
+	}
+	if len(prefixes) > 0 {
+		return prefixes, nil
+	}
+
+	first, err1 := netip.ParseAddr(n.StartAddress)
+	last, err2 := netip.ParseAddr(n.EndAddress)
+	if prefixes = iprange.FromRange(first, last); err1 != nil || err2 != nil || prefixes == nil {
+		return nil, fmt.Errorf("invalid network range %q - %q", n.StartAddress, n.EndAddress)
+	}
+	return prefixes, nil
+}
+
+// Cleanup stops refreshing the netblocks.
+func (s *RDAPSource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the netblocks of the organizations.
+func (s *RDAPSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *RDAPSource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	rdap <handle...> {
+//	    server <url>
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *RDAPSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	s.Handles = append(s.Handles, d.RemainingArgs()...)
+	if len(s.Handles) == 0 {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "server":
+			if !d.AllArgs(&s.Server) {
+				return d.ArgErr()
+			}
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown rdap option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*RDAPSource)(nil)
+	_ caddy.Provisioner       = (*RDAPSource)(nil)
+	_ caddy.CleanerUpper      = (*RDAPSource)(nil)
+	_ caddyfile.Unmarshaler   = (*RDAPSource)(nil)
+	_ caddyhttp.IPRangeSource = (*RDAPSource)(nil)
+)
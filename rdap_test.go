@@ -0,0 +1,78 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestRDAPSource(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/entity/EXMPL", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rdap+json")
+		fmt.Fprint(w, `{"objectClassName":"entity","handle":"EXMPL","networks":[
+			{"objectClassName":"ip network","startAddress":"192.0.2.0","endAddress":"192.0.2.255","ipVersion":"v4",
+			 "cidr0_cidrs":[{"v4prefix":"192.0.2.0","length":24}]},
+			{"objectClassName":"ip network","startAddress":"2001:db8::","endAddress":"2001:db8:0:ffff:ffff:ffff:ffff:ffff","ipVersion":"v6",
+			 "cidr0_cidrs":[{"v6prefix":"2001:db8::","length":48}]}
+		]}`)
+	})
+	mux.HandleFunc("/entity/ORG-EX1-RIPE", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"objectClassName":"entity","handle":"ORG-EX1-RIPE"}`)
+	})
+	mux.HandleFunc("/ips/reverse_search/entity", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("handle") != "ORG-EX1-RIPE" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"ipSearchResults":[
+			{"objectClassName":"ip network","startAddress":"198.51.100.0","endAddress":"198.51.100.191"}
+		]}`)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	for _, test := range []*struct {
+		RDAPSource
+		want string
+	}{
+		{RDAPSource{Handles: []string{"EXMPL"}, Server: srv.URL}, "[192.0.2.0/24 2001:db8::/48]"},
+		{RDAPSource{Handles: []string{"ORG-EX1-RIPE"}, Server: srv.URL + "/"}, "[198.51.100.0/25 198.51.100.128/26]"},
+	} {
+		if err := test.Provision(ctx); err != nil {
+			t.Errorf("%v: %v", test.Handles, err)
+			continue
+		}
+		if got := fmt.Sprint(test.GetIPRanges(nil)); got != test.want {
+			t.Errorf("%v: got %s, want %s", test.Handles, got, test.want)
+		}
+		test.Cleanup()
+	}
+
+	s := RDAPSource{Handles: []string{"MISSING"}, Server: srv.URL}
+	if err := s.Provision(ctx); err == nil {
+		t.Error("no error for an unknown handle")
+	}
+	s.Cleanup()
+}
+
+func TestUnmarshalRDAPSource(t *testing.T) {
+	var s RDAPSource
+	input := `rdap GOGL ORG-GL1-RIPE {
+		server https://rdap.example.net
+		interval 24h
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.Handles, " ", s.Server), "[GOGL ORG-GL1-RIPE] https://rdap.example.net"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
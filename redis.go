@@ -0,0 +1,420 @@
+package dns
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(new(RedisSource))
+}
+
+const (
+	// The default refresh interval of the Redis source.
+	DefaultRedisInterval = caddy.Duration(time.Minute)
+
+	// The default Redis server.
+	DefaultRedisAddress = "localhost:6379"
+)
+
+// RedisSource provides the IP addresses and CIDRs in a Redis set or list.
+// It can subscribe to a channel, or to keyspace notifications of the key,
+// to pick up changes right away.
+type RedisSource struct {
+	// The address of the Redis server. Defaults to DefaultRedisAddress.
+	Address string `json:"address,omitempty"`
+
+	// Connect with TLS.
+	TLS bool `json:"tls,omitempty"`
+
+	// The user to authenticate as, for Redis ACLs.
+	Username string `json:"username,omitempty"`
+
+	// The password to authenticate with. May use global placeholders like
+	// {env.REDIS_PASSWORD}.
+	Password string `json:"password,omitempty"`
+
+	// The database number.
+	DB int `json:"db,omitempty"`
+
+	// The key of the set or list.
+	Key string `json:"key"`
+
+	// A pub/sub channel on which messages announce changes.
+	Channel string `json:"channel,omitempty"`
+
+	// Subscribe to keyspace notifications of the key. The server must have
+	// them enabled, with notify-keyspace-events.
+	Keyspace bool `json:"keyspace,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+	password  string
+}
+
+// CaddyModule returns the Caddy module information.
+func (*RedisSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.redis",
+		New: func() caddy.Module { return new(RedisSource) },
+	}
+}
+
+// Provision fetches the prefixes, and starts refreshing them.
+func (s *RedisSource) Provision(ctx caddy.Context) error {
+	if s.Key == "" {
+		return errors.New("no key provided")
+	}
+	if s.DB < 0 {
+		return errors.New("db cannot be negative")
+	}
+	if err := s.SourceOptions.validate(DefaultRedisInterval); err != nil {
+		return err
+	}
+	if s.Address == "" {
+		s.Address = DefaultRedisAddress
+	}
+	s.password = caddy.NewReplacer().ReplaceKnown(s.Password, "")
+
+	var channels []string
+	if s.Channel != "" {
+		channels = append(channels, s.Channel)
+	}
+	if s.Keyspace {
+		channels = append(channels, fmt.Sprintf("__keyspace@%d__:%s", s.DB, s.Key))
+	}
+	// Subscribers start over after a reload, so the result isn't shared.
+	key := sourceKey(s)
+	if len(channels) > 0 {
+		key = ""
+	}
+	if err := s.refresher.start(ctx, s.SourceOptions, key, s.fetch); err != nil {
+		return err
+	}
+	if len(channels) > 0 {
+		s.refresher.watch(func(ctx context.Context) error {
+			return s.subscribe(ctx, channels)
+		})
+	}
+	return nil
+}
+
+// fetch fetches the members of the set or list.
+func (s *RedisSource) fetch(ctx context.Context) ([]netip.Prefix, error) {
+	c, err := s.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	typ, err := c.do("TYPE", s.Key)
+	if err != nil {
+		return nil, err
+	}
+	var members any
+	switch typ {
+	case "set":
+		members, err = c.do("SMEMBERS", s.Key)
+	case "list":
+		members, err = c.do("LRANGE", s.Key, "0", "-1")
+	case "none":
+		// Redis deletes sets and lists when their last member is removed.
+		return []netip.Prefix{}, nil
+	default:
+		return nil, fmt.Errorf("redis key %q is a %v, not a set or list", s.Key, typ)
+	}
+	if err != nil {
+		return nil, err
+	}
+	strs, err := redisStrings(members)
+	if err != nil {
+		return nil, err
+	}
+	return parsePrefixList(strs)
+}
+
+// subscribe subscribes to the channels, and refreshes the prefixes for each
+// message, until ctx is canceled or the connection fails.
+func (s *RedisSource) subscribe(ctx context.Context, channels []string) error {
+	c, err := s.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	// Unblock reads when the source is cleaned up.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-done:
+		}
+	}()
+
+	args := append([]string{"SUBSCRIBE"}, channels...)
+	if err := c.send(args...); err != nil {
+		return err
+	}
+	for {
+		reply, err := c.read()
+		if err != nil {
+			return err
+		}
+		msg, err := redisStrings(reply)
+		if err != nil {
+			return err
+		}
+		if len(msg) > 0 && msg[0] == "message" {
+			s.refresher.refreshNow()
+		}
+	}
+}
+
+// dial connects to the server, and authenticates and selects the database
+// as configured. The connection's deadline follows ctx.
+func (s *RedisSource) dial(ctx context.Context) (*redisConn, error) {
+	var (
+		conn net.Conn
+		err  error
+	)
+	if s.TLS {
+		host, _, _ := net.SplitHostPort(s.Address)
+		d := tls.Dialer{Config: &tls.Config{ServerName: host}}
+		conn, err = d.DialContext(ctx, "tcp", s.Address)
+	} else {
+		var d net.Dialer
+		conn, err = d.DialContext(ctx, "tcp", s.Address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	c := &redisConn{Conn: conn, r: bufio.NewReader(conn)}
+
+	if s.password != "" {
+		args := []string{"AUTH", s.password}
+		if s.Username != "" {
+			args = []string{"AUTH", s.Username, s.password}
+		}
+		if _, err := c.do(args...); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if s.DB != 0 {
+		if _, err := c.do("SELECT", strconv.Itoa(s.DB)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Cleanup stops refreshing the prefixes.
+func (s *RedisSource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the prefixes in the set or list.
+func (s *RedisSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *RedisSource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	redis <key> {
+//	    address <host:port>
+//	    tls
+//	    username <username>
+//	    password <password>
+//	    db <number>
+//	    channel <channel>
+//	    keyspace
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *RedisSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	if !d.AllArgs(&s.Key) {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "address":
+			if !d.AllArgs(&s.Address) {
+				return d.ArgErr()
+			}
+		case "tls":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			s.TLS = true
+		case "username":
+			if !d.AllArgs(&s.Username) {
+				return d.ArgErr()
+			}
+		case "password":
+			if !d.AllArgs(&s.Password) {
+				return d.ArgErr()
+			}
+		case "db":
+			var db string
+			if !d.AllArgs(&db) {
+				return d.ArgErr()
+			}
+			n, err := strconv.Atoi(db)
+			if err != nil {
+				return d.Errf("invalid db %q", db)
+			}
+			s.DB = n
+		case "channel":
+			if !d.AllArgs(&s.Channel) {
+				return d.ArgErr()
+			}
+		case "keyspace":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			s.Keyspace = true
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown redis option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// redisConn is a connection speaking the Redis protocol, RESP.
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// do sends a command, and returns its reply.
+func (c *redisConn) do(args ...string) (any, error) {
+	if err := c.send(args...); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+// send sends a command.
+func (c *redisConn) send(args ...string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := io.WriteString(c.Conn, b.String())
+	return err
+}
+
+// read reads a reply: a string, an integer, nil or a slice of replies.
+// Error replies are returned as errors.
+func (c *redisConn) read() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, fmt.Errorf("redis: %s", line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < -1 || n > maxDocumentSize {
+			return nil, fmt.Errorf("redis: invalid reply %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < -1 || n > maxDocumentSize {
+			return nil, fmt.Errorf("redis: invalid reply %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		replies := make([]any, n)
+		for i := range replies {
+			if replies[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return replies, nil
+	}
+	return nil, fmt.Errorf("redis: invalid reply %q", line)
+}
+
+// redisStrings returns the strings in an array reply.
+func redisStrings(reply any) ([]string, error) {
+	replies, ok := reply.([]any)
+	if !ok {
+		return nil, fmt.Errorf("redis: unexpected reply %v", reply)
+	}
+	strs := make([]string, 0, len(replies))
+	for _, r := range replies {
+		switch r := r.(type) {
+		case string:
+			strs = append(strs, r)
+		case int64:
+			strs = append(strs, strconv.FormatInt(r, 10))
+		}
+	}
+	return strs, nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*RedisSource)(nil)
+	_ caddy.Provisioner       = (*RedisSource)(nil)
+	_ caddy.CleanerUpper      = (*RedisSource)(nil)
+	_ caddyfile.Unmarshaler   = (*RedisSource)(nil)
+	_ caddyhttp.IPRangeSource = (*RedisSource)(nil)
+)
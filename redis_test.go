@@ -0,0 +1,187 @@
+package dns
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// fakeRedis is a Redis server with a few keys, which publishes a message to
+// subscribers when a key changes.
+type fakeRedis struct {
+	ln net.Listener
+
+	mu          sync.Mutex
+	keys        map[string][]string
+	types       map[string]string
+	subscribers []chan string
+	commands    []string
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{ln: ln, keys: make(map[string][]string), types: make(map[string]string)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	c := &redisConn{Conn: conn, r: bufio.NewReader(conn)}
+	for {
+		reply, err := c.read()
+		if err != nil {
+			return
+		}
+		args, _ := redisStrings(reply)
+		f.mu.Lock()
+		f.commands = append(f.commands, strings.Join(args, " "))
+		switch strings.ToUpper(args[0]) {
+		case "AUTH":
+			if args[len(args)-1] == "secret" {
+				fmt.Fprint(conn, "+OK\r\n")
+			} else {
+				fmt.Fprint(conn, "-WRONGPASS invalid password\r\n")
+			}
+		case "SELECT":
+			fmt.Fprint(conn, "+OK\r\n")
+		case "TYPE":
+			typ := f.types[args[1]]
+			if typ == "" {
+				typ = "none"
+			}
+			fmt.Fprintf(conn, "+%s\r\n", typ)
+		case "SMEMBERS", "LRANGE":
+			values := f.keys[args[1]]
+			fmt.Fprintf(conn, "*%d\r\n", len(values))
+			for _, v := range values {
+				fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(v), v)
+			}
+		case "SUBSCRIBE":
+			messages := make(chan string, 1)
+			f.subscribers = append(f.subscribers, messages)
+			for i, channel := range args[1:] {
+				fmt.Fprintf(conn, "*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:%d\r\n", len(channel), channel, i+1)
+			}
+			f.mu.Unlock()
+			for channel := range messages {
+				fmt.Fprintf(conn, "*3\r\n$7\r\nmessage\r\n$%d\r\n%s\r\n$4\r\nsadd\r\n", len(channel), channel)
+			}
+			return
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+		}
+		f.mu.Unlock()
+	}
+}
+
+// set sets key, and notifies subscribers on channel.
+func (f *fakeRedis) set(typ, key, channel string, values ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.types[key], f.keys[key] = typ, values
+	for _, s := range f.subscribers {
+		s <- channel
+	}
+}
+
+func TestRedisSource(t *testing.T) {
+	redis := newFakeRedis(t)
+	redis.set("set", "allowlist", "", "192.0.2.1", "198.51.100.0/24")
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	s := RedisSource{
+		Address:  redis.ln.Addr().String(),
+		Username: "caddy",
+		Password: "secret",
+		DB:       2,
+		Key:      "allowlist",
+		Keyspace: true,
+	}
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cleanup()
+
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[192.0.2.1/32 198.51.100.0/24]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	waitFor(t, "subscription", func() bool {
+		redis.mu.Lock()
+		defer redis.mu.Unlock()
+		return len(redis.subscribers) == 1
+	})
+	redis.mu.Lock()
+	if got, want := redis.commands[len(redis.commands)-1], "SUBSCRIBE __keyspace@2__:allowlist"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := redis.commands[0], "AUTH caddy secret"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	redis.mu.Unlock()
+
+	// Removing all members deletes the key.
+	redis.set("none", "allowlist", "__keyspace@2__:allowlist")
+	waitFor(t, "removal", func() bool { return len(s.GetIPRanges(nil)) == 0 })
+
+	// Keys may be lists too.
+	redis.set("list", "allowlist", "__keyspace@2__:allowlist", "2001:db8::1")
+	waitFor(t, "notification", func() bool { return fmt.Sprint(s.GetIPRanges(nil)) == "[2001:db8::1/128]" })
+}
+
+func TestRedisSourceErrors(t *testing.T) {
+	redis := newFakeRedis(t)
+	redis.set("string", "name", "", "proxy")
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	for _, s := range []*RedisSource{
+		{Address: redis.ln.Addr().String(), Key: "name"},
+		{Address: redis.ln.Addr().String(), Key: "name", Password: "wrong"},
+	} {
+		if err := s.Provision(ctx); err == nil {
+			t.Errorf("key %q: no error", s.Key)
+			s.Cleanup()
+		}
+	}
+}
+
+func TestUnmarshalRedisSource(t *testing.T) {
+	var s RedisSource
+	input := `redis allowlist {
+		address redis.internal:6380
+		tls
+		username caddy
+		password {env.REDIS_PASSWORD}
+		db 3
+		channel allowlist-changed
+		keyspace
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.Key, " ", s.Address, " ", s.TLS, " ", s.Username, " ", s.Password, " ", s.DB, " ", s.Channel, " ", s.Keyspace), "allowlist redis.internal:6380 true caddy {env.REDIS_PASSWORD} 3 allowlist-changed true"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
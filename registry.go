@@ -0,0 +1,71 @@
+package dns
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// Provisioned DNSRange instances, for use by the admin API and matchers.
+var (
+	instancesMu sync.RWMutex
+	instances   = make(map[*DNSRange]struct{})
+
+	// Instances by name, if they have one.
+	named = make(map[string]*DNSRange)
+)
+
+// register adds d to the set of instances reachable through the admin API,
+// and makes it available by name.
+func (d *DNSRange) register() {
+	instancesMu.Lock()
+	defer instancesMu.Unlock()
+	instances[d] = struct{}{}
+	if d.Name != "" {
+		// When the config is reloaded, the new instance replaces the old one.
+		named[d.Name] = d
+	}
+}
+
+// checkName returns an error if another range of the config being
+// provisioned with ctx already has the name of d. Ranges of the same config
+// with the same name and options are the same range, as when a server
+// option applies to several servers, so only conflicting ones are rejected.
+// A range of a new config replaces the one of the old config when it's
+// registered.
+func (d *DNSRange) checkName(ctx caddy.Context) error {
+	if d.Name == "" {
+		return nil
+	}
+	instancesMu.RLock()
+	other := named[d.Name]
+	instancesMu.RUnlock()
+	if other == nil || other.ctx.Context != ctx.Context {
+		return nil
+	}
+	if !bytes.Equal(other.config, d.config) {
+		return fmt.Errorf("dns ip range: another range is named %q", d.Name)
+	}
+	return nil
+}
+
+// unregister removes d from the set of instances reachable through the admin
+// API, along with the metrics of hosts that aren't used anymore.
+func (d *DNSRange) unregister() {
+	instancesMu.Lock()
+	defer instancesMu.Unlock()
+	delete(instances, d)
+	if d.Name != "" && named[d.Name] == d {
+		delete(named, d.Name)
+	}
+	d.forgetHostMetrics()
+}
+
+// namedRange returns the instance with the given name, or nil if there's none.
+func namedRange(name string) *DNSRange {
+	instancesMu.RLock()
+	defer instancesMu.RUnlock()
+	return named[name]
+}
@@ -0,0 +1,251 @@
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// Values for ResolverConfig.Mode.
+const (
+	// Let Go decide, which usually means using the operating system's
+	// resolver where it matters (e.g. with mDNS or other nsswitch modules).
+	ResolverModeSystem = "system"
+	// Always use Go's built-in DNS client, which reads /etc/resolv.conf and
+	// /etc/hosts itself.
+	ResolverModeGo = "go"
+)
+
+// ResolverConfig configures how host names are resolved.
+type ResolverConfig struct {
+	// Which local resolver implementation to use: "system" or "go".
+	// Defaults to "system". See net.Resolver.PreferGo.
+	Mode string `json:"mode,omitempty"`
+
+	// The URL of a resolver implementing the DNS-JSON API (application/dns-json),
+	// such as "https://cloudflare-dns.com/dns-query" or "https://dns.google/resolve".
+	// If empty, the system resolver is used.
+	DNSJSON string `json:"dns_json,omitempty"`
+
+	// The maximum number of answers to cache. Zero disables caching.
+	// When the resolver is shared through the dns_resolver app, so is its cache.
+	CacheSize int `json:"cache_size,omitempty"`
+
+	// How long to cache answers without a TTL, such as those of the system
+	// resolver. Answers of DNS-JSON resolvers are cached for their TTL.
+	// Defaults to 30s.
+	CacheTTL caddy.Duration `json:"cache_ttl,omitempty"`
+}
+
+// errNoRecords is returned by resolvers that can tell that a host exists,
+// but has no A or AAAA records.
+var errNoRecords = errors.New("host has no A or AAAA records")
+
+// resolver looks up the IP addresses of a host. The network is "ip", "ip4"
+// or "ip6", for both kinds of addresses, only IPv4 or only IPv6.
+// It's implemented by *net.Resolver.
+type resolver interface {
+	LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error)
+}
+
+// newResolver creates a resolver for the configuration.
+// A nil configuration selects the system resolver.
+// The resolver must be released with releaseResolver when no longer needed.
+func (c *ResolverConfig) newResolver() (resolver, error) {
+	if c == nil {
+		return net.DefaultResolver, nil
+	}
+
+	if c.CacheSize < 0 {
+		return nil, errors.New("cache size can't be negative")
+	}
+	if c.CacheTTL < 0 {
+		return nil, errors.New("cache TTL can't be negative")
+	}
+
+	r, err := c.newUncachedResolver()
+	if err != nil || c.CacheSize == 0 {
+		return r, err
+	}
+
+	cache, key, err := c.sharedCache()
+	if err != nil {
+		return nil, err
+	}
+	return &cachingResolver{next: r, cache: cache, key: key}, nil
+}
+
+// newUncachedResolver creates the resolver for the configuration, ignoring
+// the cache settings.
+func (c *ResolverConfig) newUncachedResolver() (resolver, error) {
+	switch c.Mode {
+	case "", ResolverModeSystem, ResolverModeGo:
+	default:
+		return nil, fmt.Errorf("unknown resolver mode %q", c.Mode)
+	}
+
+	if c.DNSJSON == "" {
+		return &net.Resolver{PreferGo: c.Mode == ResolverModeGo}, nil
+	}
+
+	if c.Mode != "" {
+		return nil, errors.New("resolver mode can't be combined with a DNS-JSON resolver")
+	}
+
+	u, err := url.Parse(c.DNSJSON)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DNS-JSON URL: %w", err)
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return nil, fmt.Errorf("invalid DNS-JSON URL %q: must be http or https", c.DNSJSON)
+	}
+
+	return &dnsJSONResolver{
+		url:    u,
+		client: &http.Client{Timeout: dnsJSONTimeout},
+	}, nil
+}
+
+// How long to wait for a DNS-JSON response.
+const dnsJSONTimeout = 10 * time.Second
+
+// DNS record types and response codes used by the DNS-JSON API.
+const (
+	typeA    = 1
+	typeAAAA = 28
+
+	rcodeSuccess  = 0
+	rcodeNXDomain = 3
+)
+
+// dnsJSONResolver resolves hosts using the DNS-JSON API.
+type dnsJSONResolver struct {
+	url    *url.URL
+	client *http.Client
+}
+
+// dnsJSONResponse is the relevant part of a DNS-JSON response.
+type dnsJSONResponse struct {
+	Status int `json:"Status"`
+	Answer []struct {
+		Type int    `json:"type"`
+		TTL  int    `json:"TTL"`
+		Data string `json:"data"`
+	} `json:"Answer"`
+}
+
+// LookupNetIP looks up the A and AAAA records of host concurrently, or
+// only one of them if the network asks for one kind of address.
+func (r *dnsJSONResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	addrs, _, err := r.lookupNetIPTTL(ctx, network, host)
+	return addrs, err
+}
+
+// lookupNetIPTTL is like LookupNetIP, but also returns the lowest TTL of the answers.
+func (r *dnsJSONResolver) lookupNetIPTTL(ctx context.Context, network, host string) ([]netip.Addr, time.Duration, error) {
+	var (
+		wg      sync.WaitGroup
+		results [2][]netip.Addr
+		ttls    [2]time.Duration
+		errs    [2]error
+	)
+	for i, qtype := range [2]int{typeA, typeAAAA} {
+		if (network == "ip4" && qtype != typeA) || (network == "ip6" && qtype != typeAAAA) {
+			continue
+		}
+		wg.Add(1)
+		go func(i, qtype int) {
+			defer wg.Done()
+			results[i], ttls[i], errs[i] = r.query(ctx, host, qtype)
+		}(i, qtype)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	addrs := append(results[0], results[1]...)
+	if len(addrs) == 0 {
+		return nil, 0, errNoRecords
+	}
+
+	ttl := ttls[0]
+	if ttl == 0 || (ttls[1] != 0 && ttls[1] < ttl) {
+		ttl = ttls[1]
+	}
+
+	return addrs, ttl, nil
+}
+
+// query performs a single DNS-JSON query and returns the data of the
+// answers of the requested type, and their lowest TTL. The TTL is zero
+// if there are no such answers.
+func (r *dnsJSONResolver) query(ctx context.Context, host string, qtype int) ([]netip.Addr, time.Duration, error) {
+	u := *r.url
+	q := u.Query()
+	q.Set("name", host)
+	q.Set("type", fmt.Sprint(qtype))
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Accept", "application/dns-json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, 0, &net.DNSError{Err: err.Error(), Name: host, Server: r.url.Host, IsTemporary: true}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, &net.DNSError{Err: "unexpected HTTP status " + resp.Status, Name: host, Server: r.url.Host, IsTemporary: true}
+	}
+
+	var msg dnsJSONResponse
+	if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+		return nil, 0, &net.DNSError{Err: "invalid DNS-JSON response: " + err.Error(), Name: host, Server: r.url.Host}
+	}
+
+	switch msg.Status {
+	case rcodeSuccess:
+	case rcodeNXDomain:
+		return nil, 0, &net.DNSError{Err: "no such host", Name: host, Server: r.url.Host, IsNotFound: true}
+	default:
+		return nil, 0, &net.DNSError{Err: fmt.Sprintf("server returned rcode %d", msg.Status), Name: host, Server: r.url.Host}
+	}
+
+	var (
+		result []netip.Addr
+		ttl    time.Duration
+	)
+	for _, answer := range msg.Answer {
+		// Skip CNAMEs etc.
+		if answer.Type != qtype {
+			continue
+		}
+		addr, err := netip.ParseAddr(answer.Data)
+		if err != nil {
+			return nil, 0, &net.DNSError{Err: fmt.Sprintf("invalid address %q in answer", answer.Data), Name: host, Server: r.url.Host}
+		}
+		result = append(result, addr)
+		if answerTTL := time.Duration(answer.TTL) * time.Second; ttl == 0 || answerTTL < ttl {
+			ttl = answerTTL
+		}
+	}
+
+	return result, ttl, nil
+}
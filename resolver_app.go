@@ -0,0 +1,151 @@
+package dns
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+)
+
+func init() {
+	caddy.RegisterModule(new(ResolverApp))
+	httpcaddyfile.RegisterGlobalOption("dns_resolver", parseResolverApp)
+}
+
+// ResolverApp is a Caddy app providing a resolver shared by all DNS-dependent
+// modules that opt in to using it, so that they share transport settings and
+// connections instead of duplicating them in every block.
+type ResolverApp struct {
+	ResolverConfig
+
+	// The resolver shared by all users of the app.
+	resolver resolver
+}
+
+// CaddyModule returns the Caddy module information.
+func (*ResolverApp) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "dns_resolver",
+		New: func() caddy.Module { return new(ResolverApp) },
+	}
+}
+
+// Provision sets up the shared resolver.
+func (a *ResolverApp) Provision(caddy.Context) error {
+	r, err := a.ResolverConfig.newResolver()
+	if err != nil {
+		return err
+	}
+	a.resolver = r
+	return nil
+}
+
+// Cleanup releases the shared resolver.
+func (a *ResolverApp) Cleanup() error {
+	releaseResolver(a.resolver)
+	return nil
+}
+
+// Start implements caddy.App.
+func (a *ResolverApp) Start() error { return nil }
+
+// Stop implements caddy.App.
+func (a *ResolverApp) Stop() error { return nil }
+
+// globalResolver returns the resolver of the dns_resolver app, and a key
+// identifying its configuration.
+func globalResolver(ctx caddy.Context) (resolver, string, error) {
+	app, err := ctx.App("dns_resolver")
+	if err != nil {
+		return nil, "", err
+	}
+	a := app.(*ResolverApp)
+	return a.resolver, "global " + string(caddyconfig.JSON(a.ResolverConfig, nil)), nil
+}
+
+// unmarshalResolver handles resolver subdirectives. It returns false if the
+// current token is not a resolver subdirective.
+func (c *ResolverConfig) unmarshalResolver(d *caddyfile.Dispenser) (bool, error) {
+	name := d.Val()
+	switch name {
+	case "dns_json", "mode", "cache_size", "cache_ttl":
+	default:
+		return false, nil
+	}
+
+	if !d.NextArg() {
+		return true, d.ArgErr()
+	}
+	switch name {
+	case "dns_json":
+		c.DNSJSON = d.Val()
+	case "mode":
+		c.Mode = d.Val()
+	case "cache_size":
+		size, err := strconv.Atoi(d.Val())
+		if err != nil {
+			return true, d.WrapErr(err)
+		}
+		c.CacheSize = size
+	case "cache_ttl":
+		ttl, err := caddy.ParseDuration(d.Val())
+		if err != nil {
+			return true, d.WrapErr(err)
+		}
+		c.CacheTTL = caddy.Duration(ttl)
+	}
+	if d.NextArg() {
+		return true, d.ArgErr()
+	}
+
+	return true, nil
+}
+
+// parseResolverApp configures the "dns_resolver" global option:
+//
+//	dns_resolver {
+//	    dns_json <url>
+//	    mode system|go
+//	    cache_size <entries>
+//	    cache_ttl <duration>
+//	}
+func parseResolverApp(d *caddyfile.Dispenser, _ any) (any, error) {
+	app := new(ResolverApp)
+
+	// Consume the option name.
+	if !d.Next() {
+		return nil, d.ArgErr()
+	}
+	if d.NextArg() {
+		return nil, d.ArgErr()
+	}
+
+	for d.NextBlock(0) {
+		ok, err := app.unmarshalResolver(d)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, d.Errf("unknown dns_resolver option %q", d.Val())
+		}
+	}
+
+	return httpcaddyfile.App{
+		Name:  "dns_resolver",
+		Value: caddyconfig.JSON(app, nil),
+	}, nil
+}
+
+// errResolverConflict is returned when a module configures its own resolver
+// and also asks to use the global one.
+var errResolverConflict = errors.New("can't use the global resolver and configure one as well")
+
+// Interface guards
+var (
+	_ caddy.App          = (*ResolverApp)(nil)
+	_ caddy.Provisioner  = (*ResolverApp)(nil)
+	_ caddy.CleanerUpper = (*ResolverApp)(nil)
+)
@@ -0,0 +1,40 @@
+package dns
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+)
+
+func TestParseResolverApp(t *testing.T) {
+	input := `dns_resolver {
+		dns_json https://cloudflare-dns.com/dns-query
+	}`
+
+	val, err := parseResolverApp(caddyfile.NewTestDispenser(input), nil)
+	if err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+
+	app := val.(httpcaddyfile.App)
+	if app.Name != "dns_resolver" {
+		t.Errorf("app name: got %q", app.Name)
+	}
+	var cfg ResolverApp
+	if err := json.Unmarshal(app.Value, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DNSJSON != "https://cloudflare-dns.com/dns-query" {
+		t.Errorf("dns_json: got %q", cfg.DNSJSON)
+	}
+
+	if err := cfg.Provision(caddy.Context{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cfg.resolver.(*dnsJSONResolver); !ok {
+		t.Errorf("got resolver %T, want DNS-JSON", cfg.resolver)
+	}
+}
@@ -0,0 +1,402 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// fakeResolver resolves hosts from a map. Hosts that aren't in the map
+// result in a "not found" error.
+type fakeResolver struct {
+	mu      sync.Mutex
+	hosts   map[string][]string
+	errs    map[string]error
+	lookups int
+}
+
+func (f *fakeResolver) LookupNetIP(_ context.Context, _, host string) ([]netip.Addr, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lookups++
+	if err := f.errs[host]; err != nil {
+		return nil, err
+	}
+	ips, ok := f.hosts[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	addrs := make([]netip.Addr, len(ips))
+	for i, ip := range ips {
+		addrs[i] = netip.MustParseAddr(ip)
+	}
+	return addrs, nil
+}
+
+func (f *fakeResolver) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lookups
+}
+
+func (f *fakeResolver) set(host string, addrs ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.hosts[host] = addrs
+}
+
+func TestDNSJSONResolver(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != "application/dns-json" {
+			t.Errorf("unexpected Accept header %q", r.Header.Get("Accept"))
+		}
+		w.Header().Set("Content-Type", "application/dns-json")
+		switch r.URL.Query().Get("name") + "/" + r.URL.Query().Get("type") {
+		case "proxy.example.com/1":
+			w.Write([]byte(`{"Status":0,"Answer":[
+				{"name":"proxy.example.com","type":5,"TTL":60,"data":"lb.example.com."},
+				{"name":"lb.example.com","type":1,"TTL":60,"data":"192.0.2.1"},
+				{"name":"lb.example.com","type":1,"TTL":60,"data":"192.0.2.2"}]}`))
+		case "proxy.example.com/28":
+			w.Write([]byte(`{"Status":0,"Answer":[{"name":"lb.example.com","type":28,"TTL":60,"data":"2001:db8::1"}]}`))
+		case "empty.example.com/1", "empty.example.com/28":
+			w.Write([]byte(`{"Status":0}`))
+		default:
+			w.Write([]byte(`{"Status":3}`))
+		}
+	}))
+	defer srv.Close()
+
+	r, err := (&ResolverConfig{DNSJSON: srv.URL}).newResolver()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addrs, err := r.LookupNetIP(context.Background(), "ip", "proxy.example.com")
+	if err != nil {
+		t.Fatalf("lookup error: %v", err)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i].Less(addrs[j]) })
+	if got, want := fmt.Sprint(addrs), "[192.0.2.1 192.0.2.2 2001:db8::1]"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	addrs, err = r.LookupNetIP(context.Background(), "ip6", "proxy.example.com")
+	if got, want := fmt.Sprint(addrs), "[2001:db8::1]"; err != nil || got != want {
+		t.Errorf("ip6: got %v, %v, want %v", got, err, want)
+	}
+
+	if _, err = r.LookupNetIP(context.Background(), "ip", "empty.example.com"); !errors.Is(err, errNoRecords) {
+		t.Errorf("expected empty answer error, got %v", err)
+	}
+
+	_, err = r.LookupNetIP(context.Background(), "ip", "missing.example.com")
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
+
+func TestResolverMode(t *testing.T) {
+	for _, test := range []struct {
+		config   ResolverConfig
+		preferGo bool
+		err      bool
+	}{
+		{ResolverConfig{}, false, false},
+		{ResolverConfig{Mode: ResolverModeSystem}, false, false},
+		{ResolverConfig{Mode: ResolverModeGo}, true, false},
+		{ResolverConfig{Mode: "cgo"}, false, true},
+		{ResolverConfig{Mode: ResolverModeGo, DNSJSON: "https://dns.google/resolve"}, false, true},
+	} {
+		r, err := test.config.newResolver()
+		if test.err {
+			if err == nil {
+				t.Errorf("%+v: expected error", test.config)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%+v: %v", test.config, err)
+			continue
+		}
+		if nr, ok := r.(*net.Resolver); !ok || nr.PreferGo != test.preferGo {
+			t.Errorf("%+v: got %#v, want PreferGo=%v", test.config, r, test.preferGo)
+		}
+	}
+}
+
+func TestCachingResolver(t *testing.T) {
+	res := &fakeResolver{hosts: map[string][]string{
+		"a.example.com": {"192.0.2.1"},
+		"b.example.com": {"192.0.2.2"},
+		"c.example.com": {"192.0.2.3"},
+	}}
+	r := &cachingResolver{next: res, cache: newDNSCache(2, time.Hour)}
+	ctx := context.Background()
+
+	lookup := func(host, want string) {
+		t.Helper()
+		addrs, err := r.LookupNetIP(ctx, "ip", host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(addrs) != 1 || addrs[0].String() != want {
+			t.Errorf("%s: got %v, want %s", host, addrs, want)
+		}
+	}
+
+	lookup("a.example.com", "192.0.2.1")
+	res.set("a.example.com", "192.0.2.9")
+	lookup("a.example.com", "192.0.2.1")
+	if n := res.count(); n != 1 {
+		t.Errorf("got %d lookups, want 1", n)
+	}
+
+	// Adding two more hosts evicts the least recently used one.
+	lookup("b.example.com", "192.0.2.2")
+	lookup("c.example.com", "192.0.2.3")
+	lookup("a.example.com", "192.0.2.9")
+	if n := res.count(); n != 4 {
+		t.Errorf("got %d lookups, want 4", n)
+	}
+
+	// Errors aren't cached.
+	if _, err := r.LookupNetIP(ctx, "ip", "unknown.example.com"); err == nil {
+		t.Error("expected error")
+	}
+	if _, err := r.LookupNetIP(ctx, "ip", "unknown.example.com"); err == nil {
+		t.Error("expected error")
+	}
+	if n := res.count(); n != 6 {
+		t.Errorf("got %d lookups, want 6", n)
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	var calls int
+	c := newDNSCache(10, time.Hour)
+	fetch := func(context.Context) ([]netip.Addr, time.Duration, error) {
+		calls++
+		return []netip.Addr{netip.MustParseAddr("192.0.2.1")}, time.Nanosecond, nil
+	}
+
+	// The TTL of the answer overrides the default.
+	for i := 0; i < 2; i++ {
+		if _, err := c.lookup(context.Background(), "ip", "a.example.com", fetch); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if calls != 2 {
+		t.Errorf("got %d calls, want 2", calls)
+	}
+}
+
+// blockingResolver counts lookups, which block until release is closed.
+type blockingResolver struct {
+	release chan struct{}
+	mu      sync.Mutex
+	lookups int
+}
+
+func (b *blockingResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	b.mu.Lock()
+	b.lookups++
+	b.mu.Unlock()
+	<-b.release
+	return []netip.Addr{netip.MustParseAddr("192.0.2.1")}, nil
+}
+
+func TestLookupGroup(t *testing.T) {
+	res := &blockingResolver{release: make(chan struct{})}
+	var g lookupGroup
+
+	const n = 10
+	var wg sync.WaitGroup
+	results := make([][]netip.Addr, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = g.lookupHost(context.Background(), res, res, "cloudflared")
+		}(i)
+	}
+	waitFor(t, "all lookups to wait", func() bool {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return len(g.calls) == 1
+	})
+	time.Sleep(10 * time.Millisecond)
+	close(res.release)
+	wg.Wait()
+
+	if res.lookups != 1 {
+		t.Errorf("got %d lookups, want 1", res.lookups)
+	}
+	for i, addrs := range results {
+		if len(addrs) != 1 {
+			t.Errorf("caller %d: got %v", i, addrs)
+		}
+	}
+
+	// Canceling a waiting caller doesn't affect the lookup.
+	res2 := &blockingResolver{release: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := g.lookupHost(ctx, res2, res2, "cloudflared"); !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+	close(res2.release)
+}
+
+func TestLookupGroupResolverConfig(t *testing.T) {
+	release := make(chan struct{})
+	var (
+		mu      sync.Mutex
+		lookups int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/dns-json")
+		if r.URL.Query().Get("type") != "1" {
+			w.Write([]byte(`{"Status":0}`))
+			return
+		}
+		mu.Lock()
+		lookups++
+		mu.Unlock()
+		<-release
+		w.Write([]byte(`{"Status":0,"Answer":[{"name":"coalesced.example.com","type":1,"TTL":60,"data":"192.0.2.1"}]}`))
+	}))
+	defer srv.Close()
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return lookups
+	}
+
+	// Each range creates its own resolver from the same configuration.
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	ranges := make([]*DNSRange, 2)
+	errs := make([]error, len(ranges))
+	var wg sync.WaitGroup
+	for i := range ranges {
+		ranges[i] = &DNSRange{Hosts: []string{"coalesced.example.com"}, Resolver: &ResolverConfig{DNSJSON: srv.URL}}
+		defer ranges[i].Cleanup()
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = ranges[i].Provision(ctx)
+		}(i)
+	}
+	waitFor(t, "lookup", func() bool { return count() > 0 })
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("range %d: %v", i, err)
+		}
+		if got := ranges[i].GetIPRanges(nil); len(got) != 1 {
+			t.Errorf("range %d: got %v, want 1 prefix", i, got)
+		}
+	}
+	if n := count(); n != 1 {
+		t.Errorf("got %d lookups, want 1", n)
+	}
+}
+
+func TestSharedAnswers(t *testing.T) {
+	res := &fakeResolver{hosts: map[string][]string{"shared.example.com": {"192.0.2.1"}}}
+	key := answerKey{resolver: res, host: "shared.example.com"}
+
+	old := &DNSRange{Hosts: []string{"shared.example.com"}}
+	cancel, err := provision(t, old, res)
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A reload provisions the new config before cleaning up the old one.
+	r := &DNSRange{Hosts: []string{"shared.example.com"}}
+	cancel, err = provision(t, r, res)
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := res.count(); n != 2 {
+		t.Errorf("got %d lookups, want 2", n)
+	}
+	if got := r.GetIPRanges(nil); len(got) != 1 {
+		t.Errorf("got %v, want 1 prefix", got)
+	}
+
+	// A refresh by one range is picked up by the other, without a lookup.
+	res.set("shared.example.com", "192.0.2.2")
+	old.sched.refreshNow("shared.example.com")
+	want := []netip.Prefix{netip.MustParsePrefix("192.0.2.2/32")}
+	waitFor(t, "shared answer", func() bool {
+		return reflect.DeepEqual(r.GetIPRanges(nil), want)
+	})
+	if n := res.count(); n != 3 {
+		t.Errorf("got %d lookups, want 3", n)
+	}
+
+	old.Cleanup()
+	old.Cleanup()
+	if refs, ok := answers.References(key); !ok || refs != 1 {
+		t.Errorf("got %d references, want 1", refs)
+	}
+
+	r.Cleanup()
+	if _, ok := answers.References(key); ok {
+		t.Errorf("answer still shared after cleanup")
+	}
+}
+
+func TestSharedCache(t *testing.T) {
+	config := &ResolverConfig{CacheSize: 10}
+	r1, err := config.newResolver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, err := (&ResolverConfig{CacheSize: 10}).newResolver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := (&ResolverConfig{CacheSize: 20}).newResolver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseResolver(other)
+
+	c1, c2 := r1.(*cachingResolver), r2.(*cachingResolver)
+	if c1.cache != c2.cache {
+		t.Error("resolvers with the same configuration don't share a cache")
+	}
+	if other.(*cachingResolver).cache == c1.cache {
+		t.Error("resolvers with different configurations share a cache")
+	}
+
+	releaseResolver(r1)
+	if refs, _ := caches.References(c1.key); refs != 1 {
+		t.Errorf("got %d references after release, want 1", refs)
+	}
+	releaseResolver(r2)
+	if _, ok := caches.References(c1.key); ok {
+		t.Error("cache still shared after all resolvers were released")
+	}
+}
@@ -0,0 +1,208 @@
+package dns
+
+import (
+	"fmt"
+	"net/netip"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// pendingUpdate is an update of the addresses of a host that was rejected by
+// a safety check. It's kept until it's accepted through the admin API, or a
+// later lookup returns the current addresses again.
+type pendingUpdate struct {
+	Addresses []netip.Prefix `json:"addresses"`
+	Reason    string         `json:"reason"`
+	Since     time.Time      `json:"since"`
+}
+
+// rejectedUpdate is the error returned by lookups whose answer was rejected
+// as a whole by a filter.
+type rejectedUpdate struct {
+	addresses []netip.Prefix
+	reason    string
+}
+
+func (e *rejectedUpdate) Error() string {
+	return "update rejected: " + e.reason
+}
+
+// checkUpdate returns why replacing the addresses old of a host by new is
+// suspicious, or an empty string if it isn't.
+func (d *DNSRange) checkUpdate(old, new []netip.Prefix) string {
+	if d.MaxAddresses > 0 {
+		if n := d.countAddresses() - len(old) + len(new); n > d.MaxAddresses {
+			return fmt.Sprintf("hosts would resolve to %d addresses, more than max_addresses (%d)", n, d.MaxAddresses)
+		}
+	}
+	if d.MaxChangeFraction > 0 && len(old) > 0 {
+		if fraction := changeFraction(old, new); fraction > d.MaxChangeFraction {
+			return fmt.Sprintf("%.0f%% of the addresses would change, more than max_change_fraction allows", 100*fraction)
+		}
+	}
+	return ""
+}
+
+// countAddresses returns the number of addresses of all hosts.
+func (d *DNSRange) countAddresses() (n int) {
+	for _, h := range d.state {
+		if v := h.current(); v != nil {
+			n += len(v.addresses)
+		}
+	}
+	return n
+}
+
+// changeFraction returns the fraction of the addresses in old and new that
+// are in only one of them.
+func changeFraction(old, new []netip.Prefix) float64 {
+	seen := make(map[netip.Prefix]int, len(old)+len(new))
+	for _, p := range old {
+		seen[p] |= 1
+	}
+	for _, p := range new {
+		seen[p] |= 2
+	}
+	if len(seen) == 0 {
+		return 0
+	}
+	changed := 0
+	for _, in := range seen {
+		if in != 3 {
+			changed++
+		}
+	}
+	return float64(changed) / float64(len(seen))
+}
+
+// diffPrefixes returns the prefixes that are in new but not in old, and
+// those that are in old but not in new, in the order of their lists.
+func diffPrefixes(old, new []netip.Prefix) (added, removed []netip.Prefix) {
+	in := make(map[netip.Prefix]bool, len(old))
+	for _, p := range old {
+		in[p] = true
+	}
+	for _, p := range new {
+		if !in[p] {
+			added = append(added, p)
+		}
+		delete(in, p)
+	}
+	for _, p := range old {
+		if in[p] {
+			removed = append(removed, p)
+		}
+	}
+	return added, removed
+}
+
+// update stores the result of a successful lookup of host, unless a safety
+// check rejects it, in which case it returns a *rejectedUpdate.
+func (d *DNSRange) update(host string, prefixes []netip.Prefix) error {
+	old, changed, err := d.apply(host, prefixes)
+	if !changed {
+		return err
+	}
+
+	added, removed := diffPrefixes(old, prefixes)
+	d.logger.Info("addresses changed",
+		zap.String("host", host),
+		zap.Stringers("added", added),
+		zap.Stringers("removed", removed))
+
+	if d.notifier != nil {
+		d.notifier.changed(host, old, prefixes)
+	}
+	return nil
+}
+
+// apply checks and applies an update of host, and reports whether the
+// addresses changed, or why the update was held back. If max_addresses is set, the check and the change
+// happen under a single lock, so concurrent updates of several hosts can't
+// exceed it together.
+func (d *DNSRange) apply(host string, prefixes []netip.Prefix) (old []netip.Prefix, changed bool, err error) {
+	if d.MaxAddresses > 0 {
+		d.updateMu.Lock()
+		defer d.updateMu.Unlock()
+	}
+	h := d.state[host]
+	h.mu.Lock()
+	v := h.current()
+	if v != nil {
+		old = v.addresses
+	}
+	if v != nil && samePrefixes(old, prefixes) {
+		// Back to normal, or never changed.
+		h.confirm()
+		h.mu.Unlock()
+		d.logger.Debug("addresses unchanged", zap.String("host", host))
+		if d.MaxAge > 0 {
+			d.publish()
+		}
+		return old, false, nil
+	}
+	if reason := d.checkUpdate(old, prefixes); reason != "" {
+		h.mu.Unlock()
+		d.hold(host, prefixes, reason)
+		return old, false, &rejectedUpdate{addresses: prefixes, reason: reason}
+	}
+	h.set(prefixes)
+	h.mu.Unlock()
+	d.publish()
+	return old, true, nil
+}
+
+// hold keeps a rejected update of host as pending, instead of applying it.
+func (d *DNSRange) hold(host string, prefixes []netip.Prefix, reason string) {
+	h := d.state[host]
+	h.mu.Lock()
+	if p := h.pending; p == nil || !samePrefixes(p.Addresses, prefixes) {
+		h.pending = &pendingUpdate{Addresses: prefixes, Reason: reason, Since: time.Now()}
+	}
+	h.mu.Unlock()
+
+	d.logger.Warn("rejected suspicious update, keeping previous addresses",
+		zap.String("host", host),
+		zap.String("reason", reason),
+		zap.Stringers("addresses", prefixes))
+}
+
+// acceptPending applies the pending update of host, or of all hosts if host
+// is empty. It returns the hosts whose update was applied.
+func (d *DNSRange) acceptPending(host string) (hosts []string) {
+	// Accepting bypasses the checks, but must not interleave with updates
+	// that are being checked.
+	d.updateMu.Lock()
+	var changes []hostChange
+	for name, h := range d.state {
+		if host != "" && name != host {
+			continue
+		}
+		h.mu.Lock()
+		if p := h.pending; p != nil {
+			var old []netip.Prefix
+			if v := h.current(); v != nil {
+				old = v.addresses
+			}
+			h.set(p.Addresses)
+			changes = append(changes, hostChange{host: name, old: old, addresses: p.Addresses})
+			hosts = append(hosts, name)
+		}
+		h.mu.Unlock()
+	}
+	if len(changes) > 0 {
+		d.publish()
+	}
+	d.updateMu.Unlock()
+
+	for _, change := range changes {
+		d.logger.Info("accepted pending update",
+			zap.String("host", change.host),
+			zap.Stringers("addresses", change.addresses))
+		if d.notifier != nil {
+			d.notifier.changed(change.host, change.old, change.addresses)
+		}
+	}
+	return hosts
+}
@@ -0,0 +1,190 @@
+package dns
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// pending returns the pending update of host.
+func pending(r *DNSRange, host string) *pendingUpdate {
+	h := r.state[host]
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.pending
+}
+
+func TestChangeFraction(t *testing.T) {
+	p := func(addrs ...string) []netip.Prefix {
+		prefixes := make([]netip.Prefix, 0, len(addrs))
+		for _, addr := range addrs {
+			prefixes = append(prefixes, netip.PrefixFrom(netip.MustParseAddr(addr), 32))
+		}
+		return prefixes
+	}
+
+	for _, tc := range []struct {
+		old, new []netip.Prefix
+		want     float64
+	}{
+		{nil, nil, 0},
+		{p("192.0.2.1"), p("192.0.2.1"), 0},
+		{p("192.0.2.1"), p("192.0.2.2"), 1},
+		{p("192.0.2.1", "192.0.2.2", "192.0.2.3"), p("192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4"), 0.25},
+		{p("192.0.2.1", "192.0.2.2"), p("192.0.2.2", "192.0.2.3"), 2.0 / 3},
+	} {
+		if got := changeFraction(tc.old, tc.new); got != tc.want {
+			t.Errorf("changeFraction(%v, %v) = %v, want %v", tc.old, tc.new, got, tc.want)
+		}
+	}
+}
+
+func TestChangeLogs(t *testing.T) {
+	res := &fakeResolver{hosts: map[string][]string{"proxy.example.com": {"192.0.2.1", "192.0.2.2"}}}
+	r := DNSRange{Hosts: []string{"proxy.example.com"}, Interval: caddy.Duration(time.Hour)}
+	cancel, err := provision(t, &r, res)
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	core, logs := observer.New(zapcore.InfoLevel)
+	r.logger = zap.New(core)
+
+	// Unchanged results aren't logged at the info level.
+	r.update("proxy.example.com", []netip.Prefix{netip.MustParsePrefix("192.0.2.1/32"), netip.MustParsePrefix("192.0.2.2/32")})
+	if n := logs.Len(); n != 0 {
+		t.Errorf("got %d info logs for an unchanged result", n)
+	}
+
+	r.update("proxy.example.com", []netip.Prefix{netip.MustParsePrefix("192.0.2.2/32"), netip.MustParsePrefix("192.0.2.3/32")})
+	entries := logs.FilterMessage("addresses changed").All()
+	if len(entries) != 1 {
+		t.Fatalf("got %d change logs, want 1", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	if got := fmt.Sprint(fields["added"], fields["removed"]); got != "[192.0.2.3/32] [192.0.2.1/32]" {
+		t.Errorf("got added and removed %s", got)
+	}
+}
+
+func TestRejectAndAccept(t *testing.T) {
+	res := &fakeResolver{hosts: map[string][]string{"proxy.example.com": {"192.0.2.1", "192.0.2.2"}}}
+	r := DNSRange{
+		Hosts:             []string{"proxy.example.com"},
+		Interval:          caddy.Duration(time.Millisecond),
+		MaxChangeFraction: 0.5,
+		History:           5,
+	}
+	cancel, err := provision(t, &r, res)
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Cleanup()
+
+	res.set("proxy.example.com", "198.51.100.1", "198.51.100.2")
+	waitFor(t, "pending update", func() bool { return pending(&r, "proxy.example.com") != nil })
+	if got := r.GetIPRanges(nil); len(got) != 2 || got[0].Addr().String()[:7] != "192.0.2" {
+		t.Errorf("rejected update was applied: %v", got)
+	}
+	entries := r.hostHistory("proxy.example.com", time.Time{}).Entries
+	if len(entries) == 0 || entries[0].Error == "" || entries[0].Addresses[0].Addr().String()[:10] != "198.51.100" {
+		t.Errorf("rejected update isn't in the history as such: %+v", entries)
+	}
+
+	var api adminAPI
+	w := httptest.NewRecorder()
+	if err := api.handleAccept(w, httptest.NewRequest(http.MethodPost, "/dns_ip_range/accept?host=proxy.example.com", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Body.String(); got != `["proxy.example.com"]`+"\n" {
+		t.Errorf("accepted: got %s", got)
+	}
+	got := r.GetIPRanges(nil)
+	if len(got) != 2 || got[0].Addr().String()[:10] != "198.51.100" {
+		t.Errorf("accepted update wasn't applied: %v", got)
+	}
+
+	w = httptest.NewRecorder()
+	if err := api.handleAccept(w, httptest.NewRequest(http.MethodPost, "/dns_ip_range/accept?host=proxy.example.com", nil)); err == nil {
+		t.Error("expected error when nothing is pending")
+	}
+}
+
+func TestLimits(t *testing.T) {
+	res := &fakeResolver{hosts: map[string][]string{
+		"a.example.com": {"192.0.2.1", "192.0.2.2"},
+		"b.example.com": {"192.0.2.3"},
+	}}
+
+	r := DNSRange{Hosts: []string{"a.example.com", "b.example.com"}, MaxHosts: 1}
+	cancel, err := provision(t, &r, res)
+	cancel()
+	if err == nil {
+		t.Error("max_hosts: expected error")
+	}
+
+	r2 := DNSRange{Hosts: []string{"a.example.com", "b.example.com"}, MaxAddresses: 2}
+	cancel, err = provision(t, &r2, res)
+	cancel()
+	if err == nil {
+		t.Error("max_addresses: expected error")
+	}
+
+	r3 := DNSRange{
+		Hosts:        []string{"a.example.com", "b.example.com"},
+		Interval:     caddy.Duration(time.Millisecond),
+		MaxAddresses: 4,
+	}
+	cancel, err = provision(t, &r3, res)
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.set("b.example.com", "192.0.2.3", "192.0.2.4", "192.0.2.5")
+	waitFor(t, "pending update", func() bool { return pending(&r3, "b.example.com") != nil })
+	if got := r3.GetIPRanges(nil); len(got) != 3 {
+		t.Errorf("update exceeding max_addresses was applied: %v", got)
+	}
+}
+
+func TestMaxAddressesConcurrent(t *testing.T) {
+	res := &fakeResolver{hosts: make(map[string][]string)}
+	r := DNSRange{Interval: caddy.Duration(time.Hour), MaxAddresses: 30}
+	for i := 0; i < 20; i++ {
+		host := fmt.Sprintf("host-%d.example.com", i)
+		res.hosts[host] = []string{fmt.Sprintf("10.0.0.%d", i)}
+		r.Hosts = append(r.Hosts, host)
+	}
+	cancel, err := provision(t, &r, res)
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each update alone is within the limit, but not all of them together.
+	var wg sync.WaitGroup
+	for i, host := range r.Hosts {
+		wg.Add(1)
+		go func(i int, host string) {
+			defer wg.Done()
+			r.update(host, []netip.Prefix{
+				netip.MustParsePrefix(fmt.Sprintf("10.0.0.%d/32", i)),
+				netip.MustParsePrefix(fmt.Sprintf("10.0.1.%d/32", i)),
+			})
+		}(i, host)
+	}
+	wg.Wait()
+	if n := r.countAddresses(); n > r.MaxAddresses {
+		t.Errorf("hosts resolve to %d addresses, more than max_addresses", n)
+	}
+}
@@ -0,0 +1,288 @@
+package dns
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// scheduler refreshes the hosts of a range when they're due. A single
+// goroutine keeps track of all hosts, and hands due ones to a small pool
+// of workers, instead of running a goroutine and timer per host. The size
+// of the pool limits the number of concurrent lookups.
+type scheduler struct {
+	d *DNSRange
+
+	mu    sync.Mutex
+	jobs  map[string]*job
+	queue jobQueue
+
+	// Wakes up the scheduler when the queue changes.
+	wake chan struct{}
+	// Passes due jobs to the workers.
+	work chan dispatch
+}
+
+// job is the refresh schedule of a host.
+type job struct {
+	host string
+	due  time.Time
+
+	// The index of the job in the queue, or -1 if it's not queued because
+	// it's running or paused.
+	index int
+
+	// Whether the next refresh was requested explicitly.
+	forced bool
+	// Whether a refresh was requested while the job was running.
+	again bool
+
+	// The SOA serial of the zone at the time of the last successful lookup,
+	// if known. Only accessed by the worker running the job.
+	serial     uint32
+	haveSerial bool
+}
+
+// dispatch is a job handed to a worker.
+type dispatch struct {
+	job    *job
+	forced bool
+}
+
+// newScheduler creates a scheduler for d and starts it. It stops when d is
+// cleaned up.
+func newScheduler(d *DNSRange) *scheduler {
+	s := &scheduler{
+		d:    d,
+		jobs: make(map[string]*job),
+		wake: make(chan struct{}, 1),
+		work: make(chan dispatch),
+	}
+	go s.run()
+	workers := d.MaxConcurrentLookups
+	if len(d.Hosts) < workers {
+		workers = len(d.Hosts)
+	}
+	for i := 0; i < workers; i++ {
+		go s.worker()
+	}
+	return s
+}
+
+// add schedules the first refresh of host within freq. The first refreshes
+// of the hosts are spread out over the interval, so that hosts that were
+// all looked up together aren't refreshed together forever after.
+func (s *scheduler) add(host string, freq time.Duration) {
+	s.d.logger.Info("starting DNS watcher", zap.String("host", host))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Dividing first keeps long intervals with many hosts from overflowing.
+	first := freq - freq/time.Duration(len(s.d.Hosts))*time.Duration(len(s.jobs))
+	j := &job{host: host, due: time.Now().Add(s.d.jittered(first)), index: -1}
+	s.jobs[host] = j
+	heap.Push(&s.queue, j)
+	s.notify()
+}
+
+// refreshNow makes host refresh immediately. It returns false if host isn't
+// being refreshed.
+func (s *scheduler) refreshNow(host string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j := s.jobs[host]
+	if j == nil {
+		return false
+	}
+	j.forced = true
+	j.due = time.Now()
+	switch {
+	case j.index >= 0:
+		heap.Fix(&s.queue, j.index)
+	case j.running():
+		// Run again once it's done.
+		j.again = true
+	default:
+		// Paused.
+		heap.Push(&s.queue, j)
+	}
+	s.notify()
+	return true
+}
+
+// follow makes host refresh now if it's waiting for its next refresh, to pick
+// up an answer another range just got. The next refresh is then scheduled
+// from there.
+func (s *scheduler) follow(host string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j := s.jobs[host]
+	if j == nil || j.index < 0 {
+		// Not refreshed, running or paused.
+		return
+	}
+	j.due = time.Now()
+	heap.Fix(&s.queue, j.index)
+	s.notify()
+}
+
+// Schedule states of hosts, as reported by status.
+const (
+	scheduleQueued  = "scheduled"
+	scheduleRunning = "running"
+	schedulePaused  = "paused"
+	scheduleStopped = "stopped"
+)
+
+// status returns the schedule state of host, and when it's due to be
+// refreshed next if it's queued.
+func (s *scheduler) status(host string) (string, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j := s.jobs[host]
+	switch {
+	case j == nil:
+		return scheduleStopped, time.Time{}
+	case j.index >= 0:
+		return scheduleQueued, j.due
+	case j.running():
+		return scheduleRunning, time.Time{}
+	}
+	return schedulePaused, time.Time{}
+}
+
+// running reports whether the job is being run by a worker.
+// The caller must hold the scheduler's mutex.
+func (j *job) running() bool {
+	return j.index == runningIndex
+}
+
+// The index of running jobs, as opposed to -1 for paused ones.
+const runningIndex = -2
+
+// notify wakes up the scheduler. The caller must hold s.mu.
+func (s *scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+		// Already awake.
+	}
+}
+
+// run hands jobs to the workers when they're due, until d is cleaned up.
+func (s *scheduler) run() {
+	done := s.d.ctx.Done()
+	for {
+		var (
+			next  *dispatch
+			timer *time.Timer
+			wait  <-chan time.Time
+		)
+
+		s.mu.Lock()
+		if len(s.queue) > 0 {
+			j := s.queue[0]
+			if delay := time.Until(j.due); delay > 0 {
+				timer = time.NewTimer(delay)
+				wait = timer.C
+			} else {
+				heap.Pop(&s.queue)
+				if !j.forced && s.d.pause(j.host) {
+					// Wait until the range is used again.
+				} else {
+					j.index = runningIndex
+					next = &dispatch{job: j, forced: j.forced}
+					j.forced = false
+				}
+			}
+		}
+		s.mu.Unlock()
+
+		if next != nil {
+			select {
+			case s.work <- *next:
+			case <-done:
+				s.d.logger.Info("stopping DNS watchers")
+				return
+			}
+			continue
+		}
+
+		select {
+		case <-done:
+			if timer != nil {
+				timer.Stop()
+			}
+			s.d.logger.Info("stopping DNS watchers")
+			return
+		case <-s.wake:
+		case <-wait:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// worker refreshes the hosts it's handed, until d is cleaned up.
+func (s *scheduler) worker() {
+	done := s.d.ctx.Done()
+	for {
+		select {
+		case <-done:
+			return
+		case w := <-s.work:
+			s.finish(w.job, s.d.refreshHost(w.job, w.forced))
+		}
+	}
+}
+
+// finish schedules the next refresh of a job after freq. A negative freq
+// stops refreshing, unless another refresh was requested in the meantime.
+func (s *scheduler) finish(j *job, freq time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j.index = -1
+	switch {
+	case j.again:
+		j.again = false
+		j.due = time.Now()
+	case freq < 0:
+		delete(s.jobs, j.host)
+		s.d.logger.Info("host resolved once, stopping DNS watcher", zap.String("host", j.host))
+		return
+	default:
+		j.due = time.Now().Add(s.d.jittered(freq))
+	}
+	heap.Push(&s.queue, j)
+	s.notify()
+}
+
+// jobQueue is a heap of jobs, ordered by when they're due.
+type jobQueue []*job
+
+func (q jobQueue) Len() int           { return len(q) }
+func (q jobQueue) Less(i, j int) bool { return q[i].due.Before(q[j].due) }
+
+func (q jobQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *jobQueue) Push(x any) {
+	j := x.(*job)
+	j.index = len(*q)
+	*q = append(*q, j)
+}
+
+func (q *jobQueue) Pop() any {
+	old := *q
+	j := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	j.index = -1
+	return j
+}
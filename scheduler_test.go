@@ -0,0 +1,117 @@
+package dns
+
+import (
+	"container/heap"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+func TestSchedulerGoroutines(t *testing.T) {
+	const n = 500
+	res := &fakeResolver{hosts: make(map[string][]string, n)}
+	r := DNSRange{Interval: caddy.Duration(time.Hour)}
+	for i := 0; i < n; i++ {
+		host := fmt.Sprintf("host-%d.example.com", i)
+		res.hosts[host] = []string{fmt.Sprintf("10.0.%d.%d", i>>8, i&0xff)}
+		r.Hosts = append(r.Hosts, host)
+	}
+
+	before := runtime.NumGoroutine()
+	cancel, err := provision(t, &r, res)
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if started := runtime.NumGoroutine() - before; started > 1+DefaultMaxConcurrentLookups {
+		t.Errorf("started %d goroutines for %d hosts", started, n)
+	}
+
+	// Refreshing all hosts looks each of them up once more.
+	if got := r.triggerRefresh(""); len(got) != n {
+		t.Errorf("refreshed %d hosts, want %d", len(got), n)
+	}
+	waitFor(t, "refreshes", func() bool { return res.count() == 2*n })
+}
+
+func TestSchedulerSpread(t *testing.T) {
+	const n = 10
+	res := &fakeResolver{hosts: make(map[string][]string, n)}
+	r := DNSRange{Interval: caddy.Duration(time.Hour), MaxConcurrentLookups: 2}
+	for i := 0; i < n; i++ {
+		host := fmt.Sprintf("host-%d.example.com", i)
+		res.hosts[host] = []string{fmt.Sprintf("10.0.0.%d", i)}
+		r.Hosts = append(r.Hosts, host)
+	}
+
+	before := runtime.NumGoroutine()
+	cancel, err := provision(t, &r, res)
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if started := runtime.NumGoroutine() - before; started > 1+2 {
+		t.Errorf("started %d goroutines with max_concurrent_lookups 2", started)
+	}
+
+	// The first refreshes are spread out over the interval.
+	r.sched.mu.Lock()
+	first, last := r.sched.queue[0].due, r.sched.queue[0].due
+	for _, j := range r.sched.queue {
+		if j.due.Before(first) {
+			first = j.due
+		}
+		if j.due.After(last) {
+			last = j.due
+		}
+	}
+	r.sched.mu.Unlock()
+	if spread := last.Sub(first); spread < 45*time.Minute {
+		t.Errorf("first refreshes spread over %v, want most of the interval", spread)
+	}
+}
+
+func TestSchedulerSpreadLongInterval(t *testing.T) {
+	const n = 5000
+	d := &DNSRange{Hosts: make([]string, n), logger: zap.NewNop()}
+	s := &scheduler{d: d, jobs: make(map[string]*job), wake: make(chan struct{}, 1)}
+	for i := 0; i < n; i++ {
+		s.jobs[fmt.Sprint(i)] = &job{}
+	}
+
+	// The interval times the number of hosts doesn't fit in a time.Duration.
+	freq := 30 * 24 * time.Hour
+	start := time.Now()
+	s.add("last.example.com", freq)
+	if due := s.jobs["last.example.com"].due; due.Before(start) || due.After(start.Add(freq)) {
+		t.Errorf("first refresh due in %v, want within %v", due.Sub(start), freq)
+	}
+}
+
+func TestJobQueue(t *testing.T) {
+	now := time.Now()
+	var q jobQueue
+	for _, offset := range []int{3, 1, 2} {
+		heap.Push(&q, &job{host: fmt.Sprint(offset), due: now.Add(time.Duration(offset) * time.Second)})
+	}
+
+	// Move the last job to the front.
+	j := q[0]
+	for _, j2 := range q {
+		if j2.host == "3" {
+			j = j2
+		}
+	}
+	j.due = now
+	heap.Fix(&q, j.index)
+
+	for _, want := range []string{"3", "1", "2"} {
+		if got := heap.Pop(&q).(*job); got.host != want {
+			t.Errorf("got job %s, want %s", got.host, want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// How long to wait for an SOA response.
+const soaTimeout = 5 * time.Second
+
+// soaChecker looks up the SOA serial of a zone, caching it briefly so that
+// the watchers of many hosts in the same zone can share one query.
+type soaChecker struct {
+	zone   string
+	server string
+	maxAge time.Duration
+	client dns.Client
+
+	mu      sync.Mutex
+	serial  uint32
+	checked time.Time
+}
+
+// newSOAChecker creates a checker for zone. If server is empty, the first
+// name server from /etc/resolv.conf is used.
+func newSOAChecker(zone, server string, maxAge time.Duration) (*soaChecker, error) {
+	if server == "" {
+		conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+		if err != nil {
+			return nil, fmt.Errorf("finding name server for SOA queries: %w", err)
+		}
+		if len(conf.Servers) == 0 {
+			return nil, errors.New("no name server found for SOA queries")
+		}
+		server = net.JoinHostPort(conf.Servers[0], conf.Port)
+	} else if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+
+	return &soaChecker{
+		zone:   dns.Fqdn(zone),
+		server: server,
+		maxAge: maxAge,
+		client: dns.Client{Timeout: soaTimeout},
+	}, nil
+}
+
+// current returns the current serial of the zone.
+func (c *soaChecker) current(ctx context.Context) (uint32, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.checked.IsZero() && time.Since(c.checked) < c.maxAge {
+		return c.serial, nil
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(c.zone, dns.TypeSOA)
+	resp, _, err := c.client.ExchangeContext(ctx, msg, c.server)
+	if err != nil {
+		return 0, err
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return 0, fmt.Errorf("SOA query for %s failed: %s", c.zone, dns.RcodeToString[resp.Rcode])
+	}
+	for _, rr := range resp.Answer {
+		if soa, ok := rr.(*dns.SOA); ok {
+			c.serial, c.checked = soa.Serial, time.Now()
+			return c.serial, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no SOA record found for %s", c.zone)
+}
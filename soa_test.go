@@ -0,0 +1,89 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/miekg/dns"
+)
+
+// startSOAServer starts a local DNS server answering SOA queries with the
+// current value of serial.
+func startSOAServer(t *testing.T, serial *atomic.Uint32) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &dns.Server{
+		PacketConn: pc,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			resp := new(dns.Msg)
+			resp.SetReply(req)
+			resp.Answer = append(resp.Answer, &dns.SOA{
+				Hdr:    dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 60},
+				Ns:     "ns.example.com.",
+				Mbox:   "hostmaster.example.com.",
+				Serial: serial.Load(),
+			})
+			w.WriteMsg(resp)
+		}),
+	}
+	go srv.ActivateAndServe()
+	t.Cleanup(func() { srv.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+func TestSOAChecker(t *testing.T) {
+	var serial atomic.Uint32
+	serial.Store(42)
+	c, err := newSOAChecker("example.com", startSOAServer(t, &serial), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := c.current(context.Background()); err != nil || got != 42 {
+		t.Fatalf("current() = %d, %v; want 42", got, err)
+	}
+
+	// Cached.
+	serial.Store(43)
+	if got, err := c.current(context.Background()); err != nil || got != 42 {
+		t.Errorf("current() = %d, %v; want cached 42", got, err)
+	}
+}
+
+func TestSOASkipsLookups(t *testing.T) {
+	var serial atomic.Uint32
+	serial.Store(1)
+	server := startSOAServer(t, &serial)
+
+	res := &fakeResolver{hosts: map[string][]string{"proxy.example.com": {"192.0.2.1"}}}
+	r := DNSRange{
+		Hosts:     []string{"proxy.example.com"},
+		Interval:  caddy.Duration(2 * time.Millisecond),
+		SOAZone:   "example.com",
+		SOAServer: server,
+	}
+	cancel, err := provision(t, &r, res)
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Initial lookup plus the first periodic one, which records the serial.
+	waitFor(t, "first refresh", func() bool { return res.count() >= 2 })
+	time.Sleep(20 * time.Millisecond)
+	before := res.count()
+	time.Sleep(20 * time.Millisecond)
+	if n := res.count(); n != before {
+		t.Errorf("%d lookups with unchanged serial", n-before)
+	}
+
+	serial.Store(2)
+	waitFor(t, "lookup after serial change", func() bool { return res.count() > before })
+}
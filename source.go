@@ -0,0 +1,405 @@
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/fvbommel/caddy-dns-ip-range/iprange"
+	"go.uber.org/zap"
+)
+
+// The default timeout of a fetch by a source.
+const DefaultSourceTimeout = caddy.Duration(30 * time.Second)
+
+// SourceOptions are the options shared by the sources other than DNSRange,
+// which fetch their prefixes when provisioning and then periodically.
+type SourceOptions struct {
+	// How often to fetch the prefixes again. Each source has its own
+	// default. IntervalOnce (-1) fetches them only once; sources that watch
+	// for changes still apply those.
+	Interval caddy.Duration `json:"interval,omitempty"`
+
+	// How long a fetch may take. Defaults to DefaultSourceTimeout.
+	Timeout caddy.Duration `json:"timeout,omitempty"`
+}
+
+// validate checks the options and sets defaults, with defaultInterval as the
+// default interval.
+func (o *SourceOptions) validate(defaultInterval caddy.Duration) error {
+	if o.Interval < 0 && o.Interval != IntervalOnce {
+		return errors.New("interval cannot be negative")
+	}
+	if o.Timeout < 0 {
+		return errors.New("timeout cannot be negative")
+	}
+	if o.Interval == 0 {
+		o.Interval = defaultInterval
+	}
+	if o.Timeout == 0 {
+		o.Timeout = DefaultSourceTimeout
+	}
+	return nil
+}
+
+// unmarshalSourceOption handles the subdirectives of the shared source
+// options. It returns false if the current token is not one of them.
+func (o *SourceOptions) unmarshalSourceOption(d *caddyfile.Dispenser) (bool, error) {
+	name := d.Val()
+	switch name {
+	case "interval", "timeout":
+	default:
+		return false, nil
+	}
+
+	if !d.NextArg() {
+		return true, d.Err("expected duration")
+	}
+	switch name {
+	case "interval":
+		interval, err := parseInterval(d.Val())
+		if err != nil {
+			return true, d.WrapErr(err)
+		}
+		o.Interval = interval
+	case "timeout":
+		timeout, err := caddy.ParseDuration(d.Val())
+		if err != nil {
+			return true, d.WrapErr(err)
+		}
+		o.Timeout = caddy.Duration(timeout)
+	}
+	if d.NextArg() {
+		return true, d.ArgErr()
+	}
+
+	return true, nil
+}
+
+// errUnchanged is returned by fetch functions to keep the current prefixes,
+// without counting as a failure.
+var errUnchanged = errors.New("prefixes unchanged")
+
+// refresher keeps the prefixes of a source up to date. It fetches them when
+// provisioning, and again every interval in the background, or when asked
+// to. When a fetch fails, the previous prefixes are kept and the fetch is
+// retried sooner.
+type refresher struct {
+	opts   SourceOptions
+	fetch  func(context.Context) ([]netip.Prefix, error)
+	logger *zap.Logger
+
+	// The current prefixes, sorted and without duplicates.
+	prefixes atomic.Pointer[[]netip.Prefix]
+
+	// The most recent result of sources with the same configuration, which
+	// survives config reloads, and its key. Nil if not shared.
+	shared    *sharedResult
+	sharedKey string
+
+	// Canceled when the source is cleaned up.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	trigger chan struct{}
+	done    chan struct{}
+
+	// Whether the last fetch failed.
+	failing atomic.Bool
+
+	// The watchers started with watch.
+	watchers sync.WaitGroup
+}
+
+// start fetches the prefixes, and keeps refreshing them until stop is called.
+// If the first fetch fails, so does start. If key is set, sources with the
+// same key share their most recent result, so that a source doesn't fetch
+// its prefixes again when the config is reloaded.
+func (r *refresher) start(ctx caddy.Context, opts SourceOptions, key string, fetch func(context.Context) ([]netip.Prefix, error)) error {
+	r.opts, r.fetch, r.logger = opts, fetch, ctx.Logger()
+	r.ctx, r.cancel = context.WithCancel(ctx)
+	r.trigger = make(chan struct{}, 1)
+	r.done = make(chan struct{})
+
+	first := time.Duration(opts.Interval)
+	if key != "" {
+		val, _, err := sharedResults.LoadOrNew(key, func() (caddy.Destructor, error) {
+			return new(sharedResult), nil
+		})
+		if err != nil {
+			return err
+		}
+		r.shared, r.sharedKey = val.(*sharedResult), key
+		if prefixes, age, ok := r.shared.get(); ok && (opts.Interval < 0 || age < first) {
+			r.logger.Debug("reusing prefixes fetched by the previous config", zap.Duration("age", age))
+			r.prefixes.Store(&prefixes)
+			go r.run(first - age)
+			return nil
+		}
+	}
+
+	if err := r.refresh(); err != nil {
+		r.cancel()
+		close(r.done)
+		return err
+	}
+	go r.run(first)
+	return nil
+}
+
+// run refreshes the prefixes until the context is canceled, the first time
+// after the given delay.
+func (r *refresher) run(first time.Duration) {
+	defer close(r.done)
+	var err error
+	next := first
+	for {
+		var wait <-chan time.Time
+		switch {
+		case err != nil:
+			wait = time.After(ttlAfterErr)
+		case r.opts.Interval > 0:
+			wait = time.After(next)
+		}
+
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-r.trigger:
+		case <-wait:
+		}
+		err = r.refresh()
+		next = time.Duration(r.opts.Interval)
+	}
+}
+
+// refresh fetches the prefixes once.
+func (r *refresher) refresh() error {
+	ctx, cancel := context.WithTimeout(r.ctx, time.Duration(r.opts.Timeout))
+	defer cancel()
+
+	prefixes, err := r.fetch(ctx)
+	r.failing.Store(err != nil && !errors.Is(err, errUnchanged))
+	if errors.Is(err, errUnchanged) {
+		return nil
+	}
+	if err != nil {
+		if r.ctx.Err() == nil {
+			r.logger.Warn("fetching prefixes failed, keeping previous ones", zap.Error(err))
+		}
+		return err
+	}
+	r.set(prefixes)
+	if r.shared != nil {
+		r.shared.set(r.current())
+	}
+	return nil
+}
+
+// refreshNow makes the refresher fetch the prefixes as soon as possible.
+func (r *refresher) refreshNow() {
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// set replaces the current prefixes, which must not be modified afterwards.
+func (r *refresher) set(prefixes []netip.Prefix) {
+	prefixes = uniquePrefixes(prefixes)
+	if old := r.prefixes.Swap(&prefixes); old != nil {
+		if added, removed := diffPrefixes(*old, prefixes); len(added) > 0 || len(removed) > 0 {
+			r.logger.Info("prefixes changed",
+				zap.Int("added", len(added)),
+				zap.Int("removed", len(removed)),
+				zap.Int("total", len(prefixes)))
+		}
+	} else {
+		r.logger.Debug("fetched prefixes", zap.Int("total", len(prefixes)))
+	}
+}
+
+// current returns the current prefixes. The result must not be modified.
+func (r *refresher) current() []netip.Prefix {
+	if p := r.prefixes.Load(); p != nil {
+		return *p
+	}
+	return nil
+}
+
+// healthy reports whether the last fetch succeeded.
+func (r *refresher) healthy() bool {
+	return !r.failing.Load()
+}
+
+// watch runs fn in the background until stop is called, to watch for changes
+// and call refreshNow when they happen. When fn fails, the prefixes are
+// refreshed, since changes may have been missed, and fn is started again
+// after a while.
+func (r *refresher) watch(fn func(ctx context.Context) error) {
+	r.watchers.Add(1)
+	go func() {
+		defer r.watchers.Done()
+		for {
+			err := fn(r.ctx)
+			if r.ctx.Err() != nil {
+				return
+			}
+			r.logger.Warn("watching for changes stopped, retrying later", zap.Error(err))
+			select {
+			case <-r.ctx.Done():
+				return
+			case <-time.After(ttlAfterErr):
+			}
+			r.refreshNow()
+		}
+	}()
+}
+
+// stop stops refreshing and watching, and waits for a refresh in progress to
+// finish.
+func (r *refresher) stop() {
+	if r.cancel != nil {
+		r.cancel()
+		<-r.done
+		r.watchers.Wait()
+	}
+	if r.shared != nil {
+		_, _ = sharedResults.Delete(r.sharedKey)
+		r.shared = nil
+	}
+}
+
+// sharedResults holds the most recent result of each source configuration.
+// Entries are reference counted, and survive config reloads as long as the
+// new config has a source with the same configuration.
+var sharedResults = caddy.NewUsagePool()
+
+// sharedResult is the most recent result of a source configuration.
+type sharedResult struct {
+	mu       sync.Mutex
+	prefixes []netip.Prefix
+	at       time.Time
+}
+
+// Destruct implements caddy.Destructor.
+func (*sharedResult) Destruct() error { return nil }
+
+// get returns the result and its age, if there is one.
+func (s *sharedResult) get() ([]netip.Prefix, time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.at.IsZero() {
+		return nil, 0, false
+	}
+	return s.prefixes, time.Since(s.at), true
+}
+
+// set records a result, which must not be modified afterwards.
+func (s *sharedResult) set(prefixes []netip.Prefix) {
+	s.mu.Lock()
+	s.prefixes, s.at = prefixes, time.Now()
+	s.mu.Unlock()
+}
+
+// sourceKey returns the key under which sources share their results: the
+// module ID and the JSON of the configuration.
+func sourceKey(m caddy.Module) string {
+	return string(m.CaddyModule().ID) + " " + string(caddyconfig.JSON(m, nil))
+}
+
+// uniquePrefixes normalizes, sorts and deduplicates prefixes in place.
+func uniquePrefixes(prefixes []netip.Prefix) []netip.Prefix {
+	for i, p := range prefixes {
+		prefixes[i] = iprange.Normalize(p)
+	}
+	sortPrefixes(prefixes)
+	out := prefixes[:0]
+	for _, p := range prefixes {
+		if len(out) == 0 || p != out[len(out)-1] {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// parsePrefix parses an IP address or CIDR.
+func parsePrefix(s string) (netip.Prefix, error) {
+	prefixes, err := parsePrefixes([]string{s})
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid IP address or CIDR %q", s)
+	}
+	return prefixes[0], nil
+}
+
+// healthChecker is implemented by sources that know whether their ranges
+// are up to date.
+type healthChecker interface {
+	healthy() bool
+}
+
+// sourceHealthy reports whether a source is healthy. Sources that can't
+// tell, such as static ones, are.
+func sourceHealthy(source caddyhttp.IPRangeSource) bool {
+	h, ok := source.(healthChecker)
+	return !ok || h.healthy()
+}
+
+// allHealthy reports whether all sources are healthy.
+func allHealthy(sources []caddyhttp.IPRangeSource) bool {
+	for _, source := range sources {
+		if !sourceHealthy(source) {
+			return false
+		}
+	}
+	return true
+}
+
+// unmarshalIPSource parses an IP range source module after a "source"
+// token, such as in "source github hooks", and returns its JSON.
+func unmarshalIPSource(d *caddyfile.Dispenser) (json.RawMessage, error) {
+	if !d.NextArg() {
+		return nil, d.Err("expected IP range source module name")
+	}
+	name := d.Val()
+	unm, err := caddyfile.UnmarshalModule(d, "http.ip_sources."+name)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := unm.(caddyhttp.IPRangeSource); !ok {
+		return nil, d.Errf("module %q is not an IP range source", name)
+	}
+	return caddyconfig.JSONModuleObject(unm, "source", name, nil), nil
+}
+
+// loadIPSources loads the IP range source modules in the named field of m,
+// which holds one module or a list of them. They are cleaned up along with
+// ctx.
+func loadIPSources(ctx caddy.Context, m any, field string) ([]caddyhttp.IPRangeSource, error) {
+	mods, err := ctx.LoadModule(m, field)
+	if err != nil {
+		return nil, fmt.Errorf("loading IP range sources: %w", err)
+	}
+	list, ok := mods.([]any)
+	if !ok {
+		list = []any{mods}
+	}
+	var sources []caddyhttp.IPRangeSource
+	for _, mod := range list {
+		source, ok := mod.(caddyhttp.IPRangeSource)
+		if !ok {
+			return nil, fmt.Errorf("module %T is not an IP range source", mod)
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
@@ -0,0 +1,149 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(new(SpamhausSource))
+}
+
+// The default refresh interval of the Spamhaus source. Spamhaus asks not to
+// download the lists more than once an hour; they change about daily.
+const DefaultSpamhausInterval = caddy.Duration(24 * time.Hour)
+
+// Where the lists are downloaded from, overridden in tests.
+var spamhausURL = "https://www.spamhaus.org/drop/"
+
+// The files of the lists, by name.
+var spamhausLists = map[string]string{
+	"drop":   "drop.txt",
+	"edrop":  "edrop.txt",
+	"dropv6": "dropv6.txt",
+}
+
+// SpamhausSource provides the ranges in the Spamhaus Don't Route Or Peer
+// lists: netblocks hijacked or leased by spammers and cybercriminals.
+type SpamhausSource struct {
+	// The lists to include: "drop", "edrop" or "dropv6". Defaults to "drop"
+	// and "dropv6"; EDROP has been merged into DROP.
+	Lists []string `json:"lists,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+}
+
+// CaddyModule returns the Caddy module information.
+func (*SpamhausSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.spamhaus",
+		New: func() caddy.Module { return new(SpamhausSource) },
+	}
+}
+
+// Provision fetches the ranges, and starts refreshing them.
+func (s *SpamhausSource) Provision(ctx caddy.Context) error {
+	if len(s.Lists) == 0 {
+		s.Lists = []string{"drop", "dropv6"}
+	}
+	for _, list := range s.Lists {
+		if _, ok := spamhausLists[list]; !ok {
+			return fmt.Errorf("unknown Spamhaus list %q", list)
+		}
+	}
+	if err := s.SourceOptions.validate(DefaultSpamhausInterval); err != nil {
+		return err
+	}
+	return s.refresher.start(ctx, s.SourceOptions, sourceKey(s), s.fetch)
+}
+
+// fetch fetches the ranges in the lists. Each line of a list has a range and
+// its SBL record, as a comment after ";".
+func (s *SpamhausSource) fetch(ctx context.Context) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, list := range s.Lists {
+		data, err := fetchDocument(ctx, spamhausURL+spamhausLists[list], nil)
+		if err != nil {
+			return nil, err
+		}
+		p, err := parseList(FormatText, data)
+		if err != nil {
+			return nil, fmt.Errorf("Spamhaus %s: %w", list, err)
+		}
+		prefixes = append(prefixes, p...)
+	}
+	if len(prefixes) == 0 {
+		return nil, errors.New("Spamhaus lists are empty")
+	}
+	return prefixes, nil
+}
+
+// Cleanup stops refreshing the ranges.
+func (s *SpamhausSource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the ranges in the lists.
+func (s *SpamhausSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *SpamhausSource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	spamhaus [<list...>] {
+//	    list <list...>
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *SpamhausSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	s.Lists = append(s.Lists, d.RemainingArgs()...)
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "list":
+			lists := d.RemainingArgs()
+			if len(lists) == 0 {
+				return d.ArgErr()
+			}
+			s.Lists = append(s.Lists, lists...)
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown spamhaus option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*SpamhausSource)(nil)
+	_ caddy.Provisioner       = (*SpamhausSource)(nil)
+	_ caddy.CleanerUpper      = (*SpamhausSource)(nil)
+	_ caddyfile.Unmarshaler   = (*SpamhausSource)(nil)
+	_ caddyhttp.IPRangeSource = (*SpamhausSource)(nil)
+)
@@ -0,0 +1,70 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestSpamhausSource(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/drop.txt", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `; Spamhaus DROP List 2024/05/01 - (c) 2024 The Spamhaus Project SLU
+; https://www.spamhaus.org/drop/drop.txt
+; Last-Modified: Wed, 01 May 2024 10:00:00 GMT
+; Expires: Wed, 01 May 2024 11:00:00 GMT
+1.10.16.0/20 ; SBL256894
+2.56.192.0/22 ; SBL459831
+`)
+	})
+	mux.HandleFunc("/edrop.txt", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "; EDROP has been merged into DROP\n")
+	})
+	mux.HandleFunc("/dropv6.txt", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "; Spamhaus DROPv6 List\n2001:67c:2f0::/48 ; SBL546278\n")
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	defer func(u string) { spamhausURL = u }(spamhausURL)
+	spamhausURL = srv.URL + "/"
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	s := SpamhausSource{}
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[1.10.16.0/20 2.56.192.0/22 2001:67c:2f0::/48]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	s.Cleanup()
+
+	s = SpamhausSource{Lists: []string{"edrop"}}
+	if err := s.Provision(ctx); err == nil {
+		t.Error("no error for empty lists")
+	}
+	s.Cleanup()
+
+	s = SpamhausSource{Lists: []string{"sbl"}}
+	if err := s.Provision(ctx); err == nil {
+		t.Error("no error for an unknown list")
+	}
+}
+
+func TestUnmarshalSpamhausSource(t *testing.T) {
+	var s SpamhausSource
+	input := `spamhaus drop {
+		list edrop dropv6
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.Lists), "[drop edrop dropv6]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
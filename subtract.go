@@ -0,0 +1,127 @@
+package dns
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/netip"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/fvbommel/caddy-dns-ip-range/iprange"
+)
+
+func init() {
+	caddy.RegisterModule(new(SubtractSource))
+}
+
+// SubtractSource provides the ranges of a source, without the addresses of
+// other sources. Prefixes that partly overlap the excluded addresses are
+// split, so that only the excluded addresses are removed.
+type SubtractSource struct {
+	// The source of the ranges.
+	BaseRaw json.RawMessage `json:"base,omitempty" caddy:"namespace=http.ip_sources inline_key=source"`
+
+	// The sources of the addresses to remove.
+	ExcludeRaw []json.RawMessage `json:"exclude,omitempty" caddy:"namespace=http.ip_sources inline_key=source"`
+
+	// The base source, then the excluded ones.
+	sources []caddyhttp.IPRangeSource
+	result  derivedRanges
+}
+
+// CaddyModule returns the Caddy module information.
+func (*SubtractSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.subtract",
+		New: func() caddy.Module { return new(SubtractSource) },
+	}
+}
+
+// Provision loads the sources, which are cleaned up along with the config.
+func (s *SubtractSource) Provision(ctx caddy.Context) error {
+	if s.BaseRaw == nil {
+		return errors.New("no base source provided")
+	}
+	if len(s.ExcludeRaw) == 0 {
+		return errors.New("no sources to exclude provided")
+	}
+	base, err := loadIPSources(ctx, s, "BaseRaw")
+	if err != nil {
+		return err
+	}
+	exclude, err := loadIPSources(ctx, s, "ExcludeRaw")
+	if err != nil {
+		return err
+	}
+	s.sources = append(base, exclude...)
+	return nil
+}
+
+// GetIPRanges returns the ranges of the base source, without the excluded
+// addresses.
+func (s *SubtractSource) GetIPRanges(r *http.Request) []netip.Prefix {
+	return s.result.get(s.sources, r, func(from [][]netip.Prefix) []netip.Prefix {
+		var exclude []netip.Prefix
+		for _, p := range from[1:] {
+			exclude = append(exclude, p...)
+		}
+		return iprange.Subtract(from[0], exclude)
+	})
+}
+
+// healthy reports whether the base and excluded sources are healthy.
+func (s *SubtractSource) healthy() bool {
+	return allHealthy(s.sources)
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	subtract {
+//	    base <module> ...
+//	    exclude <module> ...
+//	}
+//
+// where each source is an IP range source module, with the same syntax as
+// in trusted_proxies. Exclude may be given more than once.
+func (s *SubtractSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "base":
+			if s.BaseRaw != nil {
+				return d.Err("base source already specified")
+			}
+			raw, err := unmarshalIPSource(d)
+			if err != nil {
+				return err
+			}
+			s.BaseRaw = raw
+		case "exclude":
+			raw, err := unmarshalIPSource(d)
+			if err != nil {
+				return err
+			}
+			s.ExcludeRaw = append(s.ExcludeRaw, raw)
+		default:
+			return d.Errf("unknown subtract option %q", d.Val())
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*SubtractSource)(nil)
+	_ caddy.Provisioner       = (*SubtractSource)(nil)
+	_ caddyfile.Unmarshaler   = (*SubtractSource)(nil)
+	_ caddyhttp.IPRangeSource = (*SubtractSource)(nil)
+)
@@ -0,0 +1,59 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestSubtractSource(t *testing.T) {
+	var s SubtractSource
+	input := `subtract {
+		base static 192.0.2.0/24 2001:db8::/32
+		exclude static 192.0.2.64/26
+		exclude static 2001:db8:8000::/33 198.51.100.0/24
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprintf("%s %s", s.BaseRaw, s.ExcludeRaw), `{"ranges":["192.0.2.0/24","2001:db8::/32"],"source":"static"} [{"ranges":["192.0.2.64/26"],"source":"static"} {"ranges":["2001:db8:8000::/33","198.51.100.0/24"],"source":"static"}]`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[192.0.2.0/26 192.0.2.128/25 2001:db8::/33]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { s.GetIPRanges(nil) }); allocs != 0 {
+		t.Errorf("GetIPRanges allocates %v times per call", allocs)
+	}
+
+	for _, input := range []string{
+		`subtract {
+			exclude static 192.0.2.64/26
+		}`,
+		`subtract {
+			base static 192.0.2.0/24
+		}`,
+		`subtract {
+			base static 192.0.2.0/24
+			base static 198.51.100.0/24
+		}`,
+	} {
+		var s SubtractSource
+		err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input))
+		if err == nil {
+			err = s.Provision(ctx)
+		}
+		if err == nil {
+			t.Errorf("%s: no error", input)
+		}
+	}
+}
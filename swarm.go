@@ -0,0 +1,209 @@
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(new(SwarmSource))
+}
+
+// The default refresh interval of the Docker Swarm source. Tasks on other
+// nodes don't cause events on this one, so it's shorter than that of the
+// Docker source.
+const DefaultSwarmInterval = caddy.Duration(30 * time.Second)
+
+// How long after a service event to refresh again, once its tasks have had
+// time to start.
+const swarmTaskDelay = 5 * time.Second
+
+// SwarmSource provides the IP addresses of the running tasks of Docker Swarm
+// services. Resolving the name of a service only yields its virtual IP, not
+// the addresses that tasks connect from.
+type SwarmSource struct {
+	// The Docker API endpoint of a Swarm manager: "unix://<path>",
+	// "tcp://<host>:<port>" or an HTTP(S) URL. Defaults to $DOCKER_HOST, or
+	// else DefaultDockerHost.
+	Host string `json:"host,omitempty"`
+
+	// The names or IDs of the services whose tasks to include.
+	Services []string `json:"services,omitempty"`
+
+	// The networks whose addresses to include. Defaults to all networks.
+	Networks []string `json:"networks,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+	client    *dockerClient
+}
+
+// swarmTask is a task, as listed by the API.
+type swarmTask struct {
+	Status struct {
+		State string `json:"State"`
+	} `json:"Status"`
+	NetworksAttachments []struct {
+		Network struct {
+			Spec struct {
+				Name string `json:"Name"`
+			} `json:"Spec"`
+		} `json:"Network"`
+		Addresses []string `json:"Addresses"`
+	} `json:"NetworksAttachments"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (*SwarmSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.docker_swarm",
+		New: func() caddy.Module { return new(SwarmSource) },
+	}
+}
+
+// Provision fetches the addresses, and starts watching for changes.
+func (s *SwarmSource) Provision(ctx caddy.Context) error {
+	if len(s.Services) == 0 {
+		return errors.New("no services provided")
+	}
+	client, err := newDockerClient(s.Host)
+	if err != nil {
+		return err
+	}
+	s.client = client
+	if err := s.SourceOptions.validate(DefaultSwarmInterval); err != nil {
+		return err
+	}
+	// Tasks are rescheduled with new addresses all the time, so the result
+	// isn't shared across reloads.
+	if err := s.refresher.start(ctx, s.SourceOptions, "", s.fetch); err != nil {
+		return err
+	}
+	s.refresher.watch(func(ctx context.Context) error {
+		return s.client.events(ctx, []string{"service"}, func() {
+			// Scaling or updating a service starts its new tasks a little
+			// later.
+			s.refresher.refreshNow()
+			time.AfterFunc(swarmTaskDelay, s.refresher.refreshNow)
+		})
+	})
+	return nil
+}
+
+// fetch fetches the addresses of the running tasks of the services.
+func (s *SwarmSource) fetch(ctx context.Context) ([]netip.Prefix, error) {
+	filters, err := json.Marshal(map[string][]string{
+		"service":       s.Services,
+		"desired-state": {"running"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var tasks []swarmTask
+	if err := s.client.get(ctx, "/tasks?filters="+url.QueryEscape(string(filters)), &tasks); err != nil {
+		return nil, err
+	}
+
+	var prefixes []netip.Prefix
+	for _, task := range tasks {
+		if task.Status.State != "running" {
+			continue
+		}
+		for _, attachment := range task.NetworksAttachments {
+			if len(s.Networks) > 0 && !matchesAny(s.Networks, attachment.Network.Spec.Name) {
+				continue
+			}
+			for _, addr := range attachment.Addresses {
+				// Addresses come with the prefix length of the network.
+				p, err := netip.ParsePrefix(addr)
+				if err != nil {
+					return nil, err
+				}
+				prefixes = append(prefixes, netip.PrefixFrom(p.Addr(), p.Addr().BitLen()))
+			}
+		}
+	}
+	return prefixes, nil
+}
+
+// Cleanup stops refreshing the addresses.
+func (s *SwarmSource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the addresses of the tasks.
+func (s *SwarmSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *SwarmSource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	docker_swarm [<service...>] {
+//	    host <host>
+//	    service <service...>
+//	    network <network...>
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *SwarmSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	s.Services = append(s.Services, d.RemainingArgs()...)
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "host":
+			if !d.AllArgs(&s.Host) {
+				return d.ArgErr()
+			}
+		case "service":
+			services := d.RemainingArgs()
+			if len(services) == 0 {
+				return d.ArgErr()
+			}
+			s.Services = append(s.Services, services...)
+		case "network":
+			networks := d.RemainingArgs()
+			if len(networks) == 0 {
+				return d.ArgErr()
+			}
+			s.Networks = append(s.Networks, networks...)
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown docker_swarm option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*SwarmSource)(nil)
+	_ caddy.Provisioner       = (*SwarmSource)(nil)
+	_ caddy.CleanerUpper      = (*SwarmSource)(nil)
+	_ caddyfile.Unmarshaler   = (*SwarmSource)(nil)
+	_ caddyhttp.IPRangeSource = (*SwarmSource)(nil)
+)
@@ -0,0 +1,119 @@
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// fakeSwarm serves the parts of the Docker API used by the Swarm source.
+type fakeSwarm struct {
+	mu     sync.Mutex
+	tasks  map[string]string
+	events chan string
+}
+
+func (f *fakeSwarm) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/tasks":
+		var filters map[string][]string
+		if err := json.Unmarshal([]byte(r.URL.Query().Get("filters")), &filters); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		fmt.Fprint(w, "[")
+		for i, service := range filters["service"] {
+			if i > 0 {
+				fmt.Fprint(w, ",")
+			}
+			fmt.Fprint(w, f.tasks[service])
+		}
+		fmt.Fprint(w, "]")
+	case "/events":
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case event := <-f.events:
+				fmt.Fprintln(w, event)
+				w.(http.Flusher).Flush()
+			}
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeSwarm) setTasks(service, tasks string) {
+	f.mu.Lock()
+	f.tasks[service] = tasks
+	f.mu.Unlock()
+}
+
+func TestSwarmSource(t *testing.T) {
+	swarm := &fakeSwarm{
+		tasks: map[string]string{
+			"cloudflared": `
+				{"Status": {"State": "running"}, "NetworksAttachments": [
+					{"Network": {"Spec": {"Name": "ingress"}}, "Addresses": ["10.0.0.5/24"]},
+					{"Network": {"Spec": {"Name": "proxy"}}, "Addresses": ["10.0.1.5/24", "fd00:1::5/64"]}]},
+				{"Status": {"State": "starting"}, "NetworksAttachments": [
+					{"Network": {"Spec": {"Name": "proxy"}}, "Addresses": ["10.0.1.6/24"]}]}`,
+		},
+		events: make(chan string),
+	}
+	srv := httptest.NewServer(swarm)
+	defer srv.Close()
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	s := SwarmSource{
+		Host:     "tcp://" + srv.Listener.Addr().String(),
+		Services: []string{"cloudflared"},
+		Networks: []string{"proxy"},
+	}
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cleanup()
+
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[10.0.1.5/32 fd00:1::5/128]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// The service is scaled up.
+	swarm.setTasks("cloudflared", `
+		{"Status": {"State": "running"}, "NetworksAttachments": [
+			{"Network": {"Spec": {"Name": "proxy"}}, "Addresses": ["10.0.1.5/24"]}]},
+		{"Status": {"State": "running"}, "NetworksAttachments": [
+			{"Network": {"Spec": {"Name": "proxy"}}, "Addresses": ["10.0.1.6/24"]}]}`)
+	swarm.events <- `{"Type": "service", "Action": "update", "Actor": {"Attributes": {"name": "cloudflared"}}}`
+	waitFor(t, "scaled service", func() bool {
+		return fmt.Sprint(s.GetIPRanges(nil)) == "[10.0.1.5/32 10.0.1.6/32]"
+	})
+}
+
+func TestUnmarshalSwarmSource(t *testing.T) {
+	var s SwarmSource
+	input := `docker_swarm cloudflared {
+		host tcp://manager:2375
+		service traefik
+		network proxy
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.Host, " ", s.Services, s.Networks), "tcp://manager:2375 [cloudflared traefik] [proxy]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,341 @@
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(new(SwitchSource))
+}
+
+// The default interval at which the switch source evaluates its conditions.
+const DefaultSwitchInterval = caddy.Duration(time.Minute)
+
+// SwitchSource provides the ranges of one of its sources, picked by
+// conditions such as a time window, a placeholder or the health of the
+// source. The conditions are evaluated when provisioning, and then every
+// interval.
+type SwitchSource struct {
+	// The cases, in order. The source of the first case whose conditions
+	// all hold is used.
+	Cases []*SwitchCase `json:"cases,omitempty"`
+
+	// The source used when no case applies. If not set, there are no ranges
+	// then.
+	DefaultRaw json.RawMessage `json:"default,omitempty" caddy:"namespace=http.ip_sources inline_key=source"`
+
+	// How often to evaluate the conditions. Defaults to a minute.
+	Interval caddy.Duration `json:"interval,omitempty"`
+
+	defaultSource caddyhttp.IPRangeSource
+	logger        *zap.Logger
+
+	// The index of the selected case, or -1 for the default source.
+	selected atomic.Int64
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// SwitchCase is a case of a SwitchSource.
+type SwitchCase struct {
+	// The source to use while the conditions hold.
+	SourceRaw json.RawMessage `json:"source,omitempty" caddy:"namespace=http.ip_sources inline_key=source"`
+
+	// A daily time window in local time, as the start and end times, such
+	// as "22:00" and "06:00". Windows ending before they start end the next
+	// day.
+	Between []string `json:"between,omitempty"`
+
+	// A placeholder, such as "{env.FAILOVER}". The case applies if it
+	// expands to Equals, or if that isn't set, to a true boolean such as
+	// "1" or "true".
+	Placeholder string `json:"placeholder,omitempty"`
+	Equals      string `json:"equals,omitempty"`
+
+	// If set, the case applies only while its source is healthy: for
+	// example, while a DNS range has no degraded hosts, or the last refresh
+	// of another source succeeded.
+	Healthy bool `json:"healthy,omitempty"`
+
+	source     caddyhttp.IPRangeSource
+	start, end time.Duration
+}
+
+// CaddyModule returns the Caddy module information.
+func (*SwitchSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.switch",
+		New: func() caddy.Module { return new(SwitchSource) },
+	}
+}
+
+// Provision loads the sources, which are cleaned up along with the config,
+// selects one, and starts evaluating the conditions.
+func (s *SwitchSource) Provision(ctx caddy.Context) error {
+	if len(s.Cases) == 0 {
+		return errors.New("no cases provided")
+	}
+	if s.Interval == 0 {
+		s.Interval = DefaultSwitchInterval
+	}
+	if s.Interval < 0 {
+		return fmt.Errorf("invalid interval %v", time.Duration(s.Interval))
+	}
+	s.logger = ctx.Logger()
+
+	for i, c := range s.Cases {
+		if c.SourceRaw == nil {
+			return fmt.Errorf("case %d: no source provided", i)
+		}
+		if err := c.parseWindow(); err != nil {
+			return fmt.Errorf("case %d: %w", i, err)
+		}
+		sources, err := loadIPSources(ctx, c, "SourceRaw")
+		if err != nil {
+			return fmt.Errorf("case %d: %w", i, err)
+		}
+		c.source = sources[0]
+	}
+	if s.DefaultRaw != nil {
+		sources, err := loadIPSources(ctx, s, "DefaultRaw")
+		if err != nil {
+			return err
+		}
+		s.defaultSource = sources[0]
+	}
+
+	s.selected.Store(int64(s.selectCase(time.Now())))
+	var runCtx context.Context
+	runCtx, s.cancel = context.WithCancel(ctx)
+	s.done = make(chan struct{})
+	go s.run(runCtx)
+	return nil
+}
+
+// parseWindow parses the time window of the case.
+func (c *SwitchCase) parseWindow() error {
+	if c.Between == nil {
+		return nil
+	}
+	if len(c.Between) != 2 {
+		return errors.New("the time window needs a start and an end")
+	}
+	times := make([]time.Duration, 2)
+	for i, str := range c.Between {
+		t, err := time.Parse("15:04", str)
+		if err != nil {
+			return fmt.Errorf("invalid time %q", str)
+		}
+		times[i] = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	c.start, c.end = times[0], times[1]
+	return nil
+}
+
+// run evaluates the conditions every interval, until ctx is canceled.
+func (s *SwitchSource) run(ctx context.Context) {
+	defer close(s.done)
+	ticker := time.NewTicker(time.Duration(s.Interval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			i := s.selectCase(now)
+			if old := s.selected.Swap(int64(i)); old != int64(i) {
+				s.logger.Info("switched source", zap.Int64("from", old), zap.Int("to", i))
+			}
+		}
+	}
+}
+
+// selectCase returns the index of the first case that applies at now, or -1
+// if none do.
+func (s *SwitchSource) selectCase(now time.Time) int {
+	repl := caddy.NewReplacer()
+	for i, c := range s.Cases {
+		if c.applies(now, repl) {
+			return i
+		}
+	}
+	return -1
+}
+
+// applies reports whether all conditions of the case hold at now.
+func (c *SwitchCase) applies(now time.Time, repl *caddy.Replacer) bool {
+	if c.Between != nil {
+		t := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute + time.Duration(now.Second())*time.Second
+		if c.start <= c.end && (t < c.start || t >= c.end) || c.start > c.end && t < c.start && t >= c.end {
+			return false
+		}
+	}
+	if c.Placeholder != "" {
+		val := repl.ReplaceKnown(c.Placeholder, "")
+		if c.Equals != "" {
+			if val != c.Equals {
+				return false
+			}
+		} else if b, _ := strconv.ParseBool(val); !b {
+			return false
+		}
+	}
+	return !c.Healthy || sourceHealthy(c.source)
+}
+
+// Cleanup stops evaluating the conditions.
+func (s *SwitchSource) Cleanup() error {
+	if s.cancel != nil {
+		s.cancel()
+		<-s.done
+	}
+	return nil
+}
+
+// source returns the selected source, or nil.
+func (s *SwitchSource) source() caddyhttp.IPRangeSource {
+	if i := s.selected.Load(); i >= 0 {
+		return s.Cases[i].source
+	}
+	return s.defaultSource
+}
+
+// GetIPRanges returns the ranges of the selected source.
+func (s *SwitchSource) GetIPRanges(r *http.Request) []netip.Prefix {
+	if source := s.source(); source != nil {
+		return source.GetIPRanges(r)
+	}
+	return nil
+}
+
+// healthy reports whether the selected source is healthy.
+func (s *SwitchSource) healthy() bool {
+	source := s.source()
+	return source == nil || sourceHealthy(source)
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	switch {
+//	    case {
+//	        between <start> <end>
+//	        if <placeholder> [<value>]
+//	        healthy
+//	        source <module> ...
+//	    }
+//	    default <module> ...
+//	    interval <duration>
+//	}
+//
+// where each source is an IP range source module, with the same syntax as
+// in trusted_proxies.
+func (s *SwitchSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "case":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			c := new(SwitchCase)
+			if err := c.unmarshalCaddyfile(d); err != nil {
+				return err
+			}
+			s.Cases = append(s.Cases, c)
+		case "default":
+			if s.DefaultRaw != nil {
+				return d.Err("default source already specified")
+			}
+			raw, err := unmarshalIPSource(d)
+			if err != nil {
+				return err
+			}
+			s.DefaultRaw = raw
+		case "interval":
+			var interval string
+			if !d.AllArgs(&interval) {
+				return d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(interval)
+			if err != nil {
+				return d.Errf("invalid interval %q: %v", interval, err)
+			}
+			s.Interval = caddy.Duration(dur)
+		default:
+			return d.Errf("unknown switch option %q", d.Val())
+		}
+	}
+
+	return nil
+}
+
+// unmarshalCaddyfile sets up the case from the tokens in its block.
+func (c *SwitchCase) unmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "between":
+			c.Between = d.RemainingArgs()
+			if len(c.Between) != 2 {
+				return d.ArgErr()
+			}
+		case "if":
+			args := d.RemainingArgs()
+			if len(args) != 1 && len(args) != 2 {
+				return d.ArgErr()
+			}
+			c.Placeholder = args[0]
+			if len(args) == 2 {
+				c.Equals = args[1]
+			}
+		case "healthy":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			c.Healthy = true
+		case "source":
+			if c.SourceRaw != nil {
+				return d.Err("source already specified")
+			}
+			raw, err := unmarshalIPSource(d)
+			if err != nil {
+				return err
+			}
+			c.SourceRaw = raw
+		default:
+			return d.Errf("unknown switch case option %q", d.Val())
+		}
+	}
+	if c.SourceRaw == nil {
+		return d.Err("no source provided for case")
+	}
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*SwitchSource)(nil)
+	_ caddy.Provisioner       = (*SwitchSource)(nil)
+	_ caddy.CleanerUpper      = (*SwitchSource)(nil)
+	_ caddyfile.Unmarshaler   = (*SwitchSource)(nil)
+	_ caddyhttp.IPRangeSource = (*SwitchSource)(nil)
+)
@@ -0,0 +1,117 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestSwitchSource(t *testing.T) {
+	t.Setenv("SWITCH_TEST_FAILOVER", "")
+	path := filepath.Join(t.TempDir(), "proxies.txt")
+	if err := os.WriteFile(path, []byte("192.0.2.1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var s SwitchSource
+	input := fmt.Sprintf(`switch {
+		case {
+			if {env.SWITCH_TEST_FAILOVER}
+			source static 198.51.100.0/24
+		}
+		case {
+			between 22:00 06:00
+			source static 203.0.113.0/24
+		}
+		case {
+			healthy
+			source file %s
+		}
+		default static 192.0.2.0/24
+		interval 10ms
+	}`, path)
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cleanup()
+
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local)
+	for _, test := range []struct {
+		at       time.Duration
+		failover string
+		want     int
+	}{
+		{12 * time.Hour, "", 2},
+		{22 * time.Hour, "", 1},
+		{5*time.Hour + 59*time.Minute, "", 1},
+		{6 * time.Hour, "", 2},
+		{12 * time.Hour, "true", 0},
+		{23 * time.Hour, "1", 0},
+		{12 * time.Hour, "no", 2},
+	} {
+		os.Setenv("SWITCH_TEST_FAILOVER", test.failover)
+		if got := s.selectCase(day.Add(test.at)); got != test.want {
+			t.Errorf("at %v with %q: got case %d, want %d", test.at, test.failover, got, test.want)
+		}
+	}
+	os.Setenv("SWITCH_TEST_FAILOVER", "")
+
+	// The file source is used while it's healthy, at least during the day.
+	if hour := time.Now().Hour(); hour > 6 && hour < 22 {
+		if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[192.0.2.1/32]"; got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+		waitFor(t, "failure", func() bool {
+			if err := os.WriteFile(path, []byte("not an address\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			return fmt.Sprint(s.GetIPRanges(nil)) == "[192.0.2.0/24]"
+		})
+		if !s.healthy() {
+			t.Error("unhealthy with a healthy default source")
+		}
+	}
+
+	for _, input := range []string{
+		`switch`,
+		`switch {
+			case {
+				between 22:00
+				source static 192.0.2.0/24
+			}
+		}`,
+		`switch {
+			case {
+				between 22:00 6pm
+				source static 192.0.2.0/24
+			}
+		}`,
+		`switch {
+			case {
+				healthy
+			}
+		}`,
+	} {
+		var s SwitchSource
+		err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input))
+		if err == nil {
+			err = s.Provision(ctx)
+			s.Cleanup()
+		}
+		if err == nil {
+			t.Errorf("%s: no error", input)
+		}
+	}
+}
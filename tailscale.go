@@ -0,0 +1,285 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(new(TailscaleSource))
+}
+
+const (
+	// The default refresh interval of the Tailscale source.
+	DefaultTailscaleInterval = caddy.Duration(time.Minute)
+
+	// The default socket of the local tailscaled.
+	DefaultTailscaleSocket = "/var/run/tailscale/tailscaled.sock"
+)
+
+// The Tailscale control API, overridden in tests.
+var tailscaleAPIURL = "https://api.tailscale.com"
+
+// TailscaleSource provides the tailnet addresses of Tailscale devices,
+// selected by tag or host name. It asks the local tailscaled by default, or
+// the Tailscale API if an API key is set.
+type TailscaleSource struct {
+	// The tags of the devices to include, such as "tag:proxy".
+	Tags []string `json:"tags,omitempty"`
+
+	// The host names or MagicDNS names of the devices to include.
+	Hosts []string `json:"hosts,omitempty"`
+
+	// The socket of the local tailscaled. Defaults to
+	// DefaultTailscaleSocket.
+	Socket string `json:"socket,omitempty"`
+
+	// An API key for the Tailscale API, to use instead of the local
+	// tailscaled. May use global placeholders like {env.TS_API_KEY}.
+	APIKey string `json:"api_key,omitempty"`
+
+	// The tailnet to list the devices of, with an API key. Defaults to the
+	// tailnet of the key.
+	Tailnet string `json:"tailnet,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+	apiKey    string
+}
+
+// tailscaleDevice is a device, as described by either API.
+type tailscaleDevice struct {
+	names     []string
+	tags      []string
+	addresses []string
+}
+
+// CaddyModule returns the Caddy module information.
+func (*TailscaleSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.tailscale",
+		New: func() caddy.Module { return new(TailscaleSource) },
+	}
+}
+
+// Provision fetches the addresses, and starts refreshing them.
+func (s *TailscaleSource) Provision(ctx caddy.Context) error {
+	if len(s.Tags) == 0 && len(s.Hosts) == 0 {
+		return errors.New("no tags or hosts provided")
+	}
+	if s.Tailnet != "" && s.APIKey == "" {
+		return errors.New("a tailnet requires an API key")
+	}
+	if s.Socket == "" {
+		s.Socket = DefaultTailscaleSocket
+	}
+	if err := s.SourceOptions.validate(DefaultTailscaleInterval); err != nil {
+		return err
+	}
+	fetch := s.fetchLocal
+	if s.APIKey != "" {
+		s.apiKey = caddy.NewReplacer().ReplaceKnown(s.APIKey, "")
+		fetch = s.fetchAPI
+	}
+	return s.refresher.start(ctx, s.SourceOptions, sourceKey(s), func(ctx context.Context) ([]netip.Prefix, error) {
+		devices, err := fetch(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var strs []string
+		for _, device := range devices {
+			if s.include(device) {
+				strs = append(strs, device.addresses...)
+			}
+		}
+		return parsePrefixList(strs)
+	})
+}
+
+// include reports whether the device is selected.
+func (s *TailscaleSource) include(device tailscaleDevice) bool {
+	for _, tag := range device.tags {
+		if len(s.Tags) > 0 && matchesAny(s.Tags, tag) {
+			return true
+		}
+	}
+	for _, name := range device.names {
+		if len(s.Hosts) > 0 && matchesAny(s.Hosts, strings.TrimSuffix(name, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchLocal lists this device and its peers, as known to the local
+// tailscaled.
+func (s *TailscaleSource) fetchLocal(ctx context.Context) ([]tailscaleDevice, error) {
+	type peer struct {
+		HostName     string   `json:"HostName"`
+		DNSName      string   `json:"DNSName"`
+		TailscaleIPs []string `json:"TailscaleIPs"`
+		Tags         []string `json:"Tags"`
+	}
+	var status struct {
+		Self *peer           `json:"Self"`
+		Peer map[string]peer `json:"Peer"`
+	}
+	socket := s.Socket
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}}
+	// Connections go to the socket, whatever the host name.
+	if err := fetchJSONWith(ctx, client, "http://local-tailscaled.sock/localapi/v0/status", nil, &status); err != nil {
+		return nil, err
+	}
+
+	peers := make([]peer, 0, len(status.Peer)+1)
+	if status.Self != nil {
+		peers = append(peers, *status.Self)
+	}
+	for _, p := range status.Peer {
+		peers = append(peers, p)
+	}
+	devices := make([]tailscaleDevice, 0, len(peers))
+	for _, p := range peers {
+		devices = append(devices, tailscaleDevice{
+			names:     []string{p.HostName, p.DNSName, strings.SplitN(p.DNSName, ".", 2)[0]},
+			tags:      p.Tags,
+			addresses: p.TailscaleIPs,
+		})
+	}
+	return devices, nil
+}
+
+// fetchAPI lists the devices of the tailnet with the Tailscale API.
+func (s *TailscaleSource) fetchAPI(ctx context.Context) ([]tailscaleDevice, error) {
+	tailnet := s.Tailnet
+	if tailnet == "" {
+		tailnet = "-"
+	}
+	var list struct {
+		Devices []struct {
+			Hostname  string   `json:"hostname"`
+			Name      string   `json:"name"`
+			Addresses []string `json:"addresses"`
+			Tags      []string `json:"tags"`
+		} `json:"devices"`
+	}
+	header := replaceHeaders(map[string]string{"Authorization": "Bearer " + s.apiKey})
+	if err := fetchJSON(ctx, tailscaleAPIURL+"/api/v2/tailnet/"+url.PathEscape(tailnet)+"/devices", header, &list); err != nil {
+		return nil, err
+	}
+	devices := make([]tailscaleDevice, 0, len(list.Devices))
+	for _, d := range list.Devices {
+		devices = append(devices, tailscaleDevice{
+			names:     []string{d.Hostname, d.Name, strings.SplitN(d.Name, ".", 2)[0]},
+			tags:      d.Tags,
+			addresses: d.Addresses,
+		})
+	}
+	return devices, nil
+}
+
+// Cleanup stops refreshing the addresses.
+func (s *TailscaleSource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the addresses of the devices.
+func (s *TailscaleSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *TailscaleSource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	tailscale [<tag|host...>] {
+//	    tag <tag...>
+//	    host <host...>
+//	    socket <path>
+//	    api_key <key>
+//	    tailnet <tailnet>
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+//
+// Arguments starting with "tag:" are tags, others are hosts.
+func (s *TailscaleSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	for _, arg := range d.RemainingArgs() {
+		if strings.HasPrefix(arg, "tag:") {
+			s.Tags = append(s.Tags, arg)
+		} else {
+			s.Hosts = append(s.Hosts, arg)
+		}
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "tag":
+			tags := d.RemainingArgs()
+			if len(tags) == 0 {
+				return d.ArgErr()
+			}
+			s.Tags = append(s.Tags, tags...)
+		case "host":
+			hosts := d.RemainingArgs()
+			if len(hosts) == 0 {
+				return d.ArgErr()
+			}
+			s.Hosts = append(s.Hosts, hosts...)
+		case "socket":
+			if !d.AllArgs(&s.Socket) {
+				return d.ArgErr()
+			}
+		case "api_key":
+			if !d.AllArgs(&s.APIKey) {
+				return d.ArgErr()
+			}
+		case "tailnet":
+			if !d.AllArgs(&s.Tailnet) {
+				return d.ArgErr()
+			}
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown tailscale option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*TailscaleSource)(nil)
+	_ caddy.Provisioner       = (*TailscaleSource)(nil)
+	_ caddy.CleanerUpper      = (*TailscaleSource)(nil)
+	_ caddyfile.Unmarshaler   = (*TailscaleSource)(nil)
+	_ caddyhttp.IPRangeSource = (*TailscaleSource)(nil)
+)
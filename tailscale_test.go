@@ -0,0 +1,95 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestTailscaleSourceLocal(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "tailscaled.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("no unix sockets: %v", err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/localapi/v0/status" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{
+			"Self": {"HostName": "edge", "DNSName": "edge.tail1234.ts.net.", "TailscaleIPs": ["100.64.0.1", "fd7a:115c:a1e0::1"]},
+			"Peer": {
+				"key1": {"HostName": "proxy-a", "DNSName": "proxy-a.tail1234.ts.net.", "TailscaleIPs": ["100.64.0.2"], "Tags": ["tag:proxy"]},
+				"key2": {"HostName": "laptop", "DNSName": "laptop.tail1234.ts.net.", "TailscaleIPs": ["100.64.0.3"]},
+				"key3": {"HostName": "Backup", "DNSName": "backup.tail1234.ts.net.", "TailscaleIPs": ["100.64.0.4"]}
+			}
+		}`)
+	}))
+	srv.Listener = ln
+	srv.Start()
+	defer srv.Close()
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	s := TailscaleSource{Tags: []string{"tag:proxy"}, Hosts: []string{"backup", "edge.tail1234.ts.net"}, Socket: socket}
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cleanup()
+
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[100.64.0.1/32 100.64.0.2/32 100.64.0.4/32 fd7a:115c:a1e0::1/128]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestTailscaleSourceAPI(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/tailnet/example.com/devices" || r.Header.Get("Authorization") != "Bearer tskey-api-secret" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"devices": [
+			{"hostname": "proxy-a", "name": "proxy-a.tail1234.ts.net", "addresses": ["100.64.0.2", "fd7a:115c:a1e0::2"], "tags": ["tag:proxy"]},
+			{"hostname": "laptop", "name": "laptop.tail1234.ts.net", "addresses": ["100.64.0.3"]}
+		]}`)
+	}))
+	defer srv.Close()
+	defer func(u string) { tailscaleAPIURL = u }(tailscaleAPIURL)
+	tailscaleAPIURL = srv.URL
+	t.Setenv("TS_API_KEY", "tskey-api-secret")
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	s := TailscaleSource{Tags: []string{"tag:proxy"}, APIKey: "{env.TS_API_KEY}", Tailnet: "example.com"}
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cleanup()
+
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[100.64.0.2/32 fd7a:115c:a1e0::2/128]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestUnmarshalTailscaleSource(t *testing.T) {
+	var s TailscaleSource
+	input := `tailscale tag:proxy edge {
+		host backup
+		api_key {env.TS_API_KEY}
+		tailnet example.com
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.Tags, " ", s.Hosts, " ", s.APIKey, " ", s.Tailnet), "[tag:proxy] [edge backup] {env.TS_API_KEY} example.com"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
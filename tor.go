@@ -0,0 +1,209 @@
+package dns
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(new(TorSource))
+}
+
+const (
+	// The default refresh interval of the Tor source. The exit list is
+	// updated about every hour.
+	DefaultTorInterval = caddy.Duration(time.Hour)
+
+	// The list of the addresses exit relays were seen exiting from.
+	DefaultTorURL = "https://check.torproject.org/exit-addresses"
+
+	// The Onionoo details of the running exit relays.
+	DefaultOnionooURL = "https://onionoo.torproject.org/details?running=true&flag=Exit&fields=or_addresses,exit_addresses"
+)
+
+// TorSource provides the addresses of Tor exit relays.
+type TorSource struct {
+	// The URL of the exit list, in the format of TorDNSEL's exit-addresses,
+	// or with one address per line as in torbulkexitlist. Defaults to
+	// DefaultTorURL, or DefaultOnionooURL with Onionoo.
+	URL string `json:"url,omitempty"`
+
+	// Get the relays from the Onionoo API instead, including their relay
+	// addresses besides the addresses they were seen exiting from.
+	Onionoo bool `json:"onionoo,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+}
+
+// CaddyModule returns the Caddy module information.
+func (*TorSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.tor",
+		New: func() caddy.Module { return new(TorSource) },
+	}
+}
+
+// Provision fetches the exit addresses, and starts refreshing them.
+func (s *TorSource) Provision(ctx caddy.Context) error {
+	if s.URL == "" {
+		s.URL = DefaultTorURL
+		if s.Onionoo {
+			s.URL = DefaultOnionooURL
+		}
+	}
+	if err := s.SourceOptions.validate(DefaultTorInterval); err != nil {
+		return err
+	}
+	return s.refresher.start(ctx, s.SourceOptions, sourceKey(s), s.fetch)
+}
+
+// fetch fetches the exit addresses.
+func (s *TorSource) fetch(ctx context.Context) ([]netip.Prefix, error) {
+	var (
+		strs []string
+		err  error
+	)
+	if s.Onionoo {
+		strs, err = s.fetchOnionoo(ctx)
+	} else {
+		var data []byte
+		if data, err = fetchDocument(ctx, s.URL, nil); err == nil {
+			strs = parseTorExitList(data)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(strs) == 0 {
+		// The list is never legitimately empty.
+		return nil, errors.New("tor: no exit addresses listed")
+	}
+	return parsePrefixList(strs)
+}
+
+// parseTorExitList returns the addresses in an exit list. In the
+// exit-addresses format, each relay has an ExitNode line followed by some
+// others, among which an ExitAddress line for each address it exited from.
+// Lines with only an address are taken as they are.
+func parseTorExitList(data []byte) []string {
+	var strs []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		switch {
+		case len(fields) >= 2 && fields[0] == "ExitAddress":
+			strs = append(strs, fields[1])
+		case len(fields) == 1 && !strings.HasPrefix(fields[0], "#"):
+			if _, err := netip.ParseAddr(fields[0]); err == nil {
+				strs = append(strs, fields[0])
+			}
+		}
+	}
+	return strs
+}
+
+// fetchOnionoo fetches the relay and exit addresses of the relays in an
+// Onionoo details document.
+func (s *TorSource) fetchOnionoo(ctx context.Context) ([]string, error) {
+	var details struct {
+		Relays []struct {
+			ORAddresses   []string `json:"or_addresses"`
+			ExitAddresses []string `json:"exit_addresses"`
+		} `json:"relays"`
+	}
+	if err := fetchJSON(ctx, s.URL, nil, &details); err != nil {
+		return nil, err
+	}
+	var strs []string
+	for _, relay := range details.Relays {
+		for _, addr := range relay.ORAddresses {
+			// Relay addresses come with their port, such as "[2001:db8::1]:9001".
+			ap, err := netip.ParseAddrPort(addr)
+			if err != nil {
+				return nil, fmt.Errorf("tor: invalid relay address %q", addr)
+			}
+			strs = append(strs, ap.Addr().String())
+		}
+		strs = append(strs, relay.ExitAddresses...)
+	}
+	return strs, nil
+}
+
+// Cleanup stops refreshing the exit addresses.
+func (s *TorSource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the exit addresses.
+func (s *TorSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *TorSource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	tor {
+//	    url <url>
+//	    onionoo
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *TorSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "url":
+			if !d.AllArgs(&s.URL) {
+				return d.ArgErr()
+			}
+		case "onionoo":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			s.Onionoo = true
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown tor option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*TorSource)(nil)
+	_ caddy.Provisioner       = (*TorSource)(nil)
+	_ caddy.CleanerUpper      = (*TorSource)(nil)
+	_ caddyfile.Unmarshaler   = (*TorSource)(nil)
+	_ caddyhttp.IPRangeSource = (*TorSource)(nil)
+)
@@ -0,0 +1,80 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestTorSource(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/exit-addresses", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `ExitNode 0011BD2485AD45D984EC4159C88FC066E5E3300E
+Published 2024-05-01 10:11:12
+LastStatus 2024-05-01 11:00:00
+ExitAddress 192.0.2.10 2024-05-01 11:02:03
+ExitNode 0091174DE56EE8E1E6C1FC3D4E9C1F4D5A6B7C8D
+Published 2024-05-01 09:00:00
+LastStatus 2024-05-01 10:00:00
+ExitAddress 198.51.100.20 2024-05-01 10:01:02
+ExitAddress 198.51.100.21 2024-05-01 10:31:02
+`)
+	})
+	mux.HandleFunc("/torbulkexitlist", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "192.0.2.10\n198.51.100.20\n")
+	})
+	mux.HandleFunc("/details", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"version":"8.0","relays":[
+			{"or_addresses":["192.0.2.10:9001","[2001:db8::10]:9001"]},
+			{"or_addresses":["198.51.100.20:443"],"exit_addresses":["203.0.113.30"]}
+		]}`)
+	})
+	mux.HandleFunc("/empty", func(w http.ResponseWriter, r *http.Request) {})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	for _, test := range []*struct {
+		TorSource
+		want string
+	}{
+		{TorSource{URL: srv.URL + "/exit-addresses"}, "[192.0.2.10/32 198.51.100.20/32 198.51.100.21/32]"},
+		{TorSource{URL: srv.URL + "/torbulkexitlist"}, "[192.0.2.10/32 198.51.100.20/32]"},
+		{TorSource{URL: srv.URL + "/details", Onionoo: true}, "[192.0.2.10/32 198.51.100.20/32 203.0.113.30/32 2001:db8::10/128]"},
+	} {
+		if err := test.Provision(ctx); err != nil {
+			t.Errorf("%s: %v", test.URL, err)
+			continue
+		}
+		if got := fmt.Sprint(test.GetIPRanges(nil)); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.URL, got, test.want)
+		}
+		test.Cleanup()
+	}
+
+	s := TorSource{URL: srv.URL + "/empty"}
+	if err := s.Provision(ctx); err == nil {
+		t.Error("no error for an empty list")
+	}
+	s.Cleanup()
+}
+
+func TestUnmarshalTorSource(t *testing.T) {
+	var s TorSource
+	input := `tor {
+		url https://onionoo.example.com/details
+		onionoo
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.URL, " ", s.Onionoo), "https://onionoo.example.com/details true"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
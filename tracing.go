@@ -0,0 +1,111 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The name of the tracer, and of the lookup spans.
+const (
+	tracerName     = "github.com/fvbommel/caddy-dns-ip-range"
+	lookupSpanName = "dns_ip_range.lookup"
+)
+
+// tracerProviders holds the tracer provider exporting the spans of all ranges
+// with tracing enabled. Like the one of Caddy's tracing handler, it's set up
+// through the standard OTEL_* environment variables, and survives reloads as
+// long as some range keeps using it.
+var tracerProviders = caddy.NewUsagePool()
+
+// The key of the shared tracer provider.
+const tracerProviderKey = "otlp"
+
+// tracerProvider is a reference counted tracer provider.
+type tracerProvider struct {
+	*sdktrace.TracerProvider
+}
+
+// Destruct flushes and stops the tracer provider.
+func (p tracerProvider) Destruct() error {
+	return p.Shutdown(context.Background())
+}
+
+// setupTracing sets up the tracer, if tracing is enabled.
+func (d *DNSRange) setupTracing() error {
+	if !d.Tracing {
+		return nil
+	}
+	val, _, err := tracerProviders.LoadOrNew(tracerProviderKey, func() (caddy.Destructor, error) {
+		exporter, err := otlptracegrpc.New(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		return tracerProvider{sdktrace.NewTracerProvider(
+			sdktrace.WithBatcher(exporter),
+			sdktrace.WithResource(resource.Default()),
+		)}, nil
+	})
+	if err != nil {
+		return err
+	}
+	d.tracer = val.(tracerProvider).Tracer(tracerName)
+	return nil
+}
+
+// releaseTracing releases the tracer provider, if tracing is enabled.
+func (d *DNSRange) releaseTracing() {
+	if d.tracer != nil {
+		_, _ = tracerProviders.Delete(tracerProviderKey)
+	}
+}
+
+// startLookupSpan starts the span of a lookup of host, if tracing is
+// enabled. Otherwise, the returned span does nothing.
+func (d *DNSRange) startLookupSpan(ctx context.Context, host string) (context.Context, trace.Span) {
+	if d.tracer == nil {
+		return ctx, trace.SpanFromContext(context.Background())
+	}
+	r := d.resolver
+	if c, ok := r.(*cachingResolver); ok {
+		r = c.next
+	}
+	return d.tracer.Start(ctx, lookupSpanName, trace.WithAttributes(
+		attribute.String("dns.host", host),
+		attribute.String("dns.resolver", resolverName(r)),
+		attribute.String("dns.transport", transportOf(r)),
+	))
+}
+
+// endLookupSpan records the outcome of a lookup in its span, and ends it.
+func endLookupSpan(span trace.Span, answers int, err error) {
+	span.SetAttributes(attribute.Int("dns.answer_count", answers))
+	if err != nil && !errors.Is(err, context.Canceled) {
+		span.SetAttributes(attribute.String("dns.error_class", errorClass(err)))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// resolverName describes r for traces.
+func resolverName(r resolver) string {
+	switch r := r.(type) {
+	case *net.Resolver:
+		if r.PreferGo {
+			return ResolverModeGo
+		}
+		return ResolverModeSystem
+	case *dnsJSONResolver:
+		return r.url.Redacted()
+	}
+	return "other"
+}
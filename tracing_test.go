@@ -0,0 +1,65 @@
+package dns
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestLookupSpans(t *testing.T) {
+	res := &fakeResolver{hosts: map[string][]string{"proxy.example.com": {"192.0.2.1", "192.0.2.2"}}}
+	r := DNSRange{Hosts: []string{"proxy.example.com", "gone.example.com"}, ResolutionPolicy: ResolveAny}
+	cancel, err := provision(t, &r, res)
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := tracetest.NewSpanRecorder()
+	r.tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer(tracerName)
+	for _, host := range r.Hosts {
+		_, _ = r.lookupHostPrefixes(context.Background(), host, 0)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	tests := []struct {
+		status codes.Code
+		attrs  map[attribute.Key]attribute.Value
+	}{
+		{codes.Unset, map[attribute.Key]attribute.Value{
+			"dns.host":         attribute.StringValue("proxy.example.com"),
+			"dns.transport":    attribute.StringValue(transportOther),
+			"dns.answer_count": attribute.IntValue(2),
+		}},
+		{codes.Error, map[attribute.Key]attribute.Value{
+			"dns.host":         attribute.StringValue("gone.example.com"),
+			"dns.answer_count": attribute.IntValue(0),
+			"dns.error_class":  attribute.StringValue(errorClassNotFound),
+		}},
+	}
+	for i, test := range tests {
+		span := spans[i]
+		if span.Name() != lookupSpanName {
+			t.Errorf("span %d: name %q, want %q", i, span.Name(), lookupSpanName)
+		}
+		if span.Status().Code != test.status {
+			t.Errorf("span %d: status %v, want %v", i, span.Status().Code, test.status)
+		}
+		attrs := make(map[attribute.Key]attribute.Value)
+		for _, kv := range span.Attributes() {
+			attrs[kv.Key] = kv.Value
+		}
+		for key, want := range test.attrs {
+			if got := attrs[key]; got != want {
+				t.Errorf("span %d: %s = %v, want %v", i, key, got.Emit(), want.Emit())
+			}
+		}
+	}
+}
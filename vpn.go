@@ -0,0 +1,221 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"sort"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(new(VPNSource))
+}
+
+// The default refresh interval of the VPN source.
+const DefaultVPNInterval = caddy.Duration(6 * time.Hour)
+
+// The relay lists of the providers, overridden in tests.
+var (
+	mullvadRelaysURL    = "https://api.mullvad.net/www/relays/all/"
+	protonVPNServersURL = "https://api.protonvpn.ch/vpn/logicals"
+)
+
+// vpnRelay is a relay of a VPN provider.
+type vpnRelay struct {
+	// The country the relay is in, as an ISO 3166-1 alpha-2 code.
+	country string
+
+	// The addresses the relay's traffic comes from.
+	addresses []string
+}
+
+// The relay lists of the supported providers, by name.
+var vpnProviders = map[string]func(context.Context) ([]vpnRelay, error){
+	"mullvad":   fetchMullvadRelays,
+	"protonvpn": fetchProtonVPNRelays,
+}
+
+// VPNSource provides the addresses of the relays of commercial VPN
+// providers, from the lists they publish.
+type VPNSource struct {
+	// The providers: "mullvad" or "protonvpn". Defaults to all of them.
+	Providers []string `json:"providers,omitempty"`
+
+	// The countries of the relays to include, as ISO 3166-1 alpha-2 codes
+	// such as "se". Defaults to all countries.
+	Countries []string `json:"countries,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+}
+
+// CaddyModule returns the Caddy module information.
+func (*VPNSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.vpn",
+		New: func() caddy.Module { return new(VPNSource) },
+	}
+}
+
+// Provision fetches the relays, and starts refreshing them.
+func (s *VPNSource) Provision(ctx caddy.Context) error {
+	if len(s.Providers) == 0 {
+		for name := range vpnProviders {
+			s.Providers = append(s.Providers, name)
+		}
+		// For a stable sourceKey.
+		sort.Strings(s.Providers)
+	}
+	for _, provider := range s.Providers {
+		if _, ok := vpnProviders[provider]; !ok {
+			return fmt.Errorf("unknown VPN provider %q", provider)
+		}
+	}
+	if err := s.SourceOptions.validate(DefaultVPNInterval); err != nil {
+		return err
+	}
+	return s.refresher.start(ctx, s.SourceOptions, sourceKey(s), s.fetch)
+}
+
+// fetch fetches the relays of all providers. If one of them fails, so does
+// fetch, so that the previous addresses are kept.
+func (s *VPNSource) fetch(ctx context.Context) ([]netip.Prefix, error) {
+	var strs []string
+	for _, provider := range s.Providers {
+		relays, err := vpnProviders[provider](ctx)
+		if err != nil {
+			return nil, err
+		}
+		if len(relays) == 0 {
+			return nil, fmt.Errorf("%s lists no relays", provider)
+		}
+		for _, relay := range relays {
+			if matchesAny(s.Countries, relay.country) {
+				strs = append(strs, relay.addresses...)
+			}
+		}
+	}
+	if len(strs) == 0 {
+		return nil, errors.New("no relays in the selected countries")
+	}
+	return parsePrefixList(strs)
+}
+
+// fetchMullvadRelays lists Mullvad's WireGuard, OpenVPN and bridge relays.
+// Traffic leaves them from the addresses it enters at.
+func fetchMullvadRelays(ctx context.Context) ([]vpnRelay, error) {
+	var list []struct {
+		CountryCode string `json:"country_code"`
+		IPv4AddrIn  string `json:"ipv4_addr_in"`
+		IPv6AddrIn  string `json:"ipv6_addr_in"`
+	}
+	if err := fetchJSON(ctx, mullvadRelaysURL, nil, &list); err != nil {
+		return nil, err
+	}
+	relays := make([]vpnRelay, 0, len(list))
+	for _, r := range list {
+		relay := vpnRelay{country: r.CountryCode}
+		for _, addr := range []string{r.IPv4AddrIn, r.IPv6AddrIn} {
+			if addr != "" {
+				relay.addresses = append(relay.addresses, addr)
+			}
+		}
+		relays = append(relays, relay)
+	}
+	return relays, nil
+}
+
+// fetchProtonVPNRelays lists Proton VPN's servers, with the addresses their
+// traffic exits from, which may differ from those it enters at.
+func fetchProtonVPNRelays(ctx context.Context) ([]vpnRelay, error) {
+	var list struct {
+		LogicalServers []struct {
+			ExitCountry string `json:"ExitCountry"`
+			Servers     []struct {
+				ExitIP string `json:"ExitIP"`
+			} `json:"Servers"`
+		} `json:"LogicalServers"`
+	}
+	if err := fetchJSON(ctx, protonVPNServersURL, nil, &list); err != nil {
+		return nil, err
+	}
+	relays := make([]vpnRelay, 0, len(list.LogicalServers))
+	for _, l := range list.LogicalServers {
+		relay := vpnRelay{country: l.ExitCountry}
+		for _, server := range l.Servers {
+			if server.ExitIP != "" {
+				relay.addresses = append(relay.addresses, server.ExitIP)
+			}
+		}
+		relays = append(relays, relay)
+	}
+	return relays, nil
+}
+
+// Cleanup stops refreshing the relays.
+func (s *VPNSource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the addresses of the relays.
+func (s *VPNSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *VPNSource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	vpn [<provider...>] {
+//	    country <code...>
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *VPNSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	s.Providers = append(s.Providers, d.RemainingArgs()...)
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "country":
+			countries := d.RemainingArgs()
+			if len(countries) == 0 {
+				return d.ArgErr()
+			}
+			s.Countries = append(s.Countries, countries...)
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown vpn option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*VPNSource)(nil)
+	_ caddy.Provisioner       = (*VPNSource)(nil)
+	_ caddy.CleanerUpper      = (*VPNSource)(nil)
+	_ caddyfile.Unmarshaler   = (*VPNSource)(nil)
+	_ caddyhttp.IPRangeSource = (*VPNSource)(nil)
+)
@@ -0,0 +1,70 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestVPNSource(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/mullvad", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[
+			{"hostname":"se-got-wg-001","country_code":"se","city_code":"got","active":true,"ipv4_addr_in":"192.0.2.1","ipv6_addr_in":"2001:db8::1","type":"wireguard"},
+			{"hostname":"de-fra-ovpn-001","country_code":"de","city_code":"fra","active":true,"ipv4_addr_in":"192.0.2.2","type":"openvpn"}
+		]`)
+	})
+	mux.HandleFunc("/proton", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"Code":1000,"LogicalServers":[
+			{"Name":"SE#1","EntryCountry":"SE","ExitCountry":"SE","Servers":[{"EntryIP":"198.51.100.1","ExitIP":"198.51.100.2"}]},
+			{"Name":"CH-DE#1","EntryCountry":"CH","ExitCountry":"DE","Servers":[{"EntryIP":"198.51.100.3","ExitIP":"198.51.100.4"}]}
+		]}`)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	defer func(m, p string) { mullvadRelaysURL, protonVPNServersURL = m, p }(mullvadRelaysURL, protonVPNServersURL)
+	mullvadRelaysURL, protonVPNServersURL = srv.URL+"/mullvad", srv.URL+"/proton"
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	for _, test := range []*struct {
+		VPNSource
+		want string
+	}{
+		{VPNSource{}, "[192.0.2.1/32 192.0.2.2/32 198.51.100.2/32 198.51.100.4/32 2001:db8::1/128]"},
+		{VPNSource{Providers: []string{"mullvad"}}, "[192.0.2.1/32 192.0.2.2/32 2001:db8::1/128]"},
+		{VPNSource{Countries: []string{"de"}}, "[192.0.2.2/32 198.51.100.4/32]"},
+	} {
+		if err := test.Provision(ctx); err != nil {
+			t.Errorf("%v %v: %v", test.Providers, test.Countries, err)
+			continue
+		}
+		if got := fmt.Sprint(test.GetIPRanges(nil)); got != test.want {
+			t.Errorf("%v %v: got %s, want %s", test.Providers, test.Countries, got, test.want)
+		}
+		test.Cleanup()
+	}
+
+	s := VPNSource{Providers: []string{"nordvpn"}}
+	if err := s.Provision(ctx); err == nil {
+		t.Error("no error for an unknown provider")
+	}
+}
+
+func TestUnmarshalVPNSource(t *testing.T) {
+	var s VPNSource
+	input := `vpn mullvad protonvpn {
+		country se no
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.Providers, " ", s.Countries), "[mullvad protonvpn] [se no]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,317 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+// Supported values for Webhook.Events.
+const (
+	// The addresses of hosts changed.
+	WebhookChanged = "changed"
+
+	// The addresses of hosts changed, and some addresses were removed.
+	WebhookRemoved = "removed"
+
+	// A host failed to resolve FailureThreshold times in a row.
+	WebhookFailing = "failing"
+)
+
+const (
+	DefaultWebhookFailureThreshold = 3
+	DefaultWebhookTimeout          = caddy.Duration(10 * time.Second)
+)
+
+// Webhook configures an HTTP request that is sent when the addresses of hosts
+// change, or when a host keeps failing to resolve.
+type Webhook struct {
+	// The URL to send the request to.
+	URL string `json:"url"`
+
+	// The request method. Defaults to POST.
+	Method string `json:"method,omitempty"`
+
+	// Header fields to set, such as Authorization. Values may use global
+	// placeholders like {env.WEBHOOK_TOKEN}, so that secrets can be kept out
+	// of the config.
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// A text/template for the request body, executed with the event data.
+	// Defaults to the event data as JSON.
+	Body string `json:"body,omitempty"`
+
+	// The events to send: "changed", "removed" (only changes that remove
+	// addresses) or "failing". Defaults to "changed" and "failing".
+	Events []string `json:"events,omitempty"`
+
+	// The number of lookups of a host in a row that must fail before a
+	// "failing" event is sent. Defaults to DefaultWebhookFailureThreshold.
+	FailureThreshold int `json:"failure_threshold,omitempty"`
+
+	// How long to wait for the request to complete. Defaults to
+	// DefaultWebhookTimeout.
+	Timeout caddy.Duration `json:"timeout,omitempty"`
+
+	// The parsed body template, if any.
+	body *template.Template
+}
+
+// webhookData is the data of a webhook event, as passed to the body template.
+type webhookData struct {
+	// The event: "changed" or "failing". Changes that remove addresses are
+	// sent as "changed" as well.
+	Event string `json:"event"`
+
+	// The name of the range, if it has one.
+	Range string `json:"range,omitempty"`
+
+	// For "changed" events, the changes.
+	Changes []webhookChange `json:"changes,omitempty"`
+
+	// For "failing" events, the host, the number of failed lookups in a row,
+	// and the error of the last one.
+	Host     string `json:"host,omitempty"`
+	Failures int    `json:"failures,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// webhookChange describes a change of the addresses of a host.
+type webhookChange struct {
+	Host      string   `json:"host"`
+	Addresses []string `json:"addresses"`
+	Added     []string `json:"added"`
+	Removed   []string `json:"removed"`
+}
+
+// Functions available in body templates.
+var webhookFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"join": strings.Join,
+}
+
+// validate checks the configuration and sets defaults.
+func (w *Webhook) validate() error {
+	if w.URL == "" {
+		return errors.New("webhook URL is required")
+	}
+	if w.FailureThreshold < 0 || w.Timeout < 0 {
+		return errors.New("webhook failure_threshold and timeout cannot be negative")
+	}
+	for _, event := range w.Events {
+		switch event {
+		case WebhookChanged, WebhookRemoved, WebhookFailing:
+		default:
+			return fmt.Errorf("unknown webhook event %q", event)
+		}
+	}
+
+	if w.Body != "" {
+		body, err := template.New("webhook").Funcs(webhookFuncs).Parse(w.Body)
+		if err != nil {
+			return fmt.Errorf("parsing webhook body: %w", err)
+		}
+		w.body = body
+	}
+
+	if w.Method == "" {
+		w.Method = http.MethodPost
+	}
+	if len(w.Events) == 0 {
+		w.Events = []string{WebhookChanged, WebhookFailing}
+	}
+	if w.FailureThreshold == 0 {
+		w.FailureThreshold = DefaultWebhookFailureThreshold
+	}
+	if w.Timeout == 0 {
+		w.Timeout = DefaultWebhookTimeout
+	}
+
+	return nil
+}
+
+// wants reports whether the webhook is sent for event.
+func (w *Webhook) wants(event string) bool {
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// send sends the webhook request for data.
+func (w *Webhook) send(ctx context.Context, data webhookData) error {
+	var body bytes.Buffer
+	contentType := "application/json"
+	if w.body != nil {
+		if err := w.body.Execute(&body, data); err != nil {
+			return fmt.Errorf("executing body template: %w", err)
+		}
+		contentType = ""
+	} else if err := json.NewEncoder(&body).Encode(data); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(w.Timeout))
+	defer cancel()
+
+	repl := caddy.NewReplacer()
+	req, err := http.NewRequestWithContext(ctx, w.Method, repl.ReplaceKnown(w.URL, ""), &body)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for name, value := range w.Headers {
+		req.Header.Set(name, repl.ReplaceKnown(value, ""))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// sendWebhooks sends data to each webhook for which want returns true, in
+// the background.
+func (d *DNSRange) sendWebhooks(data webhookData, want func(*Webhook) bool) {
+	data.Range = d.Name
+	for _, w := range d.Webhooks {
+		if !want(w) {
+			continue
+		}
+		go func(w *Webhook) {
+			if err := w.send(d.ctx, data); err != nil && d.ctx.Err() == nil {
+				// The URL may contain credentials, so it's not logged.
+				d.logger.Warn("webhook failed",
+					zap.String("event", data.Event),
+					zap.Error(err))
+			}
+		}(w)
+	}
+}
+
+// changedWebhooks sends the webhooks for changes of the addresses of hosts.
+func (d *DNSRange) changedWebhooks(changes []hostChange) {
+	data := webhookData{Event: WebhookChanged}
+	removed := false
+	for _, change := range changes {
+		plus, minus := diffPrefixes(change.old, change.addresses)
+		removed = removed || len(minus) > 0
+		data.Changes = append(data.Changes, webhookChange{
+			Host:      change.host,
+			Addresses: prefixStrings(change.addresses),
+			Added:     prefixStrings(plus),
+			Removed:   prefixStrings(minus),
+		})
+	}
+	d.sendWebhooks(data, func(w *Webhook) bool {
+		return w.wants(WebhookChanged) || (removed && w.wants(WebhookRemoved))
+	})
+}
+
+// failingWebhooks sends the webhooks of which host just reached the failure
+// threshold, after failures lookups in a row failed with err.
+func (d *DNSRange) failingWebhooks(host string, failures int, err error) {
+	if err == nil || d.ctx.Err() != nil {
+		return
+	}
+	data := webhookData{Event: WebhookFailing, Host: host, Failures: failures, Error: err.Error()}
+	d.sendWebhooks(data, func(w *Webhook) bool {
+		return w.wants(WebhookFailing) && failures == w.FailureThreshold
+	})
+}
+
+// unmarshalWebhook parses the webhook subdirective:
+//
+//	webhook <url> {
+//	    method <method>
+//	    header <name> <value>
+//	    body <template>
+//	    events changed|removed|failing...
+//	    failure_threshold <count>
+//	    timeout <duration>
+//	}
+func unmarshalWebhook(d *caddyfile.Dispenser) (*Webhook, error) {
+	var w Webhook
+	if !d.NextArg() {
+		return nil, d.Err("expected URL")
+	}
+	w.URL = d.Val()
+	if d.NextArg() {
+		return nil, d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "method":
+			if !d.AllArgs(&w.Method) {
+				return nil, d.ArgErr()
+			}
+		case "header":
+			if err := unmarshalHeader(d, &w.Headers); err != nil {
+				return nil, err
+			}
+		case "body":
+			if !d.AllArgs(&w.Body) {
+				return nil, d.ArgErr()
+			}
+		case "events":
+			w.Events = d.RemainingArgs()
+			if len(w.Events) == 0 {
+				return nil, d.ArgErr()
+			}
+		case "failure_threshold":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			n, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return nil, d.WrapErr(err)
+			}
+			w.FailureThreshold = n
+			if d.NextArg() {
+				return nil, d.ArgErr()
+			}
+		case "timeout":
+			if !d.NextArg() {
+				return nil, d.Err("expected duration")
+			}
+			timeout, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return nil, d.WrapErr(err)
+			}
+			w.Timeout = caddy.Duration(timeout)
+			if d.NextArg() {
+				return nil, d.ArgErr()
+			}
+		default:
+			return nil, d.Errf("unknown webhook option %q", d.Val())
+		}
+	}
+
+	return &w, nil
+}
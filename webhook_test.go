@@ -0,0 +1,158 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+type webhookRequest struct {
+	method, auth, body string
+}
+
+// webhookServer returns a server that passes the requests it receives to the
+// returned channel.
+func webhookServer(t *testing.T) (*httptest.Server, <-chan webhookRequest) {
+	requests := make(chan webhookRequest, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- webhookRequest{r.Method, r.Header.Get("Authorization"), string(body)}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, requests
+}
+
+func TestWebhooks(t *testing.T) {
+	srv, requests := webhookServer(t)
+	t.Setenv("WEBHOOK_TEST_TOKEN", "secret")
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	r := DNSRange{
+		Name: "proxies",
+		Webhooks: []*Webhook{{
+			URL:     srv.URL,
+			Method:  http.MethodPut,
+			Headers: map[string]string{"Authorization": "Bearer {env.WEBHOOK_TEST_TOKEN}"},
+			Body:    `{{.Event}} {{.Range}}{{range .Changes}} {{.Host}} -{{join .Removed ","}}{{end}}{{with .Host}} {{.}} {{$.Failures}}{{end}}`,
+			Events:  []string{WebhookRemoved, WebhookFailing},
+		}},
+		ctx:    ctx,
+		logger: zap.NewNop(),
+	}
+	if err := r.Webhooks[0].validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	a := netip.MustParsePrefix("192.0.2.1/32")
+	b := netip.MustParsePrefix("192.0.2.2/32")
+	receive := func() webhookRequest {
+		t.Helper()
+		select {
+		case req := <-requests:
+			return req
+		case <-time.After(5 * time.Second):
+			t.Fatal("webhook not sent")
+			return webhookRequest{}
+		}
+	}
+
+	// Only changes removing addresses are sent.
+	r.changedWebhooks([]hostChange{{host: "proxy.example.com", old: []netip.Prefix{a}, addresses: []netip.Prefix{a, b}}})
+	r.changedWebhooks([]hostChange{{host: "proxy.example.com", old: []netip.Prefix{a, b}, addresses: []netip.Prefix{b}}})
+	want := webhookRequest{http.MethodPut, "Bearer secret", "changed proxies proxy.example.com -192.0.2.1/32"}
+	if got := receive(); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// Failures are only sent when reaching the threshold.
+	lookupErr := errors.New("lookup failed")
+	for failures := 1; failures <= DefaultWebhookFailureThreshold+1; failures++ {
+		r.failingWebhooks("proxy.example.com", failures, lookupErr)
+	}
+	want = webhookRequest{http.MethodPut, "Bearer secret", "failing proxies proxy.example.com 3"}
+	if got := receive(); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	select {
+	case req := <-requests:
+		t.Errorf("unexpected webhook %+v", req)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWebhookDefaultBody(t *testing.T) {
+	srv, requests := webhookServer(t)
+	w := Webhook{URL: srv.URL}
+	if err := w.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := webhookData{Event: WebhookFailing, Host: "proxy.example.com", Failures: 3, Error: "no such host"}
+	if err := w.send(context.Background(), data); err != nil {
+		t.Fatal(err)
+	}
+	want := webhookRequest{
+		method: http.MethodPost,
+		body:   `{"event":"failing","host":"proxy.example.com","failures":3,"error":"no such host"}` + "\n",
+	}
+	if got := <-requests; got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestUnmarshalWebhook(t *testing.T) {
+	input := `dns proxy.example.com {
+		webhook https://hooks.example.com/dns {
+			method PUT
+			header Authorization "Bearer {env.TOKEN}"
+			body "{{json .}}"
+			events removed failing
+			failure_threshold 5
+			timeout 3s
+		}
+		webhook https://other.example.com/
+	}`
+
+	var r DNSRange
+	if err := r.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+
+	want := []*Webhook{
+		{
+			URL:              "https://hooks.example.com/dns",
+			Method:           http.MethodPut,
+			Headers:          map[string]string{"Authorization": "Bearer {env.TOKEN}"},
+			Body:             "{{json .}}",
+			Events:           []string{WebhookRemoved, WebhookFailing},
+			FailureThreshold: 5,
+			Timeout:          caddy.Duration(3 * time.Second),
+		},
+		{URL: "https://other.example.com/"},
+	}
+	if !reflect.DeepEqual(r.Webhooks, want) {
+		t.Errorf("webhooks: got %+v, want %+v", r.Webhooks, want)
+	}
+
+	for _, input := range []string{
+		"dns proxy.example.com {\n webhook\n }",
+		"dns proxy.example.com {\n webhook https://hooks.example.com/ {\n retries 3\n }\n }",
+	} {
+		var r DNSRange
+		if err := r.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err == nil {
+			t.Errorf("no error parsing %q", input)
+		}
+	}
+}
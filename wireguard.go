@@ -0,0 +1,285 @@
+package dns
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(new(WireGuardSource))
+}
+
+// The default refresh interval of the WireGuard source.
+const DefaultWireGuardInterval = caddy.Duration(30 * time.Second)
+
+// Where userspace implementations like wireguard-go put their control
+// sockets, and the command to ask the kernel with. Overridden in tests.
+var (
+	wireguardSocketDir = "/var/run/wireguard"
+	wireguardCommand   = "wg"
+)
+
+// WireGuardSource provides the allowed IPs of the peers of a WireGuard
+// interface. It talks to userspace implementations through their control
+// socket, and asks the kernel with the wg tool otherwise.
+type WireGuardSource struct {
+	// The WireGuard interface, such as "wg0".
+	Interface string `json:"interface"`
+
+	// The public keys of the peers to include, in base64 as shown by wg.
+	// Defaults to all peers.
+	Peers []string `json:"peers,omitempty"`
+
+	// Also refresh shortly after the network configuration changes, which
+	// includes the routes to the allowed IPs that wg-quick sets up.
+	RefreshOnNetworkChange bool `json:"refresh_on_network_change,omitempty"`
+
+	SourceOptions
+
+	refresher refresher
+}
+
+// wireguardPeer is a peer of an interface.
+type wireguardPeer struct {
+	publicKey  string
+	allowedIPs []string
+}
+
+// CaddyModule returns the Caddy module information.
+func (*WireGuardSource) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.ip_sources.wireguard",
+		New: func() caddy.Module { return new(WireGuardSource) },
+	}
+}
+
+// Provision fetches the allowed IPs, and starts refreshing them.
+func (s *WireGuardSource) Provision(ctx caddy.Context) error {
+	if s.Interface == "" {
+		return errors.New("no interface provided")
+	}
+	if err := s.SourceOptions.validate(DefaultWireGuardInterval); err != nil {
+		return err
+	}
+	// Changes seen while watching the network are missed across a reload,
+	// so the result isn't shared then.
+	key := sourceKey(s)
+	if s.RefreshOnNetworkChange {
+		key = ""
+	}
+	if err := s.refresher.start(ctx, s.SourceOptions, key, s.fetch); err != nil {
+		return err
+	}
+	if s.RefreshOnNetworkChange {
+		if err := watchNetwork(s.refresher.ctx, s.refresher.refreshNow); err != nil {
+			s.refresher.stop()
+			return fmt.Errorf("watching network changes: %w", err)
+		}
+	}
+	return nil
+}
+
+// fetch fetches the allowed IPs of the selected peers.
+func (s *WireGuardSource) fetch(ctx context.Context) ([]netip.Prefix, error) {
+	var (
+		peers []wireguardPeer
+		err   error
+	)
+	socket := filepath.Join(wireguardSocketDir, s.Interface+".sock")
+	if _, statErr := os.Stat(socket); statErr == nil {
+		peers, err = wireguardSocketPeers(ctx, socket)
+	} else {
+		peers, err = wireguardKernelPeers(ctx, s.Interface)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var strs []string
+	for _, peer := range peers {
+		if s.include(peer) {
+			strs = append(strs, peer.allowedIPs...)
+		}
+	}
+	return parsePrefixList(strs)
+}
+
+// include reports whether the peer is selected. Keys are compared exactly,
+// since base64 is case sensitive.
+func (s *WireGuardSource) include(peer wireguardPeer) bool {
+	if len(s.Peers) == 0 {
+		return true
+	}
+	for _, key := range s.Peers {
+		if key == peer.publicKey {
+			return true
+		}
+	}
+	return false
+}
+
+// wireguardSocketPeers lists the peers of an interface with the
+// cross-platform userspace API, through its control socket.
+func wireguardSocketPeers(ctx context.Context, socket string) ([]wireguardPeer, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if _, err := io.WriteString(conn, "get=1\n\n"); err != nil {
+		return nil, err
+	}
+
+	var peers []wireguardPeer
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			return peers, nil
+		}
+		key, value, _ := strings.Cut(line, "=")
+		switch key {
+		case "public_key":
+			// Keys are hex encoded here, but base64 encoded everywhere else.
+			raw, err := hex.DecodeString(value)
+			if err != nil {
+				return nil, fmt.Errorf("wireguard: invalid public key %q", value)
+			}
+			peers = append(peers, wireguardPeer{publicKey: base64.StdEncoding.EncodeToString(raw)})
+		case "allowed_ip":
+			if len(peers) == 0 {
+				return nil, errors.New("wireguard: allowed IP before the first peer")
+			}
+			peers[len(peers)-1].allowedIPs = append(peers[len(peers)-1].allowedIPs, value)
+		case "errno":
+			if value != "0" {
+				return nil, fmt.Errorf("wireguard: get failed with errno %s", value)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.ErrUnexpectedEOF
+}
+
+// wireguardKernelPeers lists the peers of a kernel interface with
+// "wg show <interface> allowed-ips", which prints a line per peer with its
+// public key and allowed IPs, or "(none)".
+func wireguardKernelPeers(ctx context.Context, iface string) ([]wireguardPeer, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, wireguardCommand, "show", iface, "allowed-ips")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s show %s: %v: %s", wireguardCommand, iface, err, msg)
+		}
+		return nil, fmt.Errorf("%s show %s: %v", wireguardCommand, iface, err)
+	}
+
+	var peers []wireguardPeer
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		peer := wireguardPeer{publicKey: fields[0]}
+		for _, ip := range fields[1:] {
+			if ip != "(none)" {
+				peer.allowedIPs = append(peer.allowedIPs, ip)
+			}
+		}
+		peers = append(peers, peer)
+	}
+	return peers, nil
+}
+
+// Cleanup stops refreshing the allowed IPs.
+func (s *WireGuardSource) Cleanup() error {
+	s.refresher.stop()
+	return nil
+}
+
+// GetIPRanges returns the allowed IPs of the peers.
+func (s *WireGuardSource) GetIPRanges(_ *http.Request) []netip.Prefix {
+	return s.refresher.current()
+}
+
+// healthy reports whether the last refresh succeeded.
+func (s *WireGuardSource) healthy() bool {
+	return s.refresher.healthy()
+}
+
+// UnmarshalCaddyfile sets up the source from Caddyfile tokens:
+//
+//	wireguard <interface> {
+//	    peer <public key...>
+//	    refresh_on_network_change
+//	    interval <duration>|once
+//	    timeout <duration>
+//	}
+func (s *WireGuardSource) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Next() {
+		return nil
+	}
+	if !d.AllArgs(&s.Interface) {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "peer":
+			peers := d.RemainingArgs()
+			if len(peers) == 0 {
+				return d.ArgErr()
+			}
+			s.Peers = append(s.Peers, peers...)
+		case "refresh_on_network_change":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			s.RefreshOnNetworkChange = true
+		default:
+			ok, err := s.SourceOptions.unmarshalSourceOption(d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return d.Errf("unknown wireguard option %q", d.Val())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Module            = (*WireGuardSource)(nil)
+	_ caddy.Provisioner       = (*WireGuardSource)(nil)
+	_ caddy.CleanerUpper      = (*WireGuardSource)(nil)
+	_ caddyfile.Unmarshaler   = (*WireGuardSource)(nil)
+	_ caddyhttp.IPRangeSource = (*WireGuardSource)(nil)
+)
@@ -0,0 +1,107 @@
+package dns
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestWireGuardSourceSocket(t *testing.T) {
+	dir := t.TempDir()
+	ln, err := net.Listen("unix", filepath.Join(dir, "wg0.sock"))
+	if err != nil {
+		t.Skipf("no unix sockets: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				if line, _ := r.ReadString('\n'); line != "get=1\n" {
+					fmt.Fprint(conn, "errno=22\n\n")
+					return
+				}
+				fmt.Fprint(conn, "private_key=0000000000000000000000000000000000000000000000000000000000000000\n"+
+					"listen_port=51820\n"+
+					"public_key=0101010101010101010101010101010101010101010101010101010101010101\n"+
+					"endpoint=192.0.2.1:51820\n"+
+					"allowed_ip=10.8.0.2/32\n"+
+					"allowed_ip=fd00:8::2/128\n"+
+					"public_key=0202020202020202020202020202020202020202020202020202020202020202\n"+
+					"allowed_ip=10.8.0.3/32\n"+
+					"errno=0\n\n")
+			}()
+		}
+	}()
+	defer func(dir string) { wireguardSocketDir = dir }(wireguardSocketDir)
+	wireguardSocketDir = dir
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	s := WireGuardSource{Interface: "wg0", Peers: []string{"AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE="}}
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cleanup()
+
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[10.8.0.2/32 fd00:8::2/128]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestWireGuardSourceKernel(t *testing.T) {
+	dir := t.TempDir()
+	wg := filepath.Join(dir, "wg")
+	script := "#!/bin/sh\n" +
+		"[ \"$*\" = \"show wg0 allowed-ips\" ] || { echo \"Unable to access interface\" >&2; exit 1; }\n" +
+		"printf 'AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=\\t10.8.0.2/32 10.9.0.0/24\\n'\n" +
+		"printf 'AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI=\\t(none)\\n'\n"
+	if err := os.WriteFile(wg, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(dir, cmd string) { wireguardSocketDir, wireguardCommand = dir, cmd }(wireguardSocketDir, wireguardCommand)
+	wireguardSocketDir, wireguardCommand = dir, wg
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	s := WireGuardSource{Interface: "wg0"}
+	if err := s.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cleanup()
+	if got, want := fmt.Sprint(s.GetIPRanges(nil)), "[10.8.0.2/32 10.9.0.0/24]"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	bad := WireGuardSource{Interface: "wg1"}
+	if err := bad.Provision(ctx); err == nil {
+		bad.Cleanup()
+		t.Error("expected an error for a missing interface")
+	}
+}
+
+func TestUnmarshalWireGuardSource(t *testing.T) {
+	var s WireGuardSource
+	input := `wireguard wg0 {
+		peer AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=
+		refresh_on_network_change
+	}`
+	if err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatalf("error parsing Caddyfile: %v", err)
+	}
+	if got, want := fmt.Sprint(s.Interface, " ", s.Peers, " ", s.RefreshOnNetworkChange), "wg0 [AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=] true"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}